@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net/http"
@@ -13,17 +14,25 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 
+	"github.com/ismoilovdevml/firerunner/pkg/api"
+	"github.com/ismoilovdevml/firerunner/pkg/cache"
+	"github.com/ismoilovdevml/firerunner/pkg/cloudinit"
 	"github.com/ismoilovdevml/firerunner/pkg/config"
 	"github.com/ismoilovdevml/firerunner/pkg/firecracker"
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+	"github.com/ismoilovdevml/firerunner/pkg/forgejo"
+	"github.com/ismoilovdevml/firerunner/pkg/github"
 	"github.com/ismoilovdevml/firerunner/pkg/gitlab"
 	"github.com/ismoilovdevml/firerunner/pkg/scheduler"
+	"github.com/ismoilovdevml/firerunner/pkg/vmspec"
 )
 
 var (
-	configPath = flag.String("config", "config.yaml", "Path to configuration file")
-	version    = "dev"
-	commit     = "unknown"
-	buildDate  = "unknown"
+	configPath      = flag.String("config", "config.yaml", "Path to configuration file")
+	reconcileReport = flag.Bool("reconcile-report", false, "Print a dry-run orphan reconciliation report and exit")
+	version         = "dev"
+	commit          = "unknown"
+	buildDate       = "unknown"
 )
 
 func main() {
@@ -47,6 +56,13 @@ func main() {
 		logger.WithError(err).Fatal("Failed to initialize application")
 	}
 
+	if *reconcileReport {
+		if err := printReconcileReport(app); err != nil {
+			logger.WithError(err).Fatal("Failed to build reconciliation report")
+		}
+		return
+	}
+
 	if err := app.Start(); err != nil {
 		logger.WithError(err).Fatal("Failed to start application")
 	}
@@ -57,11 +73,16 @@ func main() {
 type App struct {
 	config          *config.Config
 	logger          *logrus.Logger
-	flintlockClient *firecracker.Client
+	flintlockClient firecracker.FlintlockClient
 	vmManager       *firecracker.Manager
 	gitlabService   *gitlab.Service
 	scheduler       *scheduler.Scheduler
+	jobStore        scheduler.JobStore
+	reconciler      *scheduler.Reconciler
 	webhookHandler  *gitlab.WebhookHandler
+	dispatcher      *gitlab.EventDispatcher
+	eventStore      gitlab.EventStore
+	poller          *gitlab.Poller
 	httpServer      *http.Server
 	metricsServer   *http.Server
 }
@@ -69,9 +90,20 @@ type App struct {
 func initializeApp(cfg *config.Config, logger *logrus.Logger) (*App, error) {
 	logger.Info("Initializing application components")
 
-	flintlockClient, err := firecracker.NewClient(&cfg.Flintlock)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Flintlock client: %w", err)
+	var flintlockClient firecracker.FlintlockClient
+	if len(cfg.Flintlock.Hosts) > 0 {
+		hostPool, err := firecracker.NewHostPool(&cfg.Flintlock, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Flintlock host pool: %w", err)
+		}
+		hostPool.StartHealthChecks(cfg.Flintlock.HealthCheckInterval)
+		flintlockClient = hostPool
+	} else {
+		client, err := firecracker.NewClient(&cfg.Flintlock)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Flintlock client: %w", err)
+		}
+		flintlockClient = client
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -81,6 +113,28 @@ func initializeApp(cfg *config.Config, logger *logrus.Logger) (*App, error) {
 	cancel()
 
 	vmManager := firecracker.NewManager(flintlockClient, &cfg.VM, logger)
+	if cfg.VM.CloudInitEnabled {
+		renderer, err := cloudinit.NewRenderer(&cfg.VM)
+		if err != nil {
+			flintlockClient.Close()
+			return nil, fmt.Errorf("failed to create cloud-init renderer: %w", err)
+		}
+		vmManager.SetGuestRenderer(renderer)
+	}
+	if cfg.VM.Network.IPAM.CIDR != "" {
+		ipam, err := firecracker.NewIPAM(&cfg.VM.Network.IPAM)
+		if err != nil {
+			flintlockClient.Close()
+			return nil, fmt.Errorf("failed to create IPAM pool: %w", err)
+		}
+		vmManager.SetIPAM(ipam)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := vmManager.ReserveAddresses(ctx); err != nil {
+			logger.WithError(err).Warn("Failed to reserve addresses of running VMs")
+		}
+		cancel()
+	}
 
 	gitlabService, err := gitlab.NewService(&cfg.GitLab, logger)
 	if err != nil {
@@ -95,13 +149,106 @@ func initializeApp(cfg *config.Config, logger *logrus.Logger) (*App, error) {
 
 	sched := scheduler.NewScheduler(&cfg.Scheduler, vmManager, gitlabService, logger)
 
+	var jobStore scheduler.JobStore
+	if cfg.Scheduler.StatePath != "" {
+		store, err := scheduler.NewFileJobStore(cfg.Scheduler.StatePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open job store: %w", err)
+		}
+		jobStore = store
+		sched.SetJobStore(jobStore)
+	}
+
+	sched.SetForgeLimit(forge.GitLab, cfg.GitLab.MaxConcurrent)
+	sched.SetPipelineResolver(gitlabService)
+	sched.SetHostCapacity(cfg.Flintlock.Capacity())
+	sched.SetSpecParser(vmspec.NewParser(&cfg.VM))
+	if cfg.GitLab.RunnerMode == gitlab.RunnerModeNative {
+		sched.SetJobClaimer(gitlab.NewRunnerClient(&cfg.GitLab, logger))
+	}
+	if cfg.GitHub.Enabled {
+		sched.SetForge(forge.GitHub, github.NewService(&cfg.GitHub, logger))
+	}
+	if cfg.Forgejo.Enabled {
+		sched.SetForge(forge.Forgejo, forgejo.NewService(&cfg.Forgejo, logger))
+	}
+
+	var cacheManager *cache.Manager
+	if cfg.Cache.Enabled {
+		cacheManager, err = cache.NewManager(&cfg.Cache, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cache manager: %w", err)
+		}
+		sched.SetCacheManager(cacheManager)
+	}
+
+	reconciler := scheduler.NewReconciler(&cfg.Scheduler, sched, vmManager, gitlabService, logger)
+
 	processor := &EventProcessor{
 		scheduler: sched,
 		logger:    logger,
 	}
 	webhookHandler := gitlab.NewWebhookHandler(cfg.GitLab.WebhookSecret, logger, processor)
+	webhooks := webhookRouter{forge.GitLab: webhookHandler.ProcessEvent}
 
-	httpServer := setupHTTPServer(cfg, webhookHandler)
+	var githubWebhook *github.WebhookHandler
+	if cfg.GitHub.Enabled {
+		githubWebhook = github.NewWebhookHandler(cfg.GitHub.WebhookSecret, logger, processor)
+		webhooks[forge.GitHub] = githubWebhook.ProcessEvent
+	}
+
+	var forgejoWebhook *forgejo.WebhookHandler
+	if cfg.Forgejo.Enabled {
+		forgejoWebhook = forgejo.NewWebhookHandler(cfg.Forgejo.WebhookSecret, logger, processor)
+		webhooks[forge.Forgejo] = forgejoWebhook.ProcessEvent
+	}
+
+	dispatcher := gitlab.NewEventDispatcher(
+		webhooks.process,
+		cfg.GitLab.EventQueueSize,
+		cfg.GitLab.EventMaxAttempts,
+		cfg.GitLab.EventMaxDeadLetters,
+		logger,
+	)
+	var eventStore gitlab.EventStore
+	if cfg.GitLab.EventStatePath != "" {
+		store, err := gitlab.NewFileEventStore(cfg.GitLab.EventStatePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open webhook event store: %w", err)
+		}
+		eventStore = store
+		dispatcher.SetEventStore(eventStore)
+	}
+	webhookHandler.SetDispatcher(dispatcher)
+
+	var githubHandler http.Handler
+	if githubWebhook != nil {
+		githubWebhook.SetDispatcher(dispatcher)
+		githubHandler = githubWebhook
+	}
+
+	var forgejoHandler http.Handler
+	if forgejoWebhook != nil {
+		forgejoWebhook.SetDispatcher(dispatcher)
+		forgejoHandler = forgejoWebhook
+	}
+
+	var poller *gitlab.Poller
+	if cfg.GitLab.PollEnabled {
+		poller = gitlab.NewPoller(&cfg.GitLab, gitlabService, processor, logger)
+	}
+
+	var apiHandler http.Handler
+	if cfg.API.Enabled {
+		handler := api.NewHandler(cfg.API.Token, sched, vmManager, logger)
+		handler.SetEventService(dispatcher)
+		if cacheManager != nil {
+			handler.SetCacheService(cacheManager)
+		}
+		apiHandler = handler
+	}
+
+	httpServer := setupHTTPServer(cfg, webhookHandler, githubHandler, forgejoHandler, apiHandler)
 
 	var metricsServer *http.Server
 	if cfg.Metrics.Enabled {
@@ -115,7 +262,12 @@ func initializeApp(cfg *config.Config, logger *logrus.Logger) (*App, error) {
 		vmManager:       vmManager,
 		gitlabService:   gitlabService,
 		scheduler:       sched,
+		jobStore:        jobStore,
+		reconciler:      reconciler,
 		webhookHandler:  webhookHandler,
+		dispatcher:      dispatcher,
+		eventStore:      eventStore,
+		poller:          poller,
 		httpServer:      httpServer,
 		metricsServer:   metricsServer,
 	}, nil
@@ -130,6 +282,16 @@ func (app *App) Start() error {
 		return fmt.Errorf("failed to start scheduler: %w", err)
 	}
 
+	app.reconciler.Start()
+
+	if err := app.dispatcher.Start(); err != nil {
+		return fmt.Errorf("failed to start webhook event dispatcher: %w", err)
+	}
+
+	if app.poller != nil {
+		app.poller.Start()
+	}
+
 	if app.metricsServer != nil {
 		go func() {
 			app.logger.WithField("port", app.config.Metrics.Port).Info("Starting metrics server")
@@ -179,10 +341,36 @@ func (app *App) Shutdown(ctx context.Context) error {
 		}
 	}
 
+	if app.poller != nil {
+		if err := app.poller.Shutdown(ctx); err != nil {
+			app.logger.WithError(err).Error("Failed to shutdown job poller")
+		}
+	}
+
+	if err := app.dispatcher.Shutdown(ctx); err != nil {
+		app.logger.WithError(err).Error("Failed to shutdown webhook event dispatcher")
+	}
+
+	if app.eventStore != nil {
+		if err := app.eventStore.Close(); err != nil {
+			app.logger.WithError(err).Error("Failed to close webhook event store")
+		}
+	}
+
+	if err := app.reconciler.Shutdown(ctx); err != nil {
+		app.logger.WithError(err).Error("Failed to shutdown reconciler")
+	}
+
 	if err := app.scheduler.Shutdown(ctx); err != nil {
 		app.logger.WithError(err).Error("Failed to shutdown scheduler")
 	}
 
+	if app.jobStore != nil {
+		if err := app.jobStore.Close(); err != nil {
+			app.logger.WithError(err).Error("Failed to close job store")
+		}
+	}
+
 	if err := app.vmManager.Shutdown(ctx); err != nil {
 		app.logger.WithError(err).Error("Failed to shutdown VM manager")
 	}
@@ -197,20 +385,103 @@ func (app *App) Shutdown(ctx context.Context) error {
 	return nil
 }
 
+// webhookRouter hands events the dispatcher accepted to the webhook handler
+// of the forge that sent them.
+type webhookRouter map[string]func(eventType string, body []byte) error
+
+func (r webhookRouter) process(forgeName, eventType string, body []byte) error {
+	process, ok := r[forgeName]
+	if !ok {
+		return fmt.Errorf("webhooks from %s are not enabled", forgeName)
+	}
+	return process(eventType, body)
+}
+
 type EventProcessor struct {
 	scheduler *scheduler.Scheduler
 	logger    *logrus.Logger
 }
 
 func (ep *EventProcessor) ProcessJobEvent(event *gitlab.JobEvent) error {
-	return ep.scheduler.ScheduleJob(event)
+	switch event.BuildStatus {
+	case "canceled", "failed":
+		if err := ep.scheduler.AbortJob(scheduler.JobKey{Forge: forge.GitLab, ID: event.BuildID}, event.BuildStatus); err != nil {
+			ep.logger.WithError(err).WithField("job_id", event.BuildID).Debug("Nothing to abort for job")
+		}
+		return nil
+	default:
+		return ep.scheduler.ScheduleJob(event)
+	}
 }
 
 func (ep *EventProcessor) ProcessPipelineEvent(event *gitlab.PipelineEvent) error {
 	ep.logger.WithField("pipeline_id", event.ObjectAttributes.ID).Debug("Pipeline event received")
+
+	status := event.ObjectAttributes.Status
+	if status != "canceled" && status != "failed" {
+		return nil
+	}
+
+	if aborted := ep.scheduler.AbortPipeline(forge.GitLab, event.Project.ID, event.ObjectAttributes.ID, status); aborted > 0 {
+		ep.logger.WithFields(logrus.Fields{
+			"pipeline_id": event.ObjectAttributes.ID,
+			"status":      status,
+			"jobs":        aborted,
+		}).Info("Aborted jobs of finished pipeline")
+	}
+	return nil
+}
+
+func (ep *EventProcessor) ProcessWorkflowJobEvent(event *github.WorkflowJobEvent) error {
+	switch event.Action {
+	case "queued":
+		return ep.scheduler.ScheduleForgeJob(forge.GitHub, event.Job())
+	case "completed":
+		// Finished jobs are picked up by their worker; only a job canceled
+		// before or while it runs needs to be stopped here.
+		if event.WorkflowJob.Conclusion != "cancelled" {
+			return nil
+		}
+		if err := ep.scheduler.AbortJob(scheduler.JobKey{Forge: forge.GitHub, ID: event.WorkflowJob.ID}, "canceled"); err != nil {
+			ep.logger.WithError(err).WithField("job_id", event.WorkflowJob.ID).Debug("Nothing to abort for workflow job")
+		}
+	}
+	return nil
+}
+
+func (ep *EventProcessor) ProcessForgejoJobEvent(event *forgejo.WorkflowJobEvent) error {
+	switch event.Action {
+	case "queued":
+		return ep.scheduler.ScheduleForgeJob(forge.Forgejo, event.Job())
+	case "completed":
+		if event.WorkflowJob.Conclusion != "cancelled" {
+			return nil
+		}
+		if err := ep.scheduler.AbortJob(scheduler.JobKey{Forge: forge.Forgejo, ID: event.WorkflowJob.ID}, "canceled"); err != nil {
+			ep.logger.WithError(err).WithField("job_id", event.WorkflowJob.ID).Debug("Nothing to abort for workflow job")
+		}
+	}
 	return nil
 }
 
+func printReconcileReport(app *App) error {
+	if _, err := app.scheduler.LoadPersistedJobs(); err != nil {
+		return fmt.Errorf("failed to load persisted jobs: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	report, err := app.reconciler.Reconcile(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
 func setupLogger() *logrus.Logger {
 	logger := logrus.New()
 
@@ -253,10 +524,20 @@ func loadConfig(path string, logger *logrus.Logger) (*config.Config, error) {
 	return cfg, nil
 }
 
-func setupHTTPServer(cfg *config.Config, webhookHandler *gitlab.WebhookHandler) *http.Server {
+func setupHTTPServer(cfg *config.Config, webhookHandler *gitlab.WebhookHandler, githubHandler, forgejoHandler, apiHandler http.Handler) *http.Server {
 	mux := http.NewServeMux()
 
+	if apiHandler != nil {
+		mux.Handle(api.Prefix+"/", apiHandler)
+	}
+
 	mux.Handle("/webhook", webhookHandler)
+	if githubHandler != nil {
+		mux.Handle("/webhook/github", githubHandler)
+	}
+	if forgejoHandler != nil {
+		mux.Handle("/webhook/forgejo", forgejoHandler)
+	}
 	mux.HandleFunc("/health", webhookHandler.HealthCheck)
 	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
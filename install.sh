@@ -360,6 +360,7 @@ gitlab:
   url: "$GITLAB_URL"
   token: "$GITLAB_TOKEN"
   webhook_secret: "$WEBHOOK_SECRET"
+  runner_type: "project_type"
   runner_tags:
     - firerunner
     - firecracker
@@ -381,6 +382,7 @@ scheduler:
   vm_start_timeout: 5m
   vm_shutdown_timeout: 1m
   cleanup_interval: 5m
+  state_path: "/var/lib/firerunner/jobs.json"
 
 logging:
   level: "info"
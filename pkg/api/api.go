@@ -0,0 +1,383 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/cache"
+	"github.com/ismoilovdevml/firerunner/pkg/firecracker"
+	"github.com/ismoilovdevml/firerunner/pkg/gitlab"
+	"github.com/ismoilovdevml/firerunner/pkg/scheduler"
+)
+
+const Prefix = "/api/v1"
+
+type JobService interface {
+	JobInfos() []scheduler.JobInfo
+	JobInfo(key scheduler.JobKey) (scheduler.JobInfo, bool)
+	JobForVM(vmID string) (scheduler.JobKey, bool)
+	CancelJob(key scheduler.JobKey) error
+	Pause()
+	Resume()
+	GetStats() scheduler.Stats
+}
+
+type VMService interface {
+	ListVMs() []*firecracker.MicroVM
+	GetVM(vmID string) (*firecracker.MicroVM, error)
+	AdoptVM(ctx context.Context, vmID string) (*firecracker.MicroVM, error)
+	DestroyVM(ctx context.Context, vmID string) error
+	GetVMStats() firecracker.VMStats
+}
+
+type EventService interface {
+	DeadLetters() []gitlab.EventRecord
+	Replay(id string) error
+	Discard(id string) error
+}
+
+type CacheService interface {
+	List() ([]cache.Info, error)
+}
+
+type VM struct {
+	ID        string            `json:"id"`
+	Namespace string            `json:"namespace"`
+	Host      string            `json:"host,omitempty"`
+	State     string            `json:"state"`
+	IPAddress string            `json:"ip_address,omitempty"`
+	VCPU      int64             `json:"vcpu"`
+	MemoryMB  int64             `json:"memory_mb"`
+	CreatedAt time.Time         `json:"created_at"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+type Stats struct {
+	Scheduler scheduler.Stats     `json:"scheduler"`
+	VMs       firecracker.VMStats `json:"vms"`
+}
+
+type Error struct {
+	Error string `json:"error"`
+}
+
+type Handler struct {
+	token  string
+	jobs   JobService
+	vms    VMService
+	events EventService
+	caches CacheService
+	logger *logrus.Logger
+	mux    *http.ServeMux
+}
+
+// NewHandler serves the admin API below Prefix. Every request must carry
+// "Authorization: Bearer <token>".
+func NewHandler(token string, jobs JobService, vms VMService, logger *logrus.Logger) *Handler {
+	h := &Handler{
+		token:  token,
+		jobs:   jobs,
+		vms:    vms,
+		logger: logger,
+		mux:    http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("GET "+Prefix+"/jobs", h.listJobs)
+	h.mux.HandleFunc("GET "+Prefix+"/jobs/{forge}/{id}", h.getJob)
+	h.mux.HandleFunc("POST "+Prefix+"/jobs/{forge}/{id}/cancel", h.cancelJob)
+	h.mux.HandleFunc("GET "+Prefix+"/vms", h.listVMs)
+	h.mux.HandleFunc("GET "+Prefix+"/vms/{id}", h.getVM)
+	h.mux.HandleFunc("DELETE "+Prefix+"/vms/{id}", h.destroyVM)
+	h.mux.HandleFunc("GET "+Prefix+"/stats", h.stats)
+	h.mux.HandleFunc("POST "+Prefix+"/scheduler/pause", h.pause)
+	h.mux.HandleFunc("POST "+Prefix+"/scheduler/resume", h.resume)
+	h.mux.HandleFunc("GET "+Prefix+"/webhooks/dead-letters", h.listDeadLetters)
+	h.mux.HandleFunc("POST "+Prefix+"/webhooks/dead-letters/{id}/replay", h.replayDeadLetter)
+	h.mux.HandleFunc("DELETE "+Prefix+"/webhooks/dead-letters/{id}", h.discardDeadLetter)
+	h.mux.HandleFunc("GET "+Prefix+"/caches", h.listCaches)
+
+	return h
+}
+
+// SetEventService enables the webhook dead-letter endpoints.
+func (h *Handler) SetEventService(events EventService) {
+	h.events = events
+}
+
+// SetCacheService enables listing project caches.
+func (h *Handler) SetCacheService(caches CacheService) {
+	h.caches = caches
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+	if h.token == "" {
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+func (h *Handler) listJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := h.jobs.JobInfos()
+
+	if status := r.URL.Query().Get("status"); status != "" {
+		filtered := make([]scheduler.JobInfo, 0, len(jobs))
+		for _, job := range jobs {
+			if job.Status == status {
+				filtered = append(filtered, job)
+			}
+		}
+		jobs = filtered
+	}
+
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (h *Handler) getJob(w http.ResponseWriter, r *http.Request) {
+	key, ok := parseJobKey(w, r)
+	if !ok {
+		return
+	}
+
+	job, exists := h.jobs.JobInfo(key)
+	if !exists {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (h *Handler) cancelJob(w http.ResponseWriter, r *http.Request) {
+	key, ok := parseJobKey(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.jobs.CancelJob(key); err != nil {
+		switch {
+		case errors.Is(err, scheduler.ErrJobNotFound):
+			writeError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, scheduler.ErrJobNotActive):
+			writeError(w, http.StatusConflict, err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"forge":  key.Forge,
+		"job_id": key.ID,
+	}).Info("Job canceled via admin API")
+
+	job, _ := h.jobs.JobInfo(key)
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (h *Handler) listVMs(w http.ResponseWriter, r *http.Request) {
+	vms := h.vms.ListVMs()
+
+	result := make([]VM, 0, len(vms))
+	for _, vm := range vms {
+		result = append(result, toVM(vm))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (h *Handler) getVM(w http.ResponseWriter, r *http.Request) {
+	vm, err := h.vms.GetVM(r.PathValue("id"))
+	if err != nil || vm == nil {
+		writeError(w, http.StatusNotFound, "VM not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, toVM(vm))
+}
+
+// destroyVM deletes a VM even if FireRunner is not tracking it, e.g. one
+// left behind by a previous process. The VM of a queued or running job is
+// destroyed by canceling the job, so that its worker cleans up the runner
+// and the VM the way it does for any job that ends.
+func (h *Handler) destroyVM(w http.ResponseWriter, r *http.Request) {
+	vmID := r.PathValue("id")
+
+	if key, ok := h.jobs.JobForVM(vmID); ok {
+		err := h.jobs.CancelJob(key)
+		if err == nil {
+			h.logger.WithFields(logrus.Fields{
+				"vm_id":  vmID,
+				"forge":  key.Forge,
+				"job_id": key.ID,
+			}).Info("Job canceled via admin API to destroy its VM")
+			job, _ := h.jobs.JobInfo(key)
+			writeJSON(w, http.StatusAccepted, job)
+			return
+		}
+		if !errors.Is(err, scheduler.ErrJobNotActive) && !errors.Is(err, scheduler.ErrJobNotFound) {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		// The job ended meanwhile, so the VM is no longer its own.
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+	defer cancel()
+
+	if _, err := h.vms.AdoptVM(ctx, vmID); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if err := h.vms.DestroyVM(ctx, vmID); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.logger.WithField("vm_id", vmID).Info("VM destroyed via admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) stats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Stats{
+		Scheduler: h.jobs.GetStats(),
+		VMs:       h.vms.GetVMStats(),
+	})
+}
+
+func (h *Handler) pause(w http.ResponseWriter, r *http.Request) {
+	h.jobs.Pause()
+	writeJSON(w, http.StatusOK, h.jobs.GetStats())
+}
+
+func (h *Handler) resume(w http.ResponseWriter, r *http.Request) {
+	h.jobs.Resume()
+	writeJSON(w, http.StatusOK, h.jobs.GetStats())
+}
+
+func (h *Handler) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		writeJSON(w, http.StatusOK, []gitlab.EventRecord{})
+		return
+	}
+	writeJSON(w, http.StatusOK, h.events.DeadLetters())
+}
+
+func (h *Handler) replayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if !h.eventsEnabled(w) {
+		return
+	}
+
+	eventID := r.PathValue("id")
+	if err := h.events.Replay(eventID); err != nil {
+		writeEventError(w, err)
+		return
+	}
+
+	h.logger.WithField("event_id", eventID).Info("Webhook event replayed via admin API")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) discardDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if !h.eventsEnabled(w) {
+		return
+	}
+
+	eventID := r.PathValue("id")
+	if err := h.events.Discard(eventID); err != nil {
+		writeEventError(w, err)
+		return
+	}
+
+	h.logger.WithField("event_id", eventID).Info("Webhook event discarded via admin API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) eventsEnabled(w http.ResponseWriter) bool {
+	if h.events == nil {
+		writeError(w, http.StatusNotFound, "event not found")
+		return false
+	}
+	return true
+}
+
+func (h *Handler) listCaches(w http.ResponseWriter, r *http.Request) {
+	if h.caches == nil {
+		writeJSON(w, http.StatusOK, []cache.Info{})
+		return
+	}
+
+	caches, err := h.caches.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if caches == nil {
+		caches = []cache.Info{}
+	}
+	writeJSON(w, http.StatusOK, caches)
+}
+
+func writeEventError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gitlab.ErrEventNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, gitlab.ErrEventNotDead):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// parseJobKey reads the forge and ID of a job from the path, since job IDs
+// are only unique within a forge.
+func parseJobKey(w http.ResponseWriter, r *http.Request) (scheduler.JobKey, bool) {
+	jobID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid job id")
+		return scheduler.JobKey{}, false
+	}
+	return scheduler.JobKey{Forge: r.PathValue("forge"), ID: jobID}, true
+}
+
+func toVM(vm *firecracker.MicroVM) VM {
+	return VM{
+		ID:        vm.ID,
+		Namespace: vm.Namespace,
+		Host:      vm.Host,
+		State:     vm.State,
+		IPAddress: vm.IPAddress,
+		VCPU:      vm.VCPU,
+		MemoryMB:  vm.MemoryMB,
+		CreatedAt: vm.CreatedAt,
+		Labels:    vm.Labels,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, Error{Error: message})
+}
@@ -0,0 +1,364 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/cache"
+	"github.com/ismoilovdevml/firerunner/pkg/firecracker"
+	"github.com/ismoilovdevml/firerunner/pkg/gitlab"
+	"github.com/ismoilovdevml/firerunner/pkg/scheduler"
+)
+
+type fakeJobs struct {
+	jobs     map[scheduler.JobKey]scheduler.JobInfo
+	paused   bool
+	canceled []scheduler.JobKey
+}
+
+func (f *fakeJobs) JobInfos() []scheduler.JobInfo {
+	infos := make([]scheduler.JobInfo, 0, len(f.jobs))
+	for _, job := range f.jobs {
+		infos = append(infos, job)
+	}
+	return infos
+}
+
+func (f *fakeJobs) JobInfo(key scheduler.JobKey) (scheduler.JobInfo, bool) {
+	job, ok := f.jobs[key]
+	return job, ok
+}
+
+func (f *fakeJobs) JobForVM(vmID string) (scheduler.JobKey, bool) {
+	for key, job := range f.jobs {
+		if job.VMID == vmID && (job.Status == "queued" || job.Status == "running") {
+			return key, true
+		}
+	}
+	return scheduler.JobKey{}, false
+}
+
+func (f *fakeJobs) CancelJob(key scheduler.JobKey) error {
+	job, ok := f.jobs[key]
+	if !ok {
+		return scheduler.ErrJobNotFound
+	}
+	if job.Status != "queued" && job.Status != "running" {
+		return fmt.Errorf("%w: job %s is %s", scheduler.ErrJobNotActive, key, job.Status)
+	}
+	job.Status = "canceled"
+	f.jobs[key] = job
+	f.canceled = append(f.canceled, key)
+	return nil
+}
+
+func (f *fakeJobs) Pause()  { f.paused = true }
+func (f *fakeJobs) Resume() { f.paused = false }
+
+func (f *fakeJobs) GetStats() scheduler.Stats {
+	return scheduler.Stats{TotalJobs: len(f.jobs), Paused: f.paused, ByStatus: map[string]int{}}
+}
+
+type fakeVMs struct {
+	vms       map[string]*firecracker.MicroVM
+	remote    map[string]*firecracker.MicroVM
+	destroyed []string
+}
+
+func (f *fakeVMs) ListVMs() []*firecracker.MicroVM {
+	vms := make([]*firecracker.MicroVM, 0, len(f.vms))
+	for _, vm := range f.vms {
+		vms = append(vms, vm)
+	}
+	return vms
+}
+
+func (f *fakeVMs) GetVM(vmID string) (*firecracker.MicroVM, error) {
+	vm, ok := f.vms[vmID]
+	if !ok {
+		return nil, fmt.Errorf("VM %s not found", vmID)
+	}
+	return vm, nil
+}
+
+func (f *fakeVMs) AdoptVM(ctx context.Context, vmID string) (*firecracker.MicroVM, error) {
+	if vm, ok := f.vms[vmID]; ok {
+		return vm, nil
+	}
+	vm, ok := f.remote[vmID]
+	if !ok {
+		return nil, fmt.Errorf("microVM %s not found", vmID)
+	}
+	f.vms[vmID] = vm
+	return vm, nil
+}
+
+func (f *fakeVMs) DestroyVM(ctx context.Context, vmID string) error {
+	delete(f.vms, vmID)
+	f.destroyed = append(f.destroyed, vmID)
+	return nil
+}
+
+func (f *fakeVMs) GetVMStats() firecracker.VMStats {
+	return firecracker.VMStats{TotalVMs: len(f.vms), ByState: map[string]int{}}
+}
+
+func newTestHandler() (*Handler, *fakeJobs, *fakeVMs) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	jobs := &fakeJobs{jobs: map[scheduler.JobKey]scheduler.JobInfo{
+		{Forge: "gitlab", ID: 1}: {ID: 1, Forge: "gitlab", ProjectID: 10, Status: "running", VMID: "vm-1", CreatedAt: time.Now()},
+		{Forge: "gitlab", ID: 2}: {ID: 2, Forge: "gitlab", ProjectID: 10, Status: "finished", CreatedAt: time.Now()},
+		{Forge: "github", ID: 1}: {ID: 1, Forge: "github", ProjectID: 20, Status: "finished", CreatedAt: time.Now()},
+	}}
+	vms := &fakeVMs{
+		vms: map[string]*firecracker.MicroVM{
+			"vm-1": {ID: "vm-1", Namespace: "firerunner", State: "running", IPAddress: "10.0.0.2"},
+		},
+		remote: map[string]*firecracker.MicroVM{
+			"vm-orphan": {ID: "vm-orphan", Namespace: "firerunner", State: "running"},
+		},
+	}
+
+	return NewHandler("secret", jobs, vms, logger), jobs, vms
+}
+
+func do(h http.Handler, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHandler_Auth(t *testing.T) {
+	h, _, _ := newTestHandler()
+
+	if rr := do(h, http.MethodGet, "/api/v1/jobs", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without token, got %d", rr.Code)
+	}
+	if rr := do(h, http.MethodGet, "/api/v1/jobs", "wrong"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with wrong token, got %d", rr.Code)
+	}
+	if rr := do(h, http.MethodGet, "/api/v1/jobs", "secret"); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 with token, got %d", rr.Code)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	open := NewHandler("", &fakeJobs{}, &fakeVMs{}, logger)
+	if rr := do(open, http.MethodGet, "/api/v1/jobs", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 when no token is configured, got %d", rr.Code)
+	}
+}
+
+func TestHandler_Jobs(t *testing.T) {
+	h, jobs, _ := newTestHandler()
+
+	rr := do(h, http.MethodGet, "/api/v1/jobs?status=running", "secret")
+	var list []scheduler.JobInfo
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode jobs: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != 1 || list[0].Forge != "gitlab" {
+		t.Errorf("Expected only GitLab job 1, got %+v", list)
+	}
+
+	if rr := do(h, http.MethodGet, "/api/v1/jobs/gitlab/2", "secret"); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 for existing job, got %d", rr.Code)
+	}
+	if rr := do(h, http.MethodGet, "/api/v1/jobs/gitlab/99", "secret"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown job, got %d", rr.Code)
+	}
+	if rr := do(h, http.MethodGet, "/api/v1/jobs/github/2", "secret"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a job of another forge, got %d", rr.Code)
+	}
+	if rr := do(h, http.MethodGet, "/api/v1/jobs/gitlab/abc", "secret"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid id, got %d", rr.Code)
+	}
+
+	if rr := do(h, http.MethodPost, "/api/v1/jobs/github/1/cancel", "secret"); rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 when canceling finished GitHub job, got %d", rr.Code)
+	}
+	if rr := do(h, http.MethodPost, "/api/v1/jobs/gitlab/1/cancel", "secret"); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 when canceling running job, got %d", rr.Code)
+	}
+	if want := (scheduler.JobKey{Forge: "gitlab", ID: 1}); len(jobs.canceled) != 1 || jobs.canceled[0] != want {
+		t.Errorf("Expected GitLab job 1 to be canceled, got %v", jobs.canceled)
+	}
+	if rr := do(h, http.MethodPost, "/api/v1/jobs/gitlab/2/cancel", "secret"); rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 when canceling finished job, got %d", rr.Code)
+	}
+	if rr := do(h, http.MethodPost, "/api/v1/jobs/gitlab/99/cancel", "secret"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 when canceling unknown job, got %d", rr.Code)
+	}
+}
+
+func TestHandler_VMs(t *testing.T) {
+	h, _, vms := newTestHandler()
+
+	rr := do(h, http.MethodGet, "/api/v1/vms", "secret")
+	var list []VM
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode VMs: %v", err)
+	}
+	if len(list) != 1 || list[0].IPAddress != "10.0.0.2" {
+		t.Errorf("Unexpected VM list: %+v", list)
+	}
+
+	if rr := do(h, http.MethodGet, "/api/v1/vms/missing", "secret"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown VM, got %d", rr.Code)
+	}
+
+	if rr := do(h, http.MethodDelete, "/api/v1/vms/vm-orphan", "secret"); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 when destroying untracked VM, got %d", rr.Code)
+	}
+	if len(vms.destroyed) != 1 || vms.destroyed[0] != "vm-orphan" {
+		t.Errorf("Expected vm-orphan to be destroyed, got %v", vms.destroyed)
+	}
+	if rr := do(h, http.MethodDelete, "/api/v1/vms/missing", "secret"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 when destroying unknown VM, got %d", rr.Code)
+	}
+}
+
+func TestHandler_DestroyVMOfRunningJob(t *testing.T) {
+	h, jobs, vms := newTestHandler()
+
+	if rr := do(h, http.MethodDelete, "/api/v1/vms/vm-1", "secret"); rr.Code != http.StatusAccepted {
+		t.Errorf("Expected 202 when destroying the VM of a running job, got %d", rr.Code)
+	}
+	if want := (scheduler.JobKey{Forge: "gitlab", ID: 1}); len(jobs.canceled) != 1 || jobs.canceled[0] != want {
+		t.Errorf("Expected the job owning the VM to be canceled, got %v", jobs.canceled)
+	}
+	if len(vms.destroyed) != 0 {
+		t.Errorf("The VM of a job should be destroyed by its worker, got %v", vms.destroyed)
+	}
+}
+
+func TestHandler_PauseResume(t *testing.T) {
+	h, jobs, _ := newTestHandler()
+
+	rr := do(h, http.MethodPost, "/api/v1/scheduler/pause", "secret")
+	var stats scheduler.Stats
+	if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+	if !jobs.paused || !stats.Paused {
+		t.Error("Expected scheduler to be paused")
+	}
+
+	do(h, http.MethodPost, "/api/v1/scheduler/resume", "secret")
+	if jobs.paused {
+		t.Error("Expected scheduler to be resumed")
+	}
+
+	if rr := do(h, http.MethodGet, "/api/v1/stats", "secret"); rr.Code != http.StatusOK {
+		t.Errorf("Expected 200 for stats, got %d", rr.Code)
+	}
+}
+
+type fakeEvents struct {
+	dead     map[string]gitlab.EventRecord
+	replayed []string
+}
+
+func (f *fakeEvents) DeadLetters() []gitlab.EventRecord {
+	records := make([]gitlab.EventRecord, 0, len(f.dead))
+	for _, record := range f.dead {
+		records = append(records, record)
+	}
+	return records
+}
+
+func (f *fakeEvents) Replay(id string) error {
+	if _, ok := f.dead[id]; !ok {
+		return gitlab.ErrEventNotFound
+	}
+	delete(f.dead, id)
+	f.replayed = append(f.replayed, id)
+	return nil
+}
+
+func (f *fakeEvents) Discard(id string) error {
+	if _, ok := f.dead[id]; !ok {
+		return gitlab.ErrEventNotFound
+	}
+	delete(f.dead, id)
+	return nil
+}
+
+func TestHandler_DeadLetters(t *testing.T) {
+	h, _, _ := newTestHandler()
+
+	if rr := do(h, http.MethodPost, "/api/v1/webhooks/dead-letters/a/replay", "secret"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without an event service, got %d", rr.Code)
+	}
+
+	events := &fakeEvents{dead: map[string]gitlab.EventRecord{
+		"a": {ID: "a", EventType: "Job Hook", Status: gitlab.EventStatusDead},
+		"b": {ID: "b", EventType: "Job Hook", Status: gitlab.EventStatusDead},
+	}}
+	h.SetEventService(events)
+
+	rr := do(h, http.MethodGet, "/api/v1/webhooks/dead-letters", "secret")
+	var list []gitlab.EventRecord
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode dead letters: %v", err)
+	}
+	if len(list) != 2 {
+		t.Errorf("Expected 2 dead letters, got %d", len(list))
+	}
+
+	if rr := do(h, http.MethodPost, "/api/v1/webhooks/dead-letters/a/replay", "secret"); rr.Code != http.StatusAccepted {
+		t.Errorf("Expected 202 when replaying, got %d", rr.Code)
+	}
+	if len(events.replayed) != 1 || events.replayed[0] != "a" {
+		t.Errorf("Expected event a to be replayed, got %v", events.replayed)
+	}
+	if rr := do(h, http.MethodDelete, "/api/v1/webhooks/dead-letters/b", "secret"); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 when discarding, got %d", rr.Code)
+	}
+	if rr := do(h, http.MethodDelete, "/api/v1/webhooks/dead-letters/b", "secret"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown event, got %d", rr.Code)
+	}
+}
+
+type fakeCaches struct {
+	caches []cache.Info
+}
+
+func (f *fakeCaches) List() ([]cache.Info, error) {
+	return f.caches, nil
+}
+
+func TestHandler_Caches(t *testing.T) {
+	h, _, _ := newTestHandler()
+
+	rr := do(h, http.MethodGet, "/api/v1/caches", "secret")
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("Expected an empty list without a cache service, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	h.SetCacheService(&fakeCaches{caches: []cache.Info{{Project: "gitlab-10", Key: "go-mod", Size: 1024}}})
+
+	rr = do(h, http.MethodGet, "/api/v1/caches", "secret")
+	var list []cache.Info
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode caches: %v", err)
+	}
+	if len(list) != 1 || list[0].Key != "go-mod" {
+		t.Errorf("Unexpected caches: %+v", list)
+	}
+}
@@ -0,0 +1,383 @@
+// Package cache keeps directories that survive the VMs of a project's jobs,
+// e.g. for dependency, Docker layer or Go module caches.
+//
+// Caches live in <dir>/<project>/<key> and are handed to VMs as virtio-fs
+// volumes. Only one job at a time mounts a cache read-write; other jobs of
+// the project get it read-only until the writer is done. Size limits are
+// enforced in the background whenever a job releases its caches, evicting
+// the least recently used caches that are not mounted.
+package cache
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+	"github.com/ismoilovdevml/firerunner/pkg/metrics"
+)
+
+// Mount is a cache a VM mounts.
+type Mount struct {
+	Key        string
+	Path       string // directory on the host
+	MountPoint string // directory in the guest
+	ReadOnly   bool
+}
+
+// Lease holds the caches of one job until Release is called.
+type Lease struct {
+	Mounts []Mount
+
+	manager  *Manager
+	released bool
+}
+
+// Info describes a cache for the admin API.
+type Info struct {
+	Project  string    `json:"project"`
+	Key      string    `json:"key"`
+	Size     int64     `json:"size_bytes"`
+	LastUsed time.Time `json:"last_used"`
+	InUse    bool      `json:"in_use"`
+}
+
+// evictedDir holds evicted caches below the cache directory until they are
+// removed, so that an eviction only renames the cache while mu is held.
+const evictedDir = ".evicted"
+
+type Manager struct {
+	config *config.CacheConfig
+	logger *logrus.Logger
+	now    func() time.Time
+
+	// writers holds the caches mounted read-write and readers counts the
+	// read-only mounts of each cache; neither may be evicted.
+	writers map[string]bool
+	readers map[string]int
+	mu      sync.Mutex
+
+	// enforceMu serializes Enforce. Releases while a background run is in
+	// progress set enforceAgain and are handled by one more run.
+	enforceMu    sync.Mutex
+	enforcing    bool
+	enforceAgain bool
+	background   sync.WaitGroup
+}
+
+func NewManager(cfg *config.CacheConfig, logger *logrus.Logger) (*Manager, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	// Caches evicted right before a restart may not have been removed yet.
+	if err := os.RemoveAll(filepath.Join(cfg.Dir, evictedDir)); err != nil {
+		return nil, fmt.Errorf("failed to remove evicted caches: %w", err)
+	}
+
+	return &Manager{
+		config:  cfg,
+		logger:  logger,
+		now:     time.Now,
+		writers: make(map[string]bool),
+		readers: make(map[string]int),
+	}, nil
+}
+
+// Acquire mounts the caches of a project a job asks for. A cache another
+// job holds read-write is mounted read-only.
+func (m *Manager) Acquire(project string, keys []string) (*Lease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lease := &Lease{manager: m}
+	for _, key := range keys {
+		dir := m.dir(project, key)
+		if err := os.MkdirAll(dir, 0o770); err != nil {
+			lease.release()
+			return nil, fmt.Errorf("failed to create cache %s of %s: %w", key, project, err)
+		}
+		m.touch(dir)
+
+		mount := Mount{
+			Key:        key,
+			Path:       dir,
+			MountPoint: filepath.Join(m.config.MountPoint, key),
+			ReadOnly:   m.writers[dir],
+		}
+		if mount.ReadOnly {
+			m.readers[dir]++
+		} else {
+			m.writers[dir] = true
+		}
+		lease.Mounts = append(lease.Mounts, mount)
+	}
+
+	return lease, nil
+}
+
+// Release hands the caches back and enforces the size limits in the
+// background. It is safe to call more than once.
+func (l *Lease) Release() {
+	if l == nil {
+		return
+	}
+
+	m := l.manager
+	m.mu.Lock()
+	released := l.released
+	l.release()
+	m.mu.Unlock()
+
+	if !released {
+		m.enforceInBackground()
+	}
+}
+
+// enforceInBackground runs Enforce in a goroutine unless one is running
+// already, in which case that one runs Enforce once more when it is done.
+func (m *Manager) enforceInBackground() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.enforcing {
+		m.enforceAgain = true
+		return
+	}
+	m.enforcing = true
+
+	m.background.Add(1)
+	go func() {
+		defer m.background.Done()
+		for {
+			m.Enforce()
+
+			m.mu.Lock()
+			if !m.enforceAgain {
+				m.enforcing = false
+				m.mu.Unlock()
+				return
+			}
+			m.enforceAgain = false
+			m.mu.Unlock()
+		}
+	}()
+}
+
+// release must be called with the manager's mu held.
+func (l *Lease) release() {
+	if l.released {
+		return
+	}
+	l.released = true
+
+	m := l.manager
+	for _, mount := range l.Mounts {
+		m.touch(mount.Path)
+		if mount.ReadOnly {
+			if m.readers[mount.Path]--; m.readers[mount.Path] <= 0 {
+				delete(m.readers, mount.Path)
+			}
+		} else {
+			delete(m.writers, mount.Path)
+		}
+	}
+}
+
+// Enforce evicts caches until every limit holds again: caches larger than
+// max_cache_mb first, then the least recently used caches of projects above
+// max_project_mb and finally of all projects above max_total_mb. Mounted
+// caches are never evicted. The cache directories are measured without
+// holding the lock Acquire needs.
+func (m *Manager) Enforce() {
+	m.enforceMu.Lock()
+	defer m.enforceMu.Unlock()
+
+	caches, err := m.scan()
+	if err != nil {
+		m.logger.WithError(err).Error("Failed to scan caches")
+		return
+	}
+
+	sort.Slice(caches, func(i, j int) bool {
+		return caches[i].LastUsed.Before(caches[j].LastUsed)
+	})
+
+	kept := caches[:0]
+	for _, c := range caches {
+		if m.config.MaxCacheMB > 0 && c.Size > mb(m.config.MaxCacheMB) && !c.InUse && m.evict(c, "cache_size") {
+			continue
+		}
+		kept = append(kept, c)
+	}
+	caches = kept
+
+	if m.config.MaxProjectMB > 0 {
+		usage := make(map[string]int64)
+		for _, c := range caches {
+			usage[c.Project] += c.Size
+		}
+		kept := caches[:0]
+		for _, c := range caches {
+			if usage[c.Project] > mb(m.config.MaxProjectMB) && !c.InUse && m.evict(c, "project_size") {
+				usage[c.Project] -= c.Size
+				continue
+			}
+			kept = append(kept, c)
+		}
+		caches = kept
+	}
+
+	if m.config.MaxTotalMB > 0 {
+		var total int64
+		for _, c := range caches {
+			total += c.Size
+		}
+		for _, c := range caches {
+			if total <= mb(m.config.MaxTotalMB) {
+				break
+			}
+			if !c.InUse && m.evict(c, "total_size") {
+				total -= c.Size
+			}
+		}
+	}
+}
+
+// List returns every cache, least recently used first.
+func (m *Manager) List() ([]Info, error) {
+	caches, err := m.scan()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(caches, func(i, j int) bool {
+		return caches[i].LastUsed.Before(caches[j].LastUsed)
+	})
+	return caches, nil
+}
+
+// scan measures every cache. Only looking up which caches are mounted takes
+// mu.
+func (m *Manager) scan() ([]Info, error) {
+	projects, err := os.ReadDir(m.config.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	var caches []Info
+	for _, project := range projects {
+		if !project.IsDir() || project.Name() == evictedDir {
+			continue
+		}
+		keys, err := os.ReadDir(filepath.Join(m.config.Dir, project.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read caches of %s: %w", project.Name(), err)
+		}
+		for _, key := range keys {
+			if !key.IsDir() {
+				continue
+			}
+			dir := m.dir(project.Name(), key.Name())
+			info, err := key.Info()
+			if err != nil {
+				continue
+			}
+			caches = append(caches, Info{
+				Project:  project.Name(),
+				Key:      key.Name(),
+				Size:     dirSize(dir),
+				LastUsed: info.ModTime(),
+			})
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range caches {
+		caches[i].InUse = m.inUse(m.dir(caches[i].Project, caches[i].Key))
+	}
+	return caches, nil
+}
+
+// inUse must be called with mu held.
+func (m *Manager) inUse(dir string) bool {
+	return m.writers[dir] || m.readers[dir] > 0
+}
+
+// evict removes a cache unless a job mounted it since it was measured and
+// reports whether it did. The cache is moved out of the way while mu is
+// held, so Acquire creates a fresh one, and removed afterwards.
+func (m *Manager) evict(c Info, reason string) bool {
+	logger := m.logger.WithFields(logrus.Fields{
+		"project": c.Project,
+		"key":     c.Key,
+		"size":    c.Size,
+		"reason":  reason,
+	})
+
+	root := filepath.Join(m.config.Dir, evictedDir)
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		logger.WithError(err).Error("Failed to evict cache")
+		return false
+	}
+	trash, err := os.MkdirTemp(root, "cache-")
+	if err != nil {
+		logger.WithError(err).Error("Failed to evict cache")
+		return false
+	}
+	defer os.RemoveAll(trash)
+
+	dir := m.dir(c.Project, c.Key)
+	m.mu.Lock()
+	if m.inUse(dir) {
+		m.mu.Unlock()
+		return false
+	}
+	err = os.Rename(dir, filepath.Join(trash, c.Key))
+	m.mu.Unlock()
+	if err != nil {
+		logger.WithError(err).Error("Failed to evict cache")
+		return false
+	}
+
+	metrics.CacheEvictions.WithLabelValues(reason).Inc()
+	logger.Info("Evicted cache")
+	return true
+}
+
+func (m *Manager) dir(project, key string) string {
+	return filepath.Join(m.config.Dir, project, key)
+}
+
+// touch marks a cache as used; its modification time is the LRU clock.
+func (m *Manager) touch(dir string) {
+	now := m.now()
+	if err := os.Chtimes(dir, now, now); err != nil {
+		m.logger.WithError(err).WithField("dir", dir).Warn("Failed to update cache access time")
+	}
+}
+
+func mb(n int64) int64 {
+	return n * 1024 * 1024
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,223 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+)
+
+func newTestManager(t *testing.T, cfg config.CacheConfig) *Manager {
+	t.Helper()
+
+	cfg.Dir = t.TempDir()
+	cfg.MountPoint = "/cache"
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	m, err := NewManager(&cfg, logger)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	// Every touch moves the clock forward so the LRU order is deterministic.
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.now = func() time.Time {
+		clock = clock.Add(time.Minute)
+		return clock
+	}
+	return m
+}
+
+// fill writes a file of sizeMB into a cache, releases it and waits for the
+// limits to be enforced.
+func fill(t *testing.T, m *Manager, project, key string, sizeMB int64) {
+	t.Helper()
+
+	lease, err := m.Acquire(project, []string{key})
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	data := make([]byte, mb(sizeMB))
+	if err := os.WriteFile(filepath.Join(lease.Mounts[0].Path, "data"), data, 0o600); err != nil {
+		t.Fatalf("Failed to write cache data: %v", err)
+	}
+	lease.Release()
+	m.background.Wait()
+}
+
+func keys(t *testing.T, m *Manager) []string {
+	t.Helper()
+
+	caches, err := m.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	result := make([]string, 0, len(caches))
+	for _, c := range caches {
+		result = append(result, c.Project+"/"+c.Key)
+	}
+	return result
+}
+
+func TestManager_AcquireReadWrite(t *testing.T) {
+	m := newTestManager(t, config.CacheConfig{})
+
+	first, err := m.Acquire("gitlab-10", []string{"go-mod", "docker"})
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if len(first.Mounts) != 2 {
+		t.Fatalf("Expected 2 mounts, got %d", len(first.Mounts))
+	}
+	for _, mount := range first.Mounts {
+		if mount.ReadOnly {
+			t.Errorf("Expected the first job to mount %s read-write", mount.Key)
+		}
+		if mount.MountPoint != "/cache/"+mount.Key {
+			t.Errorf("Unexpected mount point %s", mount.MountPoint)
+		}
+		if _, err := os.Stat(mount.Path); err != nil {
+			t.Errorf("Expected cache directory %s to exist: %v", mount.Path, err)
+		}
+	}
+
+	second, err := m.Acquire("gitlab-10", []string{"go-mod"})
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if !second.Mounts[0].ReadOnly {
+		t.Error("Expected a concurrent job to mount the cache read-only")
+	}
+
+	other, err := m.Acquire("gitlab-11", []string{"go-mod"})
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if other.Mounts[0].ReadOnly {
+		t.Error("Expected caches of other projects to be independent")
+	}
+
+	first.Release()
+	first.Release()
+	second.Release()
+
+	third, err := m.Acquire("gitlab-10", []string{"go-mod"})
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if third.Mounts[0].ReadOnly {
+		t.Error("Expected the cache to be writable once the writer released it")
+	}
+
+	var lease *Lease
+	lease.Release()
+}
+
+func TestManager_Enforce(t *testing.T) {
+	tests := []struct {
+		name   string
+		config config.CacheConfig
+		want   []string
+	}{
+		{
+			name:   "no limits",
+			config: config.CacheConfig{},
+			want:   []string{"a/old", "a/new", "b/big", "b/small"},
+		},
+		{
+			name:   "cache size",
+			config: config.CacheConfig{MaxCacheMB: 2},
+			want:   []string{"a/old", "a/new", "b/small"},
+		},
+		{
+			name:   "project size",
+			config: config.CacheConfig{MaxProjectMB: 3},
+			want:   []string{"a/old", "a/new", "b/small"},
+		},
+		{
+			name:   "total size",
+			config: config.CacheConfig{MaxTotalMB: 4},
+			want:   []string{"b/big", "b/small"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, tt.config)
+			fill(t, m, "a", "old", 1)
+			fill(t, m, "a", "new", 1)
+			fill(t, m, "b", "big", 3)
+			fill(t, m, "b", "small", 1)
+
+			got := keys(t, m)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Caches = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Caches = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestManager_EnforceSkipsMountedCaches(t *testing.T) {
+	m := newTestManager(t, config.CacheConfig{MaxCacheMB: 1})
+
+	lease, err := m.Acquire("a", []string{"big"})
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	data := make([]byte, mb(2))
+	if err := os.WriteFile(filepath.Join(lease.Mounts[0].Path, "data"), data, 0o600); err != nil {
+		t.Fatalf("Failed to write cache data: %v", err)
+	}
+
+	m.Enforce()
+	caches, err := m.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(caches) != 1 || !caches[0].InUse {
+		t.Fatalf("Expected the mounted cache to be kept, got %+v", caches)
+	}
+
+	lease.Release()
+	m.background.Wait()
+	if got := keys(t, m); len(got) != 0 {
+		t.Errorf("Expected the cache to be evicted after release, got %v", got)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(m.config.Dir, evictedDir)); len(entries) != 0 {
+		t.Errorf("Expected the evicted cache to be removed, got %v", entries)
+	}
+}
+
+func TestManager_EvictSkipsCachesMountedAfterScan(t *testing.T) {
+	m := newTestManager(t, config.CacheConfig{MaxCacheMB: 1})
+	fill(t, m, "a", "small", 0)
+
+	caches, err := m.List()
+	if err != nil || len(caches) != 1 {
+		t.Fatalf("List() = %v, %v", caches, err)
+	}
+
+	// A job mounts the cache between measuring and evicting it.
+	lease, err := m.Acquire("a", []string{"small"})
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	defer lease.Release()
+
+	if m.evict(caches[0], "cache_size") {
+		t.Error("A mounted cache should not be evicted")
+	}
+	if _, err := os.Stat(lease.Mounts[0].Path); err != nil {
+		t.Errorf("Expected the mounted cache to exist: %v", err)
+	}
+}
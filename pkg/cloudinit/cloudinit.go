@@ -0,0 +1,273 @@
+package cloudinit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+)
+
+// Metadata keys read by cloud-init's NoCloud datasource through the Flintlock
+// metadata service. Flintlock expects the values base64 encoded.
+const (
+	MetaDataKey   = "meta-data"
+	UserDataKey   = "user-data"
+	VendorDataKey = "vendor-data"
+)
+
+// SecretKeys lists the metadata keys that may carry credentials and must not
+// be logged or returned by the API.
+var SecretKeys = []string{UserDataKey, VendorDataKey}
+
+type Runner struct {
+	// Forge selects the runner started in the guest: "github" for a GitHub
+	// Actions runner, "forgejo" for act_runner and gitlab-runner otherwise.
+	Forge    string
+	URL      string
+	Token    string
+	Name     string
+	Executor string
+	Tags     []string
+	// JITConfig is the just-in-time configuration of a GitHub Actions
+	// runner.
+	JITConfig string
+	// RunnerFile is the ".runner" file of an act_runner FireRunner
+	// registered for the guest.
+	RunnerFile []byte
+}
+
+// Job is a job FireRunner claimed from GitLab itself. The guest runs it
+// from the payload and reports trace and status with the job token.
+type Job struct {
+	URL     string
+	ID      int64
+	Token   string
+	Payload []byte
+}
+
+// Egress is the egress policy of a VM, e.g. for user-data templates that
+// set up a guest firewall. AllowedHosts are reachable in addition to the
+// forge under gitlab-only.
+type Egress struct {
+	Policy       string
+	AllowedHosts []string
+}
+
+type Instance struct {
+	ID       string
+	Hostname string
+	Runner   *Runner
+	Job      *Job
+	Egress   *Egress
+}
+
+const defaultUserData = `#cloud-config
+hostname: {{ .Hostname }}
+write_files:
+  - path: /etc/gitlab-runner/config.toml
+    owner: root:root
+    permissions: "0600"
+    content: |
+      concurrent = 1
+      check_interval = 3
+
+      [[runners]]
+        name = {{ toml .Runner.Name }}
+        url = {{ toml .Runner.URL }}
+        token = {{ toml .Runner.Token }}
+        executor = {{ toml .Runner.Executor }}
+        limit = 1
+runcmd:
+  - [ gitlab-runner, run-single, --config, /etc/gitlab-runner/config.toml, --runner, {{ toml .Runner.Name }}, --max-builds, "1" ]
+  - [ poweroff ]
+`
+
+// defaultJobUserData hands a claimed job to firerunner-agent, which must be
+// installed in the guest image.
+const defaultJobUserData = `#cloud-config
+hostname: {{ .Hostname }}
+write_files:
+  - path: /etc/firerunner/job.json
+    owner: root:root
+    permissions: "0600"
+    encoding: b64
+    content: {{ base64 .Job.Payload }}
+runcmd:
+  - [ firerunner-agent, run, --url, {{ toml .Job.URL }}, --job, /etc/firerunner/job.json ]
+  - [ poweroff ]
+`
+
+// defaultGitHubUserData starts a GitHub Actions runner from its JIT
+// configuration. The runner must be installed in /opt/actions-runner.
+const defaultGitHubUserData = `#cloud-config
+hostname: {{ .Hostname }}
+runcmd:
+  - [ env, RUNNER_ALLOW_RUNASROOT=1, /opt/actions-runner/run.sh, --jitconfig, {{ toml .Runner.JITConfig }} ]
+  - [ poweroff ]
+`
+
+// defaultForgejoUserData runs a single job with the ephemeral act_runner
+// FireRunner registered for the guest.
+const defaultForgejoUserData = `#cloud-config
+hostname: {{ .Hostname }}
+write_files:
+  - path: /etc/act_runner/.runner
+    owner: root:root
+    permissions: "0600"
+    encoding: b64
+    content: {{ base64 .Runner.RunnerFile }}
+  - path: /etc/act_runner/config.yaml
+    owner: root:root
+    permissions: "0644"
+    content: |
+      runner:
+        file: /etc/act_runner/.runner
+runcmd:
+  - [ act_runner, --config, /etc/act_runner/config.yaml, daemon, --once ]
+  - [ poweroff ]
+`
+
+type Renderer struct {
+	userData        *template.Template
+	jobUserData     *template.Template
+	githubUserData  *template.Template
+	forgejoUserData *template.Template
+	executor        string
+}
+
+func NewRenderer(cfg *config.VMConfig) (*Renderer, error) {
+	userData, err := parseTemplate("user-data", cfg.UserDataTemplate, defaultUserData)
+	if err != nil {
+		return nil, err
+	}
+
+	jobUserData, err := parseTemplate("job-user-data", cfg.JobUserDataTemplate, defaultJobUserData)
+	if err != nil {
+		return nil, err
+	}
+
+	githubUserData, err := parseTemplate("github-user-data", cfg.GitHubUserDataTemplate, defaultGitHubUserData)
+	if err != nil {
+		return nil, err
+	}
+
+	forgejoUserData, err := parseTemplate("forgejo-user-data", cfg.ForgejoUserDataTemplate, defaultForgejoUserData)
+	if err != nil {
+		return nil, err
+	}
+
+	executor := cfg.RunnerExecutor
+	if executor == "" {
+		executor = "shell"
+	}
+
+	return &Renderer{
+		userData:        userData,
+		jobUserData:     jobUserData,
+		githubUserData:  githubUserData,
+		forgejoUserData: forgejoUserData,
+		executor:        executor,
+	}, nil
+}
+
+func parseTemplate(name, path, fallback string) (*template.Template, error) {
+	text := fallback
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s template: %w", name, err)
+		}
+		text = string(data)
+	}
+
+	tmpl, err := template.New(name).Funcs(template.FuncMap{
+		"toml":   tomlString,
+		"join":   strings.Join,
+		"base64": base64.StdEncoding.EncodeToString,
+	}).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s template: %w", name, err)
+	}
+
+	return tmpl, nil
+}
+
+// Render returns the Flintlock metadata entries that configure the guest.
+// An instance with a Job runs that job; otherwise it starts Runner with the
+// runner of its forge.
+func (r *Renderer) Render(instance *Instance) (map[string]string, error) {
+	data := *instance
+	if data.Hostname == "" {
+		data.Hostname = instance.ID
+	}
+
+	tmpl := r.userData
+	switch {
+	case instance.Job != nil:
+		if instance.Job.Token == "" || len(instance.Job.Payload) == 0 {
+			return nil, fmt.Errorf("instance %s has no job token or payload", instance.ID)
+		}
+		tmpl = r.jobUserData
+	case instance.Runner != nil && instance.Runner.Forge == "github":
+		if instance.Runner.JITConfig == "" {
+			return nil, fmt.Errorf("instance %s has no JIT runner configuration", instance.ID)
+		}
+		tmpl = r.githubUserData
+	case instance.Runner != nil && instance.Runner.Forge == "forgejo":
+		if len(instance.Runner.RunnerFile) == 0 {
+			return nil, fmt.Errorf("instance %s has no act_runner registration", instance.ID)
+		}
+		tmpl = r.forgejoUserData
+	case instance.Runner != nil:
+		if instance.Runner.Token == "" {
+			return nil, fmt.Errorf("instance %s has no runner token", instance.ID)
+		}
+		runner := *instance.Runner
+		if runner.Executor == "" {
+			runner.Executor = r.executor
+		}
+		if runner.Name == "" {
+			runner.Name = instance.ID
+		}
+		data.Runner = &runner
+	default:
+		return nil, fmt.Errorf("instance %s has no runner configuration", instance.ID)
+	}
+
+	var userData bytes.Buffer
+	if err := tmpl.Execute(&userData, &data); err != nil {
+		return nil, fmt.Errorf("failed to render user-data: %w", err)
+	}
+
+	metaData := fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", data.ID, data.Hostname)
+
+	return map[string]string{
+		MetaDataKey: base64.StdEncoding.EncodeToString([]byte(metaData)),
+		UserDataKey: base64.StdEncoding.EncodeToString(userData.Bytes()),
+	}, nil
+}
+
+// Redact returns a copy of metadata without the entries listed in SecretKeys.
+func Redact(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+
+	redacted := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		redacted[k] = v
+	}
+	for _, key := range SecretKeys {
+		delete(redacted, key)
+	}
+	return redacted
+}
+
+func tomlString(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + replacer.Replace(s) + `"`
+}
@@ -0,0 +1,202 @@
+package cloudinit
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+)
+
+func decode(t *testing.T, value string) string {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatalf("metadata is not base64 encoded: %v", err)
+	}
+	return string(data)
+}
+
+func TestRenderer_Render(t *testing.T) {
+	renderer, err := NewRenderer(&config.VMConfig{RunnerExecutor: "docker"})
+	if err != nil {
+		t.Fatalf("NewRenderer() error = %v", err)
+	}
+
+	metadata, err := renderer.Render(&Instance{
+		ID: "vm-1-abcd",
+		Runner: &Runner{
+			URL:   "https://gitlab.example.com",
+			Token: `glrt-abc"123`,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	userData := decode(t, metadata[UserDataKey])
+	for _, want := range []string{
+		"#cloud-config",
+		"concurrent = 1",
+		`url = "https://gitlab.example.com"`,
+		`token = "glrt-abc\"123"`,
+		`executor = "docker"`,
+		`name = "vm-1-abcd"`,
+		`--max-builds, "1"`,
+	} {
+		if !strings.Contains(userData, want) {
+			t.Errorf("user-data missing %q:\n%s", want, userData)
+		}
+	}
+
+	metaData := decode(t, metadata[MetaDataKey])
+	if !strings.Contains(metaData, "instance-id: vm-1-abcd") {
+		t.Errorf("Unexpected meta-data: %s", metaData)
+	}
+}
+
+func TestRenderer_RenderJob(t *testing.T) {
+	renderer, err := NewRenderer(&config.VMConfig{})
+	if err != nil {
+		t.Fatalf("NewRenderer() error = %v", err)
+	}
+
+	payload := []byte(`{"id":42,"token":"job-token"}`)
+	metadata, err := renderer.Render(&Instance{
+		ID: "vm-42-abcd",
+		Job: &Job{
+			URL:     "https://gitlab.example.com",
+			ID:      42,
+			Token:   "job-token",
+			Payload: payload,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	userData := decode(t, metadata[UserDataKey])
+	for _, want := range []string{
+		"hostname: vm-42-abcd",
+		"content: " + base64.StdEncoding.EncodeToString(payload),
+		`firerunner-agent, run, --url, "https://gitlab.example.com"`,
+	} {
+		if !strings.Contains(userData, want) {
+			t.Errorf("user-data missing %q:\n%s", want, userData)
+		}
+	}
+	if strings.Contains(userData, "gitlab-runner") {
+		t.Errorf("user-data for a claimed job should not configure a runner:\n%s", userData)
+	}
+
+	if _, err := renderer.Render(&Instance{ID: "vm-1", Job: &Job{URL: "https://gitlab.example.com"}}); err == nil {
+		t.Error("Render() should fail for a job without token and payload")
+	}
+}
+
+func TestRenderer_RenderGitHubRunner(t *testing.T) {
+	renderer, err := NewRenderer(&config.VMConfig{})
+	if err != nil {
+		t.Fatalf("NewRenderer() error = %v", err)
+	}
+
+	metadata, err := renderer.Render(&Instance{
+		ID:     "vm-1-abcd",
+		Runner: &Runner{Forge: "github", Name: "vm-1-abcd", JITConfig: "ZW5jb2RlZA=="},
+	})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	userData := decode(t, metadata[UserDataKey])
+	if !strings.Contains(userData, `/opt/actions-runner/run.sh, --jitconfig, "ZW5jb2RlZA=="`) {
+		t.Errorf("user-data does not start the JIT runner:\n%s", userData)
+	}
+	if strings.Contains(userData, "gitlab-runner") {
+		t.Errorf("user-data for a GitHub runner should not configure gitlab-runner:\n%s", userData)
+	}
+}
+
+func TestRenderer_RenderForgejoRunner(t *testing.T) {
+	renderer, err := NewRenderer(&config.VMConfig{})
+	if err != nil {
+		t.Fatalf("NewRenderer() error = %v", err)
+	}
+
+	if _, err := renderer.Render(&Instance{ID: "vm-1-abcd", Runner: &Runner{Forge: "forgejo", URL: "https://forgejo.example.com"}}); err == nil {
+		t.Error("Expected error without a registered runner")
+	}
+
+	runnerFile := []byte(`{"id":42,"uuid":"uuid","token":"runner-token"}`)
+	metadata, err := renderer.Render(&Instance{
+		ID: "vm-1-abcd",
+		Runner: &Runner{
+			Forge:      "forgejo",
+			URL:        "https://forgejo.example.com",
+			RunnerFile: runnerFile,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	userData := decode(t, metadata[UserDataKey])
+	for _, want := range []string{
+		"path: /etc/act_runner/.runner",
+		base64.StdEncoding.EncodeToString(runnerFile),
+		`act_runner, --config, /etc/act_runner/config.yaml, daemon, --once`,
+	} {
+		if !strings.Contains(userData, want) {
+			t.Errorf("user-data missing %q:\n%s", want, userData)
+		}
+	}
+	if strings.Contains(userData, "register") {
+		t.Errorf("user-data should not register a runner:\n%s", userData)
+	}
+}
+
+func TestRenderer_RequiresToken(t *testing.T) {
+	renderer, err := NewRenderer(&config.VMConfig{})
+	if err != nil {
+		t.Fatalf("NewRenderer() error = %v", err)
+	}
+
+	if _, err := renderer.Render(&Instance{ID: "vm-1", Runner: &Runner{URL: "https://gitlab.example.com"}}); err == nil {
+		t.Error("Expected error without runner token")
+	}
+}
+
+func TestRenderer_CustomTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "user-data.tmpl")
+	if err := os.WriteFile(path, []byte("#cloud-config\n# {{ .Runner.Executor }} {{ join .Runner.Tags \",\" }}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	renderer, err := NewRenderer(&config.VMConfig{UserDataTemplate: path})
+	if err != nil {
+		t.Fatalf("NewRenderer() error = %v", err)
+	}
+
+	metadata, err := renderer.Render(&Instance{ID: "vm-1", Runner: &Runner{Token: "glrt-x", Tags: []string{"a", "b"}}})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	if got := decode(t, metadata[UserDataKey]); got != "#cloud-config\n# shell a,b\n" {
+		t.Errorf("Unexpected user-data: %q", got)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	redacted := Redact(map[string]string{
+		"firerunner.job_id": "1",
+		UserDataKey:         "secret",
+		VendorDataKey:       "secret",
+	})
+
+	if len(redacted) != 1 || redacted["firerunner.job_id"] != "1" {
+		t.Errorf("Unexpected redacted metadata: %v", redacted)
+	}
+}
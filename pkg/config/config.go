@@ -2,7 +2,11 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -11,11 +15,15 @@ import (
 type Config struct {
 	Server    ServerConfig    `yaml:"server"`
 	GitLab    GitLabConfig    `yaml:"gitlab"`
+	GitHub    GitHubConfig    `yaml:"github"`
+	Forgejo   ForgejoConfig   `yaml:"forgejo"`
 	Flintlock FlintlockConfig `yaml:"flintlock"`
 	VM        VMConfig        `yaml:"vm"`
 	Scheduler SchedulerConfig `yaml:"scheduler"`
+	Cache     CacheConfig     `yaml:"cache"`
 	Metrics   MetricsConfig   `yaml:"metrics"`
 	Logging   LoggingConfig   `yaml:"logging"`
+	API       APIConfig       `yaml:"api"`
 }
 
 type ServerConfig struct {
@@ -35,6 +43,40 @@ type GitLabConfig struct {
 	RunnerTags    []string      `yaml:"runner_tags" default:"firecracker,microvm"`
 	RunnerTimeout time.Duration `yaml:"runner_timeout" default:"1h"`
 	MaxConcurrent int           `yaml:"max_concurrent" default:"10"`
+	RunnerType    string        `yaml:"runner_type" env:"GITLAB_RUNNER_TYPE" default:"project_type"`
+	GroupID       int64         `yaml:"group_id" env:"GITLAB_GROUP_ID"`
+	RunnerMode    string        `yaml:"runner_mode" env:"GITLAB_RUNNER_MODE" default:"ephemeral"`
+	RunnerToken   string        `yaml:"runner_token" env:"GITLAB_RUNNER_TOKEN"`
+
+	EventStatePath      string `yaml:"event_state_path" env:"FIRERUNNER_EVENT_STATE_PATH"`
+	EventQueueSize      int    `yaml:"event_queue_size" default:"1000"`
+	EventMaxAttempts    int    `yaml:"event_max_attempts" default:"3"`
+	EventMaxDeadLetters int    `yaml:"event_max_dead_letters" default:"1000"`
+
+	PollEnabled  bool          `yaml:"poll_enabled" env:"GITLAB_POLL_ENABLED" default:"false"`
+	PollInterval time.Duration `yaml:"poll_interval" default:"30s"`
+	PollProjects []int64       `yaml:"poll_projects"`
+	PollGroups   []int64       `yaml:"poll_groups"`
+}
+
+// GitHubConfig enables running GitHub Actions workflow jobs next to GitLab
+// jobs. Token needs administration write access to the repositories so it
+// can create just-in-time runners.
+type GitHubConfig struct {
+	Enabled       bool   `yaml:"enabled" env:"GITHUB_ENABLED" default:"false"`
+	APIURL        string `yaml:"api_url" env:"GITHUB_API_URL" default:"https://api.github.com"`
+	Token         string `yaml:"token" env:"GITHUB_TOKEN"`
+	WebhookSecret string `yaml:"webhook_secret" env:"GITHUB_WEBHOOK_SECRET"`
+	RunnerGroupID int64  `yaml:"runner_group_id" default:"1"`
+}
+
+// ForgejoConfig enables running Forgejo (or Gitea) Actions jobs. Token
+// needs repository admin access to fetch runner registration tokens.
+type ForgejoConfig struct {
+	Enabled       bool   `yaml:"enabled" env:"FORGEJO_ENABLED" default:"false"`
+	URL           string `yaml:"url" env:"FORGEJO_URL"`
+	Token         string `yaml:"token" env:"FORGEJO_TOKEN"`
+	WebhookSecret string `yaml:"webhook_secret" env:"FORGEJO_WEBHOOK_SECRET"`
 }
 
 type FlintlockConfig struct {
@@ -46,17 +88,166 @@ type FlintlockConfig struct {
 	TLSCACert     string        `yaml:"tls_ca_cert" env:"FLINTLOCK_TLS_CA_CERT"`
 	TLSClientCert string        `yaml:"tls_client_cert" env:"FLINTLOCK_TLS_CLIENT_CERT"`
 	TLSClientKey  string        `yaml:"tls_client_key" env:"FLINTLOCK_TLS_CLIENT_KEY"`
+	TLSServerName string        `yaml:"tls_server_name" env:"FLINTLOCK_TLS_SERVER_NAME"`
+
+	Hosts               []FlintlockHost `yaml:"hosts"`
+	Placement           string          `yaml:"placement" default:"binpack"`
+	HealthCheckInterval time.Duration   `yaml:"health_check_interval" default:"30s"`
+}
+
+type FlintlockHost struct {
+	Name     string            `yaml:"name"`
+	Endpoint string            `yaml:"endpoint"`
+	VCPU     int64             `yaml:"vcpu"`
+	MemoryMB int64             `yaml:"memory_mb"`
+	Labels   map[string]string `yaml:"labels"`
+}
+
+// Capacity returns the total declared capacity of the hosts. A resource is
+// zero unless every host declares it.
+func (c *FlintlockConfig) Capacity() (vcpu, memoryMB int64) {
+	if len(c.Hosts) == 0 {
+		return 0, 0
+	}
+
+	vcpuDeclared, memoryDeclared := true, true
+	for _, host := range c.Hosts {
+		vcpu += host.VCPU
+		memoryMB += host.MemoryMB
+		vcpuDeclared = vcpuDeclared && host.VCPU > 0
+		memoryDeclared = memoryDeclared && host.MemoryMB > 0
+	}
+	if !vcpuDeclared {
+		vcpu = 0
+	}
+	if !memoryDeclared {
+		memoryMB = 0
+	}
+	return vcpu, memoryMB
 }
 
 type VMConfig struct {
-	DefaultVCPU      int64             `yaml:"default_vcpu" default:"2"`
-	DefaultMemoryMB  int64             `yaml:"default_memory_mb" default:"4096"`
-	KernelImage      string            `yaml:"kernel_image" default:"ghcr.io/firerunner/kernel:latest"`
-	RootFSImage      string            `yaml:"rootfs_image" default:"ghcr.io/firerunner/gitlab-runner:latest"`
-	NetworkInterface string            `yaml:"network_interface" default:"eth0"`
-	MetadataService  bool              `yaml:"metadata_service" default:"true"`
-	CloudInitEnabled bool              `yaml:"cloud_init_enabled" default:"true"`
-	ExtraLabels      map[string]string `yaml:"extra_labels"`
+	DefaultVCPU             int64             `yaml:"default_vcpu" default:"2"`
+	DefaultMemoryMB         int64             `yaml:"default_memory_mb" default:"4096"`
+	KernelImage             string            `yaml:"kernel_image" default:"ghcr.io/firerunner/kernel:latest"`
+	RootFSImage             string            `yaml:"rootfs_image" default:"ghcr.io/firerunner/gitlab-runner:latest"`
+	NetworkInterface        string            `yaml:"network_interface" default:"eth0"`
+	MetadataService         bool              `yaml:"metadata_service" default:"true"`
+	CloudInitEnabled        bool              `yaml:"cloud_init_enabled" default:"true"`
+	ExtraLabels             map[string]string `yaml:"extra_labels"`
+	BootTimeout             time.Duration     `yaml:"boot_timeout" default:"60s"`
+	IPResolveTimeout        time.Duration     `yaml:"ip_resolve_timeout" default:"30s"`
+	DHCPLeaseFile           string            `yaml:"dhcp_lease_file"`
+	RunnerExecutor          string            `yaml:"runner_executor" default:"shell"`
+	UserDataTemplate        string            `yaml:"user_data_template"`
+	JobUserDataTemplate     string            `yaml:"job_user_data_template"`
+	GitHubUserDataTemplate  string            `yaml:"github_user_data_template"`
+	ForgejoUserDataTemplate string            `yaml:"forgejo_user_data_template"`
+
+	// SizeClasses name VM sizes jobs can ask for with tags such as
+	// "firerunner-large".
+	SizeClasses map[string]SizeClass `yaml:"size_classes"`
+	// Images is the catalog of images jobs can ask for with tags such as
+	// "firerunner-image:ubuntu-24.04". ProjectImages picks the image of
+	// jobs that do not ask for one by project or group path; the longest
+	// matching path wins. Every kernel and rootfs reference in the catalog
+	// must match a pattern of ImageAllowlist unless it is empty.
+	Images         map[string]VMImage `yaml:"images"`
+	ProjectImages  map[string]string  `yaml:"project_images"`
+	ImageAllowlist []string           `yaml:"image_allowlist"`
+	// Limits bound what a single job can ask for. ProjectLimits override
+	// them for a project or group path; the longest matching path wins and
+	// unset fields fall back to Limits.
+	Limits        VMLimits            `yaml:"limits"`
+	ProjectLimits map[string]VMLimits `yaml:"project_limits"`
+
+	// Network configures the guest interfaces. Without interfaces VMs get
+	// a single macvtap interface on NetworkInterface.
+	Network NetworkConfig `yaml:"network"`
+}
+
+const (
+	InterfaceMACVTAP = "macvtap"
+	InterfaceTAP     = "tap"
+
+	EgressAllowAll   = "allow-all"
+	EgressGitLabOnly = "gitlab-only"
+	EgressDeny       = "deny"
+)
+
+type NetworkConfig struct {
+	Interfaces []NetworkInterface `yaml:"interfaces"`
+	IPAM       IPAMConfig         `yaml:"ipam"`
+	Egress     EgressConfig       `yaml:"egress"`
+}
+
+// NetworkInterface is a guest interface. Bridge overrides the Flintlock
+// bridge of a tap interface; IPAM assigns the interface a static address
+// from the pool. At most one interface can use the pool.
+type NetworkInterface struct {
+	DeviceID string `yaml:"device_id"`
+	Type     string `yaml:"type" default:"macvtap"`
+	Bridge   string `yaml:"bridge"`
+	IPAM     bool   `yaml:"ipam"`
+}
+
+// IPAMConfig is a pool of static IPv4 guest addresses handed out by
+// FireRunner. The network, gateway and broadcast addresses and Reserved are
+// never handed out.
+type IPAMConfig struct {
+	CIDR        string   `yaml:"cidr"`
+	Gateway     string   `yaml:"gateway"`
+	Nameservers []string `yaml:"nameservers"`
+	Reserved    []string `yaml:"reserved"`
+}
+
+// EgressConfig picks what a job's VM may reach: Forks applies to GitLab
+// merge request pipelines from forks, otherwise the longest matching path
+// of Projects and then Default. An empty policy means allow-all.
+//
+// Policies are passed to the guest as metadata; Bridges attaches the tap
+// interfaces of VMs with a policy to a bridge whose firewall enforces it.
+// Every policy in use other than allow-all needs a tap interface and a
+// bridge, since the guest alone cannot be trusted to enforce it.
+// AllowedHosts are reachable in addition to GitLab under gitlab-only.
+type EgressConfig struct {
+	Default      string            `yaml:"default" default:"allow-all"`
+	Forks        string            `yaml:"forks"`
+	Projects     map[string]string `yaml:"projects"`
+	AllowedHosts []string          `yaml:"allowed_hosts"`
+	Bridges      map[string]string `yaml:"bridges"`
+}
+
+// VMImage is a kernel and root filesystem to boot. An empty kernel means
+// vm.kernel_image.
+type VMImage struct {
+	Kernel string `yaml:"kernel"`
+	RootFS string `yaml:"rootfs"`
+}
+
+// UnmarshalYAML also accepts a plain rootfs reference, e.g.
+// `ubuntu-24.04: ghcr.io/firerunner/ubuntu:24.04`.
+func (i *VMImage) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		i.Kernel = ""
+		return node.Decode(&i.RootFS)
+	}
+
+	type plain VMImage
+	return node.Decode((*plain)(i))
+}
+
+type SizeClass struct {
+	VCPU     int64 `yaml:"vcpu"`
+	MemoryMB int64 `yaml:"memory_mb"`
+	DiskGB   int64 `yaml:"disk_gb"`
+}
+
+// VMLimits are unlimited when zero.
+type VMLimits struct {
+	MaxVCPU     int64 `yaml:"max_vcpu"`
+	MaxMemoryMB int64 `yaml:"max_memory_mb"`
+	MaxDiskGB   int64 `yaml:"max_disk_gb"`
 }
 
 type SchedulerConfig struct {
@@ -66,8 +257,87 @@ type SchedulerConfig struct {
 	CleanupInterval   time.Duration `yaml:"cleanup_interval" default:"5m"`
 	VMStartTimeout    time.Duration `yaml:"vm_start_timeout" default:"60s"`
 	VMShutdownTimeout time.Duration `yaml:"vm_shutdown_timeout" default:"30s"`
+	StatePath         string        `yaml:"state_path" env:"FIRERUNNER_STATE_PATH"`
 	EnablePrewarming  bool          `yaml:"enable_prewarming" default:"false"`
 	PrewarmPoolSize   int           `yaml:"prewarm_pool_size" default:"0"`
+
+	ReconcileInterval    time.Duration `yaml:"reconcile_interval" default:"10m"`
+	ReconcileGracePeriod time.Duration `yaml:"reconcile_grace_period" default:"5m"`
+	ReconcileDryRun      bool          `yaml:"reconcile_dry_run" default:"false"`
+
+	Quotas   QuotaConfig    `yaml:"quotas"`
+	Priority PriorityConfig `yaml:"priority"`
+	Capacity CapacityConfig `yaml:"capacity"`
+}
+
+// CapacityConfig is how many vCPUs and MB of memory the VMs of running jobs
+// may allocate in total; queued jobs wait until theirs fit. Zero falls back
+// to the declared capacity of flintlock.hosts, and without that the
+// resource is not limited. Overcommit ratios multiply the capacity, e.g.
+// 2.0 allows twice as many vCPUs as the hosts have.
+type CapacityConfig struct {
+	VCPU             int64   `yaml:"vcpu"`
+	MemoryMB         int64   `yaml:"memory_mb"`
+	CPUOvercommit    float64 `yaml:"cpu_overcommit" default:"1.0"`
+	MemoryOvercommit float64 `yaml:"memory_overcommit" default:"1.0"`
+}
+
+// QuotaConfig limits how much of the fleet a single project or group can
+// use at once. Projects and groups are keyed by their path, e.g.
+// "acme/backend/api" and "acme/backend"; a group quota covers every project
+// below it. Default applies to each project without an entry of its own.
+type QuotaConfig struct {
+	Default  Quota            `yaml:"default"`
+	Projects map[string]Quota `yaml:"projects"`
+	Groups   map[string]Quota `yaml:"groups"`
+}
+
+// PriorityConfig decides which queued jobs start first. A job gets the
+// priority of the first rule it matches, or zero. Jobs that waited get one
+// point per AgingInterval on top, up to the highest priority in the queue,
+// so low-priority jobs are never starved; an unset AgingInterval means one
+// minute. Running jobs are never preempted.
+type PriorityConfig struct {
+	AgingInterval time.Duration  `yaml:"aging_interval" default:"1m"`
+	Rules         []PriorityRule `yaml:"rules"`
+}
+
+// PriorityRule matches a job when every condition that is set holds. Refs,
+// projects and job tags are path.Match patterns, e.g. "release/*".
+// ProtectedRef and PipelineSources only match GitLab jobs.
+type PriorityRule struct {
+	Priority        int      `yaml:"priority"`
+	Refs            []string `yaml:"refs"`
+	Tag             bool     `yaml:"tag"`
+	ProtectedRef    bool     `yaml:"protected_ref"`
+	Projects        []string `yaml:"projects"`
+	JobTags         []string `yaml:"job_tags"`
+	PipelineSources []string `yaml:"pipeline_sources"`
+}
+
+// Quota limits are unlimited when zero.
+type Quota struct {
+	MaxConcurrent int   `yaml:"max_concurrent"`
+	MaxVCPU       int64 `yaml:"max_vcpu"`
+	MaxMemoryMB   int64 `yaml:"max_memory_mb"`
+}
+
+type APIConfig struct {
+	Enabled bool   `yaml:"enabled" env:"FIRERUNNER_API_ENABLED" default:"false"`
+	Token   string `yaml:"token" env:"FIRERUNNER_API_TOKEN"`
+}
+
+// CacheConfig keeps per-project cache directories under Dir and shares
+// them with job VMs over virtio-fs. Dir must be reachable at the same path
+// on every Flintlock host. Size limits are in MB and unlimited when zero;
+// least recently used caches are evicted first.
+type CacheConfig struct {
+	Enabled      bool   `yaml:"enabled" default:"false"`
+	Dir          string `yaml:"dir" default:"/var/lib/firerunner/cache"`
+	MountPoint   string `yaml:"mount_point" default:"/cache"`
+	MaxCacheMB   int64  `yaml:"max_cache_mb"`
+	MaxProjectMB int64  `yaml:"max_project_mb"`
+	MaxTotalMB   int64  `yaml:"max_total_mb"`
 }
 
 type MetricsConfig struct {
@@ -120,15 +390,86 @@ func (c *Config) applyEnvOverrides() error {
 	if secret := os.Getenv("GITLAB_WEBHOOK_SECRET"); secret != "" {
 		c.GitLab.WebhookSecret = secret
 	}
+	if runnerType := os.Getenv("GITLAB_RUNNER_TYPE"); runnerType != "" {
+		c.GitLab.RunnerType = runnerType
+	}
+	if os.Getenv("GITHUB_ENABLED") == "true" {
+		c.GitHub.Enabled = true
+	}
+	if apiURL := os.Getenv("GITHUB_API_URL"); apiURL != "" {
+		c.GitHub.APIURL = apiURL
+	}
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		c.GitHub.Token = token
+	}
+	if secret := os.Getenv("GITHUB_WEBHOOK_SECRET"); secret != "" {
+		c.GitHub.WebhookSecret = secret
+	}
+	if os.Getenv("FORGEJO_ENABLED") == "true" {
+		c.Forgejo.Enabled = true
+	}
+	if url := os.Getenv("FORGEJO_URL"); url != "" {
+		c.Forgejo.URL = url
+	}
+	if token := os.Getenv("FORGEJO_TOKEN"); token != "" {
+		c.Forgejo.Token = token
+	}
+	if secret := os.Getenv("FORGEJO_WEBHOOK_SECRET"); secret != "" {
+		c.Forgejo.WebhookSecret = secret
+	}
+	if runnerMode := os.Getenv("GITLAB_RUNNER_MODE"); runnerMode != "" {
+		c.GitLab.RunnerMode = runnerMode
+	}
+	if runnerToken := os.Getenv("GITLAB_RUNNER_TOKEN"); runnerToken != "" {
+		c.GitLab.RunnerToken = runnerToken
+	}
+	if groupID := os.Getenv("GITLAB_GROUP_ID"); groupID != "" {
+		if id, err := strconv.ParseInt(groupID, 10, 64); err == nil {
+			c.GitLab.GroupID = id
+		}
+	}
+
+	if os.Getenv("GITLAB_POLL_ENABLED") == "true" {
+		c.GitLab.PollEnabled = true
+	}
+	if eventStatePath := os.Getenv("FIRERUNNER_EVENT_STATE_PATH"); eventStatePath != "" {
+		c.GitLab.EventStatePath = eventStatePath
+	}
 
 	if endpoint := os.Getenv("FLINTLOCK_ENDPOINT"); endpoint != "" {
 		c.Flintlock.Endpoint = endpoint
 	}
+	if os.Getenv("FLINTLOCK_TLS_ENABLED") == "true" {
+		c.Flintlock.TLSEnabled = true
+	}
+	if caCert := os.Getenv("FLINTLOCK_TLS_CA_CERT"); caCert != "" {
+		c.Flintlock.TLSCACert = caCert
+	}
+	if clientCert := os.Getenv("FLINTLOCK_TLS_CLIENT_CERT"); clientCert != "" {
+		c.Flintlock.TLSClientCert = clientCert
+	}
+	if clientKey := os.Getenv("FLINTLOCK_TLS_CLIENT_KEY"); clientKey != "" {
+		c.Flintlock.TLSClientKey = clientKey
+	}
+	if serverName := os.Getenv("FLINTLOCK_TLS_SERVER_NAME"); serverName != "" {
+		c.Flintlock.TLSServerName = serverName
+	}
 
 	if host := os.Getenv("SERVER_HOST"); host != "" {
 		c.Server.Host = host
 	}
 
+	if statePath := os.Getenv("FIRERUNNER_STATE_PATH"); statePath != "" {
+		c.Scheduler.StatePath = statePath
+	}
+
+	if os.Getenv("FIRERUNNER_API_ENABLED") == "true" {
+		c.API.Enabled = true
+	}
+	if token := os.Getenv("FIRERUNNER_API_TOKEN"); token != "" {
+		c.API.Token = token
+	}
+
 	return nil
 }
 
@@ -139,9 +480,83 @@ func (c *Config) Validate() error {
 	if c.GitLab.Token == "" {
 		return fmt.Errorf("gitlab.token is required")
 	}
-	if c.Flintlock.Endpoint == "" {
+	switch c.GitLab.RunnerType {
+	case "", "project_type", "instance_type":
+	case "group_type":
+		if c.GitLab.GroupID < 1 {
+			return fmt.Errorf("gitlab.group_id is required when gitlab.runner_type is group_type")
+		}
+	default:
+		return fmt.Errorf("invalid gitlab.runner_type: %s (must be project_type, group_type or instance_type)", c.GitLab.RunnerType)
+	}
+	switch c.GitLab.RunnerMode {
+	case "", "ephemeral":
+	case "native":
+		if c.GitLab.RunnerToken == "" {
+			return fmt.Errorf("gitlab.runner_token is required when gitlab.runner_mode is native")
+		}
+		if !c.VM.CloudInitEnabled {
+			return fmt.Errorf("vm.cloud_init_enabled is required when gitlab.runner_mode is native")
+		}
+	default:
+		return fmt.Errorf("invalid gitlab.runner_mode: %s (must be ephemeral or native)", c.GitLab.RunnerMode)
+	}
+	if c.GitLab.MaxConcurrent < 0 {
+		return fmt.Errorf("gitlab.max_concurrent must be >= 0")
+	}
+	if c.GitLab.EventQueueSize < 0 {
+		return fmt.Errorf("gitlab.event_queue_size must be >= 0")
+	}
+	if c.GitLab.EventMaxAttempts < 0 {
+		return fmt.Errorf("gitlab.event_max_attempts must be >= 0")
+	}
+	if c.GitLab.EventMaxDeadLetters < 0 {
+		return fmt.Errorf("gitlab.event_max_dead_letters must be >= 0")
+	}
+	if c.GitLab.PollEnabled && len(c.GitLab.PollProjects) == 0 && len(c.GitLab.PollGroups) == 0 {
+		return fmt.Errorf("gitlab.poll_projects or gitlab.poll_groups is required when polling is enabled")
+	}
+	if c.GitHub.Enabled {
+		if c.GitHub.Token == "" {
+			return fmt.Errorf("github.token is required when github is enabled")
+		}
+		if c.GitHub.WebhookSecret == "" {
+			return fmt.Errorf("github.webhook_secret is required when github is enabled")
+		}
+	}
+	if c.Forgejo.Enabled {
+		if c.Forgejo.URL == "" {
+			return fmt.Errorf("forgejo.url is required when forgejo is enabled")
+		}
+		if c.Forgejo.Token == "" {
+			return fmt.Errorf("forgejo.token is required when forgejo is enabled")
+		}
+		if c.Forgejo.WebhookSecret == "" {
+			return fmt.Errorf("forgejo.webhook_secret is required when forgejo is enabled")
+		}
+	}
+	if c.Flintlock.Endpoint == "" && len(c.Flintlock.Hosts) == 0 {
 		return fmt.Errorf("flintlock.endpoint is required")
 	}
+	if c.Flintlock.Placement != "" && c.Flintlock.Placement != "binpack" && c.Flintlock.Placement != "spread" {
+		return fmt.Errorf("invalid flintlock.placement: %s (must be binpack or spread)", c.Flintlock.Placement)
+	}
+	hostNames := make(map[string]bool)
+	for i, host := range c.Flintlock.Hosts {
+		if host.Name == "" || host.Endpoint == "" {
+			return fmt.Errorf("flintlock.hosts[%d] requires name and endpoint", i)
+		}
+		if hostNames[host.Name] {
+			return fmt.Errorf("duplicate flintlock host name: %s", host.Name)
+		}
+		hostNames[host.Name] = true
+	}
+	if c.Flintlock.TLSEnabled && (c.Flintlock.TLSClientCert == "") != (c.Flintlock.TLSClientKey == "") {
+		return fmt.Errorf("flintlock.tls_client_cert and flintlock.tls_client_key must be set together")
+	}
+	if c.API.Enabled && c.API.Token == "" {
+		return fmt.Errorf("api.token is required when the admin API is enabled")
+	}
 	if c.Server.Port < 1 || c.Server.Port > 65535 {
 		return fmt.Errorf("invalid server.port: %d", c.Server.Port)
 	}
@@ -151,13 +566,249 @@ func (c *Config) Validate() error {
 	if c.VM.DefaultMemoryMB < 512 {
 		return fmt.Errorf("vm.default_memory_mb must be >= 512")
 	}
+	for name, class := range c.VM.SizeClasses {
+		if name == "" || strings.ContainsAny(name, "-:") || strings.ToLower(name) != name {
+			return fmt.Errorf("invalid vm.size_classes name %q (must be lowercase without - or :)", name)
+		}
+		if class.VCPU < 1 || class.MemoryMB < 512 || class.DiskGB < 0 {
+			return fmt.Errorf("invalid vm.size_classes[%s]: %dcpu/%dmb", name, class.VCPU, class.MemoryMB)
+		}
+	}
+	if err := c.VM.validateImages(); err != nil {
+		return err
+	}
+	if err := c.VM.Limits.validate("vm.limits"); err != nil {
+		return err
+	}
+	for path, limits := range c.VM.ProjectLimits {
+		if err := limits.validate(fmt.Sprintf("vm.project_limits[%s]", path)); err != nil {
+			return err
+		}
+	}
+	if err := c.VM.Network.validate(); err != nil {
+		return err
+	}
 	if c.Scheduler.QueueSize < 1 {
 		return fmt.Errorf("scheduler.queue_size must be >= 1")
 	}
 	if c.Scheduler.WorkerCount < 1 {
 		return fmt.Errorf("scheduler.worker_count must be >= 1")
 	}
+	if err := c.Scheduler.Quotas.validate(); err != nil {
+		return err
+	}
+	if c.Scheduler.Capacity.VCPU < 0 || c.Scheduler.Capacity.MemoryMB < 0 {
+		return fmt.Errorf("scheduler.capacity limits must be >= 0")
+	}
+	if c.Scheduler.Capacity.CPUOvercommit < 0 || c.Scheduler.Capacity.MemoryOvercommit < 0 {
+		return fmt.Errorf("scheduler.capacity overcommit ratios must be >= 0")
+	}
+	if err := c.Scheduler.Priority.validate(); err != nil {
+		return err
+	}
+	if c.Cache.Enabled {
+		if c.Cache.Dir == "" || !path.IsAbs(c.Cache.MountPoint) {
+			return fmt.Errorf("cache.dir and an absolute cache.mount_point are required when caching is enabled")
+		}
+		if c.Cache.MaxCacheMB < 0 || c.Cache.MaxProjectMB < 0 || c.Cache.MaxTotalMB < 0 {
+			return fmt.Errorf("cache size limits must be >= 0")
+		}
+	}
+	if c.Scheduler.EnablePrewarming {
+		// A VM booted ahead of its job has already read its cloud-init
+		// metadata, and Flintlock cannot change it afterwards, so it could
+		// never learn the credentials of the job's runner.
+		return fmt.Errorf("scheduler.enable_prewarming is not supported: a prewarmed VM cannot receive runner credentials")
+	}
+
+	return nil
+}
+
+func (q *QuotaConfig) validate() error {
+	if err := q.Default.validate("scheduler.quotas.default"); err != nil {
+		return err
+	}
+	for path, quota := range q.Projects {
+		if err := quota.validate(fmt.Sprintf("scheduler.quotas.projects[%s]", path)); err != nil {
+			return err
+		}
+	}
+	for path, quota := range q.Groups {
+		if err := quota.validate(fmt.Sprintf("scheduler.quotas.groups[%s]", path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q Quota) validate(name string) error {
+	if q.MaxConcurrent < 0 || q.MaxVCPU < 0 || q.MaxMemoryMB < 0 {
+		return fmt.Errorf("%s limits must be >= 0", name)
+	}
+	return nil
+}
+
+func (c *VMConfig) validateImages() error {
+	for _, pattern := range c.ImageAllowlist {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid vm.image_allowlist pattern %q: %w", pattern, err)
+		}
+	}
+
+	for name, image := range c.Images {
+		if name == "" || image.RootFS == "" || strings.ToLower(name) != name {
+			return fmt.Errorf("invalid vm.images entry %q (name must be lowercase and rootfs set)", name)
+		}
+		for _, ref := range []string{image.Kernel, image.RootFS} {
+			if ref != "" && !c.imageAllowed(ref) {
+				return fmt.Errorf("vm.images[%s]: image %s is not in vm.image_allowlist", name, ref)
+			}
+		}
+	}
+
+	for project, name := range c.ProjectImages {
+		if _, exists := c.Images[name]; !exists {
+			return fmt.Errorf("vm.project_images[%s]: unknown image %q", project, name)
+		}
+	}
+	return nil
+}
+
+func (c *VMConfig) imageAllowed(ref string) bool {
+	if len(c.ImageAllowlist) == 0 {
+		return true
+	}
+	for _, pattern := range c.ImageAllowlist {
+		if matched, _ := path.Match(pattern, ref); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *NetworkConfig) validate() error {
+	pooled := 0
+	for i, iface := range n.Interfaces {
+		if iface.DeviceID == "" {
+			return fmt.Errorf("vm.network.interfaces[%d] requires device_id", i)
+		}
+		switch iface.Type {
+		case "", InterfaceMACVTAP:
+			if iface.Bridge != "" {
+				return fmt.Errorf("vm.network.interfaces[%d]: bridge requires type tap", i)
+			}
+		case InterfaceTAP:
+		default:
+			return fmt.Errorf("invalid vm.network.interfaces[%d].type: %s (must be macvtap or tap)", i, iface.Type)
+		}
+		if iface.IPAM {
+			pooled++
+		}
+	}
+	if pooled > 1 {
+		return fmt.Errorf("only one vm.network interface can use the IPAM pool")
+	}
+
+	if n.IPAM.CIDR == "" {
+		if pooled > 0 {
+			return fmt.Errorf("vm.network.ipam.cidr is required when an interface uses the IPAM pool")
+		}
+	} else {
+		prefix, err := netip.ParsePrefix(n.IPAM.CIDR)
+		if err != nil || !prefix.Addr().Is4() || prefix.Bits() > 30 {
+			return fmt.Errorf("invalid vm.network.ipam.cidr %q (must be an IPv4 network of at least /30)", n.IPAM.CIDR)
+		}
+		if n.IPAM.Gateway != "" {
+			if addr, err := netip.ParseAddr(n.IPAM.Gateway); err != nil || !prefix.Contains(addr) {
+				return fmt.Errorf("invalid vm.network.ipam.gateway %q (must be in %s)", n.IPAM.Gateway, n.IPAM.CIDR)
+			}
+		}
+		for _, reserved := range n.IPAM.Reserved {
+			if _, err := netip.ParseAddr(reserved); err != nil {
+				return fmt.Errorf("invalid vm.network.ipam.reserved address %q", reserved)
+			}
+		}
+		for _, nameserver := range n.IPAM.Nameservers {
+			if _, err := netip.ParseAddr(nameserver); err != nil {
+				return fmt.Errorf("invalid vm.network.ipam.nameservers address %q", nameserver)
+			}
+		}
+	}
+
+	e := &n.Egress
+	if err := validEgress("vm.network.egress.default", e.Default); err != nil {
+		return err
+	}
+	if err := validEgress("vm.network.egress.forks", e.Forks); err != nil {
+		return err
+	}
+	for project, policy := range e.Projects {
+		if err := validEgress(fmt.Sprintf("vm.network.egress.projects[%s]", project), policy); err != nil {
+			return err
+		}
+	}
+	for policy := range e.Bridges {
+		if policy == "" {
+			return fmt.Errorf("invalid vm.network.egress.bridges policy %q", policy)
+		}
+		if err := validEgress("vm.network.egress.bridges", policy); err != nil {
+			return err
+		}
+	}
+
+	// Only a bridge firewall enforces a policy; the guest could ignore it.
+	tap := false
+	for _, iface := range n.Interfaces {
+		if iface.Type == InterfaceTAP {
+			tap = true
+		}
+	}
+	used := map[string]string{"vm.network.egress.default": e.Default, "vm.network.egress.forks": e.Forks}
+	for project, policy := range e.Projects {
+		used[fmt.Sprintf("vm.network.egress.projects[%s]", project)] = policy
+	}
+	for name, policy := range used {
+		if policy == "" || policy == EgressAllowAll {
+			continue
+		}
+		if !tap {
+			return fmt.Errorf("%s: %s requires a tap interface in vm.network.interfaces", name, policy)
+		}
+		if e.Bridges[policy] == "" {
+			return fmt.Errorf("%s: %s requires vm.network.egress.bridges[%s]", name, policy, policy)
+		}
+	}
+	return nil
+}
+
+func validEgress(name, policy string) error {
+	switch policy {
+	case "", EgressAllowAll, EgressGitLabOnly, EgressDeny:
+		return nil
+	}
+	return fmt.Errorf("invalid %s: %s (must be allow-all, gitlab-only or deny)", name, policy)
+}
+
+func (l VMLimits) validate(name string) error {
+	if l.MaxVCPU < 0 || l.MaxMemoryMB < 0 || l.MaxDiskGB < 0 {
+		return fmt.Errorf("%s must be >= 0", name)
+	}
+	return nil
+}
 
+func (p *PriorityConfig) validate() error {
+	if p.AgingInterval < 0 {
+		return fmt.Errorf("scheduler.priority.aging_interval must be >= 0")
+	}
+	for i, rule := range p.Rules {
+		for _, patterns := range [][]string{rule.Refs, rule.Projects, rule.JobTags} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return fmt.Errorf("invalid pattern %q in scheduler.priority.rules[%d]: %w", pattern, i, err)
+				}
+			}
+		}
+	}
 	return nil
 }
 
@@ -173,12 +824,26 @@ func Default() *Config {
 			RunnerTags:    []string{"firecracker", "microvm"},
 			RunnerTimeout: 1 * time.Hour,
 			MaxConcurrent: 10,
+			RunnerType:    "project_type",
+			RunnerMode:    "ephemeral",
+
+			EventQueueSize:      1000,
+			EventMaxAttempts:    3,
+			EventMaxDeadLetters: 1000,
+			PollInterval:        30 * time.Second,
+		},
+		GitHub: GitHubConfig{
+			APIURL:        "https://api.github.com",
+			RunnerGroupID: 1,
 		},
 		Flintlock: FlintlockConfig{
 			Endpoint:      "localhost:9090",
 			Timeout:       30 * time.Second,
 			RetryAttempts: 3,
 			RetryDelay:    1 * time.Second,
+
+			Placement:           "binpack",
+			HealthCheckInterval: 30 * time.Second,
 		},
 		VM: VMConfig{
 			DefaultVCPU:      2,
@@ -188,6 +853,23 @@ func Default() *Config {
 			NetworkInterface: "eth0",
 			MetadataService:  true,
 			CloudInitEnabled: true,
+			BootTimeout:      60 * time.Second,
+			IPResolveTimeout: 30 * time.Second,
+			RunnerExecutor:   "shell",
+			SizeClasses: map[string]SizeClass{
+				"small":  {VCPU: 2, MemoryMB: 4096},
+				"medium": {VCPU: 4, MemoryMB: 8192},
+				"large":  {VCPU: 8, MemoryMB: 16384},
+				"xlarge": {VCPU: 16, MemoryMB: 32768},
+			},
+			Limits: VMLimits{
+				MaxVCPU:     32,
+				MaxMemoryMB: 131072,
+				MaxDiskGB:   500,
+			},
+			Network: NetworkConfig{
+				Egress: EgressConfig{Default: EgressAllowAll},
+			},
 		},
 		Scheduler: SchedulerConfig{
 			QueueSize:         1000,
@@ -196,6 +878,21 @@ func Default() *Config {
 			CleanupInterval:   5 * time.Minute,
 			VMStartTimeout:    60 * time.Second,
 			VMShutdownTimeout: 30 * time.Second,
+
+			ReconcileInterval:    10 * time.Minute,
+			ReconcileGracePeriod: 5 * time.Minute,
+
+			Priority: PriorityConfig{
+				AgingInterval: time.Minute,
+			},
+			Capacity: CapacityConfig{
+				CPUOvercommit:    1.0,
+				MemoryOvercommit: 1.0,
+			},
+		},
+		Cache: CacheConfig{
+			Dir:        "/var/lib/firerunner/cache",
+			MountPoint: "/cache",
 		},
 		Metrics: MetricsConfig{
 			Enabled: true,
@@ -4,6 +4,8 @@ import (
 	"os"
 	"testing"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestDefault(t *testing.T) {
@@ -109,6 +111,383 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestValidate_Prewarming(t *testing.T) {
+	cfg := Default()
+	cfg.GitLab.URL = "https://gitlab.com"
+	cfg.GitLab.Token = "test-token"
+	cfg.Scheduler.EnablePrewarming = true
+	cfg.Scheduler.PrewarmPoolSize = 2
+
+	for _, cloudInit := range []bool{true, false} {
+		cfg.VM.CloudInitEnabled = cloudInit
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate() should reject prewarming with cloud_init_enabled=%v", cloudInit)
+		}
+	}
+}
+
+func TestValidate_RunnerType(t *testing.T) {
+	cfg := Default()
+	cfg.GitLab.URL = "https://gitlab.com"
+	cfg.GitLab.Token = "test-token"
+
+	cfg.GitLab.RunnerType = "shared"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should reject an unknown runner type")
+	}
+
+	cfg.GitLab.RunnerType = "group_type"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should require group_id for group runners")
+	}
+
+	cfg.GitLab.GroupID = 7
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() failed: %v", err)
+	}
+}
+
+func TestValidate_Polling(t *testing.T) {
+	cfg := Default()
+	cfg.GitLab.URL = "https://gitlab.com"
+	cfg.GitLab.Token = "test-token"
+	cfg.GitLab.PollEnabled = true
+
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should require projects or groups when polling is enabled")
+	}
+
+	cfg.GitLab.PollGroups = []int64{7}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() failed: %v", err)
+	}
+}
+
+func TestValidate_RunnerMode(t *testing.T) {
+	cfg := Default()
+	cfg.GitLab.URL = "https://gitlab.com"
+	cfg.GitLab.Token = "test-token"
+
+	cfg.GitLab.RunnerMode = "shared"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should reject an unknown runner mode")
+	}
+
+	cfg.GitLab.RunnerMode = "native"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should require a runner token in native mode")
+	}
+
+	cfg.GitLab.RunnerToken = "glrt-token"
+	cfg.VM.CloudInitEnabled = false
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should require cloud-init in native mode")
+	}
+
+	cfg.VM.CloudInitEnabled = true
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() failed: %v", err)
+	}
+}
+
+func TestValidate_GitHub(t *testing.T) {
+	cfg := Default()
+	cfg.GitLab.URL = "https://gitlab.com"
+	cfg.GitLab.Token = "test-token"
+	cfg.GitHub.Enabled = true
+
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should require a GitHub token")
+	}
+
+	cfg.GitHub.Token = "ghp-token"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should require a GitHub webhook secret")
+	}
+
+	cfg.GitHub.WebhookSecret = "secret"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() failed: %v", err)
+	}
+}
+
+func TestValidate_Forgejo(t *testing.T) {
+	cfg := Default()
+	cfg.GitLab.URL = "https://gitlab.com"
+	cfg.GitLab.Token = "test-token"
+	cfg.Forgejo.Enabled = true
+
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should require a Forgejo URL")
+	}
+
+	cfg.Forgejo.URL = "https://forgejo.example.com"
+	cfg.Forgejo.Token = "api-token"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should require a Forgejo webhook secret")
+	}
+
+	cfg.Forgejo.WebhookSecret = "secret"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() failed: %v", err)
+	}
+}
+
+func TestValidate_Quotas(t *testing.T) {
+	cfg := Default()
+	cfg.GitLab.URL = "https://gitlab.com"
+	cfg.GitLab.Token = "test-token"
+	cfg.Scheduler.Quotas = QuotaConfig{
+		Default:  Quota{MaxConcurrent: 5},
+		Projects: map[string]Quota{"acme/monorepo": {MaxConcurrent: 20, MaxVCPU: 64}},
+		Groups:   map[string]Quota{"acme": {MaxMemoryMB: 262144}},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() failed: %v", err)
+	}
+
+	cfg.Scheduler.Quotas.Groups["acme"] = Quota{MaxVCPU: -1}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should reject negative quota limits")
+	}
+}
+
+func TestValidate_Priority(t *testing.T) {
+	cfg := Default()
+	cfg.GitLab.URL = "https://gitlab.com"
+	cfg.GitLab.Token = "test-token"
+	cfg.Scheduler.Priority.Rules = []PriorityRule{
+		{Priority: 100, Refs: []string{"release/*"}, ProtectedRef: true},
+		{Priority: 50, JobTags: []string{"priority-high"}},
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() failed: %v", err)
+	}
+
+	cfg.Scheduler.Priority.Rules[1].Projects = []string{"acme/[web"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should reject malformed patterns")
+	}
+}
+
+func TestFlintlockConfig_Capacity(t *testing.T) {
+	cfg := FlintlockConfig{Hosts: []FlintlockHost{
+		{Name: "a", VCPU: 32, MemoryMB: 131072},
+		{Name: "b", VCPU: 16},
+	}}
+
+	vcpu, memoryMB := cfg.Capacity()
+	if vcpu != 48 {
+		t.Errorf("Expected 48 vCPUs, got %d", vcpu)
+	}
+	if memoryMB != 0 {
+		t.Errorf("Expected memory to be unlimited when a host does not declare it, got %d", memoryMB)
+	}
+}
+
+func TestValidate_VMSizes(t *testing.T) {
+	cfg := Default()
+	cfg.GitLab.URL = "https://gitlab.com"
+	cfg.GitLab.Token = "test-token"
+	cfg.VM.Images = map[string]VMImage{"ubuntu-24.04": {RootFS: "ghcr.io/firerunner/ubuntu:24.04"}}
+	cfg.VM.ProjectLimits = map[string]VMLimits{"acme/monorepo": {MaxVCPU: 64}}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() failed: %v", err)
+	}
+
+	cfg.VM.SizeClasses["extra-large"] = SizeClass{VCPU: 32, MemoryMB: 65536}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should reject size class names with a dash")
+	}
+	delete(cfg.VM.SizeClasses, "extra-large")
+
+	cfg.VM.SizeClasses["tiny"] = SizeClass{VCPU: 1, MemoryMB: 128}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should reject size classes below 512 MB")
+	}
+	delete(cfg.VM.SizeClasses, "tiny")
+
+	cfg.VM.ProjectLimits["acme"] = VMLimits{MaxDiskGB: -1}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should reject negative limits")
+	}
+}
+
+func TestValidate_Images(t *testing.T) {
+	cfg := Default()
+	cfg.GitLab.URL = "https://gitlab.com"
+	cfg.GitLab.Token = "test-token"
+	cfg.VM.Images = map[string]VMImage{
+		"android": {Kernel: "ghcr.io/firerunner/kernel:6.1", RootFS: "ghcr.io/firerunner/android-sdk:34"},
+	}
+	cfg.VM.ProjectImages = map[string]string{"mobile": "android"}
+	cfg.VM.ImageAllowlist = []string{"ghcr.io/firerunner/*"}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() failed: %v", err)
+	}
+
+	cfg.VM.ProjectImages["web"] = "ubuntu"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should reject project images missing from the catalog")
+	}
+	delete(cfg.VM.ProjectImages, "web")
+
+	cfg.VM.Images["cuda"] = VMImage{RootFS: "docker.io/someone/cuda:latest"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should reject images outside the allowlist")
+	}
+}
+
+func TestVMImage_UnmarshalYAML(t *testing.T) {
+	var vm VMConfig
+	data := `
+images:
+  ubuntu: ghcr.io/firerunner/ubuntu:24.04
+  android:
+    kernel: ghcr.io/firerunner/kernel:6.1
+    rootfs: ghcr.io/firerunner/android-sdk:34
+`
+	if err := yaml.Unmarshal([]byte(data), &vm); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+
+	if got := vm.Images["ubuntu"]; got != (VMImage{RootFS: "ghcr.io/firerunner/ubuntu:24.04"}) {
+		t.Errorf("Unexpected plain image: %+v", got)
+	}
+	if got := vm.Images["android"]; got.Kernel != "ghcr.io/firerunner/kernel:6.1" || got.RootFS != "ghcr.io/firerunner/android-sdk:34" {
+		t.Errorf("Unexpected image: %+v", got)
+	}
+}
+
+func TestValidate_Cache(t *testing.T) {
+	cfg := Default()
+	cfg.GitLab.URL = "https://gitlab.com"
+	cfg.GitLab.Token = "test-token"
+	cfg.Cache.Enabled = true
+	cfg.Cache.MaxProjectMB = 10240
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() failed: %v", err)
+	}
+
+	cfg.Cache.MountPoint = "cache"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should require an absolute mount point")
+	}
+
+	cfg.Cache.MountPoint = "/cache"
+	cfg.Cache.MaxTotalMB = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() should reject negative size limits")
+	}
+}
+
+func TestValidate_Network(t *testing.T) {
+	tests := []struct {
+		name    string
+		network NetworkConfig
+		wantErr bool
+	}{
+		{
+			name:    "defaults",
+			network: NetworkConfig{},
+		},
+		{
+			name: "tap with ipam and egress",
+			network: NetworkConfig{
+				Interfaces: []NetworkInterface{
+					{DeviceID: "eth0", Type: InterfaceTAP, Bridge: "br-ci", IPAM: true},
+					{DeviceID: "eth1"},
+				},
+				IPAM: IPAMConfig{CIDR: "10.100.0.0/24", Gateway: "10.100.0.1", Nameservers: []string{"10.100.0.1"}},
+				Egress: EgressConfig{
+					Default:  EgressAllowAll,
+					Forks:    EgressGitLabOnly,
+					Projects: map[string]string{"acme/secret": EgressDeny},
+					Bridges:  map[string]string{EgressGitLabOnly: "br-gitlab", EgressDeny: "br-isolated"},
+				},
+			},
+		},
+		{
+			name: "egress policy without bridge",
+			network: NetworkConfig{
+				Interfaces: []NetworkInterface{{DeviceID: "eth0", Type: InterfaceTAP}},
+				Egress: EgressConfig{
+					Projects: map[string]string{"acme/secret": EgressDeny},
+					Bridges:  map[string]string{EgressGitLabOnly: "br-gitlab"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "egress policy without tap interface",
+			network: NetworkConfig{
+				Egress: EgressConfig{
+					Forks:   EgressGitLabOnly,
+					Bridges: map[string]string{EgressGitLabOnly: "br-gitlab"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "unknown interface type",
+			network: NetworkConfig{Interfaces: []NetworkInterface{{DeviceID: "eth0", Type: "veth"}}},
+			wantErr: true,
+		},
+		{
+			name:    "bridge on macvtap",
+			network: NetworkConfig{Interfaces: []NetworkInterface{{DeviceID: "eth0", Bridge: "br0"}}},
+			wantErr: true,
+		},
+		{
+			name:    "ipam without pool",
+			network: NetworkConfig{Interfaces: []NetworkInterface{{DeviceID: "eth0", IPAM: true}}},
+			wantErr: true,
+		},
+		{
+			name: "two pooled interfaces",
+			network: NetworkConfig{
+				Interfaces: []NetworkInterface{{DeviceID: "eth0", IPAM: true}, {DeviceID: "eth1", IPAM: true}},
+				IPAM:       IPAMConfig{CIDR: "10.100.0.0/24"},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "ipv6 pool",
+			network: NetworkConfig{IPAM: IPAMConfig{CIDR: "fd00::/64"}},
+			wantErr: true,
+		},
+		{
+			name:    "gateway outside pool",
+			network: NetworkConfig{IPAM: IPAMConfig{CIDR: "10.100.0.0/24", Gateway: "10.200.0.1"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown egress policy",
+			network: NetworkConfig{Egress: EgressConfig{Projects: map[string]string{"acme": "internal"}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.GitLab.URL = "https://gitlab.com"
+			cfg.GitLab.Token = "test-token"
+			cfg.VM.Network = tt.network
+
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestApplyEnvOverrides(t *testing.T) {
 	// Set test environment variables
 	os.Setenv("GITLAB_URL", "https://test.gitlab.com")
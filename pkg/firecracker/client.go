@@ -3,6 +3,7 @@ package firecracker
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"google.golang.org/grpc"
@@ -12,6 +13,7 @@ import (
 	mvmv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
 	"github.com/liquidmetal-dev/flintlock/api/types"
 
+	"github.com/ismoilovdevml/firerunner/pkg/cloudinit"
 	"github.com/ismoilovdevml/firerunner/pkg/config"
 )
 
@@ -22,32 +24,67 @@ type Client struct {
 }
 
 type MicroVMSpec struct {
-	ID               string
-	Namespace        string
-	VCPU             int64
-	MemoryMB         int64
-	KernelImage      string
-	RootFSImage      string
-	NetworkInterface string
-	Metadata         map[string]string
-	Labels           map[string]string
+	ID           string
+	Namespace    string
+	VCPU         int64
+	MemoryMB     int64
+	KernelImage  string
+	RootFSImage  string
+	Interfaces   []Interface
+	Metadata     map[string]string
+	Labels       map[string]string
+	HostSelector map[string]string
+
+	// RootVolumeGB is the size of the root volume; zero keeps the size of
+	// the image.
+	RootVolumeGB int64
+	Volumes      []Volume
+}
+
+// Interface is a guest network interface. Address is nil for interfaces
+// configured by DHCP.
+type Interface struct {
+	DeviceID string
+	Type     string // config.InterfaceMACVTAP or config.InterfaceTAP
+	Bridge   string
+	MAC      string
+	Address  *Address
+}
+
+// Volume is a host directory shared with the guest over virtio-fs.
+type Volume struct {
+	ID         string
+	HostPath   string
+	MountPoint string
+	ReadOnly   bool
 }
 
 type MicroVM struct {
 	ID        string
 	Namespace string
+	Host      string
 	State     string
 	IPAddress string
-	CreatedAt time.Time
-	Metadata  map[string]string
-	Labels    map[string]string
+	// MACAddress and StaticAddress describe the first guest interface and
+	// are what IPResolver implementations work from.
+	MACAddress    string
+	StaticAddress string
+	VCPU          int64
+	MemoryMB      int64
+	CreatedAt     time.Time
+	Metadata      map[string]string
+	Labels        map[string]string
 }
 
 func NewClient(cfg *config.FlintlockConfig) (*Client, error) {
 	var opts []grpc.DialOption
 
 	if cfg.TLSEnabled {
-		return nil, fmt.Errorf("TLS not yet implemented")
+		creds, err := newTLSCredentials(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
 	} else {
 		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	}
@@ -94,16 +131,17 @@ func (c *Client) CreateMicroVM(ctx context.Context, spec *MicroVMSpec) (*MicroVM
 					ContainerSource: &rootFSImage,
 				},
 			},
-			Interfaces: []*types.NetworkInterface{
-				{
-					DeviceId: spec.NetworkInterface,
-					Type:     types.NetworkInterface_MACVTAP,
-				},
-			},
-			Metadata: spec.Metadata,
+			Interfaces: networkInterfaces(spec.Interfaces),
+			Metadata:   spec.Metadata,
 		},
 	}
 
+	if spec.RootVolumeGB > 0 {
+		size := int32(spec.RootVolumeGB * 1024)
+		req.Microvm.RootVolume.SizeInMb = &size
+	}
+	req.Microvm.AdditionalVolumes = additionalVolumes(spec.Volumes)
+
 	resp, err := c.client.CreateMicroVM(ctx, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create microVM: %w", err)
@@ -113,15 +151,87 @@ func (c *Client) CreateMicroVM(ctx context.Context, spec *MicroVMSpec) (*MicroVM
 		ID:        resp.Microvm.Spec.Id,
 		Namespace: resp.Microvm.Spec.Namespace,
 		State:     convertState(resp.Microvm.Status.State),
+		VCPU:      int64(resp.Microvm.Spec.Vcpu),
+		MemoryMB:  int64(resp.Microvm.Spec.MemoryInMb),
 		CreatedAt: time.Now(),
-		Metadata:  resp.Microvm.Spec.Metadata,
+		Metadata:  cloudinit.Redact(resp.Microvm.Spec.Metadata),
 		Labels:    spec.Labels,
-		IPAddress: "",
 	}
+	populateNetwork(vm, resp.Microvm)
 
 	return vm, nil
 }
 
+func networkInterfaces(interfaces []Interface) []*types.NetworkInterface {
+	result := make([]*types.NetworkInterface, 0, len(interfaces))
+	for _, iface := range interfaces {
+		ni := &types.NetworkInterface{
+			DeviceId: iface.DeviceID,
+			Type:     types.NetworkInterface_MACVTAP,
+		}
+		if iface.Type == config.InterfaceTAP {
+			ni.Type = types.NetworkInterface_TAP
+		}
+		if iface.Bridge != "" {
+			bridge := iface.Bridge
+			ni.Overrides = &types.NetworkOverrides{BridgeName: &bridge}
+		}
+		if iface.MAC != "" {
+			mac := iface.MAC
+			ni.GuestMac = &mac
+		}
+		if iface.Address != nil {
+			ni.Address = &types.StaticAddress{
+				Address:     iface.Address.CIDR,
+				Nameservers: iface.Address.Nameservers,
+			}
+			if iface.Address.Gateway != "" {
+				gateway := iface.Address.Gateway
+				ni.Address.Gateway = &gateway
+			}
+		}
+		result = append(result, ni)
+	}
+	return result
+}
+
+func additionalVolumes(volumes []Volume) []*types.Volume {
+	result := make([]*types.Volume, 0, len(volumes))
+	for _, volume := range volumes {
+		hostPath, mountPoint := volume.HostPath, volume.MountPoint
+		result = append(result, &types.Volume{
+			Id:         volume.ID,
+			IsReadOnly: volume.ReadOnly,
+			MountPoint: &mountPoint,
+			Source: &types.VolumeSource{
+				VirtiofsSource: &hostPath,
+			},
+		})
+	}
+	return result
+}
+
+func populateNetwork(vm *MicroVM, mvm *types.MicroVM) {
+	if len(mvm.Spec.Interfaces) == 0 {
+		return
+	}
+
+	iface := mvm.Spec.Interfaces[0]
+	if iface.Address != nil {
+		vm.StaticAddress = iface.Address.Address
+		vm.IPAddress = strings.SplitN(iface.Address.Address, "/", 2)[0]
+	}
+	if iface.GuestMac != nil {
+		vm.MACAddress = *iface.GuestMac
+	}
+
+	if mvm.Status != nil {
+		if status, ok := mvm.Status.NetworkInterfaces[iface.DeviceId]; ok && status.MacAddress != "" {
+			vm.MACAddress = status.MacAddress
+		}
+	}
+}
+
 func convertState(state types.MicroVMStatus_MicroVMState) string {
 	switch state {
 	case types.MicroVMStatus_PENDING:
@@ -172,9 +282,11 @@ func (c *Client) GetMicroVM(ctx context.Context, namespace, id string) (*MicroVM
 		ID:        resp.Microvm.Spec.Id,
 		Namespace: resp.Microvm.Spec.Namespace,
 		State:     convertState(resp.Microvm.Status.State),
-		Metadata:  resp.Microvm.Spec.Metadata,
-		IPAddress: "",
+		VCPU:      int64(resp.Microvm.Spec.Vcpu),
+		MemoryMB:  int64(resp.Microvm.Spec.MemoryInMb),
+		Metadata:  cloudinit.Redact(resp.Microvm.Spec.Metadata),
 	}
+	populateNetwork(vm, resp.Microvm)
 
 	if resp.Microvm.Spec.CreatedAt != nil {
 		vm.CreatedAt = resp.Microvm.Spec.CreatedAt.AsTime()
@@ -202,9 +314,11 @@ func (c *Client) ListMicroVMs(ctx context.Context, namespace string) ([]*MicroVM
 			ID:        mvm.Spec.Id,
 			Namespace: mvm.Spec.Namespace,
 			State:     convertState(mvm.Status.State),
-			Metadata:  mvm.Spec.Metadata,
-			IPAddress: "",
+			VCPU:      int64(mvm.Spec.Vcpu),
+			MemoryMB:  int64(mvm.Spec.MemoryInMb),
+			Metadata:  cloudinit.Redact(mvm.Spec.Metadata),
 		}
+		populateNetwork(vm, mvm)
 
 		if mvm.Spec.CreatedAt != nil {
 			vm.CreatedAt = mvm.Spec.CreatedAt.AsTime()
@@ -0,0 +1,418 @@
+package firecracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+)
+
+const (
+	PlacementBinpack = "binpack"
+	PlacementSpread  = "spread"
+)
+
+type HostStatus struct {
+	Name              string            `json:"name"`
+	Endpoint          string            `json:"endpoint"`
+	Healthy           bool              `json:"healthy"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	CapacityVCPU      int64             `json:"capacity_vcpu"`
+	CapacityMemoryMB  int64             `json:"capacity_memory_mb"`
+	AllocatedVCPU     int64             `json:"allocated_vcpu"`
+	AllocatedMemoryMB int64             `json:"allocated_memory_mb"`
+	VMs               int               `json:"vms"`
+	LastError         string            `json:"last_error,omitempty"`
+}
+
+type poolHost struct {
+	config    config.FlintlockHost
+	client    FlintlockClient
+	healthy   bool
+	vcpu      int64
+	memoryMB  int64
+	vmCount   int
+	lastError error
+}
+
+// freeRatio is the smallest remaining share of the host's vCPU or memory
+// capacity once the given request is placed. Hosts without declared capacity
+// count as entirely free.
+func (h *poolHost) freeRatio(vcpu, memoryMB int64) float64 {
+	ratio := 1.0
+	if h.config.VCPU > 0 {
+		ratio = float64(h.config.VCPU-h.vcpu-vcpu) / float64(h.config.VCPU)
+	}
+	if h.config.MemoryMB > 0 {
+		memRatio := float64(h.config.MemoryMB-h.memoryMB-memoryMB) / float64(h.config.MemoryMB)
+		if memRatio < ratio {
+			ratio = memRatio
+		}
+	}
+	return ratio
+}
+
+func (h *poolHost) fits(vcpu, memoryMB int64) bool {
+	if h.config.VCPU > 0 && h.vcpu+vcpu > h.config.VCPU {
+		return false
+	}
+	if h.config.MemoryMB > 0 && h.memoryMB+memoryMB > h.config.MemoryMB {
+		return false
+	}
+	return true
+}
+
+func (h *poolHost) matches(selector map[string]string) bool {
+	for k, v := range selector {
+		if h.config.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+type vmPlacement struct {
+	host     *poolHost
+	vcpu     int64
+	memoryMB int64
+}
+
+// HostPool spreads microVMs over several Flintlock hosts. It satisfies
+// FlintlockClient so the Manager does not need to know how many hosts exist.
+type HostPool struct {
+	hosts     []*poolHost
+	placement string
+	vms       map[string]*vmPlacement
+	mu        sync.Mutex
+	logger    *logrus.Logger
+
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
+	wg           sync.WaitGroup
+}
+
+func NewHostPool(cfg *config.FlintlockConfig, logger *logrus.Logger) (*HostPool, error) {
+	hosts := make([]*poolHost, 0, len(cfg.Hosts))
+	for _, hostCfg := range cfg.Hosts {
+		clientCfg := *cfg
+		clientCfg.Endpoint = hostCfg.Endpoint
+
+		client, err := NewClient(&clientCfg)
+		if err != nil {
+			for _, h := range hosts {
+				h.client.Close()
+			}
+			return nil, fmt.Errorf("failed to create client for Flintlock host %s: %w", hostCfg.Name, err)
+		}
+
+		hosts = append(hosts, &poolHost{config: hostCfg, client: client, healthy: true})
+	}
+
+	return newHostPool(hosts, cfg.Placement, logger), nil
+}
+
+func newHostPool(hosts []*poolHost, placement string, logger *logrus.Logger) *HostPool {
+	if placement == "" {
+		placement = PlacementBinpack
+	}
+
+	return &HostPool{
+		hosts:      hosts,
+		placement:  placement,
+		vms:        make(map[string]*vmPlacement),
+		logger:     logger,
+		shutdownCh: make(chan struct{}),
+	}
+}
+
+func (p *HostPool) StartHealthChecks(interval time.Duration) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.checkHealth(context.Background())
+			case <-p.shutdownCh:
+				return
+			}
+		}
+	}()
+}
+
+func (p *HostPool) CreateMicroVM(ctx context.Context, spec *MicroVMSpec) (*MicroVM, error) {
+	candidates := p.candidates(spec)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no healthy Flintlock host has capacity for %d vCPU / %d MB", spec.VCPU, spec.MemoryMB)
+	}
+
+	var errs []error
+	for _, host := range candidates {
+		if !p.reserve(host, spec.ID, spec.VCPU, spec.MemoryMB) {
+			continue
+		}
+
+		vm, err := host.client.CreateMicroVM(ctx, spec)
+		if err != nil {
+			p.release(spec.ID)
+			p.logger.WithError(err).WithField("host", host.config.Name).Warn("MicroVM creation failed, trying next host")
+			errs = append(errs, fmt.Errorf("%s: %w", host.config.Name, err))
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		vm.Host = host.config.Name
+		return vm, nil
+	}
+
+	return nil, fmt.Errorf("failed to place microVM on any host: %w", errors.Join(errs...))
+}
+
+func (p *HostPool) DeleteMicroVM(ctx context.Context, namespace, id string) error {
+	host, err := p.locate(ctx, namespace, id)
+	if err != nil {
+		return err
+	}
+
+	if err := host.client.DeleteMicroVM(ctx, namespace, id); err != nil {
+		return err
+	}
+
+	p.release(id)
+	return nil
+}
+
+func (p *HostPool) GetMicroVM(ctx context.Context, namespace, id string) (*MicroVM, error) {
+	host, err := p.locate(ctx, namespace, id)
+	if err != nil {
+		return nil, err
+	}
+
+	vm, err := host.client.GetMicroVM(ctx, namespace, id)
+	if err != nil {
+		return nil, err
+	}
+	vm.Host = host.config.Name
+	return vm, nil
+}
+
+// ListMicroVMs lists the VMs of every healthy host. Hosts that cannot be
+// reached are skipped, so the result may be incomplete; it only fails when
+// no host answered.
+func (p *HostPool) ListMicroVMs(ctx context.Context, namespace string) ([]*MicroVM, error) {
+	all := make([]*MicroVM, 0)
+	hosts := p.healthyHosts()
+	var errs []error
+	for _, host := range hosts {
+		vms, err := host.client.ListMicroVMs(ctx, namespace)
+		if err != nil {
+			p.logger.WithError(err).WithField("host", host.config.Name).Warn("Failed to list microVMs, skipping host")
+			errs = append(errs, fmt.Errorf("%s: %w", host.config.Name, err))
+			continue
+		}
+		for _, vm := range vms {
+			vm.Host = host.config.Name
+			p.record(host, vm.ID, vm.VCPU, vm.MemoryMB)
+		}
+		all = append(all, vms...)
+	}
+	if len(hosts) > 0 && len(errs) == len(hosts) {
+		return nil, fmt.Errorf("failed to list microVMs on any host: %w", errors.Join(errs...))
+	}
+	return all, nil
+}
+
+func (p *HostPool) WaitForMicroVM(ctx context.Context, namespace, id string, state string, timeout time.Duration) error {
+	host, err := p.locate(ctx, namespace, id)
+	if err != nil {
+		return err
+	}
+	return host.client.WaitForMicroVM(ctx, namespace, id, state, timeout)
+}
+
+func (p *HostPool) Health(ctx context.Context) error {
+	p.checkHealth(ctx)
+
+	if len(p.healthyHosts()) == 0 {
+		return fmt.Errorf("no healthy Flintlock hosts")
+	}
+	return nil
+}
+
+func (p *HostPool) Close() error {
+	p.shutdownOnce.Do(func() {
+		close(p.shutdownCh)
+	})
+	p.wg.Wait()
+
+	var errs []error
+	for _, host := range p.hosts {
+		if err := host.client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *HostPool) HostStats() []HostStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]HostStatus, 0, len(p.hosts))
+	for _, host := range p.hosts {
+		lastError := ""
+		if host.lastError != nil {
+			lastError = host.lastError.Error()
+		}
+		stats = append(stats, HostStatus{
+			Name:              host.config.Name,
+			Endpoint:          host.config.Endpoint,
+			Healthy:           host.healthy,
+			Labels:            host.config.Labels,
+			CapacityVCPU:      host.config.VCPU,
+			CapacityMemoryMB:  host.config.MemoryMB,
+			AllocatedVCPU:     host.vcpu,
+			AllocatedMemoryMB: host.memoryMB,
+			VMs:               host.vmCount,
+			LastError:         lastError,
+		})
+	}
+	return stats
+}
+
+func (p *HostPool) checkHealth(ctx context.Context) {
+	for _, host := range p.hosts {
+		err := host.client.Health(ctx)
+
+		p.mu.Lock()
+		wasHealthy := host.healthy
+		host.healthy = err == nil
+		host.lastError = err
+		p.mu.Unlock()
+
+		if wasHealthy && err != nil {
+			p.logger.WithError(err).WithField("host", host.config.Name).Warn("Flintlock host marked unhealthy")
+		} else if !wasHealthy && err == nil {
+			p.logger.WithField("host", host.config.Name).Info("Flintlock host recovered")
+		}
+	}
+}
+
+func (p *HostPool) candidates(spec *MicroVMSpec) []*poolHost {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hosts := make([]*poolHost, 0, len(p.hosts))
+	for _, host := range p.hosts {
+		if host.healthy && host.matches(spec.HostSelector) && host.fits(spec.VCPU, spec.MemoryMB) {
+			hosts = append(hosts, host)
+		}
+	}
+
+	sort.SliceStable(hosts, func(i, j int) bool {
+		ri := hosts[i].freeRatio(spec.VCPU, spec.MemoryMB)
+		rj := hosts[j].freeRatio(spec.VCPU, spec.MemoryMB)
+		if p.placement == PlacementSpread {
+			return ri > rj
+		}
+		return ri < rj
+	})
+
+	return hosts
+}
+
+func (p *HostPool) healthyHosts() []*poolHost {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hosts := make([]*poolHost, 0, len(p.hosts))
+	for _, host := range p.hosts {
+		if host.healthy {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// locate finds the host that owns a VM, asking every host when the VM was
+// created by an earlier process and is not in the placement table yet.
+func (p *HostPool) locate(ctx context.Context, namespace, id string) (*poolHost, error) {
+	p.mu.Lock()
+	placement, ok := p.vms[id]
+	p.mu.Unlock()
+	if ok {
+		return placement.host, nil
+	}
+
+	for _, host := range p.healthyHosts() {
+		vm, err := host.client.GetMicroVM(ctx, namespace, id)
+		if err != nil {
+			continue
+		}
+		p.record(host, id, vm.VCPU, vm.MemoryMB)
+		return host, nil
+	}
+
+	return nil, fmt.Errorf("microVM %s/%s not found on any Flintlock host", namespace, id)
+}
+
+func (p *HostPool) reserve(host *poolHost, vmID string, vcpu, memoryMB int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !host.fits(vcpu, memoryMB) {
+		return false
+	}
+
+	p.allocate(host, vmID, vcpu, memoryMB)
+	return true
+}
+
+// record accounts for a VM that already exists on a host, even if that
+// pushes the host past its declared capacity.
+func (p *HostPool) record(host *poolHost, vmID string, vcpu, memoryMB int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, exists := p.vms[vmID]; exists {
+		return
+	}
+	p.allocate(host, vmID, vcpu, memoryMB)
+}
+
+func (p *HostPool) allocate(host *poolHost, vmID string, vcpu, memoryMB int64) {
+	host.vcpu += vcpu
+	host.memoryMB += memoryMB
+	host.vmCount++
+	p.vms[vmID] = &vmPlacement{host: host, vcpu: vcpu, memoryMB: memoryMB}
+}
+
+func (p *HostPool) release(vmID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	placement, exists := p.vms[vmID]
+	if !exists {
+		return
+	}
+
+	placement.host.vcpu -= placement.vcpu
+	placement.host.memoryMB -= placement.memoryMB
+	placement.host.vmCount--
+	delete(p.vms, vmID)
+}
@@ -0,0 +1,175 @@
+package firecracker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+)
+
+func testHostPool(placement string, clients ...*mockFlintlockClient) *HostPool {
+	hosts := make([]*poolHost, 0, len(clients))
+	for i, client := range clients {
+		hosts = append(hosts, &poolHost{
+			config: config.FlintlockHost{
+				Name:     fmt.Sprintf("host-%d", i+1),
+				Endpoint: fmt.Sprintf("10.0.0.%d:9090", i+1),
+				VCPU:     8,
+				MemoryMB: 16384,
+				Labels:   map[string]string{"arch": "amd64"},
+			},
+			client:  client,
+			healthy: true,
+		})
+	}
+	return newHostPool(hosts, placement, testManagerLogger())
+}
+
+func testSpec(id string, vcpu, memoryMB int64) *MicroVMSpec {
+	return &MicroVMSpec{ID: id, Namespace: "firerunner", VCPU: vcpu, MemoryMB: memoryMB}
+}
+
+func TestHostPool_Placement(t *testing.T) {
+	tests := []struct {
+		placement string
+		expected  []string
+	}{
+		{placement: PlacementBinpack, expected: []string{"host-1", "host-1", "host-1"}},
+		{placement: PlacementSpread, expected: []string{"host-1", "host-2", "host-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.placement, func(t *testing.T) {
+			pool := testHostPool(tt.placement, &mockFlintlockClient{}, &mockFlintlockClient{})
+
+			for i, want := range tt.expected {
+				vm, err := pool.CreateMicroVM(context.Background(), testSpec(fmt.Sprintf("vm-%d", i), 2, 4096))
+				if err != nil {
+					t.Fatalf("CreateMicroVM() failed: %v", err)
+				}
+				if vm.Host != want {
+					t.Errorf("VM %d: expected host %s, got %s", i, want, vm.Host)
+				}
+			}
+		})
+	}
+}
+
+func TestHostPool_Capacity(t *testing.T) {
+	pool := testHostPool(PlacementBinpack, &mockFlintlockClient{})
+
+	if _, err := pool.CreateMicroVM(context.Background(), testSpec("vm-1", 8, 8192)); err != nil {
+		t.Fatalf("CreateMicroVM() failed: %v", err)
+	}
+
+	if _, err := pool.CreateMicroVM(context.Background(), testSpec("vm-2", 1, 1024)); err == nil {
+		t.Fatal("CreateMicroVM() should fail when no host has capacity")
+	}
+
+	if err := pool.DeleteMicroVM(context.Background(), "firerunner", "vm-1"); err != nil {
+		t.Fatalf("DeleteMicroVM() failed: %v", err)
+	}
+
+	stats := pool.HostStats()
+	if stats[0].AllocatedVCPU != 0 || stats[0].VMs != 0 {
+		t.Errorf("Allocation should be released after delete, got %+v", stats[0])
+	}
+
+	if _, err := pool.CreateMicroVM(context.Background(), testSpec("vm-2", 1, 1024)); err != nil {
+		t.Errorf("CreateMicroVM() should succeed after capacity is freed: %v", err)
+	}
+}
+
+func TestHostPool_RetriesOnAnotherHost(t *testing.T) {
+	failing := &mockFlintlockClient{createError: fmt.Errorf("disk full")}
+	pool := testHostPool(PlacementBinpack, failing, &mockFlintlockClient{})
+
+	vm, err := pool.CreateMicroVM(context.Background(), testSpec("vm-1", 2, 4096))
+	if err != nil {
+		t.Fatalf("CreateMicroVM() failed: %v", err)
+	}
+
+	if vm.Host != "host-2" {
+		t.Errorf("Expected VM to land on host-2, got %s", vm.Host)
+	}
+
+	if pool.HostStats()[0].AllocatedVCPU != 0 {
+		t.Error("Failed placement should not leave an allocation behind")
+	}
+}
+
+func TestHostPool_UnhealthyHostsAreSkipped(t *testing.T) {
+	unhealthy := &mockFlintlockClient{healthError: fmt.Errorf("connection refused")}
+	pool := testHostPool(PlacementBinpack, unhealthy, &mockFlintlockClient{})
+
+	if err := pool.Health(context.Background()); err != nil {
+		t.Fatalf("Health() should pass while one host is healthy: %v", err)
+	}
+
+	if pool.HostStats()[0].Healthy {
+		t.Error("host-1 should be marked unhealthy")
+	}
+
+	vm, err := pool.CreateMicroVM(context.Background(), testSpec("vm-1", 2, 4096))
+	if err != nil {
+		t.Fatalf("CreateMicroVM() failed: %v", err)
+	}
+	if vm.Host != "host-2" {
+		t.Errorf("Expected VM on healthy host-2, got %s", vm.Host)
+	}
+	if unhealthy.createCalled {
+		t.Error("Unhealthy host should not receive create requests")
+	}
+}
+
+func TestHostPool_HostSelector(t *testing.T) {
+	pool := testHostPool(PlacementBinpack, &mockFlintlockClient{}, &mockFlintlockClient{})
+	pool.hosts[1].config.Labels = map[string]string{"arch": "arm64"}
+
+	spec := testSpec("vm-1", 2, 4096)
+	spec.HostSelector = map[string]string{"arch": "arm64"}
+
+	vm, err := pool.CreateMicroVM(context.Background(), spec)
+	if err != nil {
+		t.Fatalf("CreateMicroVM() failed: %v", err)
+	}
+	if vm.Host != "host-2" {
+		t.Errorf("Expected VM on arm64 host-2, got %s", vm.Host)
+	}
+
+	spec = testSpec("vm-2", 2, 4096)
+	spec.HostSelector = map[string]string{"arch": "riscv64"}
+	if _, err := pool.CreateMicroVM(context.Background(), spec); err == nil {
+		t.Error("CreateMicroVM() should fail when no host matches the selector")
+	}
+}
+
+func TestHostPool_LocatesUnknownVM(t *testing.T) {
+	first := &mockFlintlockClient{getError: fmt.Errorf("not found")}
+	second := &mockFlintlockClient{}
+	pool := testHostPool(PlacementBinpack, first, second)
+
+	vm, err := pool.GetMicroVM(context.Background(), "firerunner", "vm-from-before-restart")
+	if err != nil {
+		t.Fatalf("GetMicroVM() failed: %v", err)
+	}
+
+	if vm.Host != "host-2" {
+		t.Errorf("Expected VM to be found on host-2, got %s", vm.Host)
+	}
+}
+
+func TestHostPool_ListSkipsUnreachableHosts(t *testing.T) {
+	unreachable := &mockFlintlockClient{listError: fmt.Errorf("connection refused")}
+	pool := testHostPool(PlacementBinpack, unreachable, &mockFlintlockClient{})
+
+	if _, err := pool.ListMicroVMs(context.Background(), "firerunner"); err != nil {
+		t.Fatalf("ListMicroVMs() should skip an unreachable host: %v", err)
+	}
+
+	pool = testHostPool(PlacementBinpack, unreachable)
+	if _, err := pool.ListMicroVMs(context.Background(), "firerunner"); err == nil {
+		t.Error("ListMicroVMs() should fail when no host answers")
+	}
+}
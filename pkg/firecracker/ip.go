@@ -0,0 +1,111 @@
+package firecracker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+type IPResolver interface {
+	ResolveIP(ctx context.Context, vm *MicroVM) (string, error)
+}
+
+// StaticIPResolver returns the address Flintlock was asked to assign to the
+// guest interface, if any.
+type StaticIPResolver struct{}
+
+func (StaticIPResolver) ResolveIP(ctx context.Context, vm *MicroVM) (string, error) {
+	if vm.StaticAddress == "" {
+		return "", nil
+	}
+	return strings.SplitN(vm.StaticAddress, "/", 2)[0], nil
+}
+
+// LeaseFileResolver looks the guest MAC address up in a dnsmasq style lease
+// file ("<expiry> <mac> <ip> <hostname> <client-id>" per line).
+type LeaseFileResolver struct {
+	Path string
+}
+
+func (r LeaseFileResolver) ResolveIP(ctx context.Context, vm *MicroVM) (string, error) {
+	if vm.MACAddress == "" {
+		return "", nil
+	}
+
+	f, err := os.Open(r.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open DHCP lease file: %w", err)
+	}
+	defer f.Close()
+
+	mac := strings.ToLower(vm.MACAddress)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && strings.ToLower(fields[1]) == mac {
+			return fields[2], nil
+		}
+	}
+
+	return "", scanner.Err()
+}
+
+type chainResolver []IPResolver
+
+func (c chainResolver) ResolveIP(ctx context.Context, vm *MicroVM) (string, error) {
+	var lastErr error
+	for _, resolver := range c {
+		ip, err := resolver.ResolveIP(ctx, vm)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if ip != "" {
+			return ip, nil
+		}
+	}
+	return "", lastErr
+}
+
+func (m *Manager) resolveAddress(ctx context.Context, vm *MicroVM) error {
+	if m.resolver == nil || vm.IPAddress != "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.config.IPResolveTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		current, err := m.client.GetMicroVM(ctx, vm.Namespace, vm.ID)
+		if err == nil {
+			if current.IPAddress != "" {
+				vm.IPAddress = current.IPAddress
+				return nil
+			}
+			if current.MACAddress != "" {
+				vm.MACAddress = current.MACAddress
+			}
+			if current.StaticAddress != "" {
+				vm.StaticAddress = current.StaticAddress
+			}
+		}
+
+		ip, err := m.resolver.ResolveIP(ctx, vm)
+		if err == nil && ip != "" {
+			vm.IPAddress = ip
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout resolving IP address of microVM %s", vm.ID)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package firecracker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStaticIPResolver(t *testing.T) {
+	ip, err := StaticIPResolver{}.ResolveIP(context.Background(), &MicroVM{StaticAddress: "10.0.0.5/24"})
+	if err != nil {
+		t.Fatalf("ResolveIP() error = %v", err)
+	}
+	if ip != "10.0.0.5" {
+		t.Errorf("Expected 10.0.0.5, got %s", ip)
+	}
+
+	ip, err = StaticIPResolver{}.ResolveIP(context.Background(), &MicroVM{})
+	if err != nil || ip != "" {
+		t.Errorf("Expected empty result without static address, got %q, %v", ip, err)
+	}
+}
+
+func TestLeaseFileResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	leases := "1700000000 aa:bb:cc:dd:ee:01 192.168.100.10 vm1 *\n" +
+		"1700000000 AA:BB:CC:DD:EE:02 192.168.100.11 vm2 *\n"
+	if err := os.WriteFile(path, []byte(leases), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := LeaseFileResolver{Path: path}
+
+	ip, err := resolver.ResolveIP(context.Background(), &MicroVM{MACAddress: "aa:bb:cc:dd:ee:02"})
+	if err != nil {
+		t.Fatalf("ResolveIP() error = %v", err)
+	}
+	if ip != "192.168.100.11" {
+		t.Errorf("Expected 192.168.100.11, got %s", ip)
+	}
+
+	ip, err = resolver.ResolveIP(context.Background(), &MicroVM{MACAddress: "aa:bb:cc:dd:ee:99"})
+	if err != nil || ip != "" {
+		t.Errorf("Expected no lease for unknown MAC, got %q, %v", ip, err)
+	}
+
+	_, err = LeaseFileResolver{Path: filepath.Join(t.TempDir(), "missing")}.ResolveIP(context.Background(), &MicroVM{MACAddress: "aa:bb:cc:dd:ee:01"})
+	if err == nil {
+		t.Error("Expected error for missing lease file")
+	}
+}
+
+func TestManager_ResolveAddress_LeaseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	if err := os.WriteFile(path, []byte("0 aa:bb:cc:dd:ee:01 192.168.100.10 vm1 *\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testVMConfig()
+	cfg.IPResolveTimeout = time.Second
+	manager := testManager(&dhcpFlintlockClient{mac: "aa:bb:cc:dd:ee:01"})
+	manager.config = cfg
+	manager.resolver = chainResolver{StaticIPResolver{}, LeaseFileResolver{Path: path}}
+
+	vm := &MicroVM{ID: "vm-1", Namespace: "firerunner"}
+	if err := manager.resolveAddress(context.Background(), vm); err != nil {
+		t.Fatalf("resolveAddress() error = %v", err)
+	}
+	if vm.IPAddress != "192.168.100.10" {
+		t.Errorf("Expected IP 192.168.100.10, got %q", vm.IPAddress)
+	}
+}
+
+func TestManager_ResolveAddress_Timeout(t *testing.T) {
+	cfg := testVMConfig()
+	cfg.IPResolveTimeout = 100 * time.Millisecond
+	manager := testManager(&dhcpFlintlockClient{mac: "aa:bb:cc:dd:ee:01"})
+	manager.config = cfg
+	manager.resolver = chainResolver{StaticIPResolver{}}
+
+	vm := &MicroVM{ID: "vm-1", Namespace: "firerunner"}
+	if err := manager.resolveAddress(context.Background(), vm); err == nil {
+		t.Fatal("Expected timeout error")
+	}
+	if vm.MACAddress != "aa:bb:cc:dd:ee:01" {
+		t.Errorf("Expected MAC address to be refreshed, got %q", vm.MACAddress)
+	}
+}
+
+func TestManager_CreateVM_BootFailure(t *testing.T) {
+	client := &mockFlintlockClient{waitError: errors.New("vm failed")}
+	manager := testManager(client)
+
+	if _, err := manager.CreateVM(context.Background(), &VMRequest{JobID: "1"}); err == nil {
+		t.Fatal("Expected error when VM does not reach running state")
+	}
+	if !client.deleteCalled {
+		t.Error("Expected VM to be deleted after boot failure")
+	}
+	if len(manager.ListVMs()) != 0 {
+		t.Error("Expected failed VM not to be tracked")
+	}
+}
+
+// dhcpFlintlockClient reports a guest that got its address over DHCP, so
+// Flintlock only knows the MAC address.
+type dhcpFlintlockClient struct {
+	mockFlintlockClient
+	mac string
+}
+
+func (c *dhcpFlintlockClient) GetMicroVM(ctx context.Context, namespace, id string) (*MicroVM, error) {
+	return &MicroVM{ID: id, Namespace: namespace, State: "running", MACAddress: c.mac}, nil
+}
@@ -0,0 +1,159 @@
+package firecracker
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+)
+
+var ErrPoolExhausted = errors.New("no free address in the IPAM pool")
+
+// Address is a static guest address handed out by the IPAM pool.
+type Address struct {
+	CIDR        string // e.g. "10.100.0.5/24"
+	Gateway     string
+	Nameservers []string
+	MAC         string
+}
+
+// IPAM hands out the addresses of a pool and remembers which VM holds each
+// one, so a guest MAC and address are never in use twice.
+type IPAM struct {
+	prefix      netip.Prefix
+	gateway     string
+	nameservers []string
+	reserved    map[netip.Addr]bool
+
+	leases map[netip.Addr]string // address -> VM ID
+	byVM   map[string]netip.Addr
+	next   netip.Addr
+	mu     sync.Mutex
+}
+
+func NewIPAM(cfg *config.IPAMConfig) (*IPAM, error) {
+	prefix, err := netip.ParsePrefix(cfg.CIDR)
+	if err != nil || !prefix.Addr().Is4() {
+		return nil, fmt.Errorf("invalid IPAM pool %q", cfg.CIDR)
+	}
+	prefix = prefix.Masked()
+
+	p := &IPAM{
+		prefix:      prefix,
+		gateway:     cfg.Gateway,
+		nameservers: cfg.Nameservers,
+		reserved:    map[netip.Addr]bool{prefix.Addr(): true, broadcast(prefix): true},
+		leases:      make(map[netip.Addr]string),
+		byVM:        make(map[string]netip.Addr),
+		next:        prefix.Addr().Next(),
+	}
+	if cfg.Gateway != "" {
+		gateway, err := netip.ParseAddr(cfg.Gateway)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IPAM gateway %q", cfg.Gateway)
+		}
+		p.reserved[gateway] = true
+	}
+	for _, reserved := range cfg.Reserved {
+		addr, err := netip.ParseAddr(reserved)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reserved address %q", reserved)
+		}
+		p.reserved[addr] = true
+	}
+	return p, nil
+}
+
+// Allocate leases a free address to a VM. A VM that already holds an
+// address gets it again.
+func (p *IPAM) Allocate(vmID string) (Address, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if addr, exists := p.byVM[vmID]; exists {
+		return p.address(addr), nil
+	}
+
+	// Addresses are handed out round-robin so a released address is not
+	// reused while ARP caches may still remember its old MAC.
+	addr := p.next
+	for range p.size() {
+		if !p.prefix.Contains(addr) {
+			addr = p.prefix.Addr()
+		}
+		if _, leased := p.leases[addr]; !leased && !p.reserved[addr] {
+			p.leases[addr] = vmID
+			p.byVM[vmID] = addr
+			p.next = addr.Next()
+			return p.address(addr), nil
+		}
+		addr = addr.Next()
+	}
+	return Address{}, fmt.Errorf("%w %s", ErrPoolExhausted, p.prefix)
+}
+
+// Reserve records the address of an existing VM, given in CIDR notation.
+// Addresses outside the pool are ignored.
+func (p *IPAM) Reserve(vmID, cidr string) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil || !p.prefix.Contains(prefix.Addr()) {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.leases[prefix.Addr()] = vmID
+	p.byVM[vmID] = prefix.Addr()
+}
+
+// Release returns the address of a VM to the pool.
+func (p *IPAM) Release(vmID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if addr, exists := p.byVM[vmID]; exists {
+		delete(p.leases, addr)
+		delete(p.byVM, vmID)
+	}
+}
+
+// Stats returns the number of leased and leasable addresses.
+func (p *IPAM) Stats() (leased, total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	total = p.size()
+	for addr := range p.reserved {
+		if p.prefix.Contains(addr) {
+			total--
+		}
+	}
+	return len(p.leases), total
+}
+
+func (p *IPAM) size() int {
+	return 1 << (32 - p.prefix.Bits())
+}
+
+// address must be called with mu held.
+func (p *IPAM) address(addr netip.Addr) Address {
+	ip := addr.As4()
+	return Address{
+		CIDR:        netip.PrefixFrom(addr, p.prefix.Bits()).String(),
+		Gateway:     p.gateway,
+		Nameservers: p.nameservers,
+		// A locally administered MAC derived from the address keeps the
+		// pair stable across restarts.
+		MAC: fmt.Sprintf("02:fc:%02x:%02x:%02x:%02x", ip[0], ip[1], ip[2], ip[3]),
+	}
+}
+
+func broadcast(prefix netip.Prefix) netip.Addr {
+	ip := prefix.Addr().As4()
+	for i := prefix.Bits(); i < 32; i++ {
+		ip[i/8] |= 1 << (7 - i%8)
+	}
+	return netip.AddrFrom4(ip)
+}
@@ -0,0 +1,79 @@
+package firecracker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+)
+
+func TestIPAM_Allocate(t *testing.T) {
+	ipam, err := NewIPAM(&config.IPAMConfig{
+		CIDR:        "10.100.0.0/29",
+		Gateway:     "10.100.0.1",
+		Nameservers: []string{"10.100.0.1"},
+		Reserved:    []string{"10.100.0.3"},
+	})
+	if err != nil {
+		t.Fatalf("NewIPAM() error = %v", err)
+	}
+
+	if leased, total := ipam.Stats(); leased != 0 || total != 4 {
+		t.Errorf("Stats() = %d, %d, want 0, 4", leased, total)
+	}
+
+	addr, err := ipam.Allocate("vm-1")
+	if err != nil {
+		t.Fatalf("Allocate() error = %v", err)
+	}
+	if addr.CIDR != "10.100.0.2/29" || addr.Gateway != "10.100.0.1" || addr.MAC != "02:fc:0a:64:00:02" {
+		t.Errorf("Unexpected address: %+v", addr)
+	}
+
+	again, err := ipam.Allocate("vm-1")
+	if err != nil || again.CIDR != addr.CIDR {
+		t.Errorf("Expected vm-1 to keep its address, got %+v, %v", again, err)
+	}
+
+	var got []string
+	for _, id := range []string{"vm-2", "vm-3", "vm-4"} {
+		addr, err := ipam.Allocate(id)
+		if err != nil {
+			t.Fatalf("Allocate(%s) error = %v", id, err)
+		}
+		got = append(got, addr.CIDR)
+	}
+	want := []string{"10.100.0.4/29", "10.100.0.5/29", "10.100.0.6/29"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Allocated %v, want %v", got, want)
+		}
+	}
+
+	if _, err := ipam.Allocate("vm-5"); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("Expected ErrPoolExhausted, got %v", err)
+	}
+
+	ipam.Release("vm-1")
+	addr, err = ipam.Allocate("vm-5")
+	if err != nil || addr.CIDR != "10.100.0.2/29" {
+		t.Errorf("Expected the released address, got %+v, %v", addr, err)
+	}
+}
+
+func TestIPAM_Reserve(t *testing.T) {
+	ipam, err := NewIPAM(&config.IPAMConfig{CIDR: "10.100.0.0/30", Gateway: "10.100.0.2"})
+	if err != nil {
+		t.Fatalf("NewIPAM() error = %v", err)
+	}
+
+	ipam.Reserve("vm-old", "10.100.0.1/30")
+	ipam.Reserve("vm-other", "192.168.1.5/24")
+
+	if _, err := ipam.Allocate("vm-new"); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("Expected the reserved address to be taken, got %v", err)
+	}
+	if leased, _ := ipam.Stats(); leased != 1 {
+		t.Errorf("Expected addresses outside the pool to be ignored, got %d leases", leased)
+	}
+}
@@ -9,9 +9,13 @@ import (
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 
+	"github.com/ismoilovdevml/firerunner/pkg/cloudinit"
 	"github.com/ismoilovdevml/firerunner/pkg/config"
+	"github.com/ismoilovdevml/firerunner/pkg/metrics"
 )
 
+const vmNamespace = "firerunner"
+
 type FlintlockClient interface {
 	CreateMicroVM(ctx context.Context, spec *MicroVMSpec) (*MicroVM, error)
 	DeleteMicroVM(ctx context.Context, namespace, id string) error
@@ -25,6 +29,9 @@ type FlintlockClient interface {
 type Manager struct {
 	client       FlintlockClient
 	config       *config.VMConfig
+	resolver     IPResolver
+	guest        *cloudinit.Renderer
+	ipam         *IPAM
 	vms          map[string]*MicroVM
 	mu           sync.RWMutex
 	logger       *logrus.Logger
@@ -33,16 +40,42 @@ type Manager struct {
 	wg           sync.WaitGroup
 }
 
-func NewManager(client *Client, cfg *config.VMConfig, logger *logrus.Logger) *Manager {
+func NewManager(client FlintlockClient, cfg *config.VMConfig, logger *logrus.Logger) *Manager {
+	resolvers := chainResolver{StaticIPResolver{}}
+	if cfg.DHCPLeaseFile != "" {
+		resolvers = append(resolvers, LeaseFileResolver{Path: cfg.DHCPLeaseFile})
+	}
+
 	return &Manager{
 		client:     client,
 		config:     cfg,
+		resolver:   resolvers,
 		vms:        make(map[string]*MicroVM),
 		logger:     logger,
 		shutdownCh: make(chan struct{}),
 	}
 }
 
+// SetGuestRenderer enables delivering runner configuration or claimed jobs
+// to new VMs through cloud-init user-data.
+func (m *Manager) SetGuestRenderer(renderer *cloudinit.Renderer) {
+	m.guest = renderer
+}
+
+// SetIPAM hands out static guest addresses from a pool to the interface
+// configured to use it.
+func (m *Manager) SetIPAM(ipam *IPAM) {
+	m.ipam = ipam
+}
+
+type VMStats struct {
+	TotalVMs int            `json:"total_vms"`
+	ByState  map[string]int `json:"by_state"`
+	// LeasedAddresses and PoolAddresses describe the IPAM pool, if any.
+	LeasedAddresses int `json:"leased_addresses,omitempty"`
+	PoolAddresses   int `json:"pool_addresses,omitempty"`
+}
+
 type VMRequest struct {
 	JobID     string
 	ProjectID string
@@ -50,6 +83,28 @@ type VMRequest struct {
 	MemoryMB  int64
 	Tags      []string
 	Metadata  map[string]string
+
+	// DiskGB grows the root volume; zero keeps the size of the image.
+	DiskGB int64
+	// KernelImage and RootFSImage replace the configured images when set.
+	KernelImage string
+	RootFSImage string
+	// Volumes are shared with the guest in addition to the root volume.
+	Volumes []Volume
+	// Egress is the egress policy of the VM; empty means allow-all.
+	Egress string
+
+	HostSelector map[string]string
+
+	// ID optionally fixes the VM ID, e.g. when the runner was named after
+	// it before the VM existed.
+	ID string
+	// Runner is rendered into cloud-init user-data when a guest renderer
+	// is configured.
+	Runner *cloudinit.Runner
+	// Job is a job claimed by FireRunner itself and is rendered into
+	// user-data instead of Runner.
+	Job *cloudinit.Job
 }
 
 func (m *Manager) CreateVM(ctx context.Context, req *VMRequest) (*MicroVM, error) {
@@ -60,30 +115,87 @@ func (m *Manager) CreateVM(ctx context.Context, req *VMRequest) (*MicroVM, error
 		"memory_mb":  req.MemoryMB,
 	}).Info("Creating MicroVM for job")
 
-	vmID := generateVMID(req.JobID)
+	vmID := req.ID
+	if vmID == "" {
+		vmID = NewVMID(req.JobID)
+	}
+
+	kernelImage, rootFSImage := m.config.KernelImage, m.config.RootFSImage
+	if req.KernelImage != "" {
+		kernelImage = req.KernelImage
+	}
+	if req.RootFSImage != "" {
+		rootFSImage = req.RootFSImage
+	}
+
+	egress := m.egress(req.Egress)
+	metadata := m.prepareMetadata(req)
+	egressMetadata(metadata, egress)
+	if (req.Runner != nil || req.Job != nil) && m.guest != nil {
+		guestData, err := m.guest.Render(&cloudinit.Instance{ID: vmID, Runner: req.Runner, Job: req.Job, Egress: egress})
+		if err != nil {
+			return nil, fmt.Errorf("failed to render guest configuration: %w", err)
+		}
+		for k, v := range guestData {
+			metadata[k] = v
+		}
+	}
+
+	interfaces, err := m.interfaces(vmID, egress.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up guest network: %w", err)
+	}
 
 	spec := &MicroVMSpec{
-		ID:               vmID,
-		Namespace:        "firerunner",
-		VCPU:             req.VCPU,
-		MemoryMB:         req.MemoryMB,
-		KernelImage:      m.config.KernelImage,
-		RootFSImage:      m.config.RootFSImage,
-		NetworkInterface: m.config.NetworkInterface,
-		Metadata:         m.prepareMetadata(req),
-		Labels:           m.prepareLabels(req),
+		ID:           vmID,
+		Namespace:    vmNamespace,
+		VCPU:         req.VCPU,
+		MemoryMB:     req.MemoryMB,
+		KernelImage:  kernelImage,
+		RootFSImage:  rootFSImage,
+		RootVolumeGB: req.DiskGB,
+		Volumes:      req.Volumes,
+		Interfaces:   interfaces,
+		Metadata:     metadata,
+		Labels:       m.prepareLabels(req),
+		HostSelector: req.HostSelector,
 	}
 
 	startTime := time.Now()
 	vm, err := m.client.CreateMicroVM(ctx, spec)
 	if err != nil {
+		metrics.VMFailures.WithLabelValues("create").Inc()
 		m.logger.WithError(err).Error("Failed to create MicroVM")
+		m.releaseAddress(vmID)
 		return nil, fmt.Errorf("failed to create microVM: %w", err)
 	}
 
+	bootTimeout := m.config.BootTimeout
+	if bootTimeout <= 0 {
+		bootTimeout = 60 * time.Second
+	}
+	if err := m.client.WaitForMicroVM(ctx, vm.Namespace, vm.ID, "running", bootTimeout); err != nil {
+		metrics.VMFailures.WithLabelValues("boot").Inc()
+		m.logger.WithError(err).WithField("vm_id", vm.ID).Error("MicroVM did not reach running state")
+		deleteCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if deleteErr := m.client.DeleteMicroVM(deleteCtx, vm.Namespace, vm.ID); deleteErr != nil {
+			m.logger.WithError(deleteErr).WithField("vm_id", vm.ID).Error("Failed to delete MicroVM that never started")
+		} else {
+			m.releaseAddress(vm.ID)
+		}
+		cancel()
+		return nil, fmt.Errorf("microVM %s did not start: %w", vm.ID, err)
+	}
+
+	if err := m.resolveAddress(ctx, vm); err != nil {
+		m.logger.WithError(err).WithField("vm_id", vm.ID).Warn("Could not resolve MicroVM IP address")
+	}
+
 	duration := time.Since(startTime)
+	metrics.VMCreateSeconds.Observe(duration.Seconds())
 	m.logger.WithFields(logrus.Fields{
 		"vm_id":      vm.ID,
+		"host":       vm.Host,
 		"duration":   duration,
 		"ip_address": vm.IPAddress,
 	}).Info("MicroVM created successfully")
@@ -103,21 +215,48 @@ func (m *Manager) DestroyVM(ctx context.Context, vmID string) error {
 
 	startTime := time.Now()
 	if err := m.client.DeleteMicroVM(ctx, vm.Namespace, vm.ID); err != nil {
+		metrics.VMFailures.WithLabelValues("destroy").Inc()
 		m.logger.WithError(err).Error("Failed to delete MicroVM")
 		return fmt.Errorf("failed to delete microVM: %w", err)
 	}
 
 	duration := time.Since(startTime)
+	metrics.VMDestroySeconds.Observe(duration.Seconds())
 	m.logger.WithFields(logrus.Fields{
 		"vm_id":    vmID,
 		"duration": duration,
 	}).Info("MicroVM destroyed successfully")
 
 	m.untrackVM(vmID)
+	m.releaseAddress(vmID)
 
 	return nil
 }
 
+func (m *Manager) AdoptVM(ctx context.Context, vmID string) (*MicroVM, error) {
+	if vm := m.getVM(vmID); vm != nil {
+		return vm, nil
+	}
+
+	vm, err := m.client.GetMicroVM(ctx, vmNamespace, vmID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to adopt microVM %s: %w", vmID, err)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"vm_id": vm.ID,
+		"state": vm.State,
+	}).Info("Adopted existing MicroVM")
+
+	if m.ipam != nil && vm.StaticAddress != "" {
+		m.ipam.Reserve(vm.ID, vm.StaticAddress)
+	}
+
+	m.trackVM(vm)
+
+	return vm, nil
+}
+
 func (m *Manager) GetVM(vmID string) (*MicroVM, error) {
 	vm := m.getVM(vmID)
 	if vm == nil {
@@ -138,6 +277,10 @@ func (m *Manager) ListVMs() []*MicroVM {
 	return vms
 }
 
+func (m *Manager) ListRemoteVMs(ctx context.Context) ([]*MicroVM, error) {
+	return m.client.ListMicroVMs(ctx, vmNamespace)
+}
+
 func (m *Manager) StartCleanup(interval time.Duration) {
 	m.wg.Add(1)
 	go func() {
@@ -230,12 +373,27 @@ func (m *Manager) trackVM(vm *MicroVM) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.vms[vm.ID] = vm
+	m.updateVMMetrics()
 }
 
 func (m *Manager) untrackVM(vmID string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	delete(m.vms, vmID)
+	m.updateVMMetrics()
+}
+
+// updateVMMetrics must be called with m.mu held.
+func (m *Manager) updateVMMetrics() {
+	counts := make(map[string]float64)
+	for _, vm := range m.vms {
+		counts[vm.State]++
+	}
+
+	metrics.ActiveVMs.Reset()
+	for state, count := range counts {
+		metrics.ActiveVMs.WithLabelValues(state).Set(count)
+	}
 }
 
 func (m *Manager) getVM(vmID string) *MicroVM {
@@ -272,22 +430,24 @@ func (m *Manager) prepareLabels(req *VMRequest) map[string]string {
 	return labels
 }
 
-func generateVMID(jobID string) string {
+func NewVMID(jobID string) string {
 	return fmt.Sprintf("vm-%s-%s", jobID, uuid.New().String()[:8])
 }
 
-func (m *Manager) GetVMStats() map[string]interface{} {
+func (m *Manager) GetVMStats() VMStats {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	stats := map[string]interface{}{
-		"total_vms": len(m.vms),
-		"by_state":  make(map[string]int),
+	stats := VMStats{
+		TotalVMs: len(m.vms),
+		ByState:  make(map[string]int),
 	}
 
-	byState := stats["by_state"].(map[string]int)
 	for _, vm := range m.vms {
-		byState[vm.State]++
+		stats.ByState[vm.State]++
+	}
+	if m.ipam != nil {
+		stats.LeasedAddresses, stats.PoolAddresses = m.ipam.Stats()
 	}
 
 	return stats
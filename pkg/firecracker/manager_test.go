@@ -9,6 +9,7 @@ import (
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/ismoilovdevml/firerunner/pkg/cloudinit"
 	"github.com/ismoilovdevml/firerunner/pkg/config"
 )
 
@@ -21,16 +22,23 @@ type mockFlintlockClient struct {
 	createError  error
 	deleteError  error
 	getError     error
+	listError    error
+	healthError  error
+	waitError    error
+	lastSpec     *MicroVMSpec
 }
 
 func (m *mockFlintlockClient) CreateMicroVM(ctx context.Context, spec *MicroVMSpec) (*MicroVM, error) {
 	m.createCalled = true
+	m.lastSpec = spec
 	if m.createError != nil {
 		return nil, m.createError
 	}
 	return &MicroVM{
 		ID:        spec.ID,
 		Namespace: spec.Namespace,
+		VCPU:      spec.VCPU,
+		MemoryMB:  spec.MemoryMB,
 		State:     "running",
 		IPAddress: "10.0.0.100",
 		CreatedAt: time.Now(),
@@ -60,11 +68,14 @@ func (m *mockFlintlockClient) GetMicroVM(ctx context.Context, namespace, id stri
 
 func (m *mockFlintlockClient) ListMicroVMs(ctx context.Context, namespace string) ([]*MicroVM, error) {
 	m.listCalled = true
+	if m.listError != nil {
+		return nil, m.listError
+	}
 	return []*MicroVM{}, nil
 }
 
 func (m *mockFlintlockClient) WaitForMicroVM(ctx context.Context, namespace, id string, state string, timeout time.Duration) error {
-	return nil
+	return m.waitError
 }
 
 func (m *mockFlintlockClient) Close() error {
@@ -72,7 +83,7 @@ func (m *mockFlintlockClient) Close() error {
 }
 
 func (m *mockFlintlockClient) Health(ctx context.Context) error {
-	return nil
+	return m.healthError
 }
 
 func testManagerLogger() *logrus.Logger {
@@ -92,6 +103,16 @@ func testVMConfig() *config.VMConfig {
 	}
 }
 
+func testManager(client FlintlockClient) *Manager {
+	return &Manager{
+		client:     client,
+		config:     testVMConfig(),
+		vms:        make(map[string]*MicroVM),
+		logger:     testManagerLogger(),
+		shutdownCh: make(chan struct{}),
+	}
+}
+
 func TestNewManager(t *testing.T) {
 	cfg := testVMConfig()
 	logger := testManagerLogger()
@@ -340,15 +361,11 @@ func TestManager_GetVMStats(t *testing.T) {
 
 	stats := manager.GetVMStats()
 
-	totalVMs, ok := stats["total_vms"].(int)
-	if !ok || totalVMs != 4 {
-		t.Errorf("Expected total_vms = 4, got %v", stats["total_vms"])
+	if stats.TotalVMs != 4 {
+		t.Errorf("Expected TotalVMs = 4, got %d", stats.TotalVMs)
 	}
 
-	byState, ok := stats["by_state"].(map[string]int)
-	if !ok {
-		t.Fatal("by_state should be a map")
-	}
+	byState := stats.ByState
 
 	if byState["running"] != 2 {
 		t.Errorf("Expected 2 running VMs, got %d", byState["running"])
@@ -515,8 +532,8 @@ func TestManager_PrepareLabels(t *testing.T) {
 func TestGenerateVMID(t *testing.T) {
 	jobID := "123"
 
-	id1 := generateVMID(jobID)
-	id2 := generateVMID(jobID)
+	id1 := NewVMID(jobID)
+	id2 := NewVMID(jobID)
 
 	// Should contain job ID
 	if !contains(id1, "123") {
@@ -578,3 +595,154 @@ func TestManager_Shutdown(t *testing.T) {
 		t.Error("VM should be destroyed during shutdown")
 	}
 }
+
+func TestManager_CreateVM_RendersGuestConfig(t *testing.T) {
+	client := &mockFlintlockClient{}
+	manager := NewManager(client, testVMConfig(), testManagerLogger())
+
+	renderer, err := cloudinit.NewRenderer(testVMConfig())
+	if err != nil {
+		t.Fatalf("NewRenderer() error = %v", err)
+	}
+	manager.SetGuestRenderer(renderer)
+
+	_, err = manager.CreateVM(context.Background(), &VMRequest{
+		ID:     "vm-7-abcd",
+		JobID:  "7",
+		Runner: &cloudinit.Runner{URL: "https://gitlab.example.com", Token: "glrt-secret"},
+	})
+	if err != nil {
+		t.Fatalf("CreateVM() error = %v", err)
+	}
+
+	if client.lastSpec.ID != "vm-7-abcd" {
+		t.Errorf("Expected requested VM ID, got %s", client.lastSpec.ID)
+	}
+	if client.lastSpec.Metadata[cloudinit.UserDataKey] == "" {
+		t.Error("Expected user-data in VM metadata")
+	}
+	if client.lastSpec.Metadata["firerunner.job_id"] != "7" {
+		t.Error("Expected FireRunner metadata to be kept")
+	}
+}
+
+func TestManager_CreateVM_ImageAndDisk(t *testing.T) {
+	client := &mockFlintlockClient{}
+	cfg := testVMConfig()
+	manager := NewManager(client, cfg, testManagerLogger())
+
+	_, err := manager.CreateVM(context.Background(), &VMRequest{
+		JobID:        "7",
+		VCPU:         4,
+		MemoryMB:     8192,
+		DiskGB:       50,
+		KernelImage:  "ghcr.io/firerunner/kernel:6.1",
+		RootFSImage:  "ghcr.io/firerunner/ubuntu:24.04",
+		HostSelector: map[string]string{"arch": "arm64"},
+		Volumes:      []Volume{{ID: "cache-go-mod", HostPath: "/var/lib/firerunner/cache/gitlab-1/go-mod", MountPoint: "/cache/go-mod", ReadOnly: true}},
+	})
+	if err != nil {
+		t.Fatalf("CreateVM() error = %v", err)
+	}
+
+	if client.lastSpec.KernelImage != "ghcr.io/firerunner/kernel:6.1" || client.lastSpec.RootFSImage != "ghcr.io/firerunner/ubuntu:24.04" {
+		t.Errorf("Expected requested images, got %s and %s", client.lastSpec.KernelImage, client.lastSpec.RootFSImage)
+	}
+	if client.lastSpec.RootVolumeGB != 50 {
+		t.Errorf("Expected 50 GB root volume, got %d", client.lastSpec.RootVolumeGB)
+	}
+	if client.lastSpec.HostSelector["arch"] != "arm64" {
+		t.Errorf("Expected arch host selector, got %v", client.lastSpec.HostSelector)
+	}
+	if len(client.lastSpec.Volumes) != 1 || client.lastSpec.Volumes[0].ID != "cache-go-mod" || !client.lastSpec.Volumes[0].ReadOnly {
+		t.Errorf("Expected the cache volume, got %+v", client.lastSpec.Volumes)
+	}
+
+	_, err = manager.CreateVM(context.Background(), &VMRequest{JobID: "8"})
+	if err != nil {
+		t.Fatalf("CreateVM() error = %v", err)
+	}
+	if client.lastSpec.KernelImage != cfg.KernelImage || client.lastSpec.RootFSImage != cfg.RootFSImage {
+		t.Errorf("Expected configured images, got %s and %s", client.lastSpec.KernelImage, client.lastSpec.RootFSImage)
+	}
+}
+
+func TestManager_CreateVM_Network(t *testing.T) {
+	client := &mockFlintlockClient{}
+	cfg := testVMConfig()
+	cfg.Network = config.NetworkConfig{
+		Interfaces: []config.NetworkInterface{
+			{DeviceID: "eth0", Type: config.InterfaceTAP, Bridge: "br-ci", IPAM: true},
+			{DeviceID: "eth1"},
+		},
+		IPAM: config.IPAMConfig{CIDR: "10.100.0.0/24", Gateway: "10.100.0.1"},
+		Egress: config.EgressConfig{
+			AllowedHosts: []string{"proxy.example.com"},
+			Bridges:      map[string]string{config.EgressGitLabOnly: "br-gitlab"},
+		},
+	}
+	manager := NewManager(client, cfg, testManagerLogger())
+	ipam, err := NewIPAM(&cfg.Network.IPAM)
+	if err != nil {
+		t.Fatalf("NewIPAM() error = %v", err)
+	}
+	manager.SetIPAM(ipam)
+
+	vm, err := manager.CreateVM(context.Background(), &VMRequest{JobID: "7", VCPU: 2, MemoryMB: 4096, Egress: config.EgressGitLabOnly})
+	if err != nil {
+		t.Fatalf("CreateVM() error = %v", err)
+	}
+
+	interfaces := client.lastSpec.Interfaces
+	if len(interfaces) != 2 {
+		t.Fatalf("Expected 2 interfaces, got %+v", interfaces)
+	}
+	if interfaces[0].Type != config.InterfaceTAP || interfaces[0].Bridge != "br-gitlab" {
+		t.Errorf("Expected a tap interface on the egress bridge, got %+v", interfaces[0])
+	}
+	if interfaces[0].Address == nil || interfaces[0].Address.CIDR != "10.100.0.2/24" || interfaces[0].MAC != "02:fc:0a:64:00:02" {
+		t.Errorf("Expected a pool address, got %+v", interfaces[0])
+	}
+	if interfaces[1].Type != config.InterfaceMACVTAP || interfaces[1].Address != nil {
+		t.Errorf("Expected a DHCP macvtap interface, got %+v", interfaces[1])
+	}
+	if client.lastSpec.Metadata["firerunner.egress"] != config.EgressGitLabOnly ||
+		client.lastSpec.Metadata["firerunner.egress_allowed_hosts"] != "proxy.example.com" {
+		t.Errorf("Expected egress metadata, got %v", client.lastSpec.Metadata)
+	}
+
+	if leased, _ := ipam.Stats(); leased != 1 {
+		t.Errorf("Expected 1 leased address, got %d", leased)
+	}
+	if err := manager.DestroyVM(context.Background(), vm.ID); err != nil {
+		t.Fatalf("DestroyVM() error = %v", err)
+	}
+	if leased, _ := ipam.Stats(); leased != 0 {
+		t.Errorf("Expected the address to be released, got %d leased", leased)
+	}
+
+	client.createError = fmt.Errorf("no capacity")
+	if _, err := manager.CreateVM(context.Background(), &VMRequest{JobID: "8"}); err == nil {
+		t.Fatal("CreateVM() should fail")
+	}
+	if leased, _ := ipam.Stats(); leased != 0 {
+		t.Errorf("Expected the address of a failed VM to be released, got %d leased", leased)
+	}
+}
+
+func TestManager_CreateVM_DefaultInterface(t *testing.T) {
+	client := &mockFlintlockClient{}
+	manager := NewManager(client, testVMConfig(), testManagerLogger())
+
+	if _, err := manager.CreateVM(context.Background(), &VMRequest{JobID: "7"}); err != nil {
+		t.Fatalf("CreateVM() error = %v", err)
+	}
+
+	interfaces := client.lastSpec.Interfaces
+	if len(interfaces) != 1 || interfaces[0].DeviceID != "eth0" || interfaces[0].Type != config.InterfaceMACVTAP {
+		t.Errorf("Expected a single macvtap interface on eth0, got %+v", interfaces)
+	}
+	if client.lastSpec.Metadata["firerunner.egress"] != config.EgressAllowAll {
+		t.Errorf("Expected allow-all egress, got %q", client.lastSpec.Metadata["firerunner.egress"])
+	}
+}
@@ -0,0 +1,90 @@
+package firecracker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ismoilovdevml/firerunner/pkg/cloudinit"
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+)
+
+// interfaces returns the guest interfaces of a new VM and leases a pool
+// address for the interface that uses IPAM. The lease is held under the VM
+// ID until the VM is destroyed.
+func (m *Manager) interfaces(vmID, egress string) ([]Interface, error) {
+	configured := m.config.Network.Interfaces
+	if len(configured) == 0 {
+		configured = []config.NetworkInterface{{DeviceID: m.config.NetworkInterface}}
+	}
+	egressBridge := m.config.Network.Egress.Bridges[egress]
+
+	result := make([]Interface, 0, len(configured))
+	for _, c := range configured {
+		iface := Interface{DeviceID: c.DeviceID, Type: c.Type, Bridge: c.Bridge}
+		if iface.Type == "" {
+			iface.Type = config.InterfaceMACVTAP
+		}
+		if iface.Type == config.InterfaceTAP && egressBridge != "" {
+			iface.Bridge = egressBridge
+		}
+		if c.IPAM {
+			if m.ipam == nil {
+				return nil, fmt.Errorf("interface %s uses IPAM but no address pool is set", c.DeviceID)
+			}
+			addr, err := m.ipam.Allocate(vmID)
+			if err != nil {
+				return nil, err
+			}
+			iface.Address = &addr
+			iface.MAC = addr.MAC
+		}
+		result = append(result, iface)
+	}
+	return result, nil
+}
+
+// egress returns the guest view of an egress policy; an empty policy
+// means allow-all.
+func (m *Manager) egress(policy string) *cloudinit.Egress {
+	if policy == "" {
+		policy = config.EgressAllowAll
+	}
+	egress := &cloudinit.Egress{Policy: policy}
+	if policy == config.EgressGitLabOnly {
+		egress.AllowedHosts = m.config.Network.Egress.AllowedHosts
+	}
+	return egress
+}
+
+func (m *Manager) releaseAddress(vmID string) {
+	if m.ipam != nil {
+		m.ipam.Release(vmID)
+	}
+}
+
+// ReserveAddresses records the pool addresses of the VMs Flintlock already
+// runs, e.g. after a restart, so they are not handed out twice.
+func (m *Manager) ReserveAddresses(ctx context.Context) error {
+	if m.ipam == nil {
+		return nil
+	}
+
+	vms, err := m.client.ListMicroVMs(ctx, vmNamespace)
+	if err != nil {
+		return fmt.Errorf("failed to list microVMs: %w", err)
+	}
+	for _, vm := range vms {
+		if vm.StaticAddress != "" {
+			m.ipam.Reserve(vm.ID, vm.StaticAddress)
+		}
+	}
+	return nil
+}
+
+func egressMetadata(metadata map[string]string, egress *cloudinit.Egress) {
+	metadata["firerunner.egress"] = egress.Policy
+	if len(egress.AllowedHosts) > 0 {
+		metadata["firerunner.egress_allowed_hosts"] = strings.Join(egress.AllowedHosts, ",")
+	}
+}
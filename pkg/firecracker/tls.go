@@ -0,0 +1,194 @@
+package firecracker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+)
+
+// certReloader serves the Flintlock CA bundle and client key pair to the TLS
+// stack, re-reading them from disk whenever one of the files changes so that
+// rotated certificates are picked up on the next handshake.
+type certReloader struct {
+	caPath   string
+	certPath string
+	keyPath  string
+
+	mu       sync.Mutex
+	roots    *x509.CertPool
+	cert     *tls.Certificate
+	modTimes map[string]time.Time
+}
+
+func newTLSCredentials(cfg *config.FlintlockConfig) (credentials.TransportCredentials, error) {
+	if (cfg.TLSClientCert == "") != (cfg.TLSClientKey == "") {
+		return nil, fmt.Errorf("flintlock TLS: tls_client_cert and tls_client_key must be set together")
+	}
+
+	reloader := &certReloader{
+		caPath:   cfg.TLSCACert,
+		certPath: cfg.TLSClientCert,
+		keyPath:  cfg.TLSClientKey,
+		modTimes: make(map[string]time.Time),
+	}
+
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+
+	serverName := cfg.TLSServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(cfg.Endpoint)
+		if err != nil {
+			host = cfg.Endpoint
+		}
+		serverName = host
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		ServerName:           serverName,
+		GetClientCertificate: reloader.getClientCertificate,
+		// Chain verification happens in VerifyConnection so that it always
+		// uses the most recently loaded CA bundle.
+		InsecureSkipVerify: true,
+		VerifyConnection:   reloader.verifyConnection(serverName),
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+func (r *certReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if err := r.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cert == nil {
+		return &tls.Certificate{}, nil
+	}
+	return r.cert, nil
+}
+
+func (r *certReloader) verifyConnection(serverName string) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if err := r.reloadIfChanged(); err != nil {
+			return err
+		}
+
+		if len(state.PeerCertificates) == 0 {
+			return fmt.Errorf("flintlock TLS: server presented no certificate")
+		}
+
+		r.mu.Lock()
+		roots := r.roots
+		r.mu.Unlock()
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+			DNSName:       serverName,
+			Roots:         roots,
+			Intermediates: intermediates,
+		})
+		if err != nil {
+			return fmt.Errorf("flintlock TLS: failed to verify server certificate: %w", err)
+		}
+
+		return nil
+	}
+}
+
+func (r *certReloader) reloadIfChanged() error {
+	r.mu.Lock()
+	changed := false
+	for _, path := range []string{r.caPath, r.certPath, r.keyPath} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			r.mu.Unlock()
+			return fmt.Errorf("flintlock TLS: %w", err)
+		}
+		if !info.ModTime().Equal(r.modTimes[path]) {
+			changed = true
+		}
+	}
+	r.mu.Unlock()
+
+	if !changed {
+		return nil
+	}
+	return r.reload()
+}
+
+func (r *certReloader) reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, path := range []string{r.caPath, r.certPath, r.keyPath} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("flintlock TLS: %w", err)
+		}
+		modTimes[path] = info.ModTime()
+	}
+
+	var roots *x509.CertPool
+	if r.caPath != "" {
+		pem, err := os.ReadFile(r.caPath)
+		if err != nil {
+			return fmt.Errorf("flintlock TLS: failed to read CA certificate: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("flintlock TLS: no valid PEM certificates found in CA file %s", r.caPath)
+		}
+	} else {
+		systemRoots, err := x509.SystemCertPool()
+		if err != nil {
+			return fmt.Errorf("flintlock TLS: failed to load system CA pool: %w", err)
+		}
+		roots = systemRoots
+	}
+
+	var cert *tls.Certificate
+	if r.certPath != "" {
+		pair, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+		if err != nil {
+			return fmt.Errorf("flintlock TLS: invalid client certificate %s / key %s: %w", r.certPath, r.keyPath, err)
+		}
+		leaf, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("flintlock TLS: failed to parse client certificate %s: %w", r.certPath, err)
+		}
+		if time.Now().After(leaf.NotAfter) {
+			return fmt.Errorf("flintlock TLS: client certificate %s expired at %s", r.certPath, leaf.NotAfter.Format(time.RFC3339))
+		}
+		pair.Leaf = leaf
+		cert = &pair
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.roots = roots
+	r.cert = cert
+	r.modTimes = modTimes
+
+	return nil
+}
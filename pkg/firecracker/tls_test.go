@@ -0,0 +1,302 @@
+package firecracker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	mvmv1 "github.com/liquidmetal-dev/flintlock/api/services/microvm/v1alpha1"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (ca *testCA) issue(t *testing.T, cn string, notAfter time.Time, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+type stubMicroVMServer struct {
+	mvmv1.UnimplementedMicroVMServer
+
+	mu       sync.Mutex
+	clientCN string
+}
+
+func (s *stubMicroVMServer) ListMicroVMs(ctx context.Context, req *mvmv1.ListMicroVMsRequest) (*mvmv1.ListMicroVMsResponse, error) {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+			s.mu.Lock()
+			s.clientCN = info.State.PeerCertificates[0].Subject.CommonName
+			s.mu.Unlock()
+		}
+	}
+	return &mvmv1.ListMicroVMsResponse{}, nil
+}
+
+// startTLSFlintlock runs a gRPC stand-in for flintlockd that requires a client
+// certificate signed by ca.
+func startTLSFlintlock(t *testing.T, ca *testCA) (string, *stubMicroVMServer) {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, "localhost", time.Now().Add(time.Hour), x509.ExtKeyUsageServerAuth)
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("Failed to load server key pair: %v", err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})))
+	stub := &stubMicroVMServer{}
+	mvmv1.RegisterMicroVMServer(server, stub)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	_, port, _ := net.SplitHostPort(lis.Addr().String())
+	return "localhost:" + port, stub
+}
+
+func TestNewClient_MutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	endpoint, stub := startTLSFlintlock(t, ca)
+
+	dir := t.TempDir()
+	certPEM, keyPEM := ca.issue(t, "firerunner", time.Now().Add(time.Hour), x509.ExtKeyUsageClientAuth)
+
+	client, err := NewClient(&config.FlintlockConfig{
+		Endpoint:      endpoint,
+		Timeout:       5 * time.Second,
+		TLSEnabled:    true,
+		TLSCACert:     writeFile(t, dir, "ca.pem", ca.pem),
+		TLSClientCert: writeFile(t, dir, "client.pem", certPEM),
+		TLSClientKey:  writeFile(t, dir, "client-key.pem", keyPEM),
+	})
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.Health(context.Background()); err != nil {
+		t.Fatalf("Health() over mTLS failed: %v", err)
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if stub.clientCN != "firerunner" {
+		t.Errorf("Expected server to see client CN firerunner, got %q", stub.clientCN)
+	}
+}
+
+func TestNewClient_TLSRejectsUnknownServer(t *testing.T) {
+	serverCA := newTestCA(t)
+	endpoint, _ := startTLSFlintlock(t, serverCA)
+
+	otherCA := newTestCA(t)
+	dir := t.TempDir()
+	certPEM, keyPEM := serverCA.issue(t, "firerunner", time.Now().Add(time.Hour), x509.ExtKeyUsageClientAuth)
+
+	client, err := NewClient(&config.FlintlockConfig{
+		Endpoint:      endpoint,
+		Timeout:       5 * time.Second,
+		TLSEnabled:    true,
+		TLSCACert:     writeFile(t, dir, "ca.pem", otherCA.pem),
+		TLSClientCert: writeFile(t, dir, "client.pem", certPEM),
+		TLSClientKey:  writeFile(t, dir, "client-key.pem", keyPEM),
+	})
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.Health(context.Background()); err == nil {
+		t.Error("Health() should fail when the server certificate is not signed by the configured CA")
+	}
+}
+
+func TestNewClient_InvalidTLSFiles(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certPEM, keyPEM := ca.issue(t, "firerunner", time.Now().Add(time.Hour), x509.ExtKeyUsageClientAuth)
+	expiredPEM, expiredKeyPEM := ca.issue(t, "firerunner", time.Now().Add(-time.Hour), x509.ExtKeyUsageClientAuth)
+	_, otherKeyPEM := ca.issue(t, "other", time.Now().Add(time.Hour), x509.ExtKeyUsageClientAuth)
+
+	caPath := writeFile(t, dir, "ca.pem", ca.pem)
+	certPath := writeFile(t, dir, "client.pem", certPEM)
+	keyPath := writeFile(t, dir, "client-key.pem", keyPEM)
+
+	tests := []struct {
+		name    string
+		cfg     config.FlintlockConfig
+		wantErr string
+	}{
+		{
+			name:    "garbage CA",
+			cfg:     config.FlintlockConfig{TLSCACert: writeFile(t, dir, "bad-ca.pem", []byte("not a cert"))},
+			wantErr: "no valid PEM certificates",
+		},
+		{
+			name:    "missing CA file",
+			cfg:     config.FlintlockConfig{TLSCACert: filepath.Join(dir, "missing.pem")},
+			wantErr: "no such file",
+		},
+		{
+			name:    "cert without key",
+			cfg:     config.FlintlockConfig{TLSCACert: caPath, TLSClientCert: certPath},
+			wantErr: "must be set together",
+		},
+		{
+			name:    "mismatched key",
+			cfg:     config.FlintlockConfig{TLSCACert: caPath, TLSClientCert: certPath, TLSClientKey: writeFile(t, dir, "other-key.pem", otherKeyPEM)},
+			wantErr: "invalid client certificate",
+		},
+		{
+			name: "expired client certificate",
+			cfg: config.FlintlockConfig{
+				TLSCACert:     caPath,
+				TLSClientCert: writeFile(t, dir, "expired.pem", expiredPEM),
+				TLSClientKey:  writeFile(t, dir, "expired-key.pem", expiredKeyPEM),
+			},
+			wantErr: "expired",
+		},
+		{
+			name: "valid",
+			cfg:  config.FlintlockConfig{TLSCACert: caPath, TLSClientCert: certPath, TLSClientKey: keyPath},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Endpoint = "localhost:9090"
+			cfg.TLSEnabled = true
+
+			client, err := NewClient(&cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("NewClient() failed: %v", err)
+				}
+				client.Close()
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCertReloader_PicksUpRotatedCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certPEM, keyPEM := ca.issue(t, "original", time.Now().Add(time.Hour), x509.ExtKeyUsageClientAuth)
+
+	reloader := &certReloader{
+		caPath:   writeFile(t, dir, "ca.pem", ca.pem),
+		certPath: writeFile(t, dir, "client.pem", certPEM),
+		keyPath:  writeFile(t, dir, "client-key.pem", keyPEM),
+		modTimes: make(map[string]time.Time),
+	}
+	if err := reloader.reload(); err != nil {
+		t.Fatalf("reload() failed: %v", err)
+	}
+
+	rotatedPEM, rotatedKeyPEM := ca.issue(t, "rotated", time.Now().Add(time.Hour), x509.ExtKeyUsageClientAuth)
+	writeFile(t, dir, "client.pem", rotatedPEM)
+	writeFile(t, dir, "client-key.pem", rotatedKeyPEM)
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(reloader.certPath, future, future)
+	_ = os.Chtimes(reloader.keyPath, future, future)
+
+	cert, err := reloader.getClientCertificate(nil)
+	if err != nil {
+		t.Fatalf("getClientCertificate() failed: %v", err)
+	}
+
+	if cert.Leaf.Subject.CommonName != "rotated" {
+		t.Errorf("Expected rotated certificate, got CN %q", cert.Leaf.Subject.CommonName)
+	}
+}
@@ -0,0 +1,74 @@
+package forge
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultDeliveryTTL        = 10 * time.Minute
+	DefaultDeliveryMaxEntries = 10000
+)
+
+// DeliveryCache remembers recently seen webhook deliveries so that retries
+// from a forge are processed only once. Entries expire after ttl and the
+// oldest entry is evicted once maxEntries is reached.
+type DeliveryCache struct {
+	mu         sync.Mutex
+	entries    map[string]time.Time
+	ttl        time.Duration
+	maxEntries int
+}
+
+func NewDeliveryCache(ttl time.Duration, maxEntries int) *DeliveryCache {
+	return &DeliveryCache{
+		entries:    make(map[string]time.Time),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+	}
+}
+
+// MarkSeen records key and reports whether it was already recorded and has
+// not expired yet.
+func (c *DeliveryCache) MarkSeen(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if seenAt, exists := c.entries[key]; exists && now.Sub(seenAt) < c.ttl {
+		return true
+	}
+
+	if len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[key] = now
+	return false
+}
+
+// Forget removes key so that a redelivery is processed again.
+func (c *DeliveryCache) Forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// evict must be called with mu held. It drops expired entries and, if the
+// cache is still full, the oldest one.
+func (c *DeliveryCache) evict(now time.Time) {
+	var oldestKey string
+	var oldestAt time.Time
+	for key, seenAt := range c.entries {
+		if now.Sub(seenAt) >= c.ttl {
+			delete(c.entries, key)
+			continue
+		}
+		if oldestKey == "" || seenAt.Before(oldestAt) {
+			oldestKey, oldestAt = key, seenAt
+		}
+	}
+
+	if len(c.entries) >= c.maxEntries && oldestKey != "" {
+		delete(c.entries, oldestKey)
+	}
+}
@@ -0,0 +1,33 @@
+package forge
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeliveryCache(t *testing.T) {
+	cache := NewDeliveryCache(time.Hour, 2)
+
+	if cache.MarkSeen("a") {
+		t.Error("a should not be seen yet")
+	}
+	if !cache.MarkSeen("a") {
+		t.Error("a should be seen")
+	}
+
+	cache.MarkSeen("b")
+	cache.MarkSeen("c")
+	if len(cache.entries) != 2 {
+		t.Errorf("Expected cache to stay bounded at 2 entries, got %d", len(cache.entries))
+	}
+	if !cache.MarkSeen("c") {
+		t.Error("Newest entry should survive eviction")
+	}
+
+	expiring := NewDeliveryCache(time.Millisecond, 10)
+	expiring.MarkSeen("a")
+	time.Sleep(5 * time.Millisecond)
+	if expiring.MarkSeen("a") {
+		t.Error("Expired entry should not be reported as seen")
+	}
+}
@@ -0,0 +1,80 @@
+// Package forge describes the code forges FireRunner runs CI jobs for, so
+// the scheduler can handle GitLab and GitHub jobs the same way.
+package forge
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/ismoilovdevml/firerunner/pkg/cloudinit"
+)
+
+const (
+	GitLab  = "gitlab"
+	GitHub  = "github"
+	Forgejo = "forgejo"
+)
+
+// Job identifies a CI job on its forge.
+type Job struct {
+	ID int64
+	// ProjectID is the GitLab project or GitHub repository ID.
+	ProjectID int64
+	// PipelineID is the GitLab pipeline or GitHub workflow run ID.
+	PipelineID int64
+	// Repository is the "owner/name" of a GitHub or Forgejo repository.
+	Repository string
+	Tags       []string
+
+	// Ref is the branch or tag the job runs for; Tag reports which.
+	Ref string
+	Tag bool
+	// ProtectedRef and Source, what started the pipeline (e.g. "push" or
+	// "schedule"), are only known for GitLab jobs, as is Fork, whether the
+	// job runs for a merge request from a fork.
+	ProtectedRef bool
+	Source       string
+	Fork         bool
+}
+
+// Runner is a single-use runner registered for one job. Guest is how the
+// VM starts it.
+type Runner struct {
+	ID    int64
+	Guest *cloudinit.Runner
+}
+
+type Forge interface {
+	RegisterRunner(ctx context.Context, job Job, vmID string) (*Runner, error)
+	UnregisterRunner(ctx context.Context, job Job, runnerID int64) error
+	// WaitForJob blocks until the job has finished and returns an error
+	// unless it succeeded.
+	WaitForJob(ctx context.Context, job Job) error
+	// JobPending reports whether the job still waits for a runner.
+	JobPending(ctx context.Context, job Job) (bool, error)
+	// CancelJob cancels the job on the forge, or returns
+	// ErrCancelUnsupported.
+	CancelJob(ctx context.Context, job Job) error
+}
+
+// ErrCancelUnsupported is returned by forges whose API cannot cancel a job.
+var ErrCancelUnsupported = errors.New("the forge cannot cancel jobs through its API")
+
+// TagPrefixes start the tags and labels of jobs that ask for a FireRunner
+// VM, e.g. "firerunner" or "firecracker-4cpu-8gb".
+var TagPrefixes = []string{"firecracker", "microvm", "firerunner", "actuated"}
+
+// HasFireRunnerTag reports whether a job asks for a FireRunner VM through
+// one of its tags or labels.
+func HasFireRunnerTag(tags []string) bool {
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		for _, prefix := range TagPrefixes {
+			if strings.HasPrefix(tag, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
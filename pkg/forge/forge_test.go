@@ -0,0 +1,51 @@
+package forge
+
+import "testing"
+
+func TestHasFireRunnerTag(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     []string
+		expected bool
+	}{
+		{
+			name:     "has firecracker tag",
+			tags:     []string{"firecracker-2cpu-4gb"},
+			expected: true,
+		},
+		{
+			name:     "has microvm tag",
+			tags:     []string{"docker", "microvm"},
+			expected: true,
+		},
+		{
+			name:     "has firerunner tag",
+			tags:     []string{"firerunner-4cpu-8gb"},
+			expected: true,
+		},
+		{
+			name:     "has actuated label",
+			tags:     []string{"self-hosted", "actuated-2cpu-4gb"},
+			expected: true,
+		},
+		{
+			name:     "no relevant tags",
+			tags:     []string{"docker", "kubernetes"},
+			expected: false,
+		},
+		{
+			name:     "empty tags",
+			tags:     []string{},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := HasFireRunnerTag(tt.tags)
+			if result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
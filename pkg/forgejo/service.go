@@ -0,0 +1,268 @@
+package forgejo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/cloudinit"
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+	"github.com/ismoilovdevml/firerunner/pkg/metrics"
+)
+
+const (
+	defaultPollInterval = 5 * time.Second
+	// registerPath is the Connect endpoint act_runner registers with.
+	registerPath = "/api/actions/runner.v1.RunnerService/Register"
+)
+
+// Service talks to the Forgejo API and implements forge.Forge with an
+// ephemeral act_runner per job. The runner is registered on the host, so the
+// guest only gets the credentials of its own runner and never the
+// repository's registration token. An ephemeral runner removes itself after
+// its job; UnregisterRunner deletes one whose VM never ran it.
+type Service struct {
+	url        string
+	token      string
+	httpClient *http.Client
+	logger     *logrus.Logger
+
+	pollInterval time.Duration
+}
+
+func NewService(cfg *config.ForgejoConfig, logger *logrus.Logger) *Service {
+	return &Service{
+		url:          strings.TrimSuffix(cfg.URL, "/"),
+		token:        cfg.Token,
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+		logger:       logger,
+		pollInterval: defaultPollInterval,
+	}
+}
+
+// RegistrationToken returns the token act_runner registers with for a
+// repository.
+func (s *Service) RegistrationToken(ctx context.Context, repository string) (string, error) {
+	var result struct {
+		Token string `json:"token"`
+	}
+	path := fmt.Sprintf("/repos/%s/actions/runners/registration-token", repository)
+	if err := s.get(ctx, path, &result); err != nil {
+		metrics.RunnerRegistrationFailures.Inc()
+		return "", fmt.Errorf("failed to get runner registration token for %s: %w", repository, err)
+	}
+	if result.Token == "" {
+		metrics.RunnerRegistrationFailures.Inc()
+		return "", fmt.Errorf("forgejo returned an empty registration token for %s", repository)
+	}
+	return result.Token, nil
+}
+
+func (s *Service) GetWorkflowJob(ctx context.Context, repository string, jobID int64) (*WorkflowJob, error) {
+	var job WorkflowJob
+	path := fmt.Sprintf("/repos/%s/actions/jobs/%d", repository, jobID)
+	if err := s.get(ctx, path, &job); err != nil {
+		return nil, fmt.Errorf("failed to get workflow job %d: %w", jobID, err)
+	}
+	return &job, nil
+}
+
+func (s *Service) RegisterRunner(ctx context.Context, job forge.Job, vmID string) (*forge.Runner, error) {
+	s.logger.WithFields(logrus.Fields{
+		"repository": job.Repository,
+		"job_id":     job.ID,
+		"vm_id":      vmID,
+		"labels":     job.Tags,
+	}).Info("Preparing ephemeral Forgejo runner")
+
+	token, err := s.RegistrationToken(ctx, job.Repository)
+	if err != nil {
+		return nil, err
+	}
+
+	runner, err := s.register(ctx, token, vmID, runnerLabels(job.Tags))
+	if err != nil {
+		metrics.RunnerRegistrationFailures.Inc()
+		return nil, fmt.Errorf("failed to register runner for %s: %w", job.Repository, err)
+	}
+
+	runnerFile, err := json.Marshal(runner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode runner file: %w", err)
+	}
+
+	return &forge.Runner{
+		ID: runner.ID,
+		Guest: &cloudinit.Runner{
+			Forge:      forge.Forgejo,
+			URL:        s.url,
+			Name:       vmID,
+			Tags:       runner.Labels,
+			RunnerFile: runnerFile,
+		},
+	}, nil
+}
+
+// register registers an ephemeral act_runner through the runner API, the
+// way "act_runner register" does, and returns its runner file.
+func (s *Service) register(ctx context.Context, token, name string, labels []string) (*RunnerFile, error) {
+	body, err := json.Marshal(registerRequest{
+		Name:      name,
+		Token:     token,
+		Version:   "firerunner",
+		Labels:    labels,
+		Ephemeral: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+registerPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("forgejo runner API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	var result registerResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode Forgejo response: %w", err)
+	}
+	if result.Runner.UUID == "" || result.Runner.Token == "" {
+		return nil, fmt.Errorf("forgejo returned no runner credentials")
+	}
+
+	id, err := result.Runner.ID.Int64()
+	if err != nil {
+		return nil, fmt.Errorf("forgejo returned an invalid runner id %q", result.Runner.ID)
+	}
+	return &RunnerFile{
+		Warning:   "Generated by FireRunner for a single ephemeral job.",
+		ID:        id,
+		UUID:      result.Runner.UUID,
+		Name:      result.Runner.Name,
+		Token:     result.Runner.Token,
+		Address:   s.url,
+		Labels:    result.Runner.Labels,
+		Ephemeral: true,
+	}, nil
+}
+
+// DeleteRunner removes a runner of a repository. A runner that is already
+// gone is not an error.
+func (s *Service) DeleteRunner(ctx context.Context, repository string, runnerID int64) error {
+	path := fmt.Sprintf("/repos/%s/actions/runners/%d", repository, runnerID)
+	err := s.do(ctx, http.MethodDelete, path, http.StatusNoContent, nil)
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete runner %d: %w", runnerID, err)
+	}
+	return nil
+}
+
+func (s *Service) UnregisterRunner(ctx context.Context, job forge.Job, runnerID int64) error {
+	return s.DeleteRunner(ctx, job.Repository, runnerID)
+}
+
+// CancelJob returns forge.ErrCancelUnsupported: the Forgejo API has no call
+// to cancel a job.
+func (s *Service) CancelJob(ctx context.Context, job forge.Job) error {
+	return forge.ErrCancelUnsupported
+}
+
+func (s *Service) JobPending(ctx context.Context, job forge.Job) (bool, error) {
+	workflowJob, err := s.GetWorkflowJob(ctx, job.Repository, job.ID)
+	if err != nil {
+		return false, err
+	}
+	return workflowJob.Status == "queued" || workflowJob.Status == "waiting" || workflowJob.Status == "pending", nil
+}
+
+func (s *Service) WaitForJob(ctx context.Context, job forge.Job) error {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			workflowJob, err := s.GetWorkflowJob(ctx, job.Repository, job.ID)
+			if err != nil {
+				s.logger.WithError(err).WithField("job_id", job.ID).Error("Failed to get workflow job status")
+				continue
+			}
+			if workflowJob.Status != "completed" {
+				continue
+			}
+			if workflowJob.Conclusion != "success" {
+				return fmt.Errorf("workflow job %d concluded with %s", job.ID, workflowJob.Conclusion)
+			}
+			return nil
+		}
+	}
+}
+
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("forgejo API returned %d: %s", e.status, e.message)
+}
+
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.status == http.StatusNotFound
+}
+
+func (s *Service) get(ctx context.Context, path string, result interface{}) error {
+	return s.do(ctx, http.MethodGet, path, http.StatusOK, result)
+}
+
+func (s *Service) do(ctx context.Context, method, path string, wantStatus int, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, s.url+"/api/v1"+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "token "+s.token)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != wantStatus {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &apiError{status: resp.StatusCode, message: strings.TrimSpace(string(message))}
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode Forgejo response: %w", err)
+	}
+	return nil
+}
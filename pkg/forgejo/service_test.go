@@ -0,0 +1,153 @@
+package forgejo
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+)
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func TestParseLabels(t *testing.T) {
+	labels := []string{"firerunner-4cpu-8gb:docker://node:20", "ubuntu-latest", " microvm:host ", "firerunner-image:ubuntu-24.04"}
+
+	names := ParseLabels(labels)
+	if want := []string{"firerunner-4cpu-8gb", "ubuntu-latest", "microvm", "firerunner-image:ubuntu-24.04"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("ParseLabels() = %v, want %v", names, want)
+	}
+}
+
+func TestService_RegisterRunner(t *testing.T) {
+	var auth string
+	var register registerRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/repos/acme/app/actions/runners/registration-token":
+			auth = r.Header.Get("Authorization")
+			json.NewEncoder(w).Encode(map[string]string{"token": "reg-token"})
+		case registerPath:
+			if err := json.NewDecoder(r.Body).Decode(&register); err != nil {
+				t.Errorf("Failed to decode register request: %v", err)
+			}
+			w.Write([]byte(`{"runner":{"id":"42","uuid":"runner-uuid","token":"runner-token","name":"vm-1-abcd","labels":["firerunner-4cpu-8gb:host"],"ephemeral":true}}`))
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	service := NewService(&config.ForgejoConfig{URL: server.URL + "/", Token: "api-token"}, testLogger())
+
+	runner, err := service.RegisterRunner(context.Background(), forge.Job{
+		ID:         1,
+		Repository: "acme/app",
+		Tags:       []string{"firerunner-4cpu-8gb"},
+	}, "vm-1-abcd")
+	if err != nil {
+		t.Fatalf("RegisterRunner() error = %v", err)
+	}
+
+	if auth != "token api-token" {
+		t.Errorf("Expected API token, got %q", auth)
+	}
+	if register.Token != "reg-token" || register.Name != "vm-1-abcd" || !register.Ephemeral {
+		t.Errorf("Unexpected register request: %+v", register)
+	}
+	if want := []string{"firerunner-4cpu-8gb:host"}; !reflect.DeepEqual(register.Labels, want) {
+		t.Errorf("Expected labels %v, got %v", want, register.Labels)
+	}
+
+	guest := runner.Guest
+	if runner.ID != 42 || guest.Forge != forge.Forgejo || guest.Name != "vm-1-abcd" {
+		t.Errorf("Unexpected runner: %+v", runner)
+	}
+	if guest.Token != "" || strings.Contains(string(guest.RunnerFile), "reg-token") {
+		t.Error("The registration token must not reach the guest")
+	}
+
+	var file RunnerFile
+	if err := json.Unmarshal(guest.RunnerFile, &file); err != nil {
+		t.Fatalf("Failed to decode runner file: %v", err)
+	}
+	if file.ID != 42 || file.UUID != "runner-uuid" || file.Token != "runner-token" || file.Address != server.URL || !file.Ephemeral {
+		t.Errorf("Unexpected runner file: %+v", file)
+	}
+}
+
+func TestService_WaitForJob(t *testing.T) {
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		job := WorkflowJob{ID: 1, Status: "in_progress"}
+		if polls > 1 {
+			job.Status, job.Conclusion = "completed", "failure"
+		}
+		json.NewEncoder(w).Encode(job)
+	}))
+	defer server.Close()
+
+	service := NewService(&config.ForgejoConfig{URL: server.URL, Token: "api-token"}, testLogger())
+	service.pollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := service.WaitForJob(ctx, forge.Job{ID: 1, Repository: "acme/app"}); err == nil {
+		t.Error("WaitForJob() should fail for a failed job")
+	}
+	if polls != 2 {
+		t.Errorf("Expected 2 polls, got %d", polls)
+	}
+}
+
+func TestService_UnregisterRunner(t *testing.T) {
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete || r.Header.Get("Authorization") != "token api-token" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		deleted = append(deleted, r.URL.Path)
+		switch r.URL.Path {
+		case "/api/v1/repos/acme/app/actions/runners/7":
+			w.WriteHeader(http.StatusNoContent)
+		case "/api/v1/repos/acme/app/actions/runners/8":
+			http.Error(w, "runner not found", http.StatusNotFound)
+		default:
+			http.Error(w, "forbidden", http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	service := NewService(&config.ForgejoConfig{URL: server.URL, Token: "api-token"}, testLogger())
+	job := forge.Job{ID: 1, Repository: "acme/app"}
+
+	if err := service.UnregisterRunner(context.Background(), job, 7); err != nil {
+		t.Errorf("UnregisterRunner() failed: %v", err)
+	}
+	// A runner that removed itself after its job is already gone.
+	if err := service.UnregisterRunner(context.Background(), job, 8); err != nil {
+		t.Errorf("UnregisterRunner() of a deleted runner failed: %v", err)
+	}
+	if err := service.UnregisterRunner(context.Background(), job, 9); err == nil {
+		t.Error("UnregisterRunner() should fail when the API refuses")
+	}
+	if len(deleted) != 3 {
+		t.Errorf("Expected 3 delete requests, got %v", deleted)
+	}
+}
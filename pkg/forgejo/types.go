@@ -0,0 +1,125 @@
+package forgejo
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+)
+
+// WorkflowJobEvent is the payload of a workflow_job webhook. Forgejo and
+// Gitea send it in the same shape as GitHub.
+type WorkflowJobEvent struct {
+	Action      string      `json:"action"`
+	WorkflowJob WorkflowJob `json:"workflow_job"`
+	Repository  Repository  `json:"repository"`
+}
+
+type WorkflowJob struct {
+	ID          int64     `json:"id"`
+	RunID       int64     `json:"run_id"`
+	Name        string    `json:"name"`
+	Status      string    `json:"status"`
+	Conclusion  string    `json:"conclusion"`
+	Labels      []string  `json:"labels"`
+	HeadBranch  string    `json:"head_branch"`
+	RunnerID    int64     `json:"runner_id"`
+	RunnerName  string    `json:"runner_name"`
+	CreatedAt   time.Time `json:"created_at"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+type Repository struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+}
+
+// RunnerFile is the ".runner" file act_runner keeps its registration in.
+type RunnerFile struct {
+	Warning   string   `json:"WARNING"`
+	ID        int64    `json:"id"`
+	UUID      string   `json:"uuid"`
+	Name      string   `json:"name"`
+	Token     string   `json:"token"`
+	Address   string   `json:"address"`
+	Labels    []string `json:"labels"`
+	Ephemeral bool     `json:"ephemeral"`
+}
+
+// registerRequest and registerResponse are the JSON form of the runner
+// API's Register call. The response encodes the int64 runner ID as a string.
+type registerRequest struct {
+	Name      string   `json:"name"`
+	Token     string   `json:"token"`
+	Version   string   `json:"version"`
+	Labels    []string `json:"labels"`
+	Ephemeral bool     `json:"ephemeral"`
+}
+
+type registerResponse struct {
+	Runner struct {
+		ID     json.Number `json:"id"`
+		UUID   string      `json:"uuid"`
+		Name   string      `json:"name"`
+		Token  string      `json:"token"`
+		Labels []string    `json:"labels"`
+	} `json:"runner"`
+}
+
+// Job returns the job the event is about as the scheduler sees it.
+func (e *WorkflowJobEvent) Job() forge.Job {
+	return forge.Job{
+		ID:         e.WorkflowJob.ID,
+		ProjectID:  e.Repository.ID,
+		PipelineID: e.WorkflowJob.RunID,
+		Repository: e.Repository.FullName,
+		Ref:        e.WorkflowJob.HeadBranch,
+		Tags:       ParseLabels(e.WorkflowJob.Labels),
+	}
+}
+
+// labelSchemes are the act_runner schemes a label can end in.
+var labelSchemes = []string{"docker", "host", "lxc"}
+
+// ParseLabels strips the act_runner scheme from labels such as
+// "firerunner-4cpu-8gb:docker://node:20", leaving the names vmspec
+// understands. Names may contain colons themselves, as in
+// "firerunner-image:ubuntu-24.04".
+func ParseLabels(labels []string) []string {
+	names := make([]string, 0, len(labels))
+	for _, label := range labels {
+		name := stripScheme(strings.TrimSpace(label))
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func stripScheme(label string) string {
+	for i := 0; i < len(label); i++ {
+		if label[i] != ':' {
+			continue
+		}
+		rest := label[i+1:]
+		for _, scheme := range labelSchemes {
+			if after, ok := strings.CutPrefix(rest, scheme); ok && (after == "" || after[0] == ':') {
+				return label[:i]
+			}
+		}
+	}
+	return label
+}
+
+// runnerLabels gives every label the host scheme: the VM itself runs the
+// job, so act_runner must not start a container for it.
+func runnerLabels(names []string) []string {
+	labels := make([]string, 0, len(names))
+	for _, name := range names {
+		labels = append(labels, name+":host")
+	}
+	return labels
+}
@@ -0,0 +1,218 @@
+package forgejo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+	"github.com/ismoilovdevml/firerunner/pkg/metrics"
+)
+
+// Forgejo sends its own headers; Gitea and older Forgejo releases send the
+// Gitea ones.
+const (
+	HeaderForgejoEvent     = "X-Forgejo-Event"
+	HeaderForgejoDelivery  = "X-Forgejo-Delivery"
+	HeaderForgejoSignature = "X-Forgejo-Signature"
+	HeaderGiteaEvent       = "X-Gitea-Event"
+	HeaderGiteaDelivery    = "X-Gitea-Delivery"
+	HeaderGiteaSignature   = "X-Gitea-Signature"
+
+	maxBodySize = 25 << 20
+)
+
+type EventProcessor interface {
+	ProcessForgejoJobEvent(event *WorkflowJobEvent) error
+}
+
+// Dispatcher persists accepted deliveries and processes them in the
+// background.
+type Dispatcher interface {
+	Submit(forgeName, eventType string, body []byte) error
+}
+
+type WebhookHandler struct {
+	secret     string
+	logger     *logrus.Logger
+	processor  EventProcessor
+	deliveries *forge.DeliveryCache
+	dispatcher Dispatcher
+}
+
+func NewWebhookHandler(secret string, logger *logrus.Logger, processor EventProcessor) *WebhookHandler {
+	return &WebhookHandler{
+		secret:     secret,
+		logger:     logger,
+		processor:  processor,
+		deliveries: forge.NewDeliveryCache(forge.DefaultDeliveryTTL, forge.DefaultDeliveryMaxEntries),
+	}
+}
+
+// SetDispatcher makes the handler acknowledge events with 202 Accepted and
+// leave their processing to dispatcher.
+func (h *WebhookHandler) SetDispatcher(dispatcher Dispatcher) {
+	h.dispatcher = dispatcher
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		metrics.WebhookEventsRejected.WithLabelValues("method_not_allowed").Inc()
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		metrics.WebhookEventsRejected.WithLabelValues("read_error").Inc()
+		h.logger.WithError(err).Error("Failed to read Forgejo webhook body")
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if !h.verifySignature(headerValue(r, HeaderForgejoSignature, HeaderGiteaSignature), body) {
+		metrics.WebhookEventsRejected.WithLabelValues("invalid_signature").Inc()
+		h.logger.Warn("Invalid Forgejo webhook signature")
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	eventType := headerValue(r, HeaderForgejoEvent, HeaderGiteaEvent)
+	if eventType == "" {
+		metrics.WebhookEventsRejected.WithLabelValues("missing_event").Inc()
+		h.logger.Warn("Missing X-Forgejo-Event header")
+		http.Error(w, "Missing event type", http.StatusBadRequest)
+		return
+	}
+
+	metrics.WebhookEventsReceived.WithLabelValues(eventType).Inc()
+
+	// Redelivering a webhook from the Forgejo UI keeps its delivery UUID.
+	key := headerValue(r, HeaderForgejoDelivery, HeaderGiteaDelivery)
+	if key != "" && h.deliveries.MarkSeen(key) {
+		metrics.DuplicateEventsSuppressed.WithLabelValues("forgejo_delivery").Inc()
+		h.logger.WithFields(logrus.Fields{
+			"event_type": eventType,
+			"delivery":   key,
+		}).Info("Ignoring duplicate Forgejo webhook delivery")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status":"duplicate"}`))
+		return
+	}
+
+	if h.dispatcher != nil {
+		h.acceptEvent(w, eventType, body, key)
+		return
+	}
+
+	if err := h.ProcessEvent(eventType, body); err != nil {
+		if key != "" {
+			h.deliveries.Forget(key)
+		}
+		metrics.WebhookEventsRejected.WithLabelValues("processing_error").Inc()
+		h.logger.WithError(err).Error("Failed to process Forgejo webhook event")
+		http.Error(w, "Failed to process event", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"accepted"}`))
+}
+
+func (h *WebhookHandler) acceptEvent(w http.ResponseWriter, eventType string, body []byte, key string) {
+	if !json.Valid(body) {
+		metrics.WebhookEventsRejected.WithLabelValues("invalid_payload").Inc()
+		h.logger.WithField("event_type", eventType).Warn("Forgejo webhook body is not valid JSON")
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.dispatcher.Submit(forge.Forgejo, eventType, body); err != nil {
+		if key != "" {
+			h.deliveries.Forget(key)
+		}
+		metrics.WebhookEventsRejected.WithLabelValues("persist_error").Inc()
+		h.logger.WithError(err).Error("Failed to accept Forgejo webhook event")
+		http.Error(w, "Failed to accept event", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(`{"status":"accepted"}`))
+}
+
+// verifySignature checks the hex HMAC-SHA256 Forgejo computes over the body
+// with the webhook secret. Unsigned deliveries are always rejected.
+func (h *WebhookHandler) verifySignature(signature string, body []byte) bool {
+	if h.secret == "" || signature == "" {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(h.secret))
+	mac.Write(body)
+	expectedMAC := hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(signature), []byte(expectedMAC))
+}
+
+// ProcessEvent parses a webhook body and hands supported events to the
+// processor.
+func (h *WebhookHandler) ProcessEvent(eventType string, body []byte) error {
+	switch eventType {
+	case "workflow_job":
+		return h.processWorkflowJobEvent(body)
+	default:
+		h.logger.WithField("event_type", eventType).Debug("Ignoring unsupported event type")
+		return nil
+	}
+}
+
+func (h *WebhookHandler) processWorkflowJobEvent(body []byte) error {
+	var event WorkflowJobEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return fmt.Errorf("failed to parse workflow_job event: %w", err)
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"action":     event.Action,
+		"job_id":     event.WorkflowJob.ID,
+		"job_name":   event.WorkflowJob.Name,
+		"run_id":     event.WorkflowJob.RunID,
+		"repository": event.Repository.FullName,
+		"conclusion": event.WorkflowJob.Conclusion,
+	}).Info("Processing Forgejo workflow_job event")
+
+	switch event.Action {
+	case "queued", "completed":
+	default:
+		h.logger.WithField("action", event.Action).Debug("Ignoring workflow_job action")
+		return nil
+	}
+
+	if !forge.HasFireRunnerTag(ParseLabels(event.WorkflowJob.Labels)) {
+		h.logger.Debug("Workflow job does not have firerunner labels, skipping")
+		return nil
+	}
+
+	if h.processor != nil {
+		return h.processor.ProcessForgejoJobEvent(&event)
+	}
+
+	return nil
+}
+
+func headerValue(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if value := r.Header.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,141 @@
+package forgejo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+)
+
+type recordingProcessor struct {
+	events []*WorkflowJobEvent
+}
+
+func (p *recordingProcessor) ProcessForgejoJobEvent(event *WorkflowJobEvent) error {
+	p.events = append(p.events, event)
+	return nil
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookHandler(t *testing.T) {
+	body := `{"action":"queued","workflow_job":{"id":1,"run_id":3,"head_branch":"main","labels":["firerunner-2cpu-4gb:host"]},"repository":{"id":9,"full_name":"acme/app"}}`
+
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		wantStatus int
+		wantEvents int
+	}{
+		{
+			name:       "forgejo headers",
+			headers:    map[string]string{HeaderForgejoEvent: "workflow_job", HeaderForgejoSignature: sign("secret", body)},
+			wantStatus: http.StatusOK,
+			wantEvents: 1,
+		},
+		{
+			name:       "gitea headers",
+			headers:    map[string]string{HeaderGiteaEvent: "workflow_job", HeaderGiteaSignature: sign("secret", body)},
+			wantStatus: http.StatusOK,
+			wantEvents: 1,
+		},
+		{
+			name:       "invalid signature",
+			headers:    map[string]string{HeaderForgejoEvent: "workflow_job", HeaderForgejoSignature: sign("other", body)},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "unsigned",
+			headers:    map[string]string{HeaderForgejoEvent: "workflow_job"},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := &recordingProcessor{}
+			handler := NewWebhookHandler("secret", testLogger(), processor)
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook/forgejo", strings.NewReader(body))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, rr.Code)
+			}
+			if len(processor.events) != tt.wantEvents {
+				t.Fatalf("Expected %d events, got %d", tt.wantEvents, len(processor.events))
+			}
+			if tt.wantEvents > 0 {
+				job := processor.events[0].Job()
+				if job.ID != 1 || job.ProjectID != 9 || job.Repository != "acme/app" || job.Ref != "main" || job.Tags[0] != "firerunner-2cpu-4gb" {
+					t.Errorf("Unexpected job: %+v", job)
+				}
+			}
+		})
+	}
+}
+
+type recordingDispatcher struct {
+	forges []string
+	err    error
+}
+
+func (d *recordingDispatcher) Submit(forgeName, eventType string, body []byte) error {
+	if d.err != nil {
+		return d.err
+	}
+	d.forges = append(d.forges, forgeName)
+	return nil
+}
+
+func TestWebhookHandler_AcceptsAsynchronously(t *testing.T) {
+	processor := &recordingProcessor{}
+	handler := NewWebhookHandler("secret", testLogger(), processor)
+	dispatcher := &recordingDispatcher{err: errors.New("disk full")}
+	handler.SetDispatcher(dispatcher)
+
+	body := `{"action":"queued","workflow_job":{"id":1,"labels":["firerunner"]},"repository":{"id":9,"full_name":"acme/app"}}`
+	redeliver := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/webhook/forgejo", strings.NewReader(body))
+		req.Header.Set(HeaderGiteaEvent, "workflow_job")
+		req.Header.Set(HeaderGiteaDelivery, "delivery-1")
+		req.Header.Set(HeaderGiteaSignature, sign("secret", body))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// A delivery that could not be accepted must be let through on retry.
+	if rr := redeliver(); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status 500, got %d", rr.Code)
+	}
+	dispatcher.err = nil
+
+	if rr := redeliver(); rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", rr.Code)
+	}
+	if len(processor.events) != 0 {
+		t.Error("Event should not be processed before the dispatcher runs")
+	}
+	if len(dispatcher.forges) != 1 || dispatcher.forges[0] != forge.Forgejo {
+		t.Errorf("Expected the event to be submitted as a Forgejo event, got %v", dispatcher.forges)
+	}
+
+	if rr := redeliver(); rr.Code != http.StatusOK || len(dispatcher.forges) != 1 {
+		t.Errorf("Expected the redelivery to be ignored, got status %d and %d submissions", rr.Code, len(dispatcher.forges))
+	}
+}
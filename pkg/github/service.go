@@ -0,0 +1,249 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/cloudinit"
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+	"github.com/ismoilovdevml/firerunner/pkg/metrics"
+)
+
+const (
+	defaultAPIURL       = "https://api.github.com"
+	defaultPollInterval = 5 * time.Second
+)
+
+// defaultLabels are added to every self-hosted runner by GitHub and cannot
+// be requested as custom labels.
+var defaultLabels = map[string]bool{
+	"self-hosted": true,
+	"linux":       true,
+	"x64":         true,
+	"arm64":       true,
+}
+
+// Service talks to the GitHub REST API and implements forge.Forge with a
+// just-in-time runner per job.
+type Service struct {
+	apiURL        string
+	token         string
+	runnerGroupID int64
+	httpClient    *http.Client
+	logger        *logrus.Logger
+
+	pollInterval time.Duration
+}
+
+func NewService(cfg *config.GitHubConfig, logger *logrus.Logger) *Service {
+	apiURL := strings.TrimSuffix(cfg.APIURL, "/")
+	if apiURL == "" {
+		apiURL = defaultAPIURL
+	}
+
+	runnerGroupID := cfg.RunnerGroupID
+	if runnerGroupID <= 0 {
+		runnerGroupID = 1
+	}
+
+	return &Service{
+		apiURL:        apiURL,
+		token:         cfg.Token,
+		runnerGroupID: runnerGroupID,
+		httpClient:    &http.Client{Timeout: 30 * time.Second},
+		logger:        logger,
+		pollInterval:  defaultPollInterval,
+	}
+}
+
+// GenerateJITConfig creates a runner that accepts a single job and returns
+// the configuration it is started with.
+func (s *Service) GenerateJITConfig(ctx context.Context, repository, name string, labels []string) (*JITConfig, error) {
+	custom := make([]string, 0, len(labels))
+	for _, label := range labels {
+		if !defaultLabels[strings.ToLower(label)] {
+			custom = append(custom, label)
+		}
+	}
+
+	body := map[string]interface{}{
+		"name":            name,
+		"runner_group_id": s.runnerGroupID,
+		"labels":          custom,
+		"work_folder":     "_work",
+	}
+
+	var result struct {
+		Runner struct {
+			ID int64 `json:"id"`
+		} `json:"runner"`
+		EncodedJITConfig string `json:"encoded_jit_config"`
+	}
+	path := fmt.Sprintf("/repos/%s/actions/runners/generate-jitconfig", repository)
+	if err := s.do(ctx, http.MethodPost, path, body, http.StatusCreated, &result); err != nil {
+		metrics.RunnerRegistrationFailures.Inc()
+		return nil, fmt.Errorf("failed to create JIT runner for %s: %w", repository, err)
+	}
+
+	return &JITConfig{
+		RunnerID:         result.Runner.ID,
+		EncodedJITConfig: result.EncodedJITConfig,
+	}, nil
+}
+
+// DeleteRunner removes a runner. JIT runners remove themselves after their
+// job, so a runner that is already gone is not an error.
+func (s *Service) DeleteRunner(ctx context.Context, repository string, runnerID int64) error {
+	path := fmt.Sprintf("/repos/%s/actions/runners/%d", repository, runnerID)
+	err := s.do(ctx, http.MethodDelete, path, nil, http.StatusNoContent, nil)
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete runner %d: %w", runnerID, err)
+	}
+	return nil
+}
+
+func (s *Service) GetWorkflowJob(ctx context.Context, repository string, jobID int64) (*WorkflowJob, error) {
+	var job WorkflowJob
+	path := fmt.Sprintf("/repos/%s/actions/jobs/%d", repository, jobID)
+	if err := s.do(ctx, http.MethodGet, path, nil, http.StatusOK, &job); err != nil {
+		return nil, fmt.Errorf("failed to get workflow job %d: %w", jobID, err)
+	}
+	return &job, nil
+}
+
+func (s *Service) RegisterRunner(ctx context.Context, job forge.Job, vmID string) (*forge.Runner, error) {
+	s.logger.WithFields(logrus.Fields{
+		"repository": job.Repository,
+		"job_id":     job.ID,
+		"vm_id":      vmID,
+		"labels":     job.Tags,
+	}).Info("Creating just-in-time GitHub runner")
+
+	jit, err := s.GenerateJITConfig(ctx, job.Repository, fmt.Sprintf("FireRunner-VM-%s", vmID), job.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return &forge.Runner{
+		ID: jit.RunnerID,
+		Guest: &cloudinit.Runner{
+			Forge:     forge.GitHub,
+			URL:       "https://github.com/" + job.Repository,
+			Name:      vmID,
+			Tags:      job.Tags,
+			JITConfig: jit.EncodedJITConfig,
+		},
+	}, nil
+}
+
+func (s *Service) UnregisterRunner(ctx context.Context, job forge.Job, runnerID int64) error {
+	return s.DeleteRunner(ctx, job.Repository, runnerID)
+}
+
+// CancelJob cancels the workflow run of a job, since GitHub cannot cancel a
+// single job. The other jobs of the run are canceled with it.
+func (s *Service) CancelJob(ctx context.Context, job forge.Job) error {
+	path := fmt.Sprintf("/repos/%s/actions/runs/%d/cancel", job.Repository, job.PipelineID)
+	if err := s.do(ctx, http.MethodPost, path, nil, http.StatusAccepted, nil); err != nil {
+		return fmt.Errorf("failed to cancel workflow run %d: %w", job.PipelineID, err)
+	}
+	return nil
+}
+
+func (s *Service) JobPending(ctx context.Context, job forge.Job) (bool, error) {
+	workflowJob, err := s.GetWorkflowJob(ctx, job.Repository, job.ID)
+	if err != nil {
+		return false, err
+	}
+	return workflowJob.Status == "queued" || workflowJob.Status == "waiting" || workflowJob.Status == "pending", nil
+}
+
+func (s *Service) WaitForJob(ctx context.Context, job forge.Job) error {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			workflowJob, err := s.GetWorkflowJob(ctx, job.Repository, job.ID)
+			if err != nil {
+				s.logger.WithError(err).WithField("job_id", job.ID).Error("Failed to get workflow job status")
+				continue
+			}
+			if workflowJob.Status != "completed" {
+				continue
+			}
+			if workflowJob.Conclusion != "success" {
+				return fmt.Errorf("workflow job %d concluded with %s", job.ID, workflowJob.Conclusion)
+			}
+			return nil
+		}
+	}
+}
+
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("GitHub API returned %d: %s", e.status, e.message)
+}
+
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.status == http.StatusNotFound
+}
+
+func (s *Service) do(ctx context.Context, method, path string, body interface{}, wantStatus int, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.apiURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != wantStatus {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &apiError{status: resp.StatusCode, message: strings.TrimSpace(string(message))}
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode GitHub response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+)
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// fakeGitHub is a minimal stand-in for the GitHub REST API.
+type fakeGitHub struct {
+	mu       sync.Mutex
+	runners  map[int64]string
+	nextID   int64
+	jobs     map[int64]*WorkflowJob
+	jitBody  map[string]interface{}
+	authSeen string
+	canceled []int64
+}
+
+func newFakeGitHub() *fakeGitHub {
+	return &fakeGitHub{runners: make(map[int64]string), nextID: 100, jobs: make(map[int64]*WorkflowJob)}
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.authSeen = r.Header.Get("Authorization")
+
+	var runnerID, jobID, runID int64
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/acme/app/actions/runners/generate-jitconfig":
+		json.NewDecoder(r.Body).Decode(&f.jitBody)
+		f.nextID++
+		f.runners[f.nextID] = f.jitBody["name"].(string)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"runner":             map[string]interface{}{"id": f.nextID},
+			"encoded_jit_config": "ZW5jb2RlZA==",
+		})
+	case r.Method == http.MethodDelete && scan(r.URL.Path, "/repos/acme/app/actions/runners/%d", &runnerID):
+		if _, exists := f.runners[runnerID]; !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.runners, runnerID)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && scan(r.URL.Path, "/repos/acme/app/actions/jobs/%d", &jobID):
+		job, exists := f.jobs[jobID]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(job)
+	case r.Method == http.MethodPost && scan(r.URL.Path, "/repos/acme/app/actions/runs/%d/cancel", &runID):
+		f.canceled = append(f.canceled, runID)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeGitHub) setJob(job *WorkflowJob) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.jobs[job.ID] = job
+}
+
+func scan(path, format string, id *int64) bool {
+	_, err := fmt.Sscanf(path, format, id)
+	return err == nil
+}
+
+func newTestService(t *testing.T, fake *fakeGitHub) *Service {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	service := NewService(&config.GitHubConfig{APIURL: server.URL, Token: "ghp-test"}, testLogger())
+	service.pollInterval = 10 * time.Millisecond
+	return service
+}
+
+func TestService_RegisterRunner(t *testing.T) {
+	fake := newFakeGitHub()
+	service := newTestService(t, fake)
+
+	job := forge.Job{ID: 1, Repository: "acme/app", Tags: []string{"self-hosted", "firerunner-4cpu-8gb"}}
+	runner, err := service.RegisterRunner(context.Background(), job, "vm-1-abcd")
+	if err != nil {
+		t.Fatalf("RegisterRunner() error = %v", err)
+	}
+
+	if runner.ID != 101 || runner.Guest.JITConfig != "ZW5jb2RlZA==" || runner.Guest.Name != "vm-1-abcd" {
+		t.Errorf("Unexpected runner: %+v %+v", runner, runner.Guest)
+	}
+	if fake.authSeen != "Bearer ghp-test" {
+		t.Errorf("Expected bearer token, got %q", fake.authSeen)
+	}
+	if fake.jitBody["name"] != "FireRunner-VM-vm-1-abcd" {
+		t.Errorf("Unexpected runner name %v", fake.jitBody["name"])
+	}
+	labels, _ := fake.jitBody["labels"].([]interface{})
+	if len(labels) != 1 || labels[0] != "firerunner-4cpu-8gb" {
+		t.Errorf("Expected only custom labels, got %v", fake.jitBody["labels"])
+	}
+
+	if err := service.UnregisterRunner(context.Background(), job, runner.ID); err != nil {
+		t.Fatalf("UnregisterRunner() error = %v", err)
+	}
+	// The runner is gone already, e.g. because it removed itself.
+	if err := service.UnregisterRunner(context.Background(), job, runner.ID); err != nil {
+		t.Errorf("UnregisterRunner() of a removed runner error = %v", err)
+	}
+}
+
+func TestService_WaitForJob(t *testing.T) {
+	fake := newFakeGitHub()
+	service := newTestService(t, fake)
+
+	fake.setJob(&WorkflowJob{ID: 1, Status: "in_progress"})
+	fake.setJob(&WorkflowJob{ID: 2, Status: "completed", Conclusion: "failure"})
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		fake.setJob(&WorkflowJob{ID: 1, Status: "completed", Conclusion: "success"})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := service.WaitForJob(ctx, forge.Job{ID: 1, Repository: "acme/app"}); err != nil {
+		t.Errorf("WaitForJob() error = %v", err)
+	}
+	if err := service.WaitForJob(ctx, forge.Job{ID: 2, Repository: "acme/app"}); err == nil {
+		t.Error("WaitForJob() should fail for a failed job")
+	}
+}
+
+func TestService_CancelJob(t *testing.T) {
+	fake := newFakeGitHub()
+	service := newTestService(t, fake)
+
+	if err := service.CancelJob(context.Background(), forge.Job{ID: 1, PipelineID: 9, Repository: "acme/app"}); err != nil {
+		t.Fatalf("CancelJob() error = %v", err)
+	}
+	if len(fake.canceled) != 1 || fake.canceled[0] != 9 {
+		t.Errorf("Expected workflow run 9 to be canceled, got %v", fake.canceled)
+	}
+}
@@ -0,0 +1,53 @@
+package github
+
+import (
+	"time"
+
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+)
+
+// WorkflowJobEvent is the payload of a workflow_job webhook.
+type WorkflowJobEvent struct {
+	Action      string      `json:"action"`
+	WorkflowJob WorkflowJob `json:"workflow_job"`
+	Repository  Repository  `json:"repository"`
+}
+
+type WorkflowJob struct {
+	ID          int64     `json:"id"`
+	RunID       int64     `json:"run_id"`
+	Name        string    `json:"name"`
+	Status      string    `json:"status"`
+	Conclusion  string    `json:"conclusion"`
+	Labels      []string  `json:"labels"`
+	HeadBranch  string    `json:"head_branch"`
+	RunnerID    int64     `json:"runner_id"`
+	RunnerName  string    `json:"runner_name"`
+	HTMLURL     string    `json:"html_url"`
+	CreatedAt   time.Time `json:"created_at"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+type Repository struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+}
+
+// Job returns the job the event is about as the scheduler sees it.
+func (e *WorkflowJobEvent) Job() forge.Job {
+	return forge.Job{
+		ID:         e.WorkflowJob.ID,
+		ProjectID:  e.Repository.ID,
+		PipelineID: e.WorkflowJob.RunID,
+		Repository: e.Repository.FullName,
+		Ref:        e.WorkflowJob.HeadBranch,
+		Tags:       e.WorkflowJob.Labels,
+	}
+}
+
+type JITConfig struct {
+	RunnerID         int64
+	EncodedJITConfig string
+}
@@ -0,0 +1,146 @@
+package github
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+)
+
+type recordingProcessor struct {
+	events []*WorkflowJobEvent
+}
+
+func (p *recordingProcessor) ProcessWorkflowJobEvent(event *WorkflowJobEvent) error {
+	p.events = append(p.events, event)
+	return nil
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func deliver(handler http.Handler, eventType, signature, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/webhook/github", strings.NewReader(body))
+	req.Header.Set(HeaderGitHubEvent, eventType)
+	if signature != "" {
+		req.Header.Set(HeaderSignature, signature)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestWebhookHandler_Signature(t *testing.T) {
+	handler := NewWebhookHandler("secret", testLogger(), &recordingProcessor{})
+	body := `{"zen":"Keep it logically awesome."}`
+
+	tests := []struct {
+		name      string
+		signature string
+		want      int
+	}{
+		{name: "valid", signature: sign("secret", body), want: http.StatusOK},
+		{name: "wrong secret", signature: sign("other", body), want: http.StatusUnauthorized},
+		{name: "missing prefix", signature: strings.TrimPrefix(sign("secret", body), "sha256="), want: http.StatusUnauthorized},
+		{name: "missing", want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := deliver(handler, "ping", tt.signature, body); rr.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, rr.Code)
+			}
+		})
+	}
+}
+
+func TestWebhookHandler_WorkflowJob(t *testing.T) {
+	processor := &recordingProcessor{}
+	handler := NewWebhookHandler("secret", testLogger(), processor)
+
+	events := []string{
+		`{"action":"queued","workflow_job":{"id":1,"run_id":10,"head_branch":"main","labels":["self-hosted","firerunner-4cpu-8gb"]},"repository":{"id":5,"full_name":"acme/app"}}`,
+		`{"action":"queued","workflow_job":{"id":2,"labels":["ubuntu-latest"]},"repository":{"id":5,"full_name":"acme/app"}}`,
+		`{"action":"in_progress","workflow_job":{"id":1,"labels":["firerunner"]},"repository":{"id":5,"full_name":"acme/app"}}`,
+		`{"action":"completed","workflow_job":{"id":1,"conclusion":"cancelled","labels":["firerunner"]},"repository":{"id":5,"full_name":"acme/app"}}`,
+	}
+	for _, body := range events {
+		if rr := deliver(handler, "workflow_job", sign("secret", body), body); rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+	}
+
+	if len(processor.events) != 2 {
+		t.Fatalf("Expected queued and completed FireRunner events, got %d", len(processor.events))
+	}
+
+	job := processor.events[0].Job()
+	if job.ID != 1 || job.ProjectID != 5 || job.PipelineID != 10 || job.Repository != "acme/app" || job.Ref != "main" {
+		t.Errorf("Unexpected job: %+v", job)
+	}
+	if processor.events[1].Action != "completed" || processor.events[1].WorkflowJob.Conclusion != "cancelled" {
+		t.Errorf("Unexpected completed event: %+v", processor.events[1])
+	}
+}
+
+type recordingDispatcher struct {
+	forges []string
+	bodies []string
+	err    error
+}
+
+func (d *recordingDispatcher) Submit(forgeName, eventType string, body []byte) error {
+	if d.err != nil {
+		return d.err
+	}
+	d.forges = append(d.forges, forgeName)
+	d.bodies = append(d.bodies, string(body))
+	return nil
+}
+
+func TestWebhookHandler_AcceptsAsynchronously(t *testing.T) {
+	processor := &recordingProcessor{}
+	handler := NewWebhookHandler("secret", testLogger(), processor)
+	dispatcher := &recordingDispatcher{err: errors.New("disk full")}
+	handler.SetDispatcher(dispatcher)
+
+	body := `{"action":"queued","workflow_job":{"id":1,"labels":["firerunner"]},"repository":{"id":5,"full_name":"acme/app"}}`
+	redeliver := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/webhook/github", strings.NewReader(body))
+		req.Header.Set(HeaderGitHubEvent, "workflow_job")
+		req.Header.Set(HeaderGitHubDelivery, "delivery-1")
+		req.Header.Set(HeaderSignature, sign("secret", body))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// A delivery that could not be accepted must be let through on retry.
+	if rr := redeliver(); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status 500, got %d", rr.Code)
+	}
+	dispatcher.err = nil
+
+	if rr := redeliver(); rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", rr.Code)
+	}
+	if len(processor.events) != 0 {
+		t.Error("Event should not be processed before the dispatcher runs")
+	}
+	if len(dispatcher.forges) != 1 || dispatcher.forges[0] != forge.GitHub || dispatcher.bodies[0] != body {
+		t.Errorf("Expected the event to be submitted as a GitHub event, got %v", dispatcher.forges)
+	}
+
+	if rr := redeliver(); rr.Code != http.StatusOK || len(dispatcher.forges) != 1 {
+		t.Errorf("Expected the redelivery to be ignored, got status %d and %d submissions", rr.Code, len(dispatcher.forges))
+	}
+}
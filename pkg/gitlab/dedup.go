@@ -0,0 +1,20 @@
+package gitlab
+
+import "net/http"
+
+const (
+	HeaderGitLabEventUUID = "X-Gitlab-Event-UUID"
+	HeaderIdempotencyKey  = "Idempotency-Key"
+)
+
+// deliveryKey returns the identifier GitLab keeps stable across retries of
+// one webhook delivery, or "" if the request carries none.
+func deliveryKey(header http.Header) (key, source string) {
+	if key := header.Get(HeaderIdempotencyKey); key != "" {
+		return key, "idempotency_key"
+	}
+	if key := header.Get(HeaderGitLabEventUUID); key != "" {
+		return key, "event_uuid"
+	}
+	return "", ""
+}
@@ -0,0 +1,377 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/metrics"
+)
+
+const (
+	defaultEventQueueSize   = 1000
+	defaultEventMaxAttempts = 3
+	defaultEventRetryDelay  = time.Second
+	defaultMaxDeadLetters   = 1000
+)
+
+var (
+	ErrEventNotFound = errors.New("event not found")
+	ErrEventNotDead  = errors.New("event is not dead-lettered")
+)
+
+// EventDispatcher processes accepted webhook deliveries of every forge in the
+// background so the webhook can be acknowledged before the scheduler has seen
+// the event. Events are handled one at a time in the order they were
+// delivered.
+// Events that fail every attempt are kept as dead letters until they are
+// replayed or discarded. Beyond maxDeadLetters the oldest are dropped.
+type EventDispatcher struct {
+	process        func(forgeName, eventType string, body []byte) error
+	store          EventStore
+	logger         *logrus.Logger
+	maxAttempts    int
+	maxDeadLetters int
+	retryDelay     time.Duration
+
+	queue  chan *EventRecord
+	events map[string]*EventRecord
+	mu     sync.Mutex
+
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+func NewEventDispatcher(
+	process func(forgeName, eventType string, body []byte) error,
+	queueSize int,
+	maxAttempts int,
+	maxDeadLetters int,
+	logger *logrus.Logger,
+) *EventDispatcher {
+	if queueSize < 1 {
+		queueSize = defaultEventQueueSize
+	}
+	if maxAttempts < 1 {
+		maxAttempts = defaultEventMaxAttempts
+	}
+	if maxDeadLetters < 1 {
+		maxDeadLetters = defaultMaxDeadLetters
+	}
+
+	return &EventDispatcher{
+		process:        process,
+		logger:         logger,
+		maxAttempts:    maxAttempts,
+		maxDeadLetters: maxDeadLetters,
+		retryDelay:     defaultEventRetryDelay,
+		queue:          make(chan *EventRecord, queueSize),
+		events:         make(map[string]*EventRecord),
+		shutdownCh:     make(chan struct{}),
+	}
+}
+
+func (d *EventDispatcher) SetEventStore(store EventStore) {
+	d.store = store
+}
+
+// Start replays events that were accepted but not processed before the last
+// shutdown and starts the background worker.
+func (d *EventDispatcher) Start() error {
+	if d.store != nil {
+		records, err := d.store.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load webhook events: %w", err)
+		}
+
+		for _, record := range records {
+			d.mu.Lock()
+			d.events[record.ID] = record
+			d.mu.Unlock()
+
+			if record.Status == EventStatusPending {
+				d.logger.WithField("event_id", record.ID).Info("Replaying unprocessed webhook event")
+				d.enqueue(record)
+			}
+		}
+		d.mu.Lock()
+		d.trimDeadLetters()
+		d.mu.Unlock()
+		d.updateMetrics()
+	}
+
+	d.wg.Add(1)
+	go d.run()
+
+	return nil
+}
+
+// Submit persists an event and queues it for processing. An error means the
+// event was not accepted.
+func (d *EventDispatcher) Submit(forgeName, eventType string, body []byte) error {
+	record := &EventRecord{
+		ID:         uuid.New().String(),
+		Forge:      forgeName,
+		EventType:  eventType,
+		Body:       append([]byte(nil), body...),
+		Status:     EventStatusPending,
+		ReceivedAt: time.Now(),
+	}
+
+	d.mu.Lock()
+	if err := d.saveEvent(record); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	d.events[record.ID] = record
+	d.mu.Unlock()
+
+	d.enqueue(record)
+	return nil
+}
+
+// enqueue never blocks: when the queue is full the event is dead-lettered so
+// it can be replayed once the backlog has drained.
+func (d *EventDispatcher) enqueue(record *EventRecord) {
+	select {
+	case d.queue <- record:
+		metrics.WebhookEventQueueDepth.Set(float64(len(d.queue)))
+	default:
+		d.deadLetter(record, errors.New("webhook event queue is full"))
+	}
+}
+
+// DeadLetters returns a snapshot of every dead-lettered event, oldest first.
+func (d *EventDispatcher) DeadLetters() []EventRecord {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dead := make([]EventRecord, 0)
+	for _, record := range d.events {
+		if record.Status == EventStatusDead {
+			dead = append(dead, *record)
+		}
+	}
+
+	sort.Slice(dead, func(i, j int) bool {
+		return dead[i].ReceivedAt.Before(dead[j].ReceivedAt)
+	})
+	return dead
+}
+
+// Replay moves a dead-lettered event back onto the queue with a fresh set of
+// attempts.
+func (d *EventDispatcher) Replay(id string) error {
+	d.mu.Lock()
+	record, err := d.deadRecord(id)
+	if err != nil {
+		d.mu.Unlock()
+		return err
+	}
+
+	record.Status = EventStatusPending
+	record.Attempts = 0
+	record.LastError = ""
+	record.FailedAt = time.Time{}
+	d.persistEvent(record)
+	d.mu.Unlock()
+
+	d.updateMetrics()
+	d.logger.WithField("event_id", id).Info("Replaying dead-lettered webhook event")
+	d.enqueue(record)
+	return nil
+}
+
+// Discard drops a dead-lettered event without processing it.
+func (d *EventDispatcher) Discard(id string) error {
+	d.mu.Lock()
+	if _, err := d.deadRecord(id); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	d.untrackEvent(id)
+	d.mu.Unlock()
+
+	d.updateMetrics()
+	d.logger.WithField("event_id", id).Info("Discarded dead-lettered webhook event")
+	return nil
+}
+
+func (d *EventDispatcher) Shutdown(ctx context.Context) error {
+	close(d.shutdownCh)
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *EventDispatcher) run() {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case record := <-d.queue:
+			metrics.WebhookEventQueueDepth.Set(float64(len(d.queue)))
+			if !d.handle(record) {
+				return
+			}
+		case <-d.shutdownCh:
+			return
+		}
+	}
+}
+
+// handle processes one event, retrying failed attempts, and reports false if
+// the dispatcher was shut down in between. The event then stays pending and
+// is replayed on the next start.
+func (d *EventDispatcher) handle(record *EventRecord) bool {
+	logger := d.logger.WithFields(logrus.Fields{
+		"event_id":   record.ID,
+		"event_type": record.EventType,
+		"forge":      record.forgeName(),
+	})
+
+	for {
+		err := d.process(record.forgeName(), record.EventType, record.Body)
+		if err == nil {
+			d.mu.Lock()
+			d.untrackEvent(record.ID)
+			d.mu.Unlock()
+			return true
+		}
+
+		d.mu.Lock()
+		record.Attempts++
+		record.LastError = err.Error()
+		attempts := record.Attempts
+		d.persistEvent(record)
+		d.mu.Unlock()
+
+		if attempts >= d.maxAttempts {
+			d.deadLetter(record, err)
+			return true
+		}
+
+		logger.WithError(err).WithField("attempt", attempts).Warn("Failed to process webhook event, retrying")
+
+		select {
+		case <-time.After(d.retryDelay * time.Duration(attempts)):
+		case <-d.shutdownCh:
+			return false
+		}
+	}
+}
+
+func (d *EventDispatcher) deadLetter(record *EventRecord, err error) {
+	d.mu.Lock()
+	record.Status = EventStatusDead
+	record.LastError = err.Error()
+	record.FailedAt = time.Now()
+	attempts := record.Attempts
+	d.persistEvent(record)
+	d.trimDeadLetters()
+	d.mu.Unlock()
+
+	metrics.WebhookEventsDeadLettered.Inc()
+	d.updateMetrics()
+	d.logger.WithError(err).WithFields(logrus.Fields{
+		"event_id":   record.ID,
+		"event_type": record.EventType,
+		"forge":      record.forgeName(),
+		"attempts":   attempts,
+	}).Error("Webhook event moved to dead-letter store")
+}
+
+func (d *EventDispatcher) updateMetrics() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dead := 0
+	for _, record := range d.events {
+		if record.Status == EventStatusDead {
+			dead++
+		}
+	}
+	metrics.WebhookDeadLetters.Set(float64(dead))
+}
+
+// deadRecord, trimDeadLetters, saveEvent, persistEvent and untrackEvent
+// must be called with mu held.
+func (d *EventDispatcher) deadRecord(id string) (*EventRecord, error) {
+	record, exists := d.events[id]
+	if !exists {
+		return nil, ErrEventNotFound
+	}
+	if record.Status != EventStatusDead {
+		return nil, fmt.Errorf("%w: event %s is %s", ErrEventNotDead, id, record.Status)
+	}
+	return record, nil
+}
+
+// trimDeadLetters drops the oldest dead letters beyond maxDeadLetters, so
+// a forge that keeps sending events FireRunner cannot process does not grow
+// the store without bound.
+func (d *EventDispatcher) trimDeadLetters() {
+	var dead []*EventRecord
+	for _, record := range d.events {
+		if record.Status == EventStatusDead {
+			dead = append(dead, record)
+		}
+	}
+	if len(dead) <= d.maxDeadLetters {
+		return
+	}
+
+	sort.Slice(dead, func(i, j int) bool {
+		return dead[i].FailedAt.Before(dead[j].FailedAt)
+	})
+	for _, record := range dead[:len(dead)-d.maxDeadLetters] {
+		d.untrackEvent(record.ID)
+		d.logger.WithFields(logrus.Fields{
+			"event_id":   record.ID,
+			"event_type": record.EventType,
+			"failed_at":  record.FailedAt,
+		}).Warn("Dropped oldest dead-lettered webhook event, dead-letter store is full")
+	}
+}
+
+func (d *EventDispatcher) saveEvent(record *EventRecord) error {
+	if d.store == nil {
+		return nil
+	}
+	if err := d.store.Save(record); err != nil {
+		return fmt.Errorf("failed to persist webhook event: %w", err)
+	}
+	return nil
+}
+
+func (d *EventDispatcher) persistEvent(record *EventRecord) {
+	if err := d.saveEvent(record); err != nil {
+		d.logger.WithError(err).WithField("event_id", record.ID).Error("Failed to persist webhook event")
+	}
+}
+
+func (d *EventDispatcher) untrackEvent(id string) {
+	delete(d.events, id)
+	if d.store == nil {
+		return
+	}
+	if err := d.store.Delete(id); err != nil {
+		d.logger.WithError(err).WithField("event_id", id).Error("Failed to delete persisted webhook event")
+	}
+}
@@ -0,0 +1,194 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+)
+
+type recordingProcessor struct {
+	mu     sync.Mutex
+	events []string
+	forges []string
+	err    error
+}
+
+func (p *recordingProcessor) process(forgeName, eventType string, body []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, string(body))
+	p.forges = append(p.forges, forgeName)
+	return p.err
+}
+
+func (p *recordingProcessor) calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.events)
+}
+
+func (p *recordingProcessor) setErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+func testDispatcher(t *testing.T, processor *recordingProcessor, store EventStore) *EventDispatcher {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	d := NewEventDispatcher(processor.process, 10, 2, 0, logger)
+	d.retryDelay = time.Millisecond
+	if store != nil {
+		d.SetEventStore(store)
+	}
+	if err := d.Start(); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = d.Shutdown(ctx)
+	})
+	return d
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEventDispatcher_ProcessesInOrder(t *testing.T) {
+	processor := &recordingProcessor{}
+	d := testDispatcher(t, processor, nil)
+
+	for _, body := range []string{`{"n":1}`, `{"n":2}`, `{"n":3}`} {
+		if err := d.Submit(forge.GitLab, "Job Hook", []byte(body)); err != nil {
+			t.Fatalf("Submit() failed: %v", err)
+		}
+	}
+
+	waitFor(t, func() bool { return processor.calls() == 3 })
+
+	processor.mu.Lock()
+	defer processor.mu.Unlock()
+	if processor.events[0] != `{"n":1}` || processor.events[2] != `{"n":3}` {
+		t.Errorf("Events processed out of order: %v", processor.events)
+	}
+}
+
+func TestEventDispatcher_DeadLetterAndReplay(t *testing.T) {
+	processor := &recordingProcessor{err: errors.New("queue is full")}
+	store, err := NewFileEventStore(filepath.Join(t.TempDir(), "events.json"))
+	if err != nil {
+		t.Fatalf("NewFileEventStore() failed: %v", err)
+	}
+	d := testDispatcher(t, processor, store)
+
+	if err := d.Submit(forge.GitLab, "Job Hook", []byte(`{"build_id":1}`)); err != nil {
+		t.Fatalf("Submit() failed: %v", err)
+	}
+
+	waitFor(t, func() bool { return len(d.DeadLetters()) == 1 })
+
+	dead := d.DeadLetters()[0]
+	if dead.Attempts != 2 || dead.LastError != "queue is full" {
+		t.Errorf("Unexpected dead letter: %+v", dead)
+	}
+
+	records, _ := store.Load()
+	if len(records) != 1 || records[0].Status != EventStatusDead {
+		t.Fatalf("Expected dead letter to be persisted, got %+v", records)
+	}
+
+	if err := d.Discard("missing"); !errors.Is(err, ErrEventNotFound) {
+		t.Errorf("Expected ErrEventNotFound, got %v", err)
+	}
+
+	processor.setErr(nil)
+	if err := d.Replay(dead.ID); err != nil {
+		t.Fatalf("Replay() failed: %v", err)
+	}
+
+	waitFor(t, func() bool { return processor.calls() == 3 })
+	waitFor(t, func() bool {
+		records, _ := store.Load()
+		return len(records) == 0
+	})
+
+	if len(d.DeadLetters()) != 0 {
+		t.Error("Replayed event should leave the dead-letter store")
+	}
+}
+
+func TestEventDispatcher_ResumesPendingEvents(t *testing.T) {
+	store, err := NewFileEventStore(filepath.Join(t.TempDir(), "events.json"))
+	if err != nil {
+		t.Fatalf("NewFileEventStore() failed: %v", err)
+	}
+	store.Save(&EventRecord{ID: "pending", EventType: "Job Hook", Body: []byte(`{}`), Status: EventStatusPending, ReceivedAt: time.Now()})
+	store.Save(&EventRecord{ID: "dead", EventType: "Job Hook", Body: []byte(`{}`), Status: EventStatusDead, ReceivedAt: time.Now()})
+
+	processor := &recordingProcessor{}
+	d := testDispatcher(t, processor, store)
+
+	waitFor(t, func() bool { return processor.calls() == 1 })
+
+	processor.mu.Lock()
+	if processor.forges[0] != forge.GitLab {
+		t.Errorf("Expected an event stored without a forge to be a GitLab event, got %q", processor.forges[0])
+	}
+	processor.mu.Unlock()
+
+	dead := d.DeadLetters()
+	if len(dead) != 1 || dead[0].ID != "dead" {
+		t.Errorf("Expected dead letter to be restored, got %+v", dead)
+	}
+	if err := d.Replay("pending"); err == nil {
+		t.Error("Replaying a processed event should fail")
+	}
+}
+
+func TestEventDispatcher_BoundsDeadLetters(t *testing.T) {
+	store, err := NewFileEventStore(filepath.Join(t.TempDir(), "events.json"))
+	if err != nil {
+		t.Fatalf("NewFileEventStore() failed: %v", err)
+	}
+	processor := &recordingProcessor{err: errors.New("queue is full")}
+	d := testDispatcher(t, processor, store)
+	d.maxDeadLetters = 2
+
+	for i := 1; i <= 3; i++ {
+		if err := d.Submit(forge.GitLab, "Job Hook", fmt.Appendf(nil, `{"build_id":%d}`, i)); err != nil {
+			t.Fatalf("Submit() failed: %v", err)
+		}
+		waitFor(t, func() bool { return processor.calls() == 2*i })
+	}
+
+	waitFor(t, func() bool {
+		records, _ := store.Load()
+		return len(records) == 2
+	})
+
+	dead := d.DeadLetters()
+	if len(dead) != 2 || string(dead[0].Body) != `{"build_id":2}` || string(dead[1].Body) != `{"build_id":3}` {
+		t.Errorf("Expected the oldest dead letter to be dropped, got %+v", dead)
+	}
+}
@@ -0,0 +1,276 @@
+package gitlab
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+)
+
+const (
+	EventStatusPending = "pending"
+	EventStatusDead    = "dead"
+)
+
+type EventStore interface {
+	Save(record *EventRecord) error
+	Delete(id string) error
+	Load() ([]*EventRecord, error)
+	Close() error
+}
+
+// EventRecord is a webhook delivery accepted by the dispatcher. Pending
+// records are still to be processed; dead records failed every attempt and
+// wait to be replayed or discarded.
+type EventRecord struct {
+	ID         string          `json:"id"`
+	Forge      string          `json:"forge,omitempty"`
+	EventType  string          `json:"event_type"`
+	Body       json.RawMessage `json:"body"`
+	Status     string          `json:"status"`
+	Attempts   int             `json:"attempts"`
+	LastError  string          `json:"last_error,omitempty"`
+	ReceivedAt time.Time       `json:"received_at"`
+	FailedAt   time.Time       `json:"failed_at,omitempty"`
+}
+
+func (r *EventRecord) forgeName() string {
+	if r.Forge == "" {
+		// Stored before FireRunner accepted webhooks from more than one forge.
+		return forge.GitLab
+	}
+	return r.Forge
+}
+
+// FileEventStore keeps the records in memory and appends every change to a
+// journal file, one JSON entry per line, so accepting an event writes only
+// that event. The journal is rewritten with just the live records once it
+// has grown well past them.
+type FileEventStore struct {
+	path    string
+	records map[string]*EventRecord
+	file    *os.File
+	entries int // in the journal
+	closed  bool
+	mu      sync.Mutex
+}
+
+// eventEntry is a journal line: a saved record or the ID of a deleted one.
+type eventEntry struct {
+	Record  *EventRecord `json:"record,omitempty"`
+	Deleted string       `json:"deleted,omitempty"`
+}
+
+// minEventCompaction is the journal size below which it is never compacted.
+const minEventCompaction = 1000
+
+func NewFileEventStore(path string) (*FileEventStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	store := &FileEventStore{
+		path:    path,
+		records: make(map[string]*EventRecord),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read event store: %w", err)
+	}
+	if err := store.parse(data); err != nil {
+		return nil, fmt.Errorf("failed to parse event store %s: %w", path, err)
+	}
+
+	// Start from a compact journal, which also converts a store written as
+	// a single JSON array by earlier versions.
+	if err := store.compact(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (s *FileEventStore) parse(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil
+	}
+
+	if data[0] == '[' {
+		var records []*EventRecord
+		if err := json.Unmarshal(data, &records); err != nil {
+			return err
+		}
+		for _, record := range records {
+			s.records[record.ID] = record
+		}
+		return nil
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		var entry eventEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			if i == len(lines)-1 {
+				// A crash in the middle of an append leaves a partial last
+				// line. Its change was never acknowledged.
+				break
+			}
+			return fmt.Errorf("line %d: %w", i+1, err)
+		}
+		switch {
+		case entry.Record != nil:
+			s.records[entry.Record.ID] = entry.Record
+		case entry.Deleted != "":
+			delete(s.records, entry.Deleted)
+		}
+	}
+	return nil
+}
+
+func (s *FileEventStore) Save(record *EventRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *record
+	s.records[record.ID] = &copied
+	return s.append(eventEntry{Record: &copied})
+}
+
+func (s *FileEventStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.records[id]; !exists {
+		return nil
+	}
+	delete(s.records, id)
+	return s.append(eventEntry{Deleted: id})
+}
+
+func (s *FileEventStore) Load() ([]*EventRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]*EventRecord, 0, len(s.records))
+	for _, record := range s.records {
+		copied := *record
+		records = append(records, &copied)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ReceivedAt.Before(records[j].ReceivedAt)
+	})
+
+	return records, nil
+}
+
+func (s *FileEventStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	if err != nil {
+		return fmt.Errorf("failed to close event store: %w", err)
+	}
+	return nil
+}
+
+// append must be called with mu held. The entry is synced before it returns,
+// as an accepted event must survive a crash.
+func (s *FileEventStore) append(entry eventEntry) error {
+	if s.closed {
+		return fmt.Errorf("event store is closed")
+	}
+	if s.file == nil {
+		file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open event store: %w", err)
+		}
+		s.file = file
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook event: %w", err)
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write event store: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync event store: %w", err)
+	}
+	s.entries++
+
+	if s.entries >= minEventCompaction && s.entries > 2*len(s.records) {
+		// The entry is already on disk. A failed compaction leaves the
+		// journal as it was and is retried on the next append.
+		_ = s.compact()
+	}
+	return nil
+}
+
+// compact must be called with mu held. It writes the live records to a
+// temporary file and renames it over the journal so a crash mid-write never
+// leaves a truncated file behind.
+func (s *FileEventStore) compact() error {
+	var buf bytes.Buffer
+	for _, record := range s.records {
+		data, err := json.Marshal(eventEntry{Record: record})
+		if err != nil {
+			return fmt.Errorf("failed to encode event store: %w", err)
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".events-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write event store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync event store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close event store: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace event store: %w", err)
+	}
+
+	// The old handle refers to the replaced file. If the new one cannot be
+	// opened, the next append tries again.
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+	s.entries = len(s.records)
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open event store: %w", err)
+	}
+	s.file = file
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package gitlab
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileEventStore_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "events.json")
+
+	store, err := NewFileEventStore(path)
+	if err != nil {
+		t.Fatalf("NewFileEventStore() failed: %v", err)
+	}
+
+	now := time.Now()
+	for i, status := range []string{EventStatusPending, EventStatusDead, EventStatusPending} {
+		record := &EventRecord{ID: fmt.Sprint(i), EventType: "Job Hook", Body: []byte(`{}`), Status: status, ReceivedAt: now.Add(time.Duration(i) * time.Second)}
+		if err := store.Save(record); err != nil {
+			t.Fatalf("Save() failed: %v", err)
+		}
+	}
+	if err := store.Delete("2"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+
+	// Reopen without Close, as after a crash: every change is already on disk.
+	reopened, err := NewFileEventStore(path)
+	if err != nil {
+		t.Fatalf("NewFileEventStore() reopen failed: %v", err)
+	}
+	defer reopened.Close()
+
+	records, _ := reopened.Load()
+	if len(records) != 2 || records[0].ID != "0" || records[1].Status != EventStatusDead {
+		t.Errorf("Unexpected records after restart: %+v", records)
+	}
+}
+
+func TestFileEventStore_PartialLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.json")
+	journal := `{"record":{"id":"a","event_type":"Job Hook","body":{},"status":"pending"}}` + "\n" + `{"record":{"id":"b","ev`
+	if err := os.WriteFile(path, []byte(journal), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewFileEventStore(path)
+	if err != nil {
+		t.Fatalf("NewFileEventStore() failed: %v", err)
+	}
+	defer store.Close()
+
+	records, _ := store.Load()
+	if len(records) != 1 || records[0].ID != "a" {
+		t.Errorf("Expected only the complete entry, got %+v", records)
+	}
+}
+
+func TestFileEventStore_LoadsArrayFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.json")
+	if err := os.WriteFile(path, []byte(`[{"id":"a","event_type":"Job Hook","body":{},"status":"dead"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewFileEventStore(path)
+	if err != nil {
+		t.Fatalf("NewFileEventStore() failed: %v", err)
+	}
+	if err := store.Save(&EventRecord{ID: "b", EventType: "Job Hook", Body: []byte(`{}`), Status: EventStatusPending}); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	store.Close()
+
+	reopened, err := NewFileEventStore(path)
+	if err != nil {
+		t.Fatalf("NewFileEventStore() reopen failed: %v", err)
+	}
+	defer reopened.Close()
+
+	if records, _ := reopened.Load(); len(records) != 2 {
+		t.Errorf("Expected 2 records, got %+v", records)
+	}
+}
+
+func TestFileEventStore_Compacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.json")
+
+	store, err := NewFileEventStore(path)
+	if err != nil {
+		t.Fatalf("NewFileEventStore() failed: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Save(&EventRecord{ID: "kept", EventType: "Job Hook", Body: []byte(`{}`), Status: EventStatusDead}); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	for i := range minEventCompaction {
+		id := fmt.Sprint(i)
+		if err := store.Save(&EventRecord{ID: id, EventType: "Job Hook", Body: []byte(`{}`), Status: EventStatusPending}); err != nil {
+			t.Fatalf("Save() failed: %v", err)
+		}
+		if err := store.Delete(id); err != nil {
+			t.Fatalf("Delete() failed: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines >= minEventCompaction {
+		t.Errorf("Expected the journal to be compacted, it has %d lines", lines)
+	}
+
+	reopened, err := NewFileEventStore(path)
+	if err != nil {
+		t.Fatalf("NewFileEventStore() reopen failed: %v", err)
+	}
+	defer reopened.Close()
+
+	if records, _ := reopened.Load(); len(records) != 1 || records[0].ID != "kept" {
+		t.Errorf("Expected only the kept record, got %+v", records)
+	}
+}
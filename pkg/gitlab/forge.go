@@ -0,0 +1,78 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xanzy/go-gitlab"
+
+	"github.com/ismoilovdevml/firerunner/pkg/cloudinit"
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+)
+
+const jobPollInterval = 5 * time.Second
+
+type RunnerService interface {
+	RegisterRunner(ctx context.Context, projectID int64, vmID string, tags []string) (*RunnerRegistration, error)
+	UnregisterRunner(ctx context.Context, runnerID int64) error
+	GetJob(ctx context.Context, projectID, jobID int64) (*gitlab.Job, error)
+	CancelJob(ctx context.Context, projectID, jobID int64) error
+}
+
+// Forge runs GitLab jobs through an ephemeral runner registered per job.
+type Forge struct {
+	service RunnerService
+	logger  *logrus.Logger
+}
+
+func NewForge(service RunnerService, logger *logrus.Logger) *Forge {
+	return &Forge{service: service, logger: logger}
+}
+
+func (f *Forge) RegisterRunner(ctx context.Context, job forge.Job, vmID string) (*forge.Runner, error) {
+	registration, err := f.service.RegisterRunner(ctx, job.ProjectID, vmID, job.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return &forge.Runner{
+		ID: registration.ID,
+		Guest: &cloudinit.Runner{
+			URL:   registration.URL,
+			Token: registration.Token,
+			Name:  vmID,
+			Tags:  registration.Tags,
+		},
+	}, nil
+}
+
+func (f *Forge) UnregisterRunner(ctx context.Context, job forge.Job, runnerID int64) error {
+	return f.service.UnregisterRunner(ctx, runnerID)
+}
+
+func (f *Forge) CancelJob(ctx context.Context, job forge.Job) error {
+	return f.service.CancelJob(ctx, job.ProjectID, job.ID)
+}
+
+func (f *Forge) JobPending(ctx context.Context, job forge.Job) (bool, error) {
+	current, err := f.service.GetJob(ctx, job.ProjectID, job.ID)
+	if err != nil {
+		return false, err
+	}
+	return current.Status == "created" || current.Status == "pending", nil
+}
+
+func (f *Forge) WaitForJob(ctx context.Context, job forge.Job) error {
+	monitor := NewJobMonitor(f.service, f.logger)
+
+	completed, err := monitor.WaitForJobCompletion(ctx, job.ProjectID, job.ID, jobPollInterval)
+	if err != nil {
+		return err
+	}
+	if completed.Status != "success" {
+		return fmt.Errorf("job failed with status: %s", completed.Status)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package gitlab
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xanzy/go-gitlab"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+	"github.com/ismoilovdevml/firerunner/pkg/metrics"
+)
+
+const defaultPollInterval = 30 * time.Second
+
+type JobLister interface {
+	ListPendingJobs(ctx context.Context, projectID int64) ([]*gitlab.Job, error)
+	ListGroupProjects(ctx context.Context, groupID int64) ([]int64, error)
+}
+
+// Poller discovers pending FireRunner jobs by listing them through the GitLab
+// API, for GitLab instances that cannot deliver webhooks to FireRunner. Jobs
+// are handed to the same EventProcessor as webhook events.
+type Poller struct {
+	config    *config.GitLabConfig
+	lister    JobLister
+	processor EventProcessor
+	logger    *logrus.Logger
+
+	// dispatched is the polling cursor: per project, the pending jobs that
+	// were already handed to the processor. A job is dropped from it once it
+	// is no longer pending, since GitLab never makes the same job pending
+	// again (retries get a new job ID).
+	dispatched map[int64]map[int64]bool
+	mu         sync.Mutex
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewPoller(cfg *config.GitLabConfig, lister JobLister, processor EventProcessor, logger *logrus.Logger) *Poller {
+	return &Poller{
+		config:     cfg,
+		lister:     lister,
+		processor:  processor,
+		logger:     logger,
+		dispatched: make(map[int64]map[int64]bool),
+	}
+}
+
+func (p *Poller) Start() {
+	interval := p.config.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"interval": interval,
+		"projects": p.config.PollProjects,
+		"groups":   p.config.PollGroups,
+	}).Info("Starting GitLab job poller")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			p.Poll(ctx)
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown interrupts a poll in progress and waits for the poller to stop.
+func (p *Poller) Shutdown(ctx context.Context) error {
+	if p.cancel != nil {
+		p.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Poll lists pending jobs of every configured project once and hands new
+// FireRunner jobs to the processor. It returns how many jobs were handed
+// over.
+func (p *Poller) Poll(ctx context.Context) int {
+	dispatched := 0
+	for _, projectID := range p.projectIDs(ctx) {
+		if ctx.Err() != nil {
+			break
+		}
+		dispatched += p.pollProject(ctx, projectID)
+	}
+	return dispatched
+}
+
+func (p *Poller) pollProject(ctx context.Context, projectID int64) int {
+	logger := p.logger.WithField("project_id", projectID)
+
+	jobs, err := p.lister.ListPendingJobs(ctx, projectID)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to poll pending jobs")
+		return 0
+	}
+
+	p.mu.Lock()
+	seen := p.dispatched[projectID]
+	p.mu.Unlock()
+
+	pending := make(map[int64]bool)
+	dispatched := 0
+	for _, job := range jobs {
+		if !forge.HasFireRunnerTag(job.TagList) {
+			continue
+		}
+
+		jobID := int64(job.ID)
+		if seen[jobID] {
+			pending[jobID] = true
+			continue
+		}
+
+		event := jobEventFromJob(projectID, job)
+		if err := p.processor.ProcessJobEvent(event); err != nil {
+			// Leave the job out of the cursor so the next poll retries it.
+			logger.WithError(err).WithField("job_id", jobID).Error("Failed to process polled job")
+			continue
+		}
+
+		pending[jobID] = true
+		dispatched++
+		metrics.PolledJobs.Inc()
+		logger.WithField("job_id", jobID).Info("Discovered pending job by polling")
+	}
+
+	p.mu.Lock()
+	p.dispatched[projectID] = pending
+	p.mu.Unlock()
+
+	return dispatched
+}
+
+// projectIDs returns the configured projects plus every project of the
+// configured groups, without duplicates.
+func (p *Poller) projectIDs(ctx context.Context) []int64 {
+	ids := make(map[int64]bool)
+	for _, projectID := range p.config.PollProjects {
+		ids[projectID] = true
+	}
+
+	for _, groupID := range p.config.PollGroups {
+		projects, err := p.lister.ListGroupProjects(ctx, groupID)
+		if err != nil {
+			p.logger.WithError(err).WithField("group_id", groupID).Warn("Failed to list group projects")
+			continue
+		}
+		for _, projectID := range projects {
+			ids[projectID] = true
+		}
+	}
+
+	result := make([]int64, 0, len(ids))
+	for projectID := range ids {
+		result = append(result, projectID)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+func jobEventFromJob(projectID int64, job *gitlab.Job) *JobEvent {
+	event := &JobEvent{
+		ObjectKind:  "build",
+		Ref:         job.Ref,
+		Tag:         job.Tag,
+		SHA:         job.Pipeline.Sha,
+		BuildID:     int64(job.ID),
+		BuildName:   job.Name,
+		BuildStage:  job.Stage,
+		BuildStatus: job.Status,
+		PipelineID:  int64(job.Pipeline.ID),
+		ProjectID:   projectID,
+		BuildTags:   job.TagList,
+	}
+	if job.CreatedAt != nil {
+		event.BuildCreatedAt = *job.CreatedAt
+	}
+	if job.Project != nil {
+		event.ProjectName = job.Project.Name
+	}
+	if project, _, found := strings.Cut(job.WebURL, "/-/"); found {
+		event.Repository.Homepage = project
+	}
+	return event
+}
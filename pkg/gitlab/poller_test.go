@@ -0,0 +1,117 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xanzy/go-gitlab"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+)
+
+type fakeJobLister struct {
+	jobs   map[int64][]*gitlab.Job
+	groups map[int64][]int64
+}
+
+func (f *fakeJobLister) ListPendingJobs(ctx context.Context, projectID int64) ([]*gitlab.Job, error) {
+	return f.jobs[projectID], nil
+}
+
+func (f *fakeJobLister) ListGroupProjects(ctx context.Context, groupID int64) ([]int64, error) {
+	projects, ok := f.groups[groupID]
+	if !ok {
+		return nil, errors.New("group not found")
+	}
+	return projects, nil
+}
+
+type recordingEventProcessor struct {
+	jobs []*JobEvent
+	err  error
+}
+
+func (r *recordingEventProcessor) ProcessJobEvent(event *JobEvent) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.jobs = append(r.jobs, event)
+	return nil
+}
+
+func (r *recordingEventProcessor) ProcessPipelineEvent(event *PipelineEvent) error {
+	return nil
+}
+
+func pendingJob(id int, tags ...string) *gitlab.Job {
+	job := &gitlab.Job{ID: id, Name: "build", Stage: "test", Status: "pending", TagList: tags}
+	job.Pipeline.ID = 500
+	job.WebURL = fmt.Sprintf("https://gitlab.example.com/acme/app/-/jobs/%d", id)
+	return job
+}
+
+func TestPoller_Poll(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	lister := &fakeJobLister{
+		jobs: map[int64][]*gitlab.Job{
+			1: {pendingJob(10, "firecracker-2cpu-4gb"), pendingJob(11, "docker")},
+			2: {pendingJob(20, "microvm")},
+		},
+		groups: map[int64][]int64{7: {2}},
+	}
+	processor := &recordingEventProcessor{}
+	poller := NewPoller(&config.GitLabConfig{
+		PollProjects: []int64{1},
+		PollGroups:   []int64{7, 8},
+	}, lister, processor, logger)
+
+	if n := poller.Poll(context.Background()); n != 2 {
+		t.Fatalf("Expected 2 dispatched jobs, got %d", n)
+	}
+	if processor.jobs[0].BuildID != 10 || processor.jobs[0].ProjectID != 1 || processor.jobs[0].PipelineID != 500 {
+		t.Errorf("Unexpected job event: %+v", processor.jobs[0])
+	}
+	if path := ProjectPath(processor.jobs[0].Repository.Homepage); path != "acme/app" {
+		t.Errorf("Expected project path acme/app, got %q", path)
+	}
+	if processor.jobs[1].BuildID != 20 || processor.jobs[1].ProjectID != 2 {
+		t.Errorf("Unexpected job event: %+v", processor.jobs[1])
+	}
+
+	// Jobs that are still pending are not handed over again.
+	lister.jobs[1] = append(lister.jobs[1], pendingJob(12, "firerunner"))
+	if n := poller.Poll(context.Background()); n != 1 {
+		t.Errorf("Expected only the new job to be dispatched, got %d", n)
+	}
+
+	// Jobs that left the pending list drop out of the cursor.
+	lister.jobs[1] = nil
+	poller.Poll(context.Background())
+	if len(poller.dispatched[1]) != 0 {
+		t.Errorf("Expected cursor of project 1 to be empty, got %v", poller.dispatched[1])
+	}
+}
+
+func TestPoller_RetriesFailedJobs(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	lister := &fakeJobLister{jobs: map[int64][]*gitlab.Job{1: {pendingJob(10, "firecracker")}}}
+	processor := &recordingEventProcessor{err: errors.New("queue is full")}
+	poller := NewPoller(&config.GitLabConfig{PollProjects: []int64{1}}, lister, processor, logger)
+
+	if n := poller.Poll(context.Background()); n != 0 {
+		t.Errorf("Expected no dispatched jobs, got %d", n)
+	}
+
+	processor.err = nil
+	if n := poller.Poll(context.Background()); n != 1 {
+		t.Errorf("Expected failed job to be retried, got %d", n)
+	}
+}
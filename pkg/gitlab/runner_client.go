@@ -0,0 +1,230 @@
+package gitlab
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+)
+
+const (
+	RunnerModeEphemeral = "ephemeral"
+	RunnerModeNative    = "native"
+
+	HeaderJobToken   = "JOB-TOKEN"
+	HeaderLastUpdate = "X-GitLab-Last-Update"
+)
+
+// JobPayload is the job GitLab hands to a runner from POST /jobs/request.
+// Only the fields FireRunner needs are decoded; Raw keeps the full payload
+// for the guest.
+type JobPayload struct {
+	ID      int64  `json:"id"`
+	Token   string `json:"token"`
+	JobInfo struct {
+		ID          int64  `json:"id"`
+		Name        string `json:"name"`
+		Stage       string `json:"stage"`
+		ProjectID   int64  `json:"project_id"`
+		ProjectName string `json:"project_name"`
+	} `json:"job_info"`
+	GitInfo struct {
+		RepoURL string `json:"repo_url"`
+		Ref     string `json:"ref"`
+		Sha     string `json:"sha"`
+	} `json:"git_info"`
+	RunnerInfo struct {
+		Timeout int64 `json:"timeout"`
+	} `json:"runner_info"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+// RunnerClient speaks the runner side of the GitLab job API with the
+// authentication token of a single FireRunner runner, so FireRunner can claim
+// jobs itself instead of registering a runner per job.
+type RunnerClient struct {
+	url        string
+	token      string
+	httpClient *http.Client
+	logger     *logrus.Logger
+
+	// lastUpdate is echoed back to GitLab so it can long-poll job requests.
+	lastUpdate   string
+	lastUpdateMu sync.Mutex
+}
+
+func NewRunnerClient(cfg *config.GitLabConfig, logger *logrus.Logger) *RunnerClient {
+	return &RunnerClient{
+		url:        strings.TrimSuffix(cfg.URL, "/"),
+		token:      cfg.RunnerToken,
+		httpClient: &http.Client{Timeout: time.Minute},
+		logger:     logger,
+	}
+}
+
+// URL is the GitLab URL the guest reports a claimed job to.
+func (c *RunnerClient) URL() string {
+	return c.url
+}
+
+// RequestJob asks GitLab for a pending job matching the runner. It returns
+// nil without an error when no job is available.
+func (c *RunnerClient) RequestJob(ctx context.Context) (*JobPayload, error) {
+	body := map[string]interface{}{
+		"token": c.token,
+		"info": map[string]interface{}{
+			"name": "firerunner",
+			"features": map[string]bool{
+				"variables":  true,
+				"refspecs":   true,
+				"masking":    true,
+				"trace_size": true,
+				"cancelable": true,
+			},
+		},
+	}
+	c.lastUpdateMu.Lock()
+	if c.lastUpdate != "" {
+		body["last_update"] = c.lastUpdate
+	}
+	c.lastUpdateMu.Unlock()
+
+	resp, err := c.do(ctx, http.MethodPost, "/api/v4/jobs/request", body, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request job: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if lastUpdate := resp.Header.Get(HeaderLastUpdate); lastUpdate != "" {
+		c.lastUpdateMu.Lock()
+		c.lastUpdate = lastUpdate
+		c.lastUpdateMu.Unlock()
+	}
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+	case http.StatusNoContent:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("failed to request job: %s", responseError(resp))
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job payload: %w", err)
+	}
+
+	var payload JobPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse job payload: %w", err)
+	}
+	payload.Raw = raw
+
+	c.logger.WithFields(logrus.Fields{
+		"job_id":     payload.ID,
+		"project_id": payload.JobInfo.ProjectID,
+		"name":       payload.JobInfo.Name,
+	}).Info("Claimed job from GitLab")
+
+	return &payload, nil
+}
+
+// UpdateJob reports the state of a claimed job, e.g. "failed" with a
+// failure reason such as "runner_system_failure".
+func (c *RunnerClient) UpdateJob(ctx context.Context, jobID int64, jobToken, state, failureReason string) error {
+	body := map[string]interface{}{
+		"token": jobToken,
+		"state": state,
+	}
+	if failureReason != "" {
+		body["failure_reason"] = failureReason
+	}
+
+	resp, err := c.do(ctx, http.MethodPut, fmt.Sprintf("/api/v4/jobs/%d", jobID), body, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update job %d: %w", jobID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to update job %d: %s", jobID, responseError(resp))
+	}
+	return nil
+}
+
+// AppendTrace appends data to the job log at offset and returns the offset
+// for the next chunk.
+func (c *RunnerClient) AppendTrace(ctx context.Context, jobID int64, jobToken string, offset int, data []byte) (int, error) {
+	if len(data) == 0 {
+		return offset, nil
+	}
+
+	headers := map[string]string{
+		HeaderJobToken:  jobToken,
+		"Content-Type":  "text/plain",
+		"Content-Range": fmt.Sprintf("%d-%d", offset, offset+len(data)-1),
+	}
+
+	resp, err := c.do(ctx, http.MethodPatch, fmt.Sprintf("/api/v4/jobs/%d/trace", jobID), data, headers)
+	if err != nil {
+		return offset, fmt.Errorf("failed to append trace of job %d: %w", jobID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return offset, fmt.Errorf("failed to append trace of job %d: %s", jobID, responseError(resp))
+	}
+
+	// GitLab answers with the range it now holds, e.g. "0-41".
+	if _, end, ok := strings.Cut(resp.Header.Get("Range"), "-"); ok {
+		if n, err := strconv.Atoi(end); err == nil {
+			return n, nil
+		}
+	}
+	return offset + len(data), nil
+}
+
+func (c *RunnerClient) do(ctx context.Context, method, path string, body interface{}, headers map[string]string) (*http.Response, error) {
+	var reader io.Reader
+	switch b := body.(type) {
+	case []byte:
+		reader = bytes.NewReader(b)
+	case nil:
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	return c.httpClient.Do(req)
+}
+
+func responseError(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if len(body) == 0 {
+		return resp.Status
+	}
+	return fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,117 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+)
+
+func newTestRunnerClient(url string) *RunnerClient {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewRunnerClient(&config.GitLabConfig{URL: url + "/", RunnerToken: "glrt-runner"}, logger)
+}
+
+func TestRunnerClient_RequestJob(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v4/jobs/request" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		if body["token"] != "glrt-runner" {
+			t.Errorf("Expected runner token, got %v", body["token"])
+		}
+
+		requests++
+		if requests == 1 {
+			w.Header().Set(HeaderLastUpdate, "abc")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if body["last_update"] != "abc" {
+			t.Errorf("Expected last_update to be echoed, got %v", body["last_update"])
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":42,"token":"job-token","job_info":{"project_id":7,"name":"build"},"steps":[]}`))
+	}))
+	defer server.Close()
+
+	client := newTestRunnerClient(server.URL)
+
+	payload, err := client.RequestJob(context.Background())
+	if err != nil || payload != nil {
+		t.Fatalf("RequestJob() = %v, %v; want no job", payload, err)
+	}
+
+	payload, err = client.RequestJob(context.Background())
+	if err != nil {
+		t.Fatalf("RequestJob() error = %v", err)
+	}
+	if payload.ID != 42 || payload.Token != "job-token" || payload.JobInfo.ProjectID != 7 {
+		t.Errorf("Unexpected payload: %+v", payload)
+	}
+	if !json.Valid(payload.Raw) || len(payload.Raw) == 0 {
+		t.Error("Expected raw payload to be kept for the guest")
+	}
+}
+
+func TestRunnerClient_UpdateJobAndTrace(t *testing.T) {
+	var state, reason, contentRange, trace string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/api/v4/jobs/42":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["token"] != "job-token" {
+				t.Errorf("Expected job token, got %q", body["token"])
+			}
+			state, reason = body["state"], body["failure_reason"]
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodPatch && r.URL.Path == "/api/v4/jobs/42/trace":
+			if r.Header.Get(HeaderJobToken) != "job-token" {
+				t.Errorf("Expected job token header, got %q", r.Header.Get(HeaderJobToken))
+			}
+			contentRange = r.Header.Get("Content-Range")
+			data, _ := io.ReadAll(r.Body)
+			trace = string(data)
+			w.Header().Set("Range", "0-6")
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := newTestRunnerClient(server.URL)
+
+	offset, err := client.AppendTrace(context.Background(), 42, "job-token", 0, []byte("failed\n"))
+	if err != nil {
+		t.Fatalf("AppendTrace() error = %v", err)
+	}
+	if offset != 6 || contentRange != "0-6" || trace != "failed\n" {
+		t.Errorf("Unexpected trace: offset=%d range=%q trace=%q", offset, contentRange, trace)
+	}
+
+	if err := client.UpdateJob(context.Background(), 42, "job-token", "failed", "runner_system_failure"); err != nil {
+		t.Fatalf("UpdateJob() error = %v", err)
+	}
+	if state != "failed" || reason != "runner_system_failure" {
+		t.Errorf("Unexpected update: state=%q reason=%q", state, reason)
+	}
+}
@@ -3,11 +3,15 @@ package gitlab
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"github.com/xanzy/go-gitlab"
 
 	"github.com/ismoilovdevml/firerunner/pkg/config"
+	"github.com/ismoilovdevml/firerunner/pkg/metrics"
 )
 
 type Service struct {
@@ -29,44 +33,73 @@ func NewService(cfg *config.GitLabConfig, logger *logrus.Logger) (*Service, erro
 	}, nil
 }
 
-func (s *Service) RegisterRunner(ctx context.Context, projectID int64, vmIP string, tags []string) (*RunnerRegistration, error) {
+// RegisterRunner creates a runner through POST /user/runners and returns its
+// glrt- authentication token. The personal access token used by the service
+// needs the create_runner scope.
+func (s *Service) RegisterRunner(ctx context.Context, projectID int64, vmID string, tags []string) (*RunnerRegistration, error) {
+	runnerType := s.config.RunnerType
+	if runnerType == "" {
+		runnerType = "project_type"
+	}
+
 	s.logger.WithFields(logrus.Fields{
-		"project_id": projectID,
-		"vm_ip":      vmIP,
-		"tags":       tags,
-	}).Info("Registering ephemeral GitLab runner")
-
-	allTags := append(s.config.RunnerTags, tags...)
-
-	opts := &gitlab.RegisterNewRunnerOptions{
-		Token:       gitlab.Ptr(s.config.Token),
-		Description: gitlab.Ptr(fmt.Sprintf("FireRunner-VM-%s", vmIP)),
-		Active:      gitlab.Ptr(true),
-		Locked:      gitlab.Ptr(true),
+		"project_id":  projectID,
+		"vm_id":       vmID,
+		"tags":        tags,
+		"runner_type": runnerType,
+	}).Info("Creating ephemeral GitLab runner")
+
+	allTags := append(append([]string{}, s.config.RunnerTags...), tags...)
+	description := fmt.Sprintf("FireRunner-VM-%s", vmID)
+	locked := runnerType == "project_type"
+
+	opts := &gitlab.CreateUserRunnerOptions{
+		RunnerType:  gitlab.Ptr(runnerType),
+		Description: gitlab.Ptr(description),
+		Paused:      gitlab.Ptr(false),
+		Locked:      gitlab.Ptr(locked),
 		RunUntagged: gitlab.Ptr(false),
 		TagList:     &allTags,
 	}
+	if s.config.RunnerTimeout > 0 {
+		opts.MaximumTimeout = gitlab.Ptr(int(s.config.RunnerTimeout.Seconds()))
+	}
 
-	runner, _, err := s.client.Runners.RegisterNewRunner(opts)
+	switch runnerType {
+	case "project_type":
+		opts.ProjectID = gitlab.Ptr(int(projectID))
+	case "group_type":
+		opts.GroupID = gitlab.Ptr(int(s.config.GroupID))
+	}
+
+	runner, _, err := s.client.Users.CreateUserRunner(opts, gitlab.WithContext(ctx))
 	if err != nil {
-		return nil, fmt.Errorf("failed to register runner via GitLab API: %w", err)
+		metrics.RunnerRegistrationFailures.Inc()
+		return nil, fmt.Errorf("failed to create runner via GitLab API: %w", err)
+	}
+
+	if !strings.HasPrefix(runner.Token, "glrt-") {
+		s.logger.WithField("runner_id", runner.ID).Warn("GitLab returned a runner token without the glrt- prefix")
 	}
 
 	s.logger.WithFields(logrus.Fields{
 		"runner_id":    runner.ID,
 		"runner_token": "***",
+		"runner_type":  runnerType,
 		"tags":         allTags,
-	}).Info("Runner registered successfully")
+	}).Info("Runner created successfully")
 
 	registration := &RunnerRegistration{
-		ID:          int64(runner.ID),
-		Token:       runner.Token,
-		Description: fmt.Sprintf("FireRunner-VM-%s", vmIP),
-		Active:      true,
-		IsShared:    false,
-		RunnerType:  "project_type",
-		Tags:        allTags,
-		Locked:      true,
+		ID:             int64(runner.ID),
+		Token:          runner.Token,
+		URL:            s.config.URL,
+		TokenExpiresAt: runner.TokenExpiresAt,
+		Description:    description,
+		Active:         true,
+		IsShared:       runnerType == "instance_type",
+		RunnerType:     runnerType,
+		Tags:           allTags,
+		Locked:         locked,
 	}
 
 	return registration, nil
@@ -93,6 +126,70 @@ func (s *Service) GetJob(ctx context.Context, projectID, jobID int64) (*gitlab.J
 	return job, nil
 }
 
+// CancelJob cancels a job, e.g. one FireRunner will never run.
+func (s *Service) CancelJob(ctx context.Context, projectID, jobID int64) error {
+	if _, _, err := s.client.Jobs.CancelJob(int(projectID), int(jobID), gitlab.WithContext(ctx)); err != nil {
+		return fmt.Errorf("failed to cancel job %d: %w", jobID, err)
+	}
+	return nil
+}
+
+// PipelineInfo looks up what started a pipeline and whether its ref is
+// protected, which job events do not tell.
+func (s *Service) PipelineInfo(ctx context.Context, projectID, pipelineID int64) (*PipelineInfo, error) {
+	pipeline, _, err := s.client.Pipelines.GetPipeline(int(projectID), int(pipelineID), gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pipeline: %w", err)
+	}
+
+	info := &PipelineInfo{
+		Ref:    pipeline.Ref,
+		Tag:    pipeline.Tag,
+		Source: pipeline.Source,
+	}
+
+	var resp *gitlab.Response
+	if pipeline.Tag {
+		var tag *gitlab.Tag
+		tag, resp, err = s.client.Tags.GetTag(int(projectID), pipeline.Ref, gitlab.WithContext(ctx))
+		if err == nil {
+			info.Protected = tag.Protected
+		}
+	} else {
+		var branch *gitlab.Branch
+		branch, resp, err = s.client.Branches.GetBranch(int(projectID), pipeline.Ref, gitlab.WithContext(ctx))
+		if err == nil {
+			info.Protected = branch.Protected
+		}
+	}
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		// Merge request refs and deleted branches are not found and thus
+		// not protected.
+		return nil, fmt.Errorf("failed to look up ref %s: %w", pipeline.Ref, err)
+	}
+
+	if iid, ok := mergeRequestIID(pipeline.Ref); ok {
+		mr, _, err := s.client.MergeRequests.GetMergeRequest(int(projectID), iid, nil, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up merge request !%d: %w", iid, err)
+		}
+		info.Fork = mr.SourceProjectID != mr.TargetProjectID
+	}
+
+	return info, nil
+}
+
+// mergeRequestIID returns the merge request of a merge request pipeline
+// ref such as "refs/merge-requests/3/head".
+func mergeRequestIID(ref string) (int, bool) {
+	rest, ok := strings.CutPrefix(ref, "refs/merge-requests/")
+	if !ok {
+		return 0, false
+	}
+	iid, err := strconv.Atoi(strings.SplitN(rest, "/", 2)[0])
+	return iid, err == nil && iid > 0
+}
+
 func (s *Service) GetProject(ctx context.Context, projectID int64) (*gitlab.Project, error) {
 	project, _, err := s.client.Projects.GetProject(int(projectID), nil)
 	if err != nil {
@@ -111,6 +208,55 @@ func (s *Service) ListProjectRunners(ctx context.Context, projectID int64) ([]*g
 	return runners, nil
 }
 
+// ListPendingJobs returns every pending job of a project.
+func (s *Service) ListPendingJobs(ctx context.Context, projectID int64) ([]*gitlab.Job, error) {
+	opts := &gitlab.ListJobsOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+		Scope:       &[]gitlab.BuildStateValue{gitlab.Pending},
+	}
+
+	var jobs []*gitlab.Job
+	for {
+		page, resp, err := s.client.Jobs.ListProjectJobs(int(projectID), opts, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pending jobs of project %d: %w", projectID, err)
+		}
+		jobs = append(jobs, page...)
+
+		if resp.NextPage == 0 {
+			return jobs, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// ListGroupProjects returns the IDs of every non-archived project in a group
+// and its subgroups.
+func (s *Service) ListGroupProjects(ctx context.Context, groupID int64) ([]int64, error) {
+	opts := &gitlab.ListGroupProjectsOptions{
+		ListOptions:      gitlab.ListOptions{PerPage: 100},
+		Archived:         gitlab.Ptr(false),
+		IncludeSubGroups: gitlab.Ptr(true),
+		Simple:           gitlab.Ptr(true),
+	}
+
+	var projectIDs []int64
+	for {
+		projects, resp, err := s.client.Groups.ListGroupProjects(int(groupID), opts, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list projects of group %d: %w", groupID, err)
+		}
+		for _, project := range projects {
+			projectIDs = append(projectIDs, int64(project.ID))
+		}
+
+		if resp.NextPage == 0 {
+			return projectIDs, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
 func (s *Service) Health(ctx context.Context) error {
 	_, _, err := s.client.Version.GetVersion()
 	if err != nil {
@@ -0,0 +1,196 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+)
+
+func TestService_RegisterRunner(t *testing.T) {
+	tests := []struct {
+		name       string
+		runnerType string
+		groupID    int64
+		wantKey    string
+		wantValue  float64
+	}{
+		{name: "project", runnerType: "project_type", wantKey: "project_id", wantValue: 42},
+		{name: "group", runnerType: "group_type", groupID: 7, wantKey: "group_id", wantValue: 7},
+		{name: "instance", runnerType: "instance_type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/api/v4/user/runners" {
+					t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					t.Errorf("Failed to decode request: %v", err)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				io.WriteString(w, `{"id": 12, "token": "glrt-abc123", "token_expires_at": null}`)
+			}))
+			defer server.Close()
+
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+
+			svc, err := NewService(&config.GitLabConfig{
+				URL:           server.URL,
+				Token:         "glpat-test",
+				RunnerTags:    []string{"firecracker"},
+				RunnerTimeout: time.Hour,
+				RunnerType:    tt.runnerType,
+				GroupID:       tt.groupID,
+			}, logger)
+			if err != nil {
+				t.Fatalf("NewService() error = %v", err)
+			}
+
+			registration, err := svc.RegisterRunner(context.Background(), 42, "10.0.0.2", []string{"4cpu"})
+			if err != nil {
+				t.Fatalf("RegisterRunner() error = %v", err)
+			}
+
+			if registration.ID != 12 || registration.Token != "glrt-abc123" {
+				t.Errorf("Unexpected registration: %+v", registration)
+			}
+			if registration.RunnerType != tt.runnerType {
+				t.Errorf("Expected runner type %s, got %s", tt.runnerType, registration.RunnerType)
+			}
+
+			if body["runner_type"] != tt.runnerType {
+				t.Errorf("Expected runner_type %s in request, got %v", tt.runnerType, body["runner_type"])
+			}
+			if body["maximum_timeout"] != float64(3600) {
+				t.Errorf("Expected maximum_timeout 3600, got %v", body["maximum_timeout"])
+			}
+			if tt.wantKey != "" && body[tt.wantKey] != tt.wantValue {
+				t.Errorf("Expected %s=%v, got %v", tt.wantKey, tt.wantValue, body[tt.wantKey])
+			}
+			for _, key := range []string{"project_id", "group_id"} {
+				if key != tt.wantKey && body[key] != nil {
+					t.Errorf("Did not expect %s in request, got %v", key, body[key])
+				}
+			}
+			tags, _ := body["tag_list"].([]interface{})
+			if len(tags) != 2 {
+				t.Errorf("Expected 2 tags, got %v", body["tag_list"])
+			}
+		})
+	}
+}
+
+func TestService_ListPendingJobs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/projects/42/jobs" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if scope := r.URL.Query().Get("scope[]"); scope != "pending" {
+			t.Errorf("Expected scope pending, got %q", scope)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("page") == "2" {
+			io.WriteString(w, `[{"id": 2, "status": "pending", "tag_list": ["microvm"]}]`)
+			return
+		}
+		w.Header().Set("X-Next-Page", "2")
+		io.WriteString(w, `[{"id": 1, "status": "pending", "tag_list": ["firecracker"], "pipeline": {"id": 9}}]`)
+	}))
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	svc, err := NewService(&config.GitLabConfig{URL: server.URL, Token: "glpat-test"}, logger)
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+
+	jobs, err := svc.ListPendingJobs(context.Background(), 42)
+	if err != nil {
+		t.Fatalf("ListPendingJobs() error = %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != 1 || jobs[1].ID != 2 {
+		t.Errorf("Expected jobs from both pages, got %+v", jobs)
+	}
+	if jobs[0].Pipeline.ID != 9 {
+		t.Errorf("Expected pipeline 9, got %d", jobs[0].Pipeline.ID)
+	}
+}
+
+func TestService_PipelineInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.EscapedPath() {
+		case "/api/v4/projects/42/pipelines/7":
+			io.WriteString(w, `{"id": 7, "ref": "main", "source": "push"}`)
+		case "/api/v4/projects/42/repository/branches/main":
+			io.WriteString(w, `{"name": "main", "protected": true}`)
+		case "/api/v4/projects/42/pipelines/8":
+			io.WriteString(w, `{"id": 8, "ref": "v1.2.0", "tag": true, "source": "push"}`)
+		case "/api/v4/projects/42/repository/tags/v1.2.0":
+			io.WriteString(w, `{"name": "v1.2.0", "protected": false}`)
+		case "/api/v4/projects/42/pipelines/9":
+			io.WriteString(w, `{"id": 9, "ref": "refs/merge-requests/3/head", "source": "merge_request_event"}`)
+		case "/api/v4/projects/42/merge_requests/3":
+			io.WriteString(w, `{"iid": 3, "source_project_id": 77, "target_project_id": 42}`)
+		case "/api/v4/projects/42/pipelines/11":
+			io.WriteString(w, `{"id": 11, "ref": "refs/merge-requests/4/merge", "source": "merge_request_event"}`)
+		case "/api/v4/projects/42/merge_requests/4":
+			io.WriteString(w, `{"iid": 4, "source_project_id": 42, "target_project_id": 42}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"message": "404 Not Found"}`)
+		}
+	}))
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	svc, err := NewService(&config.GitLabConfig{URL: server.URL, Token: "glpat-test"}, logger)
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+
+	tests := []struct {
+		pipelineID int64
+		expected   PipelineInfo
+	}{
+		{pipelineID: 7, expected: PipelineInfo{Ref: "main", Protected: true, Source: "push"}},
+		{pipelineID: 8, expected: PipelineInfo{Ref: "v1.2.0", Tag: true, Source: "push"}},
+		{pipelineID: 9, expected: PipelineInfo{Ref: "refs/merge-requests/3/head", Source: "merge_request_event", Fork: true}},
+		{pipelineID: 11, expected: PipelineInfo{Ref: "refs/merge-requests/4/merge", Source: "merge_request_event"}},
+	}
+
+	for _, tt := range tests {
+		info, err := svc.PipelineInfo(context.Background(), 42, tt.pipelineID)
+		if err != nil {
+			t.Fatalf("PipelineInfo(%d) error = %v", tt.pipelineID, err)
+		}
+		if *info != tt.expected {
+			t.Errorf("PipelineInfo(%d) = %+v, expected %+v", tt.pipelineID, *info, tt.expected)
+		}
+	}
+
+	if _, err := svc.PipelineInfo(context.Background(), 42, 10); err == nil {
+		t.Error("PipelineInfo() should fail for an unknown pipeline")
+	}
+}
@@ -1,7 +1,7 @@
 package gitlab
 
 import (
-	"fmt"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -163,43 +163,35 @@ type Variable struct {
 }
 
 type RunnerRegistration struct {
-	ID          int64    `json:"id"`
-	Token       string   `json:"token"`
-	Description string   `json:"description"`
-	Active      bool     `json:"active"`
-	IsShared    bool     `json:"is_shared"`
-	RunnerType  string   `json:"runner_type"`
-	Tags        []string `json:"tag_list"`
-	Locked      bool     `json:"locked"`
-}
-
-func ParseVMRequirements(tags []string) (vcpu int64, memoryMB int64) {
-	vcpu = 2
-	memoryMB = 4096
-
-	for _, tag := range tags {
-		if strings.Contains(tag, "cpu") && strings.Contains(tag, "gb") {
-			parts := strings.Split(tag, "-")
-			for i, part := range parts {
-				if strings.HasSuffix(part, "cpu") {
-					if cpu, err := parseInt(strings.TrimSuffix(part, "cpu")); err == nil {
-						vcpu = cpu
-					}
-				}
-				if strings.HasSuffix(part, "gb") && i > 0 {
-					if mem, err := parseInt(strings.TrimSuffix(part, "gb")); err == nil {
-						memoryMB = mem * 1024
-					}
-				}
-			}
-		}
+	ID             int64      `json:"id"`
+	Token          string     `json:"token"`
+	URL            string     `json:"url"`
+	TokenExpiresAt *time.Time `json:"token_expires_at,omitempty"`
+	Description    string     `json:"description"`
+	Active         bool       `json:"active"`
+	IsShared       bool       `json:"is_shared"`
+	RunnerType     string     `json:"runner_type"`
+	Tags           []string   `json:"tag_list"`
+	Locked         bool       `json:"locked"`
+}
+
+// PipelineInfo describes the ref and source of a pipeline. Fork is set for
+// merge request pipelines whose source branch lives in a fork.
+type PipelineInfo struct {
+	Ref       string
+	Tag       bool
+	Protected bool
+	Source    string
+	Fork      bool
+}
+
+// ProjectPath returns the path with namespace, e.g. "group/sub/project", of
+// a project or job web URL. It returns "" if the URL cannot be parsed.
+func ProjectPath(webURL string) string {
+	u, err := url.Parse(webURL)
+	if err != nil || u.Host == "" {
+		return ""
 	}
-
-	return vcpu, memoryMB
-}
-
-func parseInt(s string) (int64, error) {
-	var result int64
-	_, err := fmt.Sscanf(s, "%d", &result)
-	return result, err
+	path, _, _ := strings.Cut(u.Path, "/-/")
+	return strings.Trim(path, "/")
 }
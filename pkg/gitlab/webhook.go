@@ -8,9 +8,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
 
 	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+	"github.com/ismoilovdevml/firerunner/pkg/metrics"
 )
 
 const (
@@ -19,9 +21,11 @@ const (
 )
 
 type WebhookHandler struct {
-	secret    string
-	logger    *logrus.Logger
-	processor EventProcessor
+	secret     string
+	logger     *logrus.Logger
+	processor  EventProcessor
+	deliveries *forge.DeliveryCache
+	dispatcher *EventDispatcher
 }
 
 type EventProcessor interface {
@@ -31,20 +35,29 @@ type EventProcessor interface {
 
 func NewWebhookHandler(secret string, logger *logrus.Logger, processor EventProcessor) *WebhookHandler {
 	return &WebhookHandler{
-		secret:    secret,
-		logger:    logger,
-		processor: processor,
+		secret:     secret,
+		logger:     logger,
+		processor:  processor,
+		deliveries: forge.NewDeliveryCache(forge.DefaultDeliveryTTL, forge.DefaultDeliveryMaxEntries),
 	}
 }
 
+// SetDispatcher makes the handler acknowledge events with 202 Accepted and
+// leave their processing to dispatcher.
+func (h *WebhookHandler) SetDispatcher(dispatcher *EventDispatcher) {
+	h.dispatcher = dispatcher
+}
+
 func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
+		metrics.WebhookEventsRejected.WithLabelValues("method_not_allowed").Inc()
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
+		metrics.WebhookEventsRejected.WithLabelValues("read_error").Inc()
 		h.logger.WithError(err).Error("Failed to read webhook body")
 		http.Error(w, "Failed to read body", http.StatusBadRequest)
 		return
@@ -52,6 +65,7 @@ func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	if !h.verifySignature(r, body) {
+		metrics.WebhookEventsRejected.WithLabelValues("invalid_signature").Inc()
 		h.logger.Warn("Invalid webhook signature")
 		http.Error(w, "Invalid signature", http.StatusUnauthorized)
 		return
@@ -59,14 +73,38 @@ func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	eventType := r.Header.Get(HeaderGitLabEvent)
 	if eventType == "" {
+		metrics.WebhookEventsRejected.WithLabelValues("missing_event").Inc()
 		h.logger.Warn("Missing X-Gitlab-Event header")
 		http.Error(w, "Missing event type", http.StatusBadRequest)
 		return
 	}
 
+	metrics.WebhookEventsReceived.WithLabelValues(eventType).Inc()
 	h.logger.WithField("event_type", eventType).Debug("Received webhook event")
 
-	if err := h.processEvent(eventType, body); err != nil {
+	key, source := deliveryKey(r.Header)
+	if key != "" && h.deliveries.MarkSeen(key) {
+		metrics.DuplicateEventsSuppressed.WithLabelValues(source).Inc()
+		h.logger.WithFields(logrus.Fields{
+			"event_type": eventType,
+			source:       key,
+		}).Info("Ignoring duplicate webhook delivery")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status":"duplicate"}`))
+		return
+	}
+
+	if h.dispatcher != nil {
+		h.acceptEvent(w, eventType, body, key)
+		return
+	}
+
+	if err := h.ProcessEvent(eventType, body); err != nil {
+		// Let GitLab's retry of this delivery through.
+		if key != "" {
+			h.deliveries.Forget(key)
+		}
+		metrics.WebhookEventsRejected.WithLabelValues("processing_error").Inc()
 		h.logger.WithError(err).Error("Failed to process webhook event")
 		http.Error(w, "Failed to process event", http.StatusInternalServerError)
 		return
@@ -76,6 +114,28 @@ func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"status":"accepted"}`))
 }
 
+func (h *WebhookHandler) acceptEvent(w http.ResponseWriter, eventType string, body []byte, key string) {
+	if !json.Valid(body) {
+		metrics.WebhookEventsRejected.WithLabelValues("invalid_payload").Inc()
+		h.logger.WithField("event_type", eventType).Warn("Webhook body is not valid JSON")
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.dispatcher.Submit(forge.GitLab, eventType, body); err != nil {
+		if key != "" {
+			h.deliveries.Forget(key)
+		}
+		metrics.WebhookEventsRejected.WithLabelValues("persist_error").Inc()
+		h.logger.WithError(err).Error("Failed to accept webhook event")
+		http.Error(w, "Failed to accept event", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(`{"status":"accepted"}`))
+}
+
 func (h *WebhookHandler) verifySignature(r *http.Request, body []byte) bool {
 	if h.secret == "" {
 		return true
@@ -107,7 +167,9 @@ func (h *WebhookHandler) verifyHMAC(body []byte, signature string) bool {
 	return hmac.Equal([]byte(signature), []byte(expectedMAC))
 }
 
-func (h *WebhookHandler) processEvent(eventType string, body []byte) error {
+// ProcessEvent parses a webhook body and hands supported events to the
+// processor.
+func (h *WebhookHandler) ProcessEvent(eventType string, body []byte) error {
 	switch eventType {
 	case "Job Hook":
 		return h.processJobEvent(body)
@@ -133,12 +195,14 @@ func (h *WebhookHandler) processJobEvent(body []byte) error {
 		"project_name": event.ProjectName,
 	}).Info("Processing job event")
 
-	if event.BuildStatus != "pending" && event.BuildStatus != "created" {
-		h.logger.WithField("status", event.BuildStatus).Debug("Ignoring non-pending job")
+	switch event.BuildStatus {
+	case "pending", "created", "canceled", "failed":
+	default:
+		h.logger.WithField("status", event.BuildStatus).Debug("Ignoring job status")
 		return nil
 	}
 
-	if !h.hasFireRunnerTag(event.BuildTags) {
+	if !forge.HasFireRunnerTag(event.BuildTags) {
 		h.logger.Debug("Job does not have firerunner tags, skipping")
 		return nil
 	}
@@ -169,19 +233,6 @@ func (h *WebhookHandler) processPipelineEvent(body []byte) error {
 	return nil
 }
 
-func (h *WebhookHandler) hasFireRunnerTag(tags []string) bool {
-	for _, tag := range tags {
-		tag = strings.ToLower(strings.TrimSpace(tag))
-		if strings.HasPrefix(tag, "firecracker") ||
-			strings.HasPrefix(tag, "microvm") ||
-			strings.HasPrefix(tag, "firerunner") ||
-			strings.HasPrefix(tag, "actuated") {
-			return true
-		}
-	}
-	return false
-}
-
 func (h *WebhookHandler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"status":"healthy"}`))
@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/metrics"
 )
 
 type SecurityConfig struct {
@@ -58,24 +60,28 @@ func NewSecureWebhookHandler(
 
 func (h *SecureWebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if h.security.RequireSSL && r.TLS == nil {
+		metrics.WebhookEventsRejected.WithLabelValues("https_required").Inc()
 		h.logger.Warn("Rejected non-HTTPS request")
 		http.Error(w, "HTTPS required", http.StatusForbidden)
 		return
 	}
 
 	if !h.isIPAllowed(r.RemoteAddr) {
+		metrics.WebhookEventsRejected.WithLabelValues("ip_not_allowed").Inc()
 		h.logger.WithField("ip", r.RemoteAddr).Warn("Rejected request from non-whitelisted IP")
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
 
 	if !h.checkRateLimit(r.RemoteAddr) {
+		metrics.WebhookEventsRejected.WithLabelValues("rate_limited").Inc()
 		h.logger.WithField("ip", r.RemoteAddr).Warn("Rate limit exceeded")
 		http.Error(w, "Too many requests", http.StatusTooManyRequests)
 		return
 	}
 
 	if r.ContentLength > h.security.MaxBodySize {
+		metrics.WebhookEventsRejected.WithLabelValues("body_too_large").Inc()
 		h.logger.WithField("size", r.ContentLength).Warn("Request body too large")
 		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
 		return
@@ -83,6 +89,7 @@ func (h *SecureWebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 
 	if h.security.RequireSecret && h.security.Secret != "" {
 		if !h.verifySignature(r) {
+			metrics.WebhookEventsRejected.WithLabelValues("invalid_signature").Inc()
 			h.logger.Warn("Invalid webhook signature")
 			http.Error(w, "Invalid signature", http.StatusUnauthorized)
 			return
@@ -7,7 +7,10 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/metrics"
 )
 
 type mockEventProcessor struct {
@@ -119,87 +122,155 @@ func TestWebhookHandler_HealthCheck(t *testing.T) {
 	}
 }
 
-func TestHasFireRunnerTag(t *testing.T) {
-	handler := &WebhookHandler{}
+func TestProjectPath(t *testing.T) {
+	tests := map[string]string{
+		"https://gitlab.example.com/acme/backend/api":              "acme/backend/api",
+		"https://gitlab.example.com/acme/backend/api/-/jobs/42":    "acme/backend/api",
+		"https://gitlab.example.com/gitlab/acme/app/-/pipelines/7": "gitlab/acme/app",
+		"":            "",
+		"not a url\n": "",
+	}
 
-	tests := []struct {
-		name     string
-		tags     []string
-		expected bool
-	}{
-		{
-			name:     "has firecracker tag",
-			tags:     []string{"firecracker-2cpu-4gb"},
-			expected: true,
-		},
-		{
-			name:     "has microvm tag",
-			tags:     []string{"docker", "microvm"},
-			expected: true,
-		},
-		{
-			name:     "has firerunner tag",
-			tags:     []string{"firerunner-4cpu-8gb"},
-			expected: true,
-		},
-		{
-			name:     "no relevant tags",
-			tags:     []string{"docker", "kubernetes"},
-			expected: false,
-		},
-		{
-			name:     "empty tags",
-			tags:     []string{},
-			expected: false,
-		},
+	for webURL, expected := range tests {
+		if path := ProjectPath(webURL); path != expected {
+			t.Errorf("ProjectPath(%q) = %q, expected %q", webURL, path, expected)
+		}
 	}
+}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := handler.hasFireRunnerTag(tt.tags)
-			if result != tt.expected {
-				t.Errorf("Expected %v, got %v", tt.expected, result)
-			}
-		})
+func TestWebhookHandler_Metrics(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	handler := NewWebhookHandler("secret", logger, &mockEventProcessor{})
+
+	received := testutil.ToFloat64(metrics.WebhookEventsReceived.WithLabelValues("Pipeline Hook"))
+	rejected := testutil.ToFloat64(metrics.WebhookEventsRejected.WithLabelValues("invalid_signature"))
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(`{}`))
+	req.Header.Set(HeaderGitLabEvent, "Pipeline Hook")
+	req.Header.Set(HeaderGitLabToken, "wrong")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(`{"object_attributes":{"id":1}}`))
+	req.Header.Set(HeaderGitLabEvent, "Pipeline Hook")
+	req.Header.Set(HeaderGitLabToken, "secret")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := testutil.ToFloat64(metrics.WebhookEventsRejected.WithLabelValues("invalid_signature")); got != rejected+1 {
+		t.Errorf("Expected rejected counter %v, got %v", rejected+1, got)
+	}
+	if got := testutil.ToFloat64(metrics.WebhookEventsReceived.WithLabelValues("Pipeline Hook")); got != received+1 {
+		t.Errorf("Expected received counter %v, got %v", received+1, got)
 	}
 }
 
-func TestParseVMRequirements(t *testing.T) {
+func TestWebhookHandler_ForwardsCanceledJobs(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
 	tests := []struct {
-		name           string
-		tags           []string
-		expectedVCPU   int64
-		expectedMemory int64
+		status  string
+		forward bool
 	}{
-		{
-			name:           "standard 2cpu 4gb",
-			tags:           []string{"firecracker-2cpu-4gb"},
-			expectedVCPU:   2,
-			expectedMemory: 4096,
-		},
-		{
-			name:           "large 8cpu 16gb",
-			tags:           []string{"actuated-8cpu-16gb"},
-			expectedVCPU:   8,
-			expectedMemory: 16384,
-		},
-		{
-			name:           "no vm tags - defaults",
-			tags:           []string{"docker"},
-			expectedVCPU:   2,
-			expectedMemory: 4096,
-		},
+		{"pending", true},
+		{"canceled", true},
+		{"failed", true},
+		{"running", false},
+		{"success", false},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			vcpu, mem := ParseVMRequirements(tt.tags)
-			if vcpu != tt.expectedVCPU {
-				t.Errorf("Expected VCPU %d, got %d", tt.expectedVCPU, vcpu)
-			}
-			if mem != tt.expectedMemory {
-				t.Errorf("Expected memory %d, got %d", tt.expectedMemory, mem)
+		t.Run(tt.status, func(t *testing.T) {
+			processor := &mockEventProcessor{}
+			handler := NewWebhookHandler("", logger, processor)
+
+			body := `{"build_id":1,"tags":["firecracker"],"build_status":"` + tt.status + `"}`
+			req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(body))
+			req.Header.Set(HeaderGitLabEvent, "Job Hook")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if processor.jobCalled != tt.forward {
+				t.Errorf("Expected forwarded=%v for status %s", tt.forward, tt.status)
 			}
 		})
 	}
 }
+
+func TestWebhookHandler_SuppressesDuplicateDeliveries(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	suppressed := testutil.ToFloat64(metrics.DuplicateEventsSuppressed.WithLabelValues("event_uuid"))
+
+	processor := &mockEventProcessor{}
+	handler := NewWebhookHandler("", logger, processor)
+
+	deliver := func(uuid string) {
+		body := `{"build_id":1,"tags":["firecracker"],"build_status":"pending"}`
+		req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(body))
+		req.Header.Set(HeaderGitLabEvent, "Job Hook")
+		req.Header.Set(HeaderGitLabEventUUID, uuid)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", rr.Code)
+		}
+	}
+
+	deliver("a")
+	if !processor.jobCalled {
+		t.Fatal("First delivery should be processed")
+	}
+
+	processor.jobCalled = false
+	deliver("a")
+	if processor.jobCalled {
+		t.Error("Redelivery with the same UUID should be suppressed")
+	}
+
+	deliver("b")
+	if !processor.jobCalled {
+		t.Error("Delivery with a new UUID should be processed")
+	}
+
+	if got := testutil.ToFloat64(metrics.DuplicateEventsSuppressed.WithLabelValues("event_uuid")); got != suppressed+1 {
+		t.Errorf("Expected suppressed counter %v, got %v", suppressed+1, got)
+	}
+}
+
+func TestWebhookHandler_AcceptsAsynchronously(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	processor := &mockEventProcessor{}
+	handler := NewWebhookHandler("", logger, processor)
+
+	events := &recordingProcessor{}
+	dispatcher := NewEventDispatcher(events.process, 10, 1, 0, logger)
+	handler.SetDispatcher(dispatcher)
+
+	body := `{"build_id":1,"tags":["firecracker"],"build_status":"pending"}`
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(body))
+	req.Header.Set(HeaderGitLabEvent, "Job Hook")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("Expected status 202, got %d", rr.Code)
+	}
+	if processor.jobCalled {
+		t.Error("Event should not be processed before the dispatcher runs")
+	}
+	if len(dispatcher.queue) != 1 {
+		t.Errorf("Expected 1 queued event, got %d", len(dispatcher.queue))
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString(`not json`))
+	req.Header.Set(HeaderGitLabEvent, "Job Hook")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid body, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "firerunner"
+
+var (
+	WebhookEventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_events_received_total",
+		Help:      "Webhook events received, by event type.",
+	}, []string{"event"})
+
+	WebhookEventsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_events_rejected_total",
+		Help:      "Webhook requests rejected before processing, by reason.",
+	}, []string{"reason"})
+
+	DuplicateEventsSuppressed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "duplicate_events_suppressed_total",
+		Help:      "Duplicate webhook deliveries and job events that were ignored, by the key that matched.",
+	}, []string{"key"})
+
+	WebhookEventQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "webhook_event_queue_depth",
+		Help:      "Accepted webhook events waiting to be processed.",
+	})
+
+	WebhookEventsDeadLettered = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_events_dead_lettered_total",
+		Help:      "Webhook events moved to the dead-letter store.",
+	})
+
+	WebhookDeadLetters = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "webhook_dead_letters",
+		Help:      "Webhook events currently in the dead-letter store.",
+	})
+
+	PolledJobs = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "polled_jobs_total",
+		Help:      "Pending jobs discovered by polling the GitLab API.",
+	})
+
+	QueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Jobs waiting in the scheduler queue.",
+	})
+
+	QueueWaitSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "queue_wait_seconds",
+		Help:      "Time between a job being scheduled and a worker picking it up.",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600},
+	})
+
+	JobsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_total",
+		Help:      "Jobs that reached a final status, by status and project.",
+	}, []string{"status", "project_id"})
+
+	JobsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_rejected_total",
+		Help:      "Jobs failed without starting because of their VM tags, by reason.",
+	}, []string{"reason"})
+
+	VMCreateSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "vm_create_seconds",
+		Help:      "Time to create a microVM and wait for it to start.",
+		Buckets:   []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120},
+	})
+
+	VMDestroySeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "vm_destroy_seconds",
+		Help:      "Time to delete a microVM.",
+		Buckets:   []float64{0.1, 0.5, 1, 2, 5, 10, 30},
+	})
+
+	VMFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "vm_failures_total",
+		Help:      "Failed microVM operations, by operation.",
+	}, []string{"operation"})
+
+	ActiveVMs = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_vms",
+		Help:      "MicroVMs tracked by the manager, by state.",
+	}, []string{"state"})
+
+	CacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_evictions_total",
+		Help:      "Project caches deleted to stay within size limits, by the limit that was exceeded.",
+	}, []string{"reason"})
+
+	RunnerRegistrationFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "runner_registration_failures_total",
+		Help:      "Failed attempts to create a GitLab runner.",
+	})
+)
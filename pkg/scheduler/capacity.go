@@ -0,0 +1,85 @@
+package scheduler
+
+import (
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+)
+
+// CapacityStats reports the vCPUs and memory the VMs of jobs allocate.
+// Totals are zero and free capacity is left out when a resource is not
+// limited.
+type CapacityStats struct {
+	VCPU              int64  `json:"vcpu"`
+	MemoryMB          int64  `json:"memory_mb"`
+	AllocatedVCPU     int64  `json:"allocated_vcpu"`
+	AllocatedMemoryMB int64  `json:"allocated_memory_mb"`
+	FreeVCPU          *int64 `json:"free_vcpu,omitempty"`
+	FreeMemoryMB      *int64 `json:"free_memory_mb,omitempty"`
+}
+
+// SetHostCapacity sets the capacity to use where scheduler.capacity does
+// not configure one, usually the declared capacity of the Flintlock hosts.
+// It must be called before Start.
+func (s *Scheduler) SetHostCapacity(vcpu, memoryMB int64) {
+	s.hostVCPU = vcpu
+	s.hostMemoryMB = memoryMB
+}
+
+// capacity returns how many vCPUs and MB of memory jobs may allocate in
+// total, overcommit included. Zero means unlimited.
+func (s *Scheduler) capacity() (vcpu, memoryMB int64) {
+	cfg := s.config.Capacity
+
+	vcpu, memoryMB = cfg.VCPU, cfg.MemoryMB
+	if vcpu == 0 {
+		vcpu = s.hostVCPU
+	}
+	if memoryMB == 0 {
+		memoryMB = s.hostMemoryMB
+	}
+
+	return overcommit(vcpu, cfg.CPUOvercommit), overcommit(memoryMB, cfg.MemoryOvercommit)
+}
+
+func overcommit(capacity int64, ratio float64) int64 {
+	if ratio <= 0 {
+		return capacity
+	}
+	return int64(float64(capacity) * ratio)
+}
+
+// capacityScope holds jobs back while the VMs of running jobs use up the
+// host capacity.
+func (s *Scheduler) capacityScope() *quotaScope {
+	vcpu, memoryMB := s.capacity()
+	quota := config.Quota{MaxVCPU: vcpu, MaxMemoryMB: memoryMB}
+	if !limited(quota) {
+		return nil
+	}
+	return &quotaScope{
+		quota: quota,
+		match: func(*Job) bool { return true },
+	}
+}
+
+// capacityStats must be called with jobsMu held.
+func (s *Scheduler) capacityStats() CapacityStats {
+	stats := CapacityStats{}
+	stats.VCPU, stats.MemoryMB = s.capacity()
+
+	for _, job := range s.jobs {
+		if job.holdsQuota() {
+			stats.AllocatedVCPU += job.VCPU
+			stats.AllocatedMemoryMB += job.MemoryMB
+		}
+	}
+
+	if stats.VCPU > 0 {
+		free := max(stats.VCPU-stats.AllocatedVCPU, 0)
+		stats.FreeVCPU = &free
+	}
+	if stats.MemoryMB > 0 {
+		free := max(stats.MemoryMB-stats.AllocatedMemoryMB, 0)
+		stats.FreeMemoryMB = &free
+	}
+	return stats
+}
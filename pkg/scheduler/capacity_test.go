@@ -0,0 +1,56 @@
+package scheduler
+
+import (
+	"testing"
+)
+
+func TestScheduler_CapacityAdmission(t *testing.T) {
+	cfg := testSchedulerConfig()
+	cfg.Capacity.VCPU = 8
+	cfg.Capacity.CPUOvercommit = 1.5
+	s := NewScheduler(cfg, &mockVMManager{}, newMockGitLabService(), testLogger())
+
+	for id := int64(1); id <= 4; id++ {
+		scheduleProjectJob(t, s, id, id, "acme/app", "firecracker-4cpu-8gb")
+	}
+
+	expectNextJob(t, s, 1)
+	expectNextJob(t, s, 2)
+	expectNextJob(t, s, 3)
+	expectNextJob(t, s, 0)
+
+	stats := s.GetStats().Capacity
+	if stats.VCPU != 12 || stats.AllocatedVCPU != 12 || stats.AllocatedMemoryMB != 24576 {
+		t.Errorf("Unexpected capacity stats: %+v", stats)
+	}
+	if stats.FreeVCPU == nil || *stats.FreeVCPU != 0 {
+		t.Errorf("Expected no free vCPUs, got %v", stats.FreeVCPU)
+	}
+	if stats.FreeMemoryMB != nil {
+		t.Errorf("Expected memory to be unlimited, got %d free", *stats.FreeMemoryMB)
+	}
+
+	s.updateJobStatus(gitlabKey(2), "finished")
+	expectNextJob(t, s, 4)
+}
+
+func TestScheduler_HostCapacity(t *testing.T) {
+	s := NewScheduler(testSchedulerConfig(), &mockVMManager{}, newMockGitLabService(), testLogger())
+	s.SetHostCapacity(0, 16384)
+
+	for id := int64(1); id <= 3; id++ {
+		scheduleProjectJob(t, s, id, id, "acme/app", "firecracker-2cpu-8gb")
+	}
+
+	expectNextJob(t, s, 1)
+	expectNextJob(t, s, 2)
+	expectNextJob(t, s, 0)
+
+	stats := s.GetStats().Capacity
+	if stats.MemoryMB != 16384 || stats.FreeMemoryMB == nil || *stats.FreeMemoryMB != 0 {
+		t.Errorf("Unexpected capacity stats: %+v", stats)
+	}
+	if stats.VCPU != 0 || stats.FreeVCPU != nil {
+		t.Errorf("Expected vCPUs to be unlimited, got %+v", stats)
+	}
+}
@@ -0,0 +1,364 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/ismoilovdevml/firerunner/pkg/cloudinit"
+	"github.com/ismoilovdevml/firerunner/pkg/firecracker"
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+	"github.com/ismoilovdevml/firerunner/pkg/gitlab"
+)
+
+const (
+	claimAttempts   = 3
+	claimRetryDelay = 2 * time.Second
+	// A slot whose job GitLab keeps handing to other runners or to nobody,
+	// e.g. because its tags do not match FireRunner's runner, goes back to
+	// the queue with a growing delay and fails after maxSlotReleases tries.
+	maxSlotReleases   = 8
+	slotRetryMaxDelay = 5 * time.Minute
+
+	// canceledMessage ends the log of a claimed job canceled in FireRunner.
+	canceledMessage = "The job was canceled through the FireRunner admin API."
+)
+
+// JobClaimer claims jobs through the runner side of the GitLab job API.
+type JobClaimer interface {
+	URL() string
+	RequestJob(ctx context.Context) (*gitlab.JobPayload, error)
+	UpdateJob(ctx context.Context, jobID int64, jobToken, state, failureReason string) error
+	AppendTrace(ctx context.Context, jobID int64, jobToken string, offset int, data []byte) (int, error)
+}
+
+// processClaimedJob treats a queued job as a slot: it claims whichever job
+// GitLab hands out next and runs that one in a fresh VM. GitLab decides the
+// order, so the claimed job may be a different one than the slot; the slot
+// then goes back to the queue while its job is still pending.
+func (w *Worker) processClaimedJob(slot *Job) {
+	if w.scheduler.jobStatus(slot.key()) != "queued" {
+		// Another slot already claimed this job.
+		return
+	}
+
+	payload, err := w.claimJob(slot)
+	if err != nil {
+		w.logger.WithError(err).WithField("job_id", slot.ID).Error("Failed to claim job")
+		w.scheduler.updateJobStatus(slot.key(), "failed")
+		slot.err = err
+		return
+	}
+	if payload == nil {
+		w.logger.WithField("job_id", slot.ID).Info("GitLab has no job for FireRunner")
+		w.scheduler.releaseSlot(slot)
+		return
+	}
+
+	job := w.scheduler.claimedJob(slot, payload)
+	if job != slot {
+		if job.FailureReason == "" && !w.scheduler.admitClaimedJob(job, slot) {
+			// Shutting down; the claimed job is recovered on the next start.
+			return
+		}
+		w.scheduler.releaseSlot(slot)
+	}
+
+	if job.FailureReason != "" {
+		// GitLab handed out a job whose tags FireRunner rejected; fail it
+		// so that the reason shows up in the job log.
+		w.logger.WithFields(logrus.Fields{
+			"job_id": job.ID,
+			"reason": job.FailureReason,
+		}).Warn("Failing claimed job with unsupported VM tags")
+		w.failClaimedJob(job, "runner_unsupported", "FireRunner cannot run this job: "+job.FailureReason)
+		w.scheduler.updateJobStatus(job.key(), "failed")
+		job.cancel()
+		return
+	}
+
+	w.logger.WithFields(logrus.Fields{
+		"job_id":     job.ID,
+		"project_id": job.ProjectID,
+		"vcpu":       job.VCPU,
+		"memory_mb":  job.MemoryMB,
+	}).Info("Processing claimed job")
+
+	vmID := firecracker.NewVMID(fmt.Sprintf("%s-%d", job.Forge, job.ID))
+	w.runJob(job, vmID, nil, &cloudinit.Job{
+		URL:     w.scheduler.claimer.URL(),
+		ID:      payload.ID,
+		Token:   payload.Token,
+		Payload: payload.Raw,
+	})
+}
+
+// claimJob asks GitLab for a job a few times before giving up, since the job
+// that triggered the slot may not be visible to runners yet.
+func (w *Worker) claimJob(slot *Job) (*gitlab.JobPayload, error) {
+	var lastErr error
+	for attempt := 1; attempt <= claimAttempts; attempt++ {
+		payload, err := w.scheduler.claimer.RequestJob(slot.ctx)
+		if err == nil && payload != nil {
+			return payload, nil
+		}
+		lastErr = err
+
+		if attempt == claimAttempts {
+			break
+		}
+		select {
+		case <-time.After(claimRetryDelay):
+		case <-slot.ctx.Done():
+			return nil, slot.ctx.Err()
+		}
+	}
+	return nil, lastErr
+}
+
+// failClaimedJob reports a claimed job as failed to GitLab, since no guest
+// is left to do so. Jobs run through a registered runner and jobs GitLab
+// ended itself are left alone.
+func (w *Worker) failClaimedJob(job *Job, reason, message string) {
+	claimer := w.scheduler.claimer
+	if claimer == nil || job.JobToken == "" {
+		return
+	}
+
+	w.scheduler.jobsMu.RLock()
+	forgeAborted := job.forgeAborted
+	w.scheduler.jobsMu.RUnlock()
+	if forgeAborted {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if message != "" {
+		if _, err := claimer.AppendTrace(ctx, job.ID, job.JobToken, 0, []byte(message+"\n")); err != nil {
+			w.logger.WithError(err).WithField("job_id", job.ID).Warn("Failed to append job trace")
+		}
+	}
+
+	if err := claimer.UpdateJob(ctx, job.ID, job.JobToken, "failed", reason); err != nil {
+		w.logger.WithError(err).WithField("job_id", job.ID).Error("Failed to report job failure to GitLab")
+		return
+	}
+
+	w.logger.WithFields(logrus.Fields{
+		"job_id": job.ID,
+		"reason": reason,
+	}).Info("Reported claimed job as failed")
+}
+
+// claimedJob returns the tracked job for a claim, marked running with its
+// job token. A job FireRunner has not seen yet is looked up in GitLab and
+// tracked; the slot's tags are used if the lookup fails.
+func (s *Scheduler) claimedJob(slot *Job, payload *gitlab.JobPayload) *Job {
+	s.jobsMu.Lock()
+	key := JobKey{Forge: forge.GitLab, ID: payload.ID}
+	job, exists := s.jobs[key]
+	if exists {
+		s.startClaimedJob(job, payload.Token)
+		s.jobsMu.Unlock()
+		return job
+	}
+	s.jobsMu.Unlock()
+
+	projectID := payload.JobInfo.ProjectID
+	tags := slot.Tags
+	var pipelineID int64
+	var repository string
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if details, err := s.gitlabSvc.GetJob(ctx, projectID, payload.ID); err != nil {
+		s.logger.WithError(err).WithField("job_id", payload.ID).Warn("Failed to look up claimed job, using slot tags")
+	} else {
+		tags = details.TagList
+		pipelineID = int64(details.Pipeline.ID)
+		repository = gitlab.ProjectPath(details.WebURL)
+	}
+	cancel()
+
+	fj := forge.Job{ID: payload.ID, ProjectID: projectID, PipelineID: pipelineID}
+	s.resolvePipeline(&fj)
+	spec, specErr := s.vmSpec(tags, repository)
+
+	jobCtx, jobCancel := context.WithTimeout(context.Background(), s.config.JobTimeout)
+	job = &Job{
+		ID:         payload.ID,
+		Forge:      forge.GitLab,
+		Repository: repository,
+		ProjectID:  projectID,
+		PipelineID: pipelineID,
+		Tags:       tags,
+		Egress:     s.specs.Egress(repository, fj.Fork),
+		CreatedAt:  time.Now(),
+		ctx:        jobCtx,
+		cancel:     jobCancel,
+	}
+	job.setSpec(spec)
+	if specErr != nil {
+		job.FailureReason = specErr.Error()
+	}
+
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	if existing, exists := s.jobs[key]; exists {
+		// An event for the job arrived while it was being looked up.
+		jobCancel()
+		job = existing
+	} else {
+		s.jobs[key] = job
+	}
+	s.startClaimedJob(job, payload.Token)
+	return job
+}
+
+// startClaimedJob must be called with jobsMu held. A job claimed for
+// another slot is not admitted yet; see admitClaimedJob.
+func (s *Scheduler) startClaimedJob(job *Job, token string) {
+	job.JobToken = token
+	job.Status = "running"
+	if job.StartedAt.IsZero() {
+		job.StartedAt = time.Now()
+	}
+	s.saveJob(job)
+}
+
+// admitClaimedJob holds a job GitLab handed out for another slot until it
+// fits into its quotas and the host capacity, counting the slot's
+// reservation as its own. Until then the slot keeps its reservation, so no
+// queued job takes the capacity the claimed job is waiting for. It reports
+// false if the scheduler shuts down first.
+func (s *Scheduler) admitClaimedJob(job, slot *Job) bool {
+	logged := false
+	for {
+		s.jobsMu.Lock()
+		if job.admitted {
+			s.jobsMu.Unlock()
+			return true
+		}
+		slot.admitted = false
+		fits := job.aborted || job.ctx.Err() != nil || s.withinQuotas(job)
+		if fits {
+			job.admitted = true
+		} else {
+			slot.admitted = true
+		}
+		changed := s.queue.changes()
+		s.jobsMu.Unlock()
+
+		if fits {
+			return true
+		}
+		if !logged {
+			s.logger.WithFields(logrus.Fields{
+				"job_id":  job.ID,
+				"slot_id": slot.ID,
+			}).Info("Holding claimed job until it fits into its quotas")
+			logged = true
+		}
+
+		select {
+		case <-changed:
+		case <-s.shutdownCh:
+			return false
+		}
+	}
+}
+
+// releaseSlot puts a slot whose job was not claimed back in the queue while
+// the job is still pending in GitLab, after a delay that grows with every
+// try. Otherwise another runner took the job and FireRunner stops tracking
+// it. The slot gives up its quota and capacity while it waits.
+func (s *Scheduler) releaseSlot(slot *Job) {
+	s.jobsMu.Lock()
+	slot.admitted = false
+	s.queue.wake()
+	s.jobsMu.Unlock()
+
+	if s.jobStatus(slot.key()) != "queued" || slot.ctx.Err() != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	details, err := s.gitlabSvc.GetJob(ctx, slot.ProjectID, slot.ID)
+	cancel()
+
+	if err == nil && details.Status != "pending" {
+		s.logger.WithFields(logrus.Fields{
+			"job_id": slot.ID,
+			"status": details.Status,
+		}).Info("Job was picked up elsewhere, dropping it")
+		s.untrackJob(slot.key())
+		slot.cancel()
+		return
+	}
+
+	slot.releases++
+	if slot.releases > maxSlotReleases {
+		s.logger.WithField("job_id", slot.ID).Warn("GitLab never handed the job to FireRunner, giving up")
+		s.jobsMu.Lock()
+		slot.FailureReason = fmt.Sprintf("GitLab did not hand the job to FireRunner in %d tries; check that its tags match the runner", maxSlotReleases)
+		s.jobsMu.Unlock()
+		s.updateJobStatus(slot.key(), "failed")
+		slot.cancel()
+		return
+	}
+
+	delay := claimRetryDelay << slot.releases
+	if delay > slotRetryMaxDelay {
+		delay = slotRetryMaxDelay
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		select {
+		case <-time.After(delay):
+		case <-slot.ctx.Done():
+			return
+		case <-s.shutdownCh:
+			return
+		}
+
+		s.requeueSlot(slot)
+	}()
+}
+
+// requeueSlot puts a released slot back in the queue. While the queue is
+// full the slot is released again, so it counts as another try.
+func (s *Scheduler) requeueSlot(slot *Job) {
+	if s.jobStatus(slot.key()) != "queued" {
+		return
+	}
+	err := s.pushJob(slot)
+	if errors.Is(err, errQueueFull) {
+		s.logger.WithError(err).WithField("job_id", slot.ID).Warn("Failed to requeue job, trying again later")
+		s.releaseSlot(slot)
+	} else if err != nil {
+		s.logger.WithError(err).WithField("job_id", slot.ID).Error("Failed to requeue job")
+	}
+}
+
+// canceledLocally reports whether a job was aborted by FireRunner, e.g.
+// through the admin API, rather than by its forge.
+func (s *Scheduler) canceledLocally(job *Job) bool {
+	s.jobsMu.RLock()
+	defer s.jobsMu.RUnlock()
+	return job.aborted && !job.forgeAborted
+}
+
+func (s *Scheduler) jobStatus(key JobKey) string {
+	s.jobsMu.RLock()
+	defer s.jobsMu.RUnlock()
+	if job, exists := s.jobs[key]; exists {
+		return job.Status
+	}
+	return ""
+}
@@ -0,0 +1,132 @@
+package scheduler
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+	"github.com/ismoilovdevml/firerunner/pkg/gitlab"
+	"github.com/sirupsen/logrus"
+)
+
+// PipelineResolver looks up the pipeline details GitLab job events lack,
+// for priority rules on protected refs and pipeline sources.
+type PipelineResolver interface {
+	PipelineInfo(ctx context.Context, projectID, pipelineID int64) (*gitlab.PipelineInfo, error)
+}
+
+// SetPipelineResolver lets priority rules match protected refs and pipeline
+// sources of GitLab jobs. It must be called before Start.
+func (s *Scheduler) SetPipelineResolver(resolver PipelineResolver) {
+	s.resolver = resolver
+}
+
+// pipelineLookupTimeout bounds the pipeline lookup. It runs on the path
+// that schedules jobs, so a slow GitLab API must not hold up the queue.
+const pipelineLookupTimeout = 5 * time.Second
+
+// resolvePipeline fills in the protected ref and source of a GitLab job
+// when a priority rule needs them. A failed lookup leaves them unset.
+func (s *Scheduler) resolvePipeline(fj *forge.Job) {
+	if s.resolver == nil || fj.PipelineID == 0 || !s.needsPipelineInfo() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pipelineLookupTimeout)
+	defer cancel()
+
+	info, err := s.resolver.PipelineInfo(ctx, fj.ProjectID, fj.PipelineID)
+	if err != nil {
+		logger := s.logger.WithError(err).WithFields(logrus.Fields{
+			"job_id":      fj.ID,
+			"pipeline_id": fj.PipelineID,
+		})
+		logger.Warn("Failed to look up pipeline for priority rules")
+		// Without the pipeline the job may come from a fork, so it gets
+		// the stricter egress policy.
+		if s.specs.ForkEgress() {
+			fj.Fork = true
+			logger.Warn("Applying fork egress policy to job with unknown pipeline")
+		}
+		return
+	}
+	fj.ProtectedRef = info.Protected
+	fj.Source = info.Source
+	fj.Fork = info.Fork
+}
+
+func (s *Scheduler) needsPipelineInfo() bool {
+	if s.specs.ForkEgress() {
+		return true
+	}
+	for _, rule := range s.config.Priority.Rules {
+		if rule.ProtectedRef || len(rule.PipelineSources) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// priority returns the priority of the first rule the job matches.
+func (s *Scheduler) priority(fj forge.Job) int {
+	for _, rule := range s.config.Priority.Rules {
+		if ruleMatches(rule, fj) {
+			return rule.Priority
+		}
+	}
+	return 0
+}
+
+func ruleMatches(rule config.PriorityRule, fj forge.Job) bool {
+	if rule.Tag && !fj.Tag {
+		return false
+	}
+	if rule.ProtectedRef && !fj.ProtectedRef {
+		return false
+	}
+	if len(rule.Refs) > 0 && !matchAny(rule.Refs, fj.Ref) {
+		return false
+	}
+	if len(rule.Projects) > 0 && !matchAny(rule.Projects, fj.Repository) {
+		return false
+	}
+	if len(rule.JobTags) > 0 && !matchAnyOf(rule.JobTags, fj.Tags) {
+		return false
+	}
+	if len(rule.PipelineSources) > 0 && !contains(rule.PipelineSources, fj.Source) {
+		return false
+	}
+	return true
+}
+
+func matchAny(patterns []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAnyOf(patterns, values []string) bool {
+	for _, value := range values {
+		if matchAny(patterns, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package scheduler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+	"github.com/ismoilovdevml/firerunner/pkg/gitlab"
+)
+
+type mockPipelineResolver struct {
+	infos map[int64]*gitlab.PipelineInfo
+	err   error
+	calls int
+}
+
+func (m *mockPipelineResolver) PipelineInfo(ctx context.Context, projectID, pipelineID int64) (*gitlab.PipelineInfo, error) {
+	m.calls++
+	if m.err != nil {
+		return nil, m.err
+	}
+	if info, ok := m.infos[pipelineID]; ok {
+		return info, nil
+	}
+	return &gitlab.PipelineInfo{}, nil
+}
+
+func TestScheduler_Priority(t *testing.T) {
+	cfg := testSchedulerConfig()
+	cfg.Priority.Rules = []config.PriorityRule{
+		{Priority: 100, Refs: []string{"release/*"}, ProtectedRef: true},
+		{Priority: 80, Tag: true},
+		{Priority: 50, JobTags: []string{"priority-high"}},
+		{Priority: 20, Projects: []string{"acme/*"}},
+		{Priority: -10, PipelineSources: []string{"schedule"}},
+	}
+
+	tests := []struct {
+		name     string
+		job      forge.Job
+		expected int
+	}{
+		{name: "protected release", job: forge.Job{Ref: "release/1.2", ProtectedRef: true}, expected: 100},
+		{name: "unprotected release", job: forge.Job{Ref: "release/1.2"}, expected: 0},
+		{name: "tag", job: forge.Job{Ref: "v1.2.0", Tag: true}, expected: 80},
+		{name: "job tag", job: forge.Job{Tags: []string{"firecracker", "priority-high"}}, expected: 50},
+		{name: "project", job: forge.Job{Repository: "acme/app", Source: "schedule"}, expected: 20},
+		{name: "nested project", job: forge.Job{Repository: "acme/backend/api"}, expected: 0},
+		{name: "pipeline source", job: forge.Job{Repository: "other/app", Source: "schedule"}, expected: -10},
+		{name: "no rule", job: forge.Job{Ref: "main"}, expected: 0},
+	}
+
+	s := NewScheduler(cfg, &mockVMManager{}, newMockGitLabService(), testLogger())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if priority := s.priority(tt.job); priority != tt.expected {
+				t.Errorf("Expected priority %d, got %d", tt.expected, priority)
+			}
+		})
+	}
+}
+
+func TestScheduler_ScheduleJobResolvesPipeline(t *testing.T) {
+	cfg := testSchedulerConfig()
+	cfg.Priority.Rules = []config.PriorityRule{{Priority: 100, ProtectedRef: true}}
+	resolver := &mockPipelineResolver{infos: map[int64]*gitlab.PipelineInfo{
+		7: {Ref: "main", Protected: true, Source: "push"},
+	}}
+	s := NewScheduler(cfg, &mockVMManager{}, newMockGitLabService(), testLogger())
+	s.SetPipelineResolver(resolver)
+
+	if err := s.ScheduleJob(&gitlab.JobEvent{BuildID: 1, ProjectID: 2, PipelineID: 8, Ref: "feature"}); err != nil {
+		t.Fatalf("ScheduleJob() failed: %v", err)
+	}
+	if err := s.ScheduleJob(&gitlab.JobEvent{BuildID: 2, ProjectID: 2, PipelineID: 7, Ref: "main"}); err != nil {
+		t.Fatalf("ScheduleJob() failed: %v", err)
+	}
+
+	expectNextJob(t, s, 2)
+	expectNextJob(t, s, 1)
+
+	if info, _ := s.JobInfo(gitlabKey(2)); info.Priority != 100 {
+		t.Errorf("Expected priority 100, got %d", info.Priority)
+	}
+
+	// Without rules on protected refs or sources nothing is looked up.
+	cfg.Priority.Rules = []config.PriorityRule{{Priority: 10, Refs: []string{"main"}}}
+	calls := resolver.calls
+	if err := s.ScheduleJob(&gitlab.JobEvent{BuildID: 3, ProjectID: 2, PipelineID: 7, Ref: "main"}); err != nil {
+		t.Fatalf("ScheduleJob() failed: %v", err)
+	}
+	if resolver.calls != calls {
+		t.Error("Expected no pipeline lookup")
+	}
+	if info, _ := s.JobInfo(gitlabKey(3)); info.Priority != 10 {
+		t.Errorf("Expected priority 10, got %d", info.Priority)
+	}
+}
+
+func TestNewScheduler_AgingDefaultsWithLoadedConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := `
+gitlab:
+  url: https://gitlab.example.com
+  token: test-token
+flintlock:
+  endpoint: localhost:9090
+server:
+  port: 8080
+vm:
+  default_vcpu: 2
+  default_memory_mb: 2048
+scheduler:
+  queue_size: 10
+  worker_count: 1
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	scheduler := NewScheduler(&cfg.Scheduler, &mockVMManager{}, newMockGitLabService(), testLogger())
+	now := time.Now()
+	scheduler.queue.now = func() time.Time { return now }
+
+	waiting := queuedJob(1, 100)
+	waiting.CreatedAt = now.Add(-time.Hour)
+	urgent := queuedJob(2, 200)
+	urgent.Priority = 10
+	urgent.CreatedAt = now
+	scheduler.queue.push(waiting)
+	scheduler.queue.push(urgent)
+
+	// Without aging the urgent job would start first.
+	if job, _ := scheduler.queue.pop(admitAll); job == nil || job.ID != 1 {
+		t.Errorf("Expected the job that waited an hour to have aged up to the urgent one, got %+v", job)
+	}
+}
@@ -0,0 +1,224 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultAgingInterval is used when scheduler.priority.aging_interval is
+// not set, so that low-priority jobs are never starved.
+const defaultAgingInterval = time.Minute
+
+// fairQueue holds queued jobs in one queue per project and hands out the
+// job with the highest priority first. Projects take turns among jobs of
+// equal priority, so a project with hundreds of queued jobs cannot keep the
+// jobs of other projects waiting behind it.
+type fairQueue struct {
+	capacity int
+	size     int
+	// Every agingInterval a job waits raises its priority by one, up to
+	// the highest priority in the queue; zero disables aging.
+	agingInterval time.Duration
+	now           func() time.Time
+
+	queues map[string][]*Job
+	// order lists the projects with queued jobs; next is the project whose
+	// turn it is.
+	order []string
+	next  int
+
+	// changed is closed and replaced whenever a job is pushed or popped or
+	// quota usage may have dropped, waking everybody waiting on the queue.
+	changed chan struct{}
+	closed  bool
+	mu      sync.Mutex
+}
+
+func newFairQueue(capacity int, agingInterval time.Duration) *fairQueue {
+	return &fairQueue{
+		capacity:      capacity,
+		agingInterval: agingInterval,
+		now:           time.Now,
+		queues:        make(map[string][]*Job),
+		changed:       make(chan struct{}),
+	}
+}
+
+// queueKey groups jobs by project. Project IDs are only unique per forge.
+func queueKey(job *Job) string {
+	return fmt.Sprintf("%s/%d", job.Forge, job.ProjectID)
+}
+
+// push appends job to its project's queue. If the queue is full or closed it
+// returns false and a channel that is closed on the next change.
+func (q *fairQueue) push(job *Job) (bool, <-chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed || q.size >= q.capacity {
+		return false, q.changed
+	}
+
+	key := queueKey(job)
+	if len(q.queues[key]) == 0 {
+		q.order = append(q.order, key)
+	}
+	q.queues[key] = append(q.queues[key], job)
+	q.size++
+	q.broadcast()
+	return true, q.changed
+}
+
+// candidate is the job a project would start next.
+type candidate struct {
+	order    int // position of the project in order
+	index    int // position of the job in its project's queue
+	priority int
+}
+
+// pop removes the first job admit accepts. Every project offers its job
+// with the highest priority, the earliest queued on a tie, and the offers
+// are tried by priority, starting with the project whose turn it is on a
+// tie. If no job is accepted it returns nil and a channel that is closed on
+// the next change.
+func (q *fairQueue) pop(admit func(*Job) bool) (*Job, <-chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, q.changed
+	}
+
+	ceiling := q.highestPriority()
+	now := q.now()
+
+	candidates := make([]candidate, 0, len(q.order))
+	for i := 0; i < len(q.order); i++ {
+		idx := (q.next + i) % len(q.order)
+		best := candidate{order: idx, index: -1}
+		for index, job := range q.queues[q.order[idx]] {
+			priority := q.effectivePriority(job, ceiling, now)
+			if best.index < 0 || priority > best.priority {
+				best.index, best.priority = index, priority
+			}
+		}
+		candidates = append(candidates, best)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].priority > candidates[j].priority
+	})
+
+	for _, c := range candidates {
+		key := q.order[c.order]
+		job := q.queues[key][c.index]
+		if !admit(job) {
+			continue
+		}
+
+		jobs := q.queues[key]
+		q.queues[key] = append(jobs[:c.index], jobs[c.index+1:]...)
+		q.size--
+		if len(q.queues[key]) == 0 {
+			delete(q.queues, key)
+			q.order = append(q.order[:c.order], q.order[c.order+1:]...)
+			q.next = c.order
+		} else {
+			q.next = c.order + 1
+		}
+		if len(q.order) > 0 {
+			q.next %= len(q.order)
+		} else {
+			q.next = 0
+		}
+
+		q.broadcast()
+		return job, q.changed
+	}
+
+	return nil, q.changed
+}
+
+// effectivePriority is the job's priority raised by the time it waited,
+// but never beyond ceiling so that waiting alone cannot overtake jobs of
+// the same priority.
+func (q *fairQueue) effectivePriority(job *Job, ceiling int, now time.Time) int {
+	if q.agingInterval <= 0 || job.Priority >= ceiling {
+		return job.Priority
+	}
+	steps := now.Sub(job.CreatedAt) / q.agingInterval
+	if steps <= 0 {
+		return job.Priority
+	}
+	if steps >= time.Duration(ceiling-job.Priority) {
+		return ceiling
+	}
+	return job.Priority + int(steps)
+}
+
+// highestPriority must be called with mu held.
+func (q *fairQueue) highestPriority() int {
+	highest, first := 0, true
+	for _, jobs := range q.queues {
+		for _, job := range jobs {
+			if first || job.Priority > highest {
+				highest, first = job.Priority, false
+			}
+		}
+	}
+	return highest
+}
+
+// wake lets waiters retry, e.g. after a job finished and freed its quota.
+func (q *fairQueue) wake() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.broadcast()
+}
+
+// changes returns a channel that is closed on the next change.
+func (q *fairQueue) changes() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.changed
+}
+
+func (q *fairQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		q.broadcast()
+	}
+}
+
+func (q *fairQueue) isClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+func (q *fairQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// projects returns the number of queued jobs per project.
+func (q *fairQueue) projects() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	counts := make(map[string]int, len(q.queues))
+	for key, jobs := range q.queues {
+		counts[key] = len(jobs)
+	}
+	return counts
+}
+
+// broadcast must be called with mu held.
+func (q *fairQueue) broadcast() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
@@ -0,0 +1,146 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func queuedJob(id, projectID int64) *Job {
+	return &Job{ID: id, Forge: "gitlab", ProjectID: projectID, Status: "queued"}
+}
+
+func admitAll(*Job) bool { return true }
+
+func TestFairQueue_RoundRobin(t *testing.T) {
+	q := newFairQueue(10, 0)
+	for _, job := range []*Job{
+		queuedJob(1, 100), queuedJob(2, 100), queuedJob(3, 100),
+		queuedJob(4, 200),
+		queuedJob(5, 300), queuedJob(6, 300),
+	} {
+		if pushed, _ := q.push(job); !pushed {
+			t.Fatalf("push(%d) failed", job.ID)
+		}
+	}
+
+	var order []int64
+	for {
+		job, _ := q.pop(admitAll)
+		if job == nil {
+			break
+		}
+		order = append(order, job.ID)
+	}
+
+	expected := []int64{1, 4, 5, 2, 6, 3}
+	if len(order) != len(expected) {
+		t.Fatalf("Expected order %v, got %v", expected, order)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("Expected order %v, got %v", expected, order)
+		}
+	}
+	if q.len() != 0 {
+		t.Errorf("Expected empty queue, got %d", q.len())
+	}
+}
+
+func TestFairQueue_SkipsProjectsNotAdmitted(t *testing.T) {
+	q := newFairQueue(10, 0)
+	q.push(queuedJob(1, 100))
+	q.push(queuedJob(2, 100))
+	q.push(queuedJob(3, 200))
+
+	blocked := func(job *Job) bool { return job.ProjectID != 100 }
+	job, _ := q.pop(blocked)
+	if job == nil || job.ID != 3 {
+		t.Fatalf("Expected job 3, got %+v", job)
+	}
+
+	job, changed := q.pop(blocked)
+	if job != nil {
+		t.Fatalf("Expected no admitted job, got %d", job.ID)
+	}
+
+	q.wake()
+	select {
+	case <-changed:
+	default:
+		t.Error("Expected wake to signal waiters")
+	}
+
+	if projects := q.projects(); projects["gitlab/100"] != 2 {
+		t.Errorf("Expected 2 queued jobs of project 100, got %v", projects)
+	}
+}
+
+func TestFairQueue_CapacityAndClose(t *testing.T) {
+	q := newFairQueue(1, 0)
+	if pushed, _ := q.push(queuedJob(1, 100)); !pushed {
+		t.Fatal("push() into an empty queue failed")
+	}
+
+	pushed, changed := q.push(queuedJob(2, 200))
+	if pushed {
+		t.Fatal("push() into a full queue should fail")
+	}
+
+	q.close()
+	select {
+	case <-changed:
+	default:
+		t.Error("Expected close to signal waiters")
+	}
+	if job, _ := q.pop(admitAll); job != nil {
+		t.Errorf("pop() on a closed queue should return nothing, got %d", job.ID)
+	}
+	if pushed, _ := q.push(queuedJob(3, 300)); pushed {
+		t.Error("push() into a closed queue should fail")
+	}
+}
+
+func TestFairQueue_Priority(t *testing.T) {
+	q := newFairQueue(10, 0)
+	nightly := queuedJob(1, 100)
+	hotfix := queuedJob(2, 100)
+	hotfix.Priority = 50
+	release := queuedJob(3, 200)
+	release.Priority = 100
+	q.push(nightly)
+	q.push(hotfix)
+	q.push(release)
+
+	for _, expected := range []int64{3, 2, 1} {
+		job, _ := q.pop(admitAll)
+		if job == nil || job.ID != expected {
+			t.Fatalf("Expected job %d, got %+v", expected, job)
+		}
+	}
+}
+
+func TestFairQueue_Aging(t *testing.T) {
+	now := time.Now()
+	q := newFairQueue(10, time.Minute)
+	q.now = func() time.Time { return now }
+
+	old := queuedJob(1, 100)
+	old.CreatedAt = now.Add(-30 * time.Minute)
+	recent := queuedJob(2, 100)
+	recent.CreatedAt = now.Add(-5 * time.Minute)
+	urgent := queuedJob(3, 200)
+	urgent.Priority = 10
+	urgent.CreatedAt = now
+	q.push(old)
+	q.push(recent)
+	q.push(urgent)
+
+	// The old job aged up to the urgent job's priority and was queued
+	// first; the recent one has not caught up yet.
+	for _, expected := range []int64{1, 3, 2} {
+		job, _ := q.pop(admitAll)
+		if job == nil || job.ID != expected {
+			t.Fatalf("Expected job %d, got %+v", expected, job)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"strings"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+)
+
+// quotaScope is a set of jobs that share a quota, e.g. every job of a group.
+type quotaScope struct {
+	quota config.Quota
+	match func(*Job) bool
+
+	jobs     int
+	vcpu     int64
+	memoryMB int64
+}
+
+// admit reports whether a queued job may start now. It must be called with
+// jobsMu held.
+func (s *Scheduler) admit(job *Job) bool {
+	if job.aborted || job.ctx.Err() != nil {
+		// Nothing will run; a worker only has to drop the job.
+		return true
+	}
+	if s.Paused() {
+		return false
+	}
+	return s.withinQuotas(job)
+}
+
+// withinQuotas reports whether job fits into every quota it falls under next
+// to the jobs holding quota. It must be called with jobsMu held.
+func (s *Scheduler) withinQuotas(job *Job) bool {
+	scopes := s.quotaScopes(job)
+	if len(scopes) == 0 {
+		return true
+	}
+
+	for _, other := range s.jobs {
+		if !other.holdsQuota() {
+			continue
+		}
+		for _, scope := range scopes {
+			if scope.match(other) {
+				scope.jobs++
+				scope.vcpu += other.VCPU
+				scope.memoryMB += other.MemoryMB
+			}
+		}
+	}
+
+	for _, scope := range scopes {
+		if !scope.fits(job) {
+			return false
+		}
+	}
+	return true
+}
+
+// quotaScopes returns every limited scope job belongs to: the hosts, its
+// forge, its project and each group above the project.
+func (s *Scheduler) quotaScopes(job *Job) []*quotaScope {
+	var scopes []*quotaScope
+
+	if scope := s.capacityScope(); scope != nil {
+		scopes = append(scopes, scope)
+	}
+
+	if limit := s.forgeLimits[job.Forge]; limit > 0 {
+		scopes = append(scopes, &quotaScope{
+			quota: config.Quota{MaxConcurrent: limit},
+			match: func(other *Job) bool { return other.Forge == job.Forge },
+		})
+	}
+
+	quotas := s.config.Quotas
+	projectQuota, exists := quotas.Projects[job.Repository]
+	if !exists || job.Repository == "" {
+		projectQuota = quotas.Default
+	}
+	if limited(projectQuota) {
+		key := queueKey(job)
+		scopes = append(scopes, &quotaScope{
+			quota: projectQuota,
+			match: func(other *Job) bool { return queueKey(other) == key },
+		})
+	}
+
+	for group, quota := range quotas.Groups {
+		if !limited(quota) || !inGroup(job.Repository, group) {
+			continue
+		}
+		scopes = append(scopes, &quotaScope{
+			quota: quota,
+			match: func(other *Job) bool { return inGroup(other.Repository, group) },
+		})
+	}
+
+	return scopes
+}
+
+// fits reports whether job fits into the scope's quota next to the jobs
+// already counted. A job larger than the quota still fits while nothing else
+// counts against it, so it cannot be held back forever.
+func (q *quotaScope) fits(job *Job) bool {
+	if q.jobs == 0 {
+		return true
+	}
+	if q.quota.MaxConcurrent > 0 && q.jobs+1 > q.quota.MaxConcurrent {
+		return false
+	}
+	if q.quota.MaxVCPU > 0 && q.vcpu+job.VCPU > q.quota.MaxVCPU {
+		return false
+	}
+	if q.quota.MaxMemoryMB > 0 && q.memoryMB+job.MemoryMB > q.quota.MaxMemoryMB {
+		return false
+	}
+	return true
+}
+
+// holdsQuota reports whether the job counts against quotas: it was handed
+// to a worker and has not ended yet. It must be called with jobsMu held.
+func (j *Job) holdsQuota() bool {
+	return j.admitted && !j.aborted && (j.Status == "queued" || j.Status == "running")
+}
+
+func inGroup(path, group string) bool {
+	group = strings.Trim(group, "/")
+	return group != "" && strings.HasPrefix(path, group+"/")
+}
+
+func limited(q config.Quota) bool {
+	return q.MaxConcurrent > 0 || q.MaxVCPU > 0 || q.MaxMemoryMB > 0
+}
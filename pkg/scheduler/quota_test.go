@@ -0,0 +1,138 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+	"github.com/ismoilovdevml/firerunner/pkg/gitlab"
+)
+
+// tryNextJob returns the next admitted job without waiting for one.
+func tryNextJob(s *Scheduler) *Job {
+	stop := make(chan struct{})
+	close(stop)
+	job, _ := s.nextJob(stop)
+	return job
+}
+
+func scheduleProjectJob(t *testing.T, s *Scheduler, jobID, projectID int64, path string, tags ...string) {
+	t.Helper()
+	event := &gitlab.JobEvent{
+		BuildID:    jobID,
+		ProjectID:  projectID,
+		BuildTags:  tags,
+		Repository: gitlab.Repository{Homepage: "https://gitlab.example.com/" + path},
+	}
+	if err := s.ScheduleJob(event); err != nil {
+		t.Fatalf("ScheduleJob(%d) failed: %v", jobID, err)
+	}
+}
+
+func expectNextJob(t *testing.T, s *Scheduler, expected int64) {
+	t.Helper()
+	job := tryNextJob(s)
+	switch {
+	case expected == 0 && job != nil:
+		t.Fatalf("Expected no admitted job, got %d", job.ID)
+	case expected != 0 && job == nil:
+		t.Fatalf("Expected job %d, got none", expected)
+	case expected != 0 && job.ID != expected:
+		t.Fatalf("Expected job %d, got %d", expected, job.ID)
+	}
+}
+
+func TestScheduler_FairShare(t *testing.T) {
+	s := NewScheduler(testSchedulerConfig(), &mockVMManager{}, newMockGitLabService(), testLogger())
+
+	for id := int64(1); id <= 4; id++ {
+		scheduleProjectJob(t, s, id, 1, "acme/monorepo")
+	}
+	scheduleProjectJob(t, s, 10, 2, "acme/tool")
+
+	expectNextJob(t, s, 1)
+	expectNextJob(t, s, 10)
+	expectNextJob(t, s, 2)
+
+	if job, _ := s.GetJob(gitlabKey(10)); job.Repository != "acme/tool" {
+		t.Errorf("Expected repository acme/tool, got %q", job.Repository)
+	}
+}
+
+func TestScheduler_ProjectQuota(t *testing.T) {
+	cfg := testSchedulerConfig()
+	cfg.Quotas = config.QuotaConfig{
+		Default:  config.Quota{MaxConcurrent: 1},
+		Projects: map[string]config.Quota{"acme/monorepo": {MaxConcurrent: 2}},
+	}
+	s := NewScheduler(cfg, &mockVMManager{}, newMockGitLabService(), testLogger())
+
+	for id := int64(1); id <= 3; id++ {
+		scheduleProjectJob(t, s, id, 1, "acme/monorepo")
+	}
+	scheduleProjectJob(t, s, 10, 2, "acme/tool")
+	scheduleProjectJob(t, s, 11, 2, "acme/tool")
+
+	expectNextJob(t, s, 1)
+	expectNextJob(t, s, 10)
+	expectNextJob(t, s, 2)
+	expectNextJob(t, s, 0)
+
+	s.updateJobStatus(gitlabKey(10), "finished")
+	expectNextJob(t, s, 11)
+
+	s.updateJobStatus(gitlabKey(1), "failed")
+	expectNextJob(t, s, 3)
+}
+
+func TestScheduler_GroupQuota(t *testing.T) {
+	cfg := testSchedulerConfig()
+	cfg.Quotas = config.QuotaConfig{
+		Groups: map[string]config.Quota{"acme": {MaxVCPU: 6}},
+	}
+	s := NewScheduler(cfg, &mockVMManager{}, newMockGitLabService(), testLogger())
+
+	scheduleProjectJob(t, s, 1, 1, "acme/backend/api", "firecracker-4cpu-8gb")
+	scheduleProjectJob(t, s, 2, 2, "acme/web", "firecracker-4cpu-8gb")
+	scheduleProjectJob(t, s, 3, 3, "other/app", "firecracker-4cpu-8gb")
+	scheduleProjectJob(t, s, 4, 2, "acme/web", "firecracker-2cpu-4gb")
+
+	expectNextJob(t, s, 1)
+	expectNextJob(t, s, 3)
+	expectNextJob(t, s, 0)
+
+	if err := s.CancelJob(gitlabKey(1)); err != nil {
+		t.Fatalf("CancelJob() failed: %v", err)
+	}
+	expectNextJob(t, s, 2)
+	expectNextJob(t, s, 4)
+	expectNextJob(t, s, 0)
+}
+
+func TestScheduler_QuotaAdmitsOversizedJob(t *testing.T) {
+	cfg := testSchedulerConfig()
+	cfg.Quotas = config.QuotaConfig{Default: config.Quota{MaxVCPU: 2}}
+	s := NewScheduler(cfg, &mockVMManager{}, newMockGitLabService(), testLogger())
+
+	scheduleProjectJob(t, s, 1, 1, "acme/app", "firecracker-8cpu-16gb")
+	scheduleProjectJob(t, s, 2, 1, "acme/app", "firecracker-2cpu-4gb")
+
+	expectNextJob(t, s, 1)
+	expectNextJob(t, s, 0)
+}
+
+func TestScheduler_ForgeLimit(t *testing.T) {
+	s := NewScheduler(testSchedulerConfig(), &mockVMManager{}, newMockGitLabService(), testLogger())
+	s.SetForge(forge.GitHub, &fakeForge{})
+	s.SetForgeLimit(forge.GitLab, 1)
+
+	scheduleProjectJob(t, s, 1, 1, "acme/app")
+	scheduleProjectJob(t, s, 2, 2, "acme/tool")
+	if err := s.ScheduleForgeJob(forge.GitHub, forge.Job{ID: 3, ProjectID: 3, Repository: "acme/site"}); err != nil {
+		t.Fatalf("ScheduleForgeJob() failed: %v", err)
+	}
+
+	expectNextJob(t, s, 1)
+	expectNextJob(t, s, 3)
+	expectNextJob(t, s, 0)
+}
@@ -0,0 +1,491 @@
+package scheduler
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	gogitlab "github.com/xanzy/go-gitlab"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+	"github.com/ismoilovdevml/firerunner/pkg/firecracker"
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+	"github.com/ismoilovdevml/firerunner/pkg/gitlab"
+)
+
+const (
+	ReconcileAdopt   = "adopt"
+	ReconcileFinish  = "finish"
+	ReconcileDestroy = "destroy"
+	ReconcileRemove  = "unregister"
+	ReconcileSkip    = "skip"
+
+	// Used when scheduler.reconcile_interval or reconcile_grace_period is
+	// not set. Without a grace period a VM that is still booting, and not
+	// tracked by the manager yet, would look like an orphan.
+	defaultReconcileInterval    = 10 * time.Minute
+	defaultReconcileGracePeriod = 5 * time.Minute
+)
+
+type VMInventory interface {
+	ListRemoteVMs(ctx context.Context) ([]*firecracker.MicroVM, error)
+	AdoptVM(ctx context.Context, vmID string) (*firecracker.MicroVM, error)
+	GetVM(vmID string) (*firecracker.MicroVM, error)
+	DestroyVM(ctx context.Context, vmID string) error
+}
+
+type RunnerInventory interface {
+	ListProjectRunners(ctx context.Context, projectID int64) ([]*gogitlab.Runner, error)
+	UnregisterRunner(ctx context.Context, runnerID int64) error
+	GetJob(ctx context.Context, projectID, jobID int64) (*gogitlab.Job, error)
+}
+
+type ReconcileAction struct {
+	Kind       string `json:"kind"`
+	ID         string `json:"id"`
+	Forge      string `json:"forge,omitempty"`
+	JobID      int64  `json:"job_id,omitempty"`
+	ProjectID  int64  `json:"project_id,omitempty"`
+	Repository string `json:"repository,omitempty"`
+	RunnerID   int64  `json:"runner_id,omitempty"`
+	Action     string `json:"action"`
+	Reason     string `json:"reason"`
+	Error      string `json:"error,omitempty"`
+}
+
+type ReconcileReport struct {
+	StartedAt time.Time          `json:"started_at"`
+	DryRun    bool               `json:"dry_run"`
+	Actions   []*ReconcileAction `json:"actions"`
+}
+
+type Reconciler struct {
+	config    *config.SchedulerConfig
+	scheduler *Scheduler
+	vms       VMInventory
+	runners   RunnerInventory
+	logger    *logrus.Logger
+
+	finishing   map[string]bool
+	finishingMu sync.Mutex
+
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
+	wg           sync.WaitGroup
+}
+
+func NewReconciler(
+	cfg *config.SchedulerConfig,
+	scheduler *Scheduler,
+	vms VMInventory,
+	runners RunnerInventory,
+	logger *logrus.Logger,
+) *Reconciler {
+	return &Reconciler{
+		config:     cfg,
+		scheduler:  scheduler,
+		vms:        vms,
+		runners:    runners,
+		logger:     logger,
+		finishing:  make(map[string]bool),
+		shutdownCh: make(chan struct{}),
+	}
+}
+
+func (r *Reconciler) Start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		r.run()
+
+		ticker := time.NewTicker(r.interval())
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.run()
+			case <-r.shutdownCh:
+				return
+			}
+		}
+	}()
+
+	r.logger.WithFields(logrus.Fields{
+		"interval": r.interval(),
+		"dry_run":  r.config.ReconcileDryRun,
+	}).Info("Started orphan reconciler")
+}
+
+func (r *Reconciler) interval() time.Duration {
+	if r.config.ReconcileInterval <= 0 {
+		return defaultReconcileInterval
+	}
+	return r.config.ReconcileInterval
+}
+
+func (r *Reconciler) gracePeriod() time.Duration {
+	if r.config.ReconcileGracePeriod <= 0 {
+		return defaultReconcileGracePeriod
+	}
+	return r.config.ReconcileGracePeriod
+}
+
+func (r *Reconciler) Shutdown(ctx context.Context) error {
+	r.shutdownOnce.Do(func() {
+		close(r.shutdownCh)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		r.logger.Warn("Reconciler shutdown timeout")
+		return ctx.Err()
+	}
+}
+
+func (r *Reconciler) run() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	report, err := r.Reconcile(ctx, r.config.ReconcileDryRun)
+	if err != nil {
+		r.logger.WithError(err).Error("Reconciliation failed")
+		return
+	}
+
+	for _, action := range report.Actions {
+		entry := r.logger.WithFields(logrus.Fields{
+			"kind":       action.Kind,
+			"id":         action.ID,
+			"job_id":     action.JobID,
+			"project_id": action.ProjectID,
+			"action":     action.Action,
+			"reason":     action.Reason,
+			"dry_run":    report.DryRun,
+		})
+		if action.Error != "" {
+			entry.WithField("error", action.Error).Error("Reconcile action failed")
+		} else {
+			entry.Info("Reconcile action")
+		}
+	}
+}
+
+// Reconcile compares the microVMs and runners that exist remotely with the
+// jobs the scheduler knows about. With dryRun set the report is built but no
+// action is taken.
+func (r *Reconciler) Reconcile(ctx context.Context, dryRun bool) (*ReconcileReport, error) {
+	report := &ReconcileReport{
+		StartedAt: time.Now(),
+		DryRun:    dryRun,
+		Actions:   make([]*ReconcileAction, 0),
+	}
+
+	activeVMs, activeRunners := r.activeResources()
+	reservedRunners := make(map[int64]bool)
+	projects := make(map[int64]bool)
+	for _, job := range r.scheduler.ListJobs() {
+		if job.Forge == forge.GitLab {
+			projects[job.ProjectID] = true
+		}
+	}
+
+	remote, err := r.vms.ListRemoteVMs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, vm := range remote {
+		action := r.reconcileVM(ctx, vm, activeVMs, reservedRunners)
+		if action == nil {
+			continue
+		}
+		if action.ProjectID > 0 {
+			projects[action.ProjectID] = true
+		}
+		report.Actions = append(report.Actions, action)
+	}
+
+	for projectID := range projects {
+		runners, err := r.runners.ListProjectRunners(ctx, projectID)
+		if err != nil {
+			r.logger.WithError(err).WithField("project_id", projectID).Warn("Failed to list project runners")
+			continue
+		}
+		for _, runner := range runners {
+			id := int64(runner.ID)
+			if !strings.HasPrefix(runner.Description, "FireRunner-VM-") || activeRunners[id] || reservedRunners[id] {
+				continue
+			}
+			report.Actions = append(report.Actions, &ReconcileAction{
+				Kind:      "runner",
+				ID:        strconv.FormatInt(id, 10),
+				Forge:     forge.GitLab,
+				ProjectID: projectID,
+				Action:    ReconcileRemove,
+				Reason:    "runner is not owned by any active job",
+			})
+		}
+	}
+
+	if !dryRun {
+		for _, action := range report.Actions {
+			if err := r.apply(ctx, action); err != nil {
+				action.Error = err.Error()
+			}
+		}
+	}
+
+	return report, nil
+}
+
+func (r *Reconciler) activeResources() (map[string]*Job, map[int64]bool) {
+	r.scheduler.jobsMu.RLock()
+	defer r.scheduler.jobsMu.RUnlock()
+
+	vms := make(map[string]*Job)
+	runners := make(map[int64]bool)
+	for _, job := range r.scheduler.jobs {
+		if job.Status != "queued" && job.Status != "running" {
+			continue
+		}
+		if job.VMID != "" {
+			vms[job.VMID] = job
+		}
+		// Runner IDs of other forges may clash with GitLab's.
+		if job.RunnerID > 0 && job.Forge == forge.GitLab {
+			runners[job.RunnerID] = true
+		}
+	}
+	return vms, runners
+}
+
+func (r *Reconciler) reconcileVM(
+	ctx context.Context,
+	vm *firecracker.MicroVM,
+	activeVMs map[string]*Job,
+	reservedRunners map[int64]bool,
+) *ReconcileAction {
+	if _, err := r.vms.GetVM(vm.ID); err == nil {
+		return nil
+	}
+
+	r.finishingMu.Lock()
+	finishing := r.finishing[vm.ID]
+	r.finishingMu.Unlock()
+	if finishing {
+		return nil
+	}
+
+	if !vm.CreatedAt.IsZero() && time.Since(vm.CreatedAt) < r.gracePeriod() {
+		return nil
+	}
+
+	action := &ReconcileAction{Kind: "vm", ID: vm.ID}
+
+	jobID, jobErr := strconv.ParseInt(vm.Metadata["firerunner.job_id"], 10, 64)
+	projectID, projectErr := strconv.ParseInt(vm.Metadata["firerunner.project_id"], 10, 64)
+	if jobErr != nil || projectErr != nil {
+		action.Action = ReconcileDestroy
+		action.Reason = "VM has no FireRunner job metadata"
+		return action
+	}
+	action.JobID = jobID
+	action.ProjectID = projectID
+
+	vmForge := vm.Metadata["forge"]
+	if vmForge == "" {
+		vmForge = forge.GitLab
+	}
+	action.Forge = vmForge
+	if job, ok := activeVMs[vm.ID]; ok && job.key() == (JobKey{Forge: vmForge, ID: jobID}) {
+		action.Action = ReconcileAdopt
+		action.Reason = "VM belongs to an in-flight job"
+		return action
+	}
+
+	if vmForge != forge.GitLab {
+		return r.reconcileForgeVM(ctx, vm, action)
+	}
+
+	glJob, err := r.runners.GetJob(ctx, projectID, jobID)
+	if err != nil {
+		action.Action = ReconcileSkip
+		action.Reason = "failed to look up job in GitLab: " + err.Error()
+		return action
+	}
+
+	if glJob.Status == "running" || glJob.Status == "pending" {
+		if glJob.Runner.ID > 0 {
+			reservedRunners[int64(glJob.Runner.ID)] = true
+		}
+		action.Action = ReconcileFinish
+		action.Reason = "job is still " + glJob.Status + " in GitLab"
+		return action
+	}
+
+	action.Action = ReconcileDestroy
+	action.Reason = "job already " + glJob.Status + " in GitLab"
+	return action
+}
+
+// reconcileForgeVM decides about the orphaned VM of a GitHub or Forgejo job.
+// forge.Forge only reports whether a job is pending, so a VM whose job can
+// be looked up is always finished: WaitForJob returns at once for a job that
+// is done, and then the runner is deleted and the VM destroyed. Runners
+// whose VM is gone already are not found, as the forge is never asked for
+// its runners.
+func (r *Reconciler) reconcileForgeVM(ctx context.Context, vm *firecracker.MicroVM, action *ReconcileAction) *ReconcileAction {
+	action.Repository = vm.Metadata["repository"]
+	action.RunnerID, _ = strconv.ParseInt(vm.Metadata["runner_id"], 10, 64)
+	if action.Repository == "" {
+		// Created before FireRunner recorded the repository of a job.
+		action.Action = ReconcileSkip
+		action.Reason = "VM has no repository to look up its " + action.Forge + " job"
+		return action
+	}
+
+	f, err := r.scheduler.forgeFor(&Job{ID: action.JobID, Forge: action.Forge})
+	if err != nil {
+		action.Action = ReconcileSkip
+		action.Reason = err.Error()
+		return action
+	}
+
+	pending, err := f.JobPending(ctx, action.forgeJob())
+	if err != nil {
+		action.Action = ReconcileSkip
+		action.Reason = "failed to look up job in " + action.Forge + ": " + err.Error()
+		return action
+	}
+
+	action.Action = ReconcileFinish
+	if pending {
+		action.Reason = "job is still pending in " + action.Forge
+	} else {
+		action.Reason = "job may still be running in " + action.Forge
+	}
+	return action
+}
+
+func (a *ReconcileAction) forgeJob() forge.Job {
+	return forge.Job{ID: a.JobID, ProjectID: a.ProjectID, Repository: a.Repository}
+}
+
+func (r *Reconciler) apply(ctx context.Context, action *ReconcileAction) error {
+	switch action.Action {
+	case ReconcileAdopt:
+		_, err := r.vms.AdoptVM(ctx, action.ID)
+		return err
+
+	case ReconcileDestroy:
+		if _, err := r.vms.AdoptVM(ctx, action.ID); err != nil {
+			return err
+		}
+		return r.vms.DestroyVM(ctx, action.ID)
+
+	case ReconcileFinish:
+		if _, err := r.vms.AdoptVM(ctx, action.ID); err != nil {
+			return err
+		}
+		r.finishingMu.Lock()
+		r.finishing[action.ID] = true
+		r.finishingMu.Unlock()
+
+		r.wg.Add(1)
+		go r.finish(action)
+		return nil
+
+	case ReconcileRemove:
+		runnerID, err := strconv.ParseInt(action.ID, 10, 64)
+		if err != nil {
+			return err
+		}
+		return r.runners.UnregisterRunner(ctx, runnerID)
+	}
+
+	return nil
+}
+
+func (r *Reconciler) finish(action *ReconcileAction) {
+	defer r.wg.Done()
+	defer func() {
+		r.finishingMu.Lock()
+		delete(r.finishing, action.ID)
+		r.finishingMu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.JobTimeout)
+	go func() {
+		select {
+		case <-r.shutdownCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	defer cancel()
+
+	if action.Forge != "" && action.Forge != forge.GitLab {
+		if !r.finishForgeJob(ctx, action) {
+			return
+		}
+	} else {
+		monitor := gitlab.NewJobMonitor(r.runners, r.logger)
+		job, err := monitor.WaitForJobCompletion(ctx, action.ProjectID, action.JobID, 10*time.Second)
+		if err != nil && ctx.Err() != nil {
+			// Shutting down: leave the VM for the next reconciliation.
+			return
+		}
+
+		if job != nil && job.Runner.ID > 0 {
+			unregisterCtx, unregisterCancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := r.runners.UnregisterRunner(unregisterCtx, int64(job.Runner.ID)); err != nil {
+				r.logger.WithError(err).WithField("runner_id", job.Runner.ID).Error("Failed to unregister runner of orphaned VM")
+			}
+			unregisterCancel()
+		}
+	}
+
+	destroyCtx, destroyCancel := context.WithTimeout(context.Background(), r.config.VMShutdownTimeout)
+	defer destroyCancel()
+	if err := r.vms.DestroyVM(destroyCtx, action.ID); err != nil {
+		r.logger.WithError(err).WithField("vm_id", action.ID).Error("Failed to destroy finished orphaned VM")
+	}
+}
+
+// finishForgeJob waits for the job of an orphaned GitHub or Forgejo VM and
+// deletes its runner. It reports false if the reconciler shut down first.
+func (r *Reconciler) finishForgeJob(ctx context.Context, action *ReconcileAction) bool {
+	f, err := r.scheduler.forgeFor(&Job{ID: action.JobID, Forge: action.Forge})
+	if err != nil {
+		r.logger.WithError(err).WithField("vm_id", action.ID).Error("Failed to finish orphaned VM")
+		return true
+	}
+
+	job := action.forgeJob()
+	if err := f.WaitForJob(ctx, job); err != nil && ctx.Err() != nil {
+		// Shutting down: leave the VM for the next reconciliation.
+		return false
+	}
+
+	if action.RunnerID > 0 {
+		unregisterCtx, unregisterCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := f.UnregisterRunner(unregisterCtx, job, action.RunnerID); err != nil {
+			r.logger.WithError(err).WithField("runner_id", action.RunnerID).Error("Failed to unregister runner of orphaned VM")
+		}
+		unregisterCancel()
+	}
+	return true
+}
@@ -0,0 +1,260 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	gogitlab "github.com/xanzy/go-gitlab"
+
+	"github.com/ismoilovdevml/firerunner/pkg/firecracker"
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+)
+
+type fakeVMInventory struct {
+	mu        sync.Mutex
+	remote    []*firecracker.MicroVM
+	tracked   map[string]bool
+	destroyed []string
+	adopted   []string
+}
+
+func (f *fakeVMInventory) ListRemoteVMs(ctx context.Context) ([]*firecracker.MicroVM, error) {
+	return f.remote, nil
+}
+
+func (f *fakeVMInventory) AdoptVM(ctx context.Context, vmID string) (*firecracker.MicroVM, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.adopted = append(f.adopted, vmID)
+	return &firecracker.MicroVM{ID: vmID}, nil
+}
+
+func (f *fakeVMInventory) GetVM(vmID string) (*firecracker.MicroVM, error) {
+	if f.tracked[vmID] {
+		return &firecracker.MicroVM{ID: vmID}, nil
+	}
+	return nil, fmt.Errorf("VM %s not found", vmID)
+}
+
+func (f *fakeVMInventory) DestroyVM(ctx context.Context, vmID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.destroyed = append(f.destroyed, vmID)
+	return nil
+}
+
+type fakeRunnerInventory struct {
+	mu           sync.Mutex
+	runners      map[int64][]*gogitlab.Runner
+	jobs         map[int64]*gogitlab.Job
+	unregistered []int64
+}
+
+func (f *fakeRunnerInventory) ListProjectRunners(ctx context.Context, projectID int64) ([]*gogitlab.Runner, error) {
+	return f.runners[projectID], nil
+}
+
+func (f *fakeRunnerInventory) UnregisterRunner(ctx context.Context, runnerID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unregistered = append(f.unregistered, runnerID)
+	return nil
+}
+
+func (f *fakeRunnerInventory) GetJob(ctx context.Context, projectID, jobID int64) (*gogitlab.Job, error) {
+	job, ok := f.jobs[jobID]
+	if !ok {
+		return nil, fmt.Errorf("job %d not found", jobID)
+	}
+	return job, nil
+}
+
+func orphanVM(id string, jobID int64, age time.Duration) *firecracker.MicroVM {
+	metadata := map[string]string{}
+	if jobID > 0 {
+		metadata["firerunner.job_id"] = fmt.Sprintf("%d", jobID)
+		metadata["firerunner.project_id"] = "10"
+	}
+	return &firecracker.MicroVM{
+		ID:        id,
+		Namespace: "firerunner",
+		CreatedAt: time.Now().Add(-age),
+		Metadata:  metadata,
+	}
+}
+
+func testReconciler(vms *fakeVMInventory, runners *fakeRunnerInventory) (*Reconciler, *Scheduler) {
+	cfg := testSchedulerConfig()
+	cfg.ReconcileGracePeriod = 5 * time.Minute
+
+	scheduler := NewScheduler(cfg, &mockVMManager{}, newMockGitLabService(), testLogger())
+	return NewReconciler(cfg, scheduler, vms, runners, testLogger()), scheduler
+}
+
+func TestReconciler_Reconcile(t *testing.T) {
+	runningJob := &gogitlab.Job{ID: 4, Status: "running"}
+	runningJob.Runner.ID = 500
+
+	vms := &fakeVMInventory{
+		remote: []*firecracker.MicroVM{
+			orphanVM("vm-tracked", 1, time.Hour),
+			orphanVM("vm-nometa", 0, time.Hour),
+			orphanVM("vm-inflight", 3, time.Hour),
+			orphanVM("vm-running", 4, time.Hour),
+			orphanVM("vm-done", 5, time.Hour),
+			orphanVM("vm-young", 6, time.Minute),
+		},
+		tracked: map[string]bool{"vm-tracked": true},
+	}
+	runners := &fakeRunnerInventory{
+		jobs: map[int64]*gogitlab.Job{
+			4: runningJob,
+			5: {ID: 5, Status: "success"},
+		},
+		runners: map[int64][]*gogitlab.Runner{
+			10: {
+				{ID: 300, Description: "FireRunner-VM-10.0.0.3"},
+				{ID: 400, Description: "FireRunner-VM-10.0.0.4"},
+				{ID: 500, Description: "FireRunner-VM-10.0.0.5"},
+				{ID: 600, Description: "shared-docker-runner"},
+				{ID: 700, Description: "FireRunner-VM-10.0.0.7"},
+			},
+		},
+	}
+
+	reconciler, scheduler := testReconciler(vms, runners)
+	scheduler.trackJob(&Job{ID: 3, Forge: forge.GitLab, ProjectID: 10, Status: "running", VMID: "vm-inflight", RunnerID: 300})
+	// The runner ID of a GitHub job does not reserve the GitLab runner 700.
+	scheduler.trackJob(&Job{ID: 3, Forge: forge.GitHub, ProjectID: 10, Status: "running", RunnerID: 700})
+
+	report, err := reconciler.Reconcile(context.Background(), true)
+	if err != nil {
+		t.Fatalf("Reconcile() failed: %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, action := range report.Actions {
+		got[action.Kind+"/"+action.ID] = action.Action
+	}
+
+	expected := map[string]string{
+		"vm/vm-nometa":   ReconcileDestroy,
+		"vm/vm-inflight": ReconcileAdopt,
+		"vm/vm-running":  ReconcileFinish,
+		"vm/vm-done":     ReconcileDestroy,
+		"runner/400":     ReconcileRemove,
+		"runner/700":     ReconcileRemove,
+	}
+
+	if len(got) != len(expected) {
+		t.Errorf("Expected %d actions, got %d: %v", len(expected), len(got), got)
+	}
+
+	for key, action := range expected {
+		if got[key] != action {
+			t.Errorf("Expected %s to be %q, got %q", key, action, got[key])
+		}
+	}
+
+	if len(vms.destroyed) != 0 || len(vms.adopted) != 0 || len(runners.unregistered) != 0 {
+		t.Error("Dry run must not change anything")
+	}
+}
+
+func TestReconciler_Apply(t *testing.T) {
+	vms := &fakeVMInventory{
+		remote: []*firecracker.MicroVM{
+			orphanVM("vm-done", 5, time.Hour),
+		},
+	}
+	runners := &fakeRunnerInventory{
+		jobs: map[int64]*gogitlab.Job{5: {ID: 5, Status: "failed"}},
+		runners: map[int64][]*gogitlab.Runner{
+			10: {{ID: 400, Description: "FireRunner-VM-10.0.0.4"}},
+		},
+	}
+
+	reconciler, _ := testReconciler(vms, runners)
+
+	report, err := reconciler.Reconcile(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Reconcile() failed: %v", err)
+	}
+
+	for _, action := range report.Actions {
+		if action.Error != "" {
+			t.Errorf("Action %s/%s failed: %s", action.Kind, action.ID, action.Error)
+		}
+	}
+
+	if len(vms.destroyed) != 1 || vms.destroyed[0] != "vm-done" {
+		t.Errorf("Expected vm-done to be destroyed, got %v", vms.destroyed)
+	}
+
+	if len(runners.unregistered) != 1 || runners.unregistered[0] != 400 {
+		t.Errorf("Expected runner 400 to be unregistered, got %v", runners.unregistered)
+	}
+}
+
+func TestReconciler_GracePeriodDefault(t *testing.T) {
+	vms := &fakeVMInventory{
+		remote: []*firecracker.MicroVM{orphanVM("vm-booting", 0, time.Second)},
+	}
+	reconciler, _ := testReconciler(vms, &fakeRunnerInventory{})
+	reconciler.config.ReconcileGracePeriod = 0
+
+	report, err := reconciler.Reconcile(context.Background(), true)
+	if err != nil {
+		t.Fatalf("Reconcile() failed: %v", err)
+	}
+	if len(report.Actions) != 0 {
+		t.Errorf("Expected a VM created a second ago to be left alone, got %v", report.Actions[0])
+	}
+	if reconciler.interval() != defaultReconcileInterval {
+		t.Errorf("Expected the default interval, got %v", reconciler.interval())
+	}
+}
+
+func TestReconciler_FinishesForgeVM(t *testing.T) {
+	orphan := orphanVM("vm-github", 8, time.Hour)
+	orphan.Metadata["forge"] = forge.GitHub
+	orphan.Metadata["repository"] = "acme/app"
+	orphan.Metadata["runner_id"] = "77"
+
+	legacy := orphanVM("vm-legacy", 9, time.Hour)
+	legacy.Metadata["forge"] = forge.GitHub
+
+	vms := &fakeVMInventory{remote: []*firecracker.MicroVM{orphan, legacy}}
+	reconciler, scheduler := testReconciler(vms, &fakeRunnerInventory{})
+	github := &fakeForge{}
+	scheduler.SetForge(forge.GitHub, github)
+
+	report, err := reconciler.Reconcile(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Reconcile() failed: %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, action := range report.Actions {
+		got[action.ID] = action.Action
+	}
+	if got["vm-github"] != ReconcileFinish || got["vm-legacy"] != ReconcileSkip {
+		t.Errorf("Unexpected actions: %v", got)
+	}
+
+	if err := reconciler.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() failed: %v", err)
+	}
+
+	github.mu.Lock()
+	defer github.mu.Unlock()
+	if len(github.unregistered) != 1 || github.unregistered[0] != 77 {
+		t.Errorf("Expected runner 77 to be deleted on GitHub, got %v", github.unregistered)
+	}
+	if len(vms.destroyed) != 1 || vms.destroyed[0] != "vm-github" {
+		t.Errorf("Expected vm-github to be destroyed, got %v", vms.destroyed)
+	}
+}
@@ -2,20 +2,29 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	gogitlab "github.com/xanzy/go-gitlab"
 
+	"github.com/ismoilovdevml/firerunner/pkg/cache"
+	"github.com/ismoilovdevml/firerunner/pkg/cloudinit"
 	"github.com/ismoilovdevml/firerunner/pkg/config"
 	"github.com/ismoilovdevml/firerunner/pkg/firecracker"
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
 	"github.com/ismoilovdevml/firerunner/pkg/gitlab"
+	"github.com/ismoilovdevml/firerunner/pkg/metrics"
+	"github.com/ismoilovdevml/firerunner/pkg/vmspec"
 )
 
 type VMManager interface {
 	CreateVM(ctx context.Context, req *firecracker.VMRequest) (*firecracker.MicroVM, error)
+	AdoptVM(ctx context.Context, vmID string) (*firecracker.MicroVM, error)
 	DestroyVM(ctx context.Context, vmID string) error
 	GetVM(vmID string) (*firecracker.MicroVM, error)
 	ListVMs() []*firecracker.MicroVM
@@ -24,10 +33,16 @@ type VMManager interface {
 	Shutdown(ctx context.Context) error
 }
 
+// CacheManager hands out the persistent caches of a project.
+type CacheManager interface {
+	Acquire(project string, keys []string) (*cache.Lease, error)
+}
+
 type GitLabService interface {
-	RegisterRunner(ctx context.Context, projectID int64, vmIP string, tags []string) (*gitlab.RunnerRegistration, error)
+	RegisterRunner(ctx context.Context, projectID int64, vmID string, tags []string) (*gitlab.RunnerRegistration, error)
 	UnregisterRunner(ctx context.Context, runnerID int64) error
 	GetJob(ctx context.Context, projectID, jobID int64) (*gogitlab.Job, error)
+	CancelJob(ctx context.Context, projectID, jobID int64) error
 	ProcessJobEvent(event *gitlab.JobEvent) error
 	ProcessPipelineEvent(event *gitlab.PipelineEvent) error
 }
@@ -36,36 +51,144 @@ type Scheduler struct {
 	config    *config.SchedulerConfig
 	vmManager VMManager
 	gitlabSvc GitLabService
+	store     JobStore
+	claimer   JobClaimer
+	resolver  PipelineResolver
+	specs     *vmspec.Parser
+	caches    CacheManager
+	forges    map[string]forge.Forge
 	logger    *logrus.Logger
 
-	jobQueue chan *Job
-	workers  []*Worker
-	jobs     map[int64]*Job
-	jobsMu   sync.RWMutex
+	// forgeLimits caps the running jobs of a forge.
+	forgeLimits map[string]int
+	// hostVCPU and hostMemoryMB are the capacity of the Flintlock hosts.
+	hostVCPU     int64
+	hostMemoryMB int64
+
+	// queue is guarded by jobsMu while popping, since admitting a job looks
+	// at every tracked job; jobsMu is always taken before the queue's lock.
+	queue   *fairQueue
+	workers []*Worker
+	jobs    map[JobKey]*Job
+	jobsMu  sync.RWMutex
+
+	paused  bool
+	pauseMu sync.Mutex
 
 	shutdownCh chan struct{}
 	wg         sync.WaitGroup
+
+	// destroyRetryDelay is the first delay before destroying a VM is
+	// retried; see reclaimCaches.
+	destroyRetryDelay time.Duration
 }
 
+// destroyRetryMaxDelay caps the delay between retries of destroying a VM.
+const destroyRetryMaxDelay = 10 * time.Minute
+
 type Job struct {
 	ID         int64
+	Forge      string
+	Repository string // "namespace/name" path of the project
 	ProjectID  int64
 	PipelineID int64
 	Status     string
+	Priority   int
 	Tags       []string
 	VCPU       int64
 	MemoryMB   int64
-	CreatedAt  time.Time
-	StartedAt  time.Time
-	FinishedAt time.Time
+	DiskGB     int64
+	Arch       string
+	// Image names the catalog image of the job; KernelImage and
+	// RootFSImage are its references, empty for the configured defaults.
+	Image       string
+	KernelImage string
+	RootFSImage string
+	Caches      []string // keys of the project caches to mount
+	Egress      string   // egress policy of the job's VM
+	CreatedAt   time.Time
+	StartedAt   time.Time
+	FinishedAt  time.Time
+	// FailureReason tells why a job failed without starting, e.g. because
+	// of an invalid VM tag.
+	FailureReason string
 
 	VMID     string
 	VM       *firecracker.MicroVM
-	RunnerID int64 // GitLab runner ID for cleanup
+	RunnerID int64  // GitLab runner ID for cleanup
+	JobToken string // token of a job FireRunner claimed itself
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	err     error
+	aborted bool
+	// forgeAborted is set when the job was aborted because its forge ended
+	// it, so the forge does not have to be told.
+	forgeAborted bool
+	// cacheLease holds the caches mounted by the job's VM.
+	cacheLease *cache.Lease
+	// admitted is set once a worker took the job off the queue, from when
+	// on it counts against quotas.
+	admitted bool
+	// releases counts how often a native mode slot went back to the queue
+	// without GitLab handing its job to FireRunner.
+	releases int
+}
+
+// JobKey identifies a job. Job IDs are only unique within a forge, so a
+// GitHub job can have the same ID as a GitLab one.
+type JobKey struct {
+	Forge string
+	ID    int64
+}
+
+func (k JobKey) String() string {
+	return fmt.Sprintf("%s/%d", k.Forge, k.ID)
+}
 
-	ctx    context.Context
-	cancel context.CancelFunc
-	err    error
+func (j *Job) key() JobKey {
+	return JobKey{Forge: j.Forge, ID: j.ID}
+}
+
+var (
+	ErrJobNotFound  = errors.New("job not found")
+	ErrJobNotActive = errors.New("job is not queued or running")
+)
+
+// JobInfo is the JSON representation of a job exposed by the admin API.
+type JobInfo struct {
+	ID            int64     `json:"id"`
+	Forge         string    `json:"forge"`
+	Repository    string    `json:"repository,omitempty"`
+	ProjectID     int64     `json:"project_id"`
+	PipelineID    int64     `json:"pipeline_id"`
+	Status        string    `json:"status"`
+	Priority      int       `json:"priority"`
+	Tags          []string  `json:"tags"`
+	VCPU          int64     `json:"vcpu"`
+	MemoryMB      int64     `json:"memory_mb"`
+	DiskGB        int64     `json:"disk_gb,omitempty"`
+	Arch          string    `json:"arch,omitempty"`
+	Image         string    `json:"image,omitempty"`
+	Caches        []string  `json:"caches,omitempty"`
+	Egress        string    `json:"egress,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	VMID          string    `json:"vm_id,omitempty"`
+	RunnerID      int64     `json:"runner_id,omitempty"`
+}
+
+type Stats struct {
+	TotalJobs       int            `json:"total_jobs"`
+	QueueSize       int            `json:"queue_size"`
+	QueueCapacity   int            `json:"queue_capacity"`
+	Workers         int            `json:"workers"`
+	Paused          bool           `json:"paused"`
+	ByStatus        map[string]int `json:"by_status"`
+	QueuedByProject map[string]int `json:"queued_by_project"`
+	Capacity        CapacityStats  `json:"capacity"`
 }
 
 type Worker struct {
@@ -81,17 +204,62 @@ func NewScheduler(
 	gitlabSvc GitLabService,
 	logger *logrus.Logger,
 ) *Scheduler {
+	agingInterval := cfg.Priority.AgingInterval
+	if agingInterval <= 0 {
+		agingInterval = defaultAgingInterval
+	}
+
 	return &Scheduler{
-		config:     cfg,
-		vmManager:  vmManager,
-		gitlabSvc:  gitlabSvc,
-		logger:     logger,
-		jobQueue:   make(chan *Job, cfg.QueueSize),
-		jobs:       make(map[int64]*Job),
-		shutdownCh: make(chan struct{}),
+		config:      cfg,
+		vmManager:   vmManager,
+		gitlabSvc:   gitlabSvc,
+		forges:      map[string]forge.Forge{forge.GitLab: gitlab.NewForge(gitlabSvc, logger)},
+		forgeLimits: make(map[string]int),
+		specs:       vmspec.NewParser(nil),
+		logger:      logger,
+		queue:       newFairQueue(cfg.QueueSize, agingInterval),
+		jobs:        make(map[JobKey]*Job),
+		shutdownCh:  make(chan struct{}),
+
+		destroyRetryDelay: 30 * time.Second,
 	}
 }
 
+func (s *Scheduler) SetJobStore(store JobStore) {
+	s.store = store
+}
+
+// SetJobClaimer switches the scheduler to native runner mode: workers claim
+// jobs from GitLab themselves instead of registering a runner per job. It
+// must be called before Start.
+func (s *Scheduler) SetJobClaimer(claimer JobClaimer) {
+	s.claimer = claimer
+}
+
+// SetForge adds a forge besides GitLab whose jobs can be scheduled with
+// ScheduleForgeJob. It must be called before Start.
+func (s *Scheduler) SetForge(name string, f forge.Forge) {
+	s.forges[name] = f
+}
+
+// SetForgeLimit caps how many jobs of a forge run at the same time. A limit
+// of zero removes the cap. It must be called before Start.
+func (s *Scheduler) SetForgeLimit(name string, maxConcurrent int) {
+	s.forgeLimits[name] = maxConcurrent
+}
+
+// SetSpecParser sets how VM sizes, images and limits are read from job
+// tags. It must be called before Start.
+func (s *Scheduler) SetSpecParser(parser *vmspec.Parser) {
+	s.specs = parser
+}
+
+// SetCacheManager mounts the caches jobs ask for into their VMs. It must be
+// called before Start.
+func (s *Scheduler) SetCacheManager(caches CacheManager) {
+	s.caches = caches
+}
+
 func (s *Scheduler) Start() error {
 	s.logger.WithField("workers", s.config.WorkerCount).Info("Starting scheduler")
 
@@ -112,6 +280,10 @@ func (s *Scheduler) Start() error {
 	s.wg.Add(1)
 	go s.cleanupRoutine()
 
+	if err := s.recoverJobs(); err != nil {
+		return fmt.Errorf("failed to recover persisted jobs: %w", err)
+	}
+
 	s.logger.Info("Scheduler started successfully")
 	return nil
 }
@@ -124,39 +296,317 @@ func (s *Scheduler) ScheduleJob(event *gitlab.JobEvent) error {
 		"name":       event.BuildName,
 	}).Info("Scheduling new job")
 
-	vcpu, memoryMB := gitlab.ParseVMRequirements(event.BuildTags)
-
-	ctx, cancel := context.WithTimeout(context.Background(), s.config.JobTimeout)
-	job := &Job{
+	fj := forge.Job{
 		ID:         event.BuildID,
 		ProjectID:  event.ProjectID,
 		PipelineID: event.PipelineID,
-		Status:     "queued",
+		Repository: gitlab.ProjectPath(event.Repository.Homepage),
 		Tags:       event.BuildTags,
-		VCPU:       vcpu,
-		MemoryMB:   memoryMB,
+		Ref:        event.Ref,
+		Tag:        event.Tag,
+	}
+	s.resolvePipeline(&fj)
+
+	return s.schedule(forge.GitLab, fj)
+}
+
+// ScheduleForgeJob schedules a job of a forge added with SetForge.
+func (s *Scheduler) ScheduleForgeJob(forgeName string, fj forge.Job) error {
+	if _, exists := s.forges[forgeName]; !exists {
+		return fmt.Errorf("forge %s is not configured", forgeName)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"forge":      forgeName,
+		"job_id":     fj.ID,
+		"project_id": fj.ProjectID,
+		"repository": fj.Repository,
+	}).Info("Scheduling new job")
+
+	return s.schedule(forgeName, fj)
+}
+
+func (s *Scheduler) schedule(forgeName string, fj forge.Job) error {
+	spec, specErr := s.vmSpec(fj.Tags, fj.Repository)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.JobTimeout)
+	job := &Job{
+		ID:         fj.ID,
+		Forge:      forgeName,
+		Repository: fj.Repository,
+		ProjectID:  fj.ProjectID,
+		PipelineID: fj.PipelineID,
+		Status:     "queued",
+		Priority:   s.priority(fj),
+		Tags:       fj.Tags,
+		Egress:     s.specs.Egress(fj.Repository, fj.Fork),
 		CreatedAt:  time.Now(),
 		ctx:        ctx,
 		cancel:     cancel,
 	}
+	job.setSpec(spec)
+	if specErr != nil {
+		job.Status = "failed"
+		job.FinishedAt = job.CreatedAt
+		job.FailureReason = specErr.Error()
+	}
 
-	s.trackJob(job)
+	if !s.trackNewJob(job) {
+		cancel()
+		metrics.DuplicateEventsSuppressed.WithLabelValues("build_id").Inc()
+		s.logger.WithField("job_id", fj.ID).Info("Job is already scheduled, ignoring duplicate event")
+		return nil
+	}
 
-	select {
-	case s.jobQueue <- job:
-		s.logger.WithField("job_id", job.ID).Info("Job queued successfully")
+	if specErr != nil {
+		s.rejectJob(job, specErr)
 		return nil
-	case <-time.After(5 * time.Second):
+	}
+
+	return s.enqueue(job)
+}
+
+// vmSpec returns the VM the tags of a job ask for, checked against the
+// limits of its project.
+func (s *Scheduler) vmSpec(tags []string, repository string) (vmspec.Spec, error) {
+	spec, err := s.specs.Parse(tags, repository)
+	if err != nil {
+		return spec, err
+	}
+	return spec, s.specs.Check(spec, repository)
+}
+
+// rejectJob records a job that fails without starting because its tags ask
+// for a VM FireRunner cannot or may not create.
+func (s *Scheduler) rejectJob(job *Job, err error) {
+	job.cancel()
+
+	reason := "invalid_tag"
+	if errors.Is(err, vmspec.ErrExceedsLimits) {
+		reason = "exceeds_limits"
+	}
+	metrics.JobsRejected.WithLabelValues(reason).Inc()
+	metrics.JobsTotal.WithLabelValues("failed", strconv.FormatInt(job.ProjectID, 10)).Inc()
+
+	s.logger.WithError(err).WithFields(logrus.Fields{
+		"job_id":     job.ID,
+		"repository": job.Repository,
+		"tags":       job.Tags,
+	}).Warn("Rejected job with unsupported VM tags")
+
+	if job.Forge != forge.GitLab || s.claimer != nil {
+		// A claimed job is failed with the reason in its log instead.
+		return
+	}
+
+	// No runner will ever pick the job up, so cancel it rather than leave it
+	// pending until GitLab times it out.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := s.gitlabSvc.CancelJob(ctx, job.ProjectID, job.ID); err != nil {
+		s.logger.WithError(err).WithField("job_id", job.ID).Error("Failed to cancel rejected job in GitLab")
+	}
+}
+
+// enqueue queues a job and stops tracking it if the queue stays full.
+func (s *Scheduler) enqueue(job *Job) error {
+	err := s.pushJob(job)
+	if errors.Is(err, errQueueFull) {
+		job.cancel()
+		s.untrackJob(job.key())
+	}
+	return err
+}
+
+var errQueueFull = errors.New("job queue is full")
+
+// pushJob queues a job, waiting a few seconds for room if the queue is full.
+func (s *Scheduler) pushJob(job *Job) error {
+	s.jobsMu.Lock()
+	job.admitted = false
+	s.jobsMu.Unlock()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		pushed, changed := s.queue.push(job)
+		if pushed {
+			metrics.QueueDepth.Set(float64(s.queue.len()))
+			s.logger.WithFields(logrus.Fields{
+				"job_id":   job.ID,
+				"priority": job.Priority,
+			}).Info("Job queued successfully")
+			return nil
+		}
+		if s.queue.isClosed() {
+			job.cancel()
+			return fmt.Errorf("scheduler is shutting down, cannot schedule job %d", job.ID)
+		}
+
+		select {
+		case <-changed:
+		case <-timeout:
+			return fmt.Errorf("%w, cannot schedule job %d", errQueueFull, job.ID)
+		}
+	}
+}
+
+// nextJob blocks until a queued job may start and hands it out marked as
+// admitted. It reports false once the scheduler or worker shuts down.
+func (s *Scheduler) nextJob(stop <-chan struct{}) (*Job, bool) {
+	for {
+		s.jobsMu.Lock()
+		job, changed := s.queue.pop(s.admit)
+		if job != nil {
+			job.admitted = true
+		}
+		s.jobsMu.Unlock()
+
+		if job != nil {
+			metrics.QueueDepth.Set(float64(s.queue.len()))
+			return job, true
+		}
+		if s.queue.isClosed() {
+			return nil, false
+		}
+
+		select {
+		case <-changed:
+		case <-stop:
+			return nil, false
+		case <-s.shutdownCh:
+			return nil, false
+		}
+	}
+}
+
+// LoadPersistedJobs tracks every job from the store without queueing or
+// resuming any of them.
+func (s *Scheduler) LoadPersistedJobs() ([]*Job, error) {
+	if s.store == nil {
+		return nil, nil
+	}
+
+	records, err := s.store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(records))
+	for _, record := range records {
+		job := jobFromRecord(record)
+		job.ctx, job.cancel = context.WithDeadline(context.Background(), record.CreatedAt.Add(s.config.JobTimeout))
+		s.trackJob(job)
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+func (s *Scheduler) recoverJobs() error {
+	jobs, err := s.LoadPersistedJobs()
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		logger := s.logger.WithFields(logrus.Fields{
+			"job_id": job.ID,
+			"status": job.Status,
+			"vm_id":  job.VMID,
+		})
+
+		switch job.Status {
+		case "queued":
+			logger.Info("Replaying queued job")
+			if err := s.enqueue(job); err != nil {
+				logger.WithError(err).Error("Failed to replay queued job")
+			}
+		case "running":
+			logger.Info("Resuming in-flight job")
+			s.recoverInFlightJob(job)
+		}
+	}
+
+	return nil
+}
+
+func (s *Scheduler) recoverInFlightJob(job *Job) {
+	w := &Worker{
+		scheduler: s,
+		logger:    s.logger.WithField("job_id", job.ID),
+	}
+
+	if job.VMID != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.VMStartTimeout)
+		vm, err := s.vmManager.AdoptVM(ctx, job.VMID)
 		cancel()
-		s.untrackJob(job.ID)
-		return fmt.Errorf("job queue is full, cannot schedule job %d", job.ID)
+		if err != nil {
+			w.logger.WithError(err).Warn("VM of in-flight job is gone")
+			job.VMID = ""
+		} else {
+			job.VM = vm
+			// The VM still mounts its caches.
+			s.acquireCaches(job)
+		}
 	}
+
+	if job.VM == nil && job.JobToken != "" {
+		// A claimed job is running in GitLab but its VM is gone, so nothing
+		// will ever finish it.
+		w.failClaimedJob(job, "runner_system_failure", "FireRunner lost the microVM of this job while restarting")
+		s.updateJobStatus(job.key(), "failed")
+		return
+	}
+
+	if job.VM == nil && job.RunnerID != 0 {
+		// The runner is gone with its VM, but the forge may already have
+		// handed the job to it. Only a job that still waits for a runner
+		// can go through the queue again; anything else would start a
+		// second VM for it.
+		w.cleanupVM(job)
+		job.RunnerID = 0
+		if pending, err := s.jobPending(job); !pending {
+			if err != nil {
+				w.logger.WithError(err).Warn("Could not check whether the recovered job is still pending")
+			}
+			w.logger.Warn("Runner of in-flight job was lost with its VM")
+			job.err = fmt.Errorf("runner lost with its VM while FireRunner restarted")
+			s.updateJobStatus(job.key(), "failed")
+			return
+		}
+	}
+
+	if job.VM == nil || (job.RunnerID == 0 && job.JobToken == "") {
+		// The job never reached a runner, so it is still pending in its
+		// forge and can simply go through the queue again.
+		w.cleanupVM(job)
+		job.VM, job.VMID, job.RunnerID = nil, "", 0
+		s.updateJobStatus(job.key(), "queued")
+		if err := s.enqueue(job); err != nil {
+			w.logger.WithError(err).Error("Failed to requeue recovered job")
+		}
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		w.waitForJobCompletion(job)
+		w.cleanupVM(job)
+
+		if job.err != nil {
+			s.updateJobStatus(job.key(), "failed")
+		} else {
+			s.updateJobStatus(job.key(), "finished")
+		}
+	}()
 }
 
-func (s *Scheduler) GetJob(jobID int64) (*Job, bool) {
+func (s *Scheduler) GetJob(key JobKey) (*Job, bool) {
 	s.jobsMu.RLock()
 	defer s.jobsMu.RUnlock()
-	job, exists := s.jobs[jobID]
+	job, exists := s.jobs[key]
 	return job, exists
 }
 
@@ -171,32 +621,200 @@ func (s *Scheduler) ListJobs() []*Job {
 	return jobs
 }
 
-func (s *Scheduler) GetStats() map[string]interface{} {
+func (s *Scheduler) GetStats() Stats {
 	s.jobsMu.RLock()
 	defer s.jobsMu.RUnlock()
 
-	stats := map[string]interface{}{
-		"total_jobs":     len(s.jobs),
-		"queue_size":     len(s.jobQueue),
-		"queue_capacity": s.config.QueueSize,
-		"workers":        s.config.WorkerCount,
-		"by_status":      make(map[string]int),
+	stats := Stats{
+		TotalJobs:       len(s.jobs),
+		QueueSize:       s.queue.len(),
+		QueueCapacity:   s.config.QueueSize,
+		Workers:         s.config.WorkerCount,
+		Paused:          s.Paused(),
+		ByStatus:        make(map[string]int),
+		QueuedByProject: s.queue.projects(),
+		Capacity:        s.capacityStats(),
 	}
 
-	byStatus := stats["by_status"].(map[string]int)
 	for _, job := range s.jobs {
-		byStatus[job.Status]++
+		stats.ByStatus[job.Status]++
 	}
 
 	return stats
 }
 
+// JobInfos returns a snapshot of every tracked job.
+func (s *Scheduler) JobInfos() []JobInfo {
+	s.jobsMu.RLock()
+	defer s.jobsMu.RUnlock()
+
+	infos := make([]JobInfo, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		infos = append(infos, job.info())
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreatedAt.Before(infos[j].CreatedAt)
+	})
+	return infos
+}
+
+func (s *Scheduler) JobInfo(key JobKey) (JobInfo, bool) {
+	s.jobsMu.RLock()
+	defer s.jobsMu.RUnlock()
+
+	job, exists := s.jobs[key]
+	if !exists {
+		return JobInfo{}, false
+	}
+	return job.info(), true
+}
+
+// JobForVM returns the queued or running job a VM was created for.
+func (s *Scheduler) JobForVM(vmID string) (JobKey, bool) {
+	s.jobsMu.RLock()
+	defer s.jobsMu.RUnlock()
+	for key, job := range s.jobs {
+		if job.VMID == vmID && !job.aborted && (job.Status == "queued" || job.Status == "running") {
+			return key, true
+		}
+	}
+	return JobKey{}, false
+}
+
+// CancelJob stops a queued or running job and cancels it on its forge. Its
+// worker unregisters the runner and destroys the VM; the job keeps the
+// canceled status. A job FireRunner claimed itself is reported as failed
+// through its job token by the worker instead.
+func (s *Scheduler) CancelJob(key JobKey) error {
+	job, err := s.abortJob(key, "canceled", false)
+	if err != nil {
+		return err
+	}
+	if s.claimer != nil && job.JobToken != "" {
+		return nil
+	}
+
+	f, err := s.forgeFor(job)
+	if err != nil {
+		s.logger.WithError(err).WithField("job_id", job.ID).Error("Failed to cancel job on its forge")
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := f.CancelJob(ctx, job.forgeJob()); err != nil {
+		entry := s.logger.WithError(err).WithFields(logrus.Fields{
+			"forge":  key.Forge,
+			"job_id": key.ID,
+		})
+		if errors.Is(err, forge.ErrCancelUnsupported) {
+			entry.Info("Job is only canceled in FireRunner")
+		} else {
+			entry.Error("Failed to cancel job on its forge")
+		}
+	}
+	return nil
+}
+
+// AbortJob ends a queued or running job with the given final status because
+// its forge ended it, e.g. GitLab canceled it. A queued job is skipped when a
+// worker picks it up; a running job's VM creation or monitoring is
+// interrupted right away.
+func (s *Scheduler) AbortJob(key JobKey, status string) error {
+	_, err := s.abortJob(key, status, true)
+	return err
+}
+
+func (s *Scheduler) abortJob(key JobKey, status string, byForge bool) (*Job, error) {
+	s.jobsMu.Lock()
+	job, exists := s.jobs[key]
+	if !exists {
+		s.jobsMu.Unlock()
+		return nil, ErrJobNotFound
+	}
+	if job.aborted || (job.Status != "queued" && job.Status != "running") {
+		s.jobsMu.Unlock()
+		return nil, fmt.Errorf("%w: job %s is %s", ErrJobNotActive, key, job.Status)
+	}
+
+	job.aborted = true
+	job.forgeAborted = byForge
+	job.Status = status
+	job.FinishedAt = time.Now()
+	metrics.JobsTotal.WithLabelValues(status, strconv.FormatInt(job.ProjectID, 10)).Inc()
+	s.saveJob(job)
+	s.queue.wake()
+	s.jobsMu.Unlock()
+
+	s.logger.WithFields(logrus.Fields{
+		"forge":  key.Forge,
+		"job_id": key.ID,
+		"status": status,
+	}).Info("Job aborted")
+
+	if job.cancel != nil {
+		job.cancel()
+	}
+	return job, nil
+}
+
+// AbortPipeline aborts every active job of a pipeline of the given forge and
+// returns how many were aborted.
+func (s *Scheduler) AbortPipeline(forgeName string, projectID, pipelineID int64, status string) int {
+	s.jobsMu.RLock()
+	var keys []JobKey
+	for key, job := range s.jobs {
+		if key.Forge == forgeName && job.ProjectID == projectID && job.PipelineID == pipelineID {
+			keys = append(keys, key)
+		}
+	}
+	s.jobsMu.RUnlock()
+
+	aborted := 0
+	for _, key := range keys {
+		if err := s.AbortJob(key, status); err == nil {
+			aborted++
+		}
+	}
+	return aborted
+}
+
+// Pause stops workers from starting new jobs. Jobs already running are not
+// affected and queued jobs stay queued until Resume is called.
+func (s *Scheduler) Pause() {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+
+	if !s.paused {
+		s.paused = true
+		s.logger.Info("Scheduling paused")
+	}
+}
+
+func (s *Scheduler) Resume() {
+	s.pauseMu.Lock()
+	resumed := s.paused
+	s.paused = false
+	s.pauseMu.Unlock()
+
+	if resumed {
+		s.queue.wake()
+		s.logger.Info("Scheduling resumed")
+	}
+}
+
+func (s *Scheduler) Paused() bool {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	return s.paused
+}
+
 func (s *Scheduler) Shutdown(ctx context.Context) error {
 	s.logger.Info("Shutting down scheduler")
 
 	close(s.shutdownCh)
 
-	close(s.jobQueue)
+	s.queue.close()
 
 	done := make(chan struct{})
 	go func() {
@@ -226,25 +844,208 @@ func (s *Scheduler) Shutdown(ctx context.Context) error {
 func (s *Scheduler) trackJob(job *Job) {
 	s.jobsMu.Lock()
 	defer s.jobsMu.Unlock()
-	s.jobs[job.ID] = job
+	s.jobs[job.key()] = job
+	s.saveJob(job)
 }
 
-func (s *Scheduler) untrackJob(jobID int64) {
+// trackNewJob tracks job unless a job with the same forge and ID is already
+// tracked and reports whether it did.
+func (s *Scheduler) trackNewJob(job *Job) bool {
 	s.jobsMu.Lock()
 	defer s.jobsMu.Unlock()
-	delete(s.jobs, jobID)
+	if _, exists := s.jobs[job.key()]; exists {
+		return false
+	}
+	s.jobs[job.key()] = job
+	s.saveJob(job)
+	return true
+}
+
+func (s *Scheduler) untrackJob(key JobKey) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	delete(s.jobs, key)
+	s.deleteJob(key)
+	s.queue.wake()
+}
+
+func (s *Scheduler) persistJob(job *Job) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	s.saveJob(job)
+}
+
+// saveJob and deleteJob hand a job's record to the store, which only keeps
+// it in memory and writes the file later in the background. They must be
+// called with jobsMu held: the record is read from fields jobsMu guards,
+// and the store has to see changes in the order the scheduler made them.
+// They never wait for the disk; a failed write surfaces as the error of a
+// later call.
+func (s *Scheduler) saveJob(job *Job) {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.Save(job.record()); err != nil {
+		s.logger.WithError(err).WithField("job_id", job.ID).Error("Failed to persist job")
+	}
+}
+
+func (s *Scheduler) deleteJob(key JobKey) {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.Delete(key); err != nil {
+		s.logger.WithError(err).WithField("job_id", key.ID).Error("Failed to delete persisted job")
+	}
+}
+
+func (j *Job) record() *JobRecord {
+	return &JobRecord{
+		ID:            j.ID,
+		Forge:         j.Forge,
+		Repository:    j.Repository,
+		ProjectID:     j.ProjectID,
+		PipelineID:    j.PipelineID,
+		Status:        j.Status,
+		Priority:      j.Priority,
+		Tags:          j.Tags,
+		VCPU:          j.VCPU,
+		MemoryMB:      j.MemoryMB,
+		DiskGB:        j.DiskGB,
+		Arch:          j.Arch,
+		Image:         j.Image,
+		KernelImage:   j.KernelImage,
+		RootFSImage:   j.RootFSImage,
+		Caches:        j.Caches,
+		Egress:        j.Egress,
+		CreatedAt:     j.CreatedAt,
+		StartedAt:     j.StartedAt,
+		FinishedAt:    j.FinishedAt,
+		FailureReason: j.FailureReason,
+		VMID:          j.VMID,
+		RunnerID:      j.RunnerID,
+		JobToken:      j.JobToken,
+	}
+}
+
+func (j *Job) info() JobInfo {
+	return JobInfo{
+		ID:            j.ID,
+		Forge:         j.Forge,
+		Repository:    j.Repository,
+		ProjectID:     j.ProjectID,
+		PipelineID:    j.PipelineID,
+		Status:        j.Status,
+		Priority:      j.Priority,
+		Tags:          j.Tags,
+		VCPU:          j.VCPU,
+		MemoryMB:      j.MemoryMB,
+		DiskGB:        j.DiskGB,
+		Arch:          j.Arch,
+		Image:         j.Image,
+		Caches:        j.Caches,
+		Egress:        j.Egress,
+		CreatedAt:     j.CreatedAt,
+		StartedAt:     j.StartedAt,
+		FinishedAt:    j.FinishedAt,
+		FailureReason: j.FailureReason,
+		VMID:          j.VMID,
+		RunnerID:      j.RunnerID,
+	}
+}
+
+func jobFromRecord(r *JobRecord) *Job {
+	forgeName := r.Forge
+	if forgeName == "" {
+		// Stored before FireRunner supported more than one forge.
+		forgeName = forge.GitLab
+	}
+
+	return &Job{
+		ID:            r.ID,
+		Forge:         forgeName,
+		Repository:    r.Repository,
+		ProjectID:     r.ProjectID,
+		PipelineID:    r.PipelineID,
+		Status:        r.Status,
+		Priority:      r.Priority,
+		Tags:          r.Tags,
+		VCPU:          r.VCPU,
+		MemoryMB:      r.MemoryMB,
+		DiskGB:        r.DiskGB,
+		Arch:          r.Arch,
+		Image:         r.Image,
+		KernelImage:   r.KernelImage,
+		RootFSImage:   r.RootFSImage,
+		Caches:        r.Caches,
+		Egress:        r.Egress,
+		CreatedAt:     r.CreatedAt,
+		StartedAt:     r.StartedAt,
+		FinishedAt:    r.FinishedAt,
+		FailureReason: r.FailureReason,
+		VMID:          r.VMID,
+		RunnerID:      r.RunnerID,
+		JobToken:      r.JobToken,
+		admitted:      r.Status == "running",
+	}
+}
+
+func (j *Job) forgeJob() forge.Job {
+	return forge.Job{
+		ID:         j.ID,
+		ProjectID:  j.ProjectID,
+		PipelineID: j.PipelineID,
+		Repository: j.Repository,
+		Tags:       j.Tags,
+	}
+}
+
+func (j *Job) setSpec(spec vmspec.Spec) {
+	j.VCPU = spec.VCPU
+	j.MemoryMB = spec.MemoryMB
+	j.DiskGB = spec.DiskGB
+	j.Arch = spec.Arch
+	j.Image = spec.Image
+	j.KernelImage = spec.Kernel
+	j.RootFSImage = spec.RootFS
+	j.Caches = spec.Caches
+}
+
+func (s *Scheduler) jobPending(job *Job) (bool, error) {
+	f, err := s.forgeFor(job)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return f.JobPending(ctx, job.forgeJob())
+}
+
+func (s *Scheduler) forgeFor(job *Job) (forge.Forge, error) {
+	f, exists := s.forges[job.Forge]
+	if !exists {
+		return nil, fmt.Errorf("forge %s of job %d is not configured", job.Forge, job.ID)
+	}
+	return f, nil
 }
 
-func (s *Scheduler) updateJobStatus(jobID int64, status string) {
+func (s *Scheduler) updateJobStatus(key JobKey, status string) {
 	s.jobsMu.Lock()
 	defer s.jobsMu.Unlock()
-	if job, exists := s.jobs[jobID]; exists {
+	if job, exists := s.jobs[key]; exists {
+		if job.aborted {
+			return
+		}
 		job.Status = status
 		if status == "running" && job.StartedAt.IsZero() {
 			job.StartedAt = time.Now()
 		} else if status == "finished" || status == "failed" {
 			job.FinishedAt = time.Now()
+			metrics.JobsTotal.WithLabelValues(status, strconv.FormatInt(job.ProjectID, 10)).Inc()
+			s.queue.wake()
 		}
+		s.saveJob(job)
 	}
 }
 
@@ -273,18 +1074,19 @@ func (s *Scheduler) cleanup() {
 	maxAge := 1 * time.Hour
 	now := time.Now()
 
-	for id, job := range s.jobs {
-		if (job.Status == "finished" || job.Status == "failed") &&
+	for key, job := range s.jobs {
+		if (job.Status == "finished" || job.Status == "failed" || job.Status == "canceled") &&
 			!job.FinishedAt.IsZero() &&
 			now.Sub(job.FinishedAt) > maxAge {
 
-			s.logger.WithField("job_id", id).Debug("Cleaning up old job")
+			s.logger.WithField("job_id", job.ID).Debug("Cleaning up old job")
 
 			if job.cancel != nil {
 				job.cancel()
 			}
 
-			delete(s.jobs, id)
+			delete(s.jobs, key)
+			s.deleteJob(key)
 		}
 	}
 }
@@ -295,22 +1097,28 @@ func (w *Worker) run() {
 	w.logger.Info("Worker started")
 
 	for {
-		select {
-		case job, ok := <-w.scheduler.jobQueue:
-			if !ok {
-				w.logger.Info("Job queue closed, worker stopping")
-				return
-			}
-			w.processJob(job)
-
-		case <-w.shutdownCh:
-			w.logger.Info("Worker shutdown signal received")
+		job, ok := w.scheduler.nextJob(w.shutdownCh)
+		if !ok {
+			w.logger.Info("Job queue closed, worker stopping")
 			return
 		}
+		metrics.QueueWaitSeconds.Observe(time.Since(job.CreatedAt).Seconds())
+		w.processJob(job)
 	}
 }
 
 func (w *Worker) processJob(job *Job) {
+	if job.ctx.Err() != nil {
+		w.logger.WithField("job_id", job.ID).Info("Skipping job that was canceled while queued")
+		w.scheduler.updateJobStatus(job.key(), "failed")
+		return
+	}
+
+	if w.scheduler.claimer != nil && job.Forge == forge.GitLab {
+		w.processClaimedJob(job)
+		return
+	}
+
 	w.logger.WithFields(logrus.Fields{
 		"job_id":     job.ID,
 		"project_id": job.ProjectID,
@@ -318,53 +1126,94 @@ func (w *Worker) processJob(job *Job) {
 		"memory_mb":  job.MemoryMB,
 	}).Info("Processing job")
 
-	w.scheduler.updateJobStatus(job.ID, "running")
+	w.scheduler.updateJobStatus(job.key(), "running")
 
-	vm, err := w.createVM(job)
+	// The runner is created first so that its token can be handed to the
+	// VM through cloud-init when it boots.
+	vmID := firecracker.NewVMID(fmt.Sprintf("%s-%d", job.Forge, job.ID))
+	runner, err := w.registerRunner(job, vmID)
 	if err != nil {
-		w.logger.WithError(err).Error("Failed to create VM for job")
-		w.scheduler.updateJobStatus(job.ID, "failed")
+		w.logger.WithError(err).Error("Failed to register runner")
+		w.scheduler.updateJobStatus(job.key(), "failed")
 		job.err = err
 		return
 	}
 
-	job.VM = vm
-	job.VMID = vm.ID
+	w.runJob(job, vmID, runner.Guest, nil)
+}
 
-	if err := w.registerRunner(job); err != nil {
-		w.logger.WithError(err).Error("Failed to register runner")
-		w.scheduler.updateJobStatus(job.ID, "failed")
+// runJob boots the VM for a job whose runner or claim is already set up,
+// waits for the job to finish and tears the VM down again.
+func (w *Worker) runJob(job *Job, vmID string, runner *cloudinit.Runner, claimed *cloudinit.Job) {
+	vm, err := w.createVM(job, vmID, runner, claimed)
+	if err != nil {
+		w.logger.WithError(err).Error("Failed to create VM for job")
+		w.scheduler.updateJobStatus(job.key(), "failed")
 		job.err = err
+		message := fmt.Sprintf("FireRunner could not start a microVM for this job: %v", err)
+		if w.scheduler.canceledLocally(job) {
+			message = canceledMessage
+		}
+		w.failClaimedJob(job, "runner_system_failure", message)
 		w.cleanupVM(job)
 		return
 	}
 
+	job.VM = vm
+	job.VMID = vm.ID
+	w.scheduler.persistJob(job)
+
 	w.waitForJobCompletion(job)
 
 	w.cleanupVM(job)
 
 	if job.err != nil {
-		w.scheduler.updateJobStatus(job.ID, "failed")
+		switch {
+		case errors.Is(job.ctx.Err(), context.DeadlineExceeded):
+			w.failClaimedJob(job, "job_execution_timeout", "")
+		case w.scheduler.canceledLocally(job):
+			w.failClaimedJob(job, "runner_system_failure", canceledMessage)
+		}
+		w.scheduler.updateJobStatus(job.key(), "failed")
 	} else {
-		w.scheduler.updateJobStatus(job.ID, "finished")
+		w.scheduler.updateJobStatus(job.key(), "finished")
 	}
 
 	w.logger.WithField("job_id", job.ID).Info("Job processing completed")
 }
 
-func (w *Worker) createVM(job *Job) (*firecracker.MicroVM, error) {
+func (w *Worker) createVM(job *Job, vmID string, runner *cloudinit.Runner, claimed *cloudinit.Job) (*firecracker.MicroVM, error) {
 	req := &firecracker.VMRequest{
-		JobID:     fmt.Sprintf("%d", job.ID),
-		ProjectID: fmt.Sprintf("%d", job.ProjectID),
-		VCPU:      job.VCPU,
-		MemoryMB:  job.MemoryMB,
-		Tags:      job.Tags,
+		ID:          vmID,
+		JobID:       fmt.Sprintf("%d", job.ID),
+		ProjectID:   fmt.Sprintf("%d", job.ProjectID),
+		VCPU:        job.VCPU,
+		MemoryMB:    job.MemoryMB,
+		DiskGB:      job.DiskGB,
+		KernelImage: job.KernelImage,
+		RootFSImage: job.RootFSImage,
+		Tags:        job.Tags,
 		Metadata: map[string]string{
 			"job_id":      fmt.Sprintf("%d", job.ID),
 			"project_id":  fmt.Sprintf("%d", job.ProjectID),
 			"pipeline_id": fmt.Sprintf("%d", job.PipelineID),
+			"forge":       job.Forge,
 		},
 	}
+	// The reconciler needs them to finish the job of an orphaned VM.
+	if job.Repository != "" {
+		req.Metadata["repository"] = job.Repository
+	}
+	if job.RunnerID > 0 {
+		req.Metadata["runner_id"] = fmt.Sprintf("%d", job.RunnerID)
+	}
+	if job.Arch != "" {
+		req.HostSelector = map[string]string{"arch": job.Arch}
+	}
+	req.Volumes = w.scheduler.acquireCaches(job)
+	req.Egress = job.Egress
+	req.Runner = runner
+	req.Job = claimed
 
 	ctx, cancel := context.WithTimeout(job.ctx, w.scheduler.config.VMStartTimeout)
 	defer cancel()
@@ -372,69 +1221,99 @@ func (w *Worker) createVM(job *Job) (*firecracker.MicroVM, error) {
 	return w.scheduler.vmManager.CreateVM(ctx, req)
 }
 
-func (w *Worker) registerRunner(job *Job) error {
+// acquireCaches leases the caches of a job and returns them as VM volumes.
+// A job whose caches cannot be set up runs without them.
+func (s *Scheduler) acquireCaches(job *Job) []firecracker.Volume {
+	if s.caches == nil || len(job.Caches) == 0 {
+		return nil
+	}
+
+	lease, err := s.caches.Acquire(fmt.Sprintf("%s-%d", job.Forge, job.ProjectID), job.Caches)
+	if err != nil {
+		s.logger.WithError(err).WithField("job_id", job.ID).Warn("Failed to set up caches, running without them")
+		return nil
+	}
+	job.cacheLease = lease
+
+	volumes := make([]firecracker.Volume, 0, len(lease.Mounts))
+	for _, mount := range lease.Mounts {
+		volumes = append(volumes, firecracker.Volume{
+			ID:         "cache-" + mount.Key,
+			HostPath:   mount.Path,
+			MountPoint: mount.MountPoint,
+			ReadOnly:   mount.ReadOnly,
+		})
+	}
+	return volumes
+}
+
+func (w *Worker) registerRunner(job *Job, vmID string) (*forge.Runner, error) {
 	w.logger.WithFields(logrus.Fields{
+		"forge":      job.Forge,
 		"job_id":     job.ID,
 		"project_id": job.ProjectID,
-		"vm_ip":      job.VM.IPAddress,
-	}).Info("Registering ephemeral GitLab runner")
+		"vm_id":      vmID,
+	}).Info("Registering ephemeral runner")
+
+	f, err := w.scheduler.forgeFor(job)
+	if err != nil {
+		return nil, err
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	registration, err := w.scheduler.gitlabSvc.RegisterRunner(ctx, job.ProjectID, job.VM.IPAddress, job.Tags)
+	runner, err := f.RegisterRunner(ctx, job.forgeJob(), vmID)
 	if err != nil {
-		return fmt.Errorf("failed to register runner: %w", err)
+		return nil, fmt.Errorf("failed to register runner: %w", err)
 	}
 
 	w.logger.WithFields(logrus.Fields{
-		"runner_id": registration.ID,
-		"tags":      registration.Tags,
+		"runner_id": runner.ID,
+		"tags":      runner.Guest.Tags,
 	}).Info("Runner registered successfully")
 
-	job.RunnerID = registration.ID
-	job.VMID = job.VM.ID
+	job.RunnerID = runner.ID
+	w.scheduler.persistJob(job)
 
-	return nil
+	return runner, nil
 }
 
 func (w *Worker) waitForJobCompletion(job *Job) {
 	w.logger.WithField("job_id", job.ID).Info("Waiting for job completion")
 
-	monitor := gitlab.NewJobMonitor(w.scheduler.gitlabSvc, w.logger.Logger)
-
-	pollInterval := 5 * time.Second
-
-	completedJob, err := monitor.WaitForJobCompletion(job.ctx, job.ProjectID, job.ID, pollInterval)
+	f, err := w.scheduler.forgeFor(job)
 	if err != nil {
-		w.logger.WithError(err).WithField("job_id", job.ID).Error("Job monitoring failed")
 		job.err = err
 		return
 	}
 
-	w.logger.WithFields(logrus.Fields{
-		"job_id":   job.ID,
-		"status":   completedJob.Status,
-		"duration": completedJob.Duration,
-	}).Info("Job completed")
-
-	if completedJob.Status != "success" {
-		job.err = fmt.Errorf("job failed with status: %s", completedJob.Status)
+	if err := f.WaitForJob(job.ctx, job.forgeJob()); err != nil {
+		w.logger.WithError(err).WithField("job_id", job.ID).Error("Job did not succeed")
+		job.err = err
+		return
 	}
+
+	w.logger.WithField("job_id", job.ID).Info("Job completed")
 }
 
 func (w *Worker) cleanupVM(job *Job) {
 	if job.RunnerID > 0 {
-		w.logger.WithField("runner_id", job.RunnerID).Info("Unregistering GitLab runner")
+		w.logger.WithField("runner_id", job.RunnerID).Info("Unregistering runner")
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		if err := w.scheduler.gitlabSvc.UnregisterRunner(ctx, job.RunnerID); err != nil {
+		if f, err := w.scheduler.forgeFor(job); err != nil {
 			w.logger.WithError(err).Error("Failed to unregister runner")
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := f.UnregisterRunner(ctx, job.forgeJob(), job.RunnerID); err != nil {
+				w.logger.WithError(err).Error("Failed to unregister runner")
+			}
+			cancel()
 		}
-		cancel()
 	}
 
 	if job.VMID == "" {
+		w.releaseCaches(job)
 		return
 	}
 
@@ -444,8 +1323,66 @@ func (w *Worker) cleanupVM(job *Job) {
 	defer cancel()
 
 	if err := w.scheduler.vmManager.DestroyVM(ctx, job.VMID); err != nil {
+		// The VM may still write to its caches, so they stay leased until
+		// a retry destroys it.
 		w.logger.WithError(err).Error("Failed to destroy VM")
+		w.scheduler.reclaimCaches(job)
 	} else {
 		w.logger.WithField("vm_id", job.VMID).Info("VM destroyed successfully")
+		w.releaseCaches(job)
+	}
+}
+
+// releaseCaches hands the caches of a job back once its VM is gone.
+func (w *Worker) releaseCaches(job *Job) {
+	if job.cacheLease != nil {
+		job.cacheLease.Release()
+		job.cacheLease = nil
+	}
+}
+
+// reclaimCaches keeps trying to destroy the VM of a job that could not be
+// destroyed, with a growing delay, and releases the job's caches once the VM
+// is gone, either destroyed by a retry or no longer tracked at all.
+func (s *Scheduler) reclaimCaches(job *Job) {
+	lease := job.cacheLease
+	if lease == nil {
+		return
 	}
+	job.cacheLease = nil
+	vmID := job.VMID
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		delay := s.destroyRetryDelay
+		for {
+			select {
+			case <-time.After(delay):
+			case <-s.shutdownCh:
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), s.config.VMShutdownTimeout)
+			err := s.vmManager.DestroyVM(ctx, vmID)
+			cancel()
+			if err == nil {
+				s.logger.WithField("vm_id", vmID).Info("Destroyed VM on retry, releasing its caches")
+				lease.Release()
+				return
+			}
+			if _, lookupErr := s.vmManager.GetVM(vmID); lookupErr != nil {
+				s.logger.WithField("vm_id", vmID).Info("VM is gone, releasing its caches")
+				lease.Release()
+				return
+			}
+			s.logger.WithError(err).WithField("vm_id", vmID).Warn("Failed to destroy VM again, keeping its caches leased")
+
+			delay *= 2
+			if delay > destroyRetryMaxDelay {
+				delay = destroyRetryMaxDelay
+			}
+		}
+	}()
 }
@@ -2,17 +2,26 @@ package scheduler
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/sirupsen/logrus"
 	gogitlab "github.com/xanzy/go-gitlab"
 
+	"github.com/ismoilovdevml/firerunner/pkg/cache"
+	"github.com/ismoilovdevml/firerunner/pkg/cloudinit"
 	"github.com/ismoilovdevml/firerunner/pkg/config"
 	"github.com/ismoilovdevml/firerunner/pkg/firecracker"
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
 	"github.com/ismoilovdevml/firerunner/pkg/gitlab"
+	"github.com/ismoilovdevml/firerunner/pkg/metrics"
+	"github.com/ismoilovdevml/firerunner/pkg/vmspec"
 )
 
 // Mock VM Manager
@@ -22,11 +31,14 @@ type mockVMManager struct {
 	destroyCalled bool
 	createError   error
 	destroyError  error
+	adoptError    error
+	lastRequest   *firecracker.VMRequest
 }
 
 func (m *mockVMManager) CreateVM(ctx context.Context, req *firecracker.VMRequest) (*firecracker.MicroVM, error) {
 	m.mu.Lock()
 	m.createCalled = true
+	m.lastRequest = req
 	createError := m.createError
 	m.mu.Unlock()
 
@@ -42,6 +54,21 @@ func (m *mockVMManager) CreateVM(ctx context.Context, req *firecracker.VMRequest
 	}, nil
 }
 
+func (m *mockVMManager) AdoptVM(ctx context.Context, vmID string) (*firecracker.MicroVM, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.adoptError != nil {
+		return nil, m.adoptError
+	}
+	return &firecracker.MicroVM{
+		ID:        vmID,
+		Namespace: "firerunner",
+		State:     "running",
+		IPAddress: "10.0.0.100",
+		CreatedAt: time.Now(),
+	}, nil
+}
+
 func (m *mockVMManager) DestroyVM(ctx context.Context, vmID string) error {
 	m.mu.Lock()
 	m.destroyCalled = true
@@ -69,9 +96,12 @@ func (m *mockVMManager) StopCleanup()                        {}
 func (m *mockVMManager) Shutdown(ctx context.Context) error  { return nil }
 
 // Mock GitLab Service
-type mockGitLabService struct{}
+type mockGitLabService struct {
+	mu       sync.Mutex
+	canceled []int64
+}
 
-func (m *mockGitLabService) RegisterRunner(ctx context.Context, projectID int64, vmIP string, tags []string) (*gitlab.RunnerRegistration, error) {
+func (m *mockGitLabService) RegisterRunner(ctx context.Context, projectID int64, vmID string, tags []string) (*gitlab.RunnerRegistration, error) {
 	return &gitlab.RunnerRegistration{
 		ID:    1234,
 		Token: "mock-token",
@@ -90,6 +120,13 @@ func (m *mockGitLabService) GetJob(ctx context.Context, projectID, jobID int64)
 	}, nil
 }
 
+func (m *mockGitLabService) CancelJob(ctx context.Context, projectID, jobID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.canceled = append(m.canceled, jobID)
+	return nil
+}
+
 func (m *mockGitLabService) ProcessJobEvent(event *gitlab.JobEvent) error {
 	return nil
 }
@@ -108,6 +145,10 @@ func testLogger() *logrus.Logger {
 	return logger
 }
 
+func gitlabKey(id int64) JobKey {
+	return JobKey{Forge: forge.GitLab, ID: id}
+}
+
 func testSchedulerConfig() *config.SchedulerConfig {
 	return &config.SchedulerConfig{
 		QueueSize:         10,
@@ -139,8 +180,8 @@ func TestNewScheduler(t *testing.T) {
 		t.Error("Jobs map should be empty initially")
 	}
 
-	if cap(scheduler.jobQueue) != cfg.QueueSize {
-		t.Errorf("Job queue capacity should be %d, got %d", cfg.QueueSize, cap(scheduler.jobQueue))
+	if scheduler.queue.capacity != cfg.QueueSize {
+		t.Errorf("Job queue capacity should be %d, got %d", cfg.QueueSize, scheduler.queue.capacity)
 	}
 }
 
@@ -203,7 +244,7 @@ func TestScheduler_ScheduleJob(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 
 	// Check job was tracked
-	job, exists := scheduler.GetJob(123)
+	job, exists := scheduler.GetJob(gitlabKey(123))
 	if !exists {
 		t.Error("Job should exist after scheduling")
 	}
@@ -226,7 +267,7 @@ func TestScheduler_GetJob(t *testing.T) {
 	scheduler := NewScheduler(cfg, vmManager, gitlabSvc, logger)
 
 	// Test non-existent job
-	_, exists := scheduler.GetJob(999)
+	_, exists := scheduler.GetJob(gitlabKey(999))
 	if exists {
 		t.Error("Non-existent job should not exist")
 	}
@@ -234,6 +275,7 @@ func TestScheduler_GetJob(t *testing.T) {
 	// Add a job manually for testing
 	job := &Job{
 		ID:        123,
+		Forge:     forge.GitLab,
 		ProjectID: 456,
 		Status:    "queued",
 		CreatedAt: time.Now(),
@@ -241,7 +283,7 @@ func TestScheduler_GetJob(t *testing.T) {
 	scheduler.trackJob(job)
 
 	// Test existing job
-	retrieved, exists := scheduler.GetJob(123)
+	retrieved, exists := scheduler.GetJob(gitlabKey(123))
 	if !exists {
 		t.Error("Job should exist")
 	}
@@ -269,6 +311,7 @@ func TestScheduler_ListJobs(t *testing.T) {
 	for i := int64(1); i <= 3; i++ {
 		job := &Job{
 			ID:        i,
+			Forge:     forge.GitLab,
 			ProjectID: 100,
 			Status:    "queued",
 			CreatedAt: time.Now(),
@@ -304,21 +347,15 @@ func TestScheduler_GetStats(t *testing.T) {
 
 	stats := scheduler.GetStats()
 
-	totalJobs, ok := stats["total_jobs"].(int)
-	if !ok || totalJobs != 4 {
-		t.Errorf("Expected total_jobs = 4, got %v", stats["total_jobs"])
+	if stats.TotalJobs != 4 {
+		t.Errorf("Expected TotalJobs = 4, got %d", stats.TotalJobs)
 	}
 
-	queueSize, ok := stats["queue_size"].(int)
-	if !ok {
-		t.Error("queue_size should be an int")
+	if stats.QueueCapacity != cfg.QueueSize {
+		t.Errorf("Expected QueueCapacity = %d, got %d", cfg.QueueSize, stats.QueueCapacity)
 	}
-	_ = queueSize // We don't check exact value as jobs might be processed
 
-	byStatus, ok := stats["by_status"].(map[string]int)
-	if !ok {
-		t.Fatal("by_status should be a map")
-	}
+	byStatus := stats.ByStatus
 
 	if byStatus["queued"] != 1 {
 		t.Errorf("Expected 1 queued job, got %d", byStatus["queued"])
@@ -345,6 +382,7 @@ func TestScheduler_Shutdown(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	job := &Job{
 		ID:        123,
+		Forge:     forge.GitLab,
 		ProjectID: 456,
 		Status:    "running",
 		CreatedAt: time.Now(),
@@ -381,6 +419,7 @@ func TestScheduler_TrackUntrack(t *testing.T) {
 
 	job := &Job{
 		ID:        123,
+		Forge:     forge.GitLab,
 		ProjectID: 456,
 		Status:    "queued",
 		CreatedAt: time.Now(),
@@ -393,7 +432,7 @@ func TestScheduler_TrackUntrack(t *testing.T) {
 	}
 
 	// Untrack
-	scheduler.untrackJob(123)
+	scheduler.untrackJob(gitlabKey(123))
 	if len(scheduler.jobs) != 0 {
 		t.Error("Job should be untracked")
 	}
@@ -409,6 +448,7 @@ func TestScheduler_UpdateJobStatus(t *testing.T) {
 
 	job := &Job{
 		ID:        123,
+		Forge:     forge.GitLab,
 		ProjectID: 456,
 		Status:    "queued",
 		CreatedAt: time.Now(),
@@ -416,8 +456,8 @@ func TestScheduler_UpdateJobStatus(t *testing.T) {
 	scheduler.trackJob(job)
 
 	// Update to running
-	scheduler.updateJobStatus(123, "running")
-	updated, _ := scheduler.GetJob(123)
+	scheduler.updateJobStatus(gitlabKey(123), "running")
+	updated, _ := scheduler.GetJob(gitlabKey(123))
 	if updated.Status != "running" {
 		t.Errorf("Expected status 'running', got '%s'", updated.Status)
 	}
@@ -426,8 +466,8 @@ func TestScheduler_UpdateJobStatus(t *testing.T) {
 	}
 
 	// Update to finished
-	scheduler.updateJobStatus(123, "finished")
-	updated, _ = scheduler.GetJob(123)
+	scheduler.updateJobStatus(gitlabKey(123), "finished")
+	updated, _ = scheduler.GetJob(gitlabKey(123))
 	if updated.Status != "finished" {
 		t.Errorf("Expected status 'finished', got '%s'", updated.Status)
 	}
@@ -447,6 +487,7 @@ func TestScheduler_Cleanup(t *testing.T) {
 	// Add old finished job (should be cleaned up)
 	oldJob := &Job{
 		ID:         123,
+		Forge:      forge.GitLab,
 		ProjectID:  456,
 		Status:     "finished",
 		CreatedAt:  time.Now().Add(-3 * time.Hour),
@@ -457,6 +498,7 @@ func TestScheduler_Cleanup(t *testing.T) {
 	// Add recent finished job (should NOT be cleaned up)
 	recentJob := &Job{
 		ID:         456,
+		Forge:      forge.GitLab,
 		ProjectID:  789,
 		Status:     "finished",
 		CreatedAt:  time.Now().Add(-30 * time.Minute),
@@ -467,6 +509,7 @@ func TestScheduler_Cleanup(t *testing.T) {
 	// Add running job (should NOT be cleaned up)
 	runningJob := &Job{
 		ID:        789,
+		Forge:     forge.GitLab,
 		ProjectID: 101112,
 		Status:    "running",
 		CreatedAt: time.Now().Add(-3 * time.Hour),
@@ -478,17 +521,17 @@ func TestScheduler_Cleanup(t *testing.T) {
 	scheduler.cleanup()
 
 	// Check results
-	_, exists := scheduler.GetJob(123)
+	_, exists := scheduler.GetJob(gitlabKey(123))
 	if exists {
 		t.Error("Old finished job should be cleaned up")
 	}
 
-	_, exists = scheduler.GetJob(456)
+	_, exists = scheduler.GetJob(gitlabKey(456))
 	if !exists {
 		t.Error("Recent finished job should NOT be cleaned up")
 	}
 
-	_, exists = scheduler.GetJob(789)
+	_, exists = scheduler.GetJob(gitlabKey(789))
 	if !exists {
 		t.Error("Running job should NOT be cleaned up")
 	}
@@ -542,6 +585,7 @@ func TestJob_ContextCancellation(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	job := &Job{
 		ID:        123,
+		Forge:     forge.GitLab,
 		ProjectID: 456,
 		Status:    "queued",
 		CreatedAt: time.Now(),
@@ -602,7 +646,7 @@ func TestWorker_ProcessJob_Success(t *testing.T) {
 	}
 
 	// Check job status
-	job, exists := scheduler.GetJob(123)
+	job, exists := scheduler.GetJob(gitlabKey(123))
 	if !exists {
 		t.Fatal("Job should exist")
 	}
@@ -619,3 +663,829 @@ func TestWorker_ProcessJob_Success(t *testing.T) {
 		t.Errorf("Expected memory 8192, got %d", job.MemoryMB)
 	}
 }
+
+func TestWorker_CreateVM_PassesRunnerToken(t *testing.T) {
+	cfg := testSchedulerConfig()
+	vmManager := &mockVMManager{}
+	scheduler := NewScheduler(cfg, vmManager, newMockGitLabService(), testLogger())
+
+	worker := &Worker{ID: 1, scheduler: scheduler, logger: testLogger().WithField("worker_id", 1)}
+	job := &Job{ID: 1, Forge: forge.GitLab, ProjectID: 2, VCPU: 2, MemoryMB: 4096, ctx: context.Background()}
+
+	runner := &cloudinit.Runner{Token: "glrt-secret", URL: "https://gitlab.example.com"}
+	if _, err := worker.createVM(job, "vm-1-abcd", runner, nil); err != nil {
+		t.Fatalf("createVM() failed: %v", err)
+	}
+
+	req := vmManager.lastRequest
+	if req == nil || req.Runner == nil {
+		t.Fatal("Expected runner configuration in VM request")
+	}
+	if req.ID != "vm-1-abcd" {
+		t.Errorf("Expected VM ID vm-1-abcd, got %s", req.ID)
+	}
+	if req.Runner.Token != "glrt-secret" || req.Runner.URL != "https://gitlab.example.com" {
+		t.Errorf("Unexpected runner configuration: %+v", req.Runner)
+	}
+
+	github := &Job{ID: 2, Forge: forge.GitHub, ProjectID: 5, Repository: "acme/app", RunnerID: 77, VCPU: 2, MemoryMB: 4096, ctx: context.Background()}
+	if _, err := worker.createVM(github, "vm-2", nil, nil); err != nil {
+		t.Fatalf("createVM() failed: %v", err)
+	}
+	if metadata := vmManager.lastRequest.Metadata; metadata["repository"] != "acme/app" || metadata["runner_id"] != "77" {
+		t.Errorf("Expected the reconciler to find the repository and runner, got %v", metadata)
+	}
+}
+
+func TestScheduler_CancelJob(t *testing.T) {
+	scheduler := NewScheduler(testSchedulerConfig(), &mockVMManager{}, newMockGitLabService(), testLogger())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduler.trackJob(&Job{ID: 1, Forge: forge.GitLab, ProjectID: 2, Status: "queued", CreatedAt: time.Now(), ctx: ctx, cancel: cancel})
+	scheduler.trackJob(&Job{ID: 2, Forge: forge.GitLab, ProjectID: 2, Status: "finished", CreatedAt: time.Now()})
+
+	if err := scheduler.CancelJob(gitlabKey(1)); err != nil {
+		t.Fatalf("CancelJob() failed: %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Error("Expected job context to be canceled")
+	}
+	if canceled := scheduler.gitlabSvc.(*mockGitLabService).canceled; len(canceled) != 1 || canceled[0] != 1 {
+		t.Errorf("Expected the job to be canceled in GitLab, got %v", canceled)
+	}
+
+	scheduler.updateJobStatus(gitlabKey(1), "failed")
+	if info, _ := scheduler.JobInfo(gitlabKey(1)); info.Status != "canceled" {
+		t.Errorf("Expected canceled status to stick, got %s", info.Status)
+	}
+
+	if err := scheduler.CancelJob(gitlabKey(2)); !errors.Is(err, ErrJobNotActive) {
+		t.Errorf("Expected ErrJobNotActive, got %v", err)
+	}
+	if err := scheduler.CancelJob(gitlabKey(3)); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected ErrJobNotFound, got %v", err)
+	}
+}
+
+func TestScheduler_JobForVM(t *testing.T) {
+	scheduler := NewScheduler(testSchedulerConfig(), &mockVMManager{}, newMockGitLabService(), testLogger())
+	scheduler.trackJob(&Job{ID: 1, Forge: forge.GitLab, Status: "running", VMID: "vm-1"})
+	scheduler.trackJob(&Job{ID: 2, Forge: forge.GitLab, Status: "finished", VMID: "vm-2"})
+
+	if key, ok := scheduler.JobForVM("vm-1"); !ok || key != gitlabKey(1) {
+		t.Errorf("Expected vm-1 to belong to job 1, got %v %v", key, ok)
+	}
+	if _, ok := scheduler.JobForVM("vm-2"); ok {
+		t.Error("A finished job should not own its VM anymore")
+	}
+}
+
+func TestScheduler_PauseResume(t *testing.T) {
+	cfg := testSchedulerConfig()
+	vmManager := &mockVMManager{}
+	scheduler := NewScheduler(cfg, vmManager, newMockGitLabService(), testLogger())
+
+	scheduler.Pause()
+	if err := scheduler.Start(); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	defer scheduler.Shutdown(context.Background())
+
+	if err := scheduler.ScheduleJob(&gitlab.JobEvent{BuildID: 1, ProjectID: 2}); err != nil {
+		t.Fatalf("ScheduleJob() failed: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if vmManager.wasCreateCalled() {
+		t.Fatal("No VM should be created while paused")
+	}
+	if !scheduler.GetStats().Paused {
+		t.Error("Expected stats to report paused")
+	}
+
+	scheduler.Resume()
+	deadline := time.Now().Add(2 * time.Second)
+	for !vmManager.wasCreateCalled() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !vmManager.wasCreateCalled() {
+		t.Error("Expected job to be processed after resume")
+	}
+}
+
+func TestScheduler_AbortPipeline(t *testing.T) {
+	vmManager := &mockVMManager{}
+	scheduler := NewScheduler(testSchedulerConfig(), vmManager, newMockGitLabService(), testLogger())
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	ctx3, cancel3 := context.WithCancel(context.Background())
+	defer cancel3()
+	scheduler.trackJob(&Job{ID: 1, Forge: forge.GitLab, ProjectID: 2, PipelineID: 7, Status: "queued", ctx: ctx1, cancel: cancel1})
+	scheduler.trackJob(&Job{ID: 2, Forge: forge.GitLab, ProjectID: 2, PipelineID: 7, Status: "running", ctx: ctx2, cancel: cancel2})
+	scheduler.trackJob(&Job{ID: 3, Forge: forge.GitLab, ProjectID: 2, PipelineID: 8, Status: "running", ctx: ctx3, cancel: cancel3})
+
+	if aborted := scheduler.AbortPipeline(forge.GitLab, 2, 7, "canceled"); aborted != 2 {
+		t.Errorf("Expected 2 aborted jobs, got %d", aborted)
+	}
+	if ctx1.Err() == nil || ctx2.Err() == nil {
+		t.Error("Expected jobs of pipeline 7 to be canceled")
+	}
+	if ctx3.Err() != nil {
+		t.Error("Job of another pipeline should not be canceled")
+	}
+
+	// A queued job that was aborted is skipped by the worker.
+	job, _ := scheduler.GetJob(gitlabKey(1))
+	worker := &Worker{ID: 1, scheduler: scheduler, logger: testLogger().WithField("worker_id", 1)}
+	worker.processJob(job)
+
+	if vmManager.wasCreateCalled() {
+		t.Error("No VM should be created for an aborted job")
+	}
+	if info, _ := scheduler.JobInfo(gitlabKey(1)); info.Status != "canceled" {
+		t.Errorf("Expected status canceled, got %s", info.Status)
+	}
+}
+
+func TestScheduler_AbortJobKeepsForgesApart(t *testing.T) {
+	scheduler := NewScheduler(testSchedulerConfig(), &mockVMManager{}, newMockGitLabService(), testLogger())
+
+	gitlabCtx, gitlabCancel := context.WithCancel(context.Background())
+	defer gitlabCancel()
+	githubCtx, githubCancel := context.WithCancel(context.Background())
+	scheduler.trackJob(&Job{ID: 1, Forge: forge.GitLab, ProjectID: 2, PipelineID: 7, Status: "running", ctx: gitlabCtx, cancel: gitlabCancel})
+	scheduler.trackJob(&Job{ID: 1, Forge: forge.GitHub, ProjectID: 2, PipelineID: 7, Status: "running", ctx: githubCtx, cancel: githubCancel})
+
+	if len(scheduler.ListJobs()) != 2 {
+		t.Fatalf("Expected jobs of both forges to be tracked, got %d", len(scheduler.ListJobs()))
+	}
+	if aborted := scheduler.AbortPipeline(forge.Forgejo, 2, 7, "canceled"); aborted != 0 {
+		t.Errorf("Expected no job of another forge to be aborted, got %d", aborted)
+	}
+	if err := scheduler.AbortJob(JobKey{Forge: forge.GitHub, ID: 1}, "canceled"); err != nil {
+		t.Fatalf("AbortJob() failed: %v", err)
+	}
+
+	if githubCtx.Err() == nil {
+		t.Error("Expected the GitHub job to be canceled")
+	}
+	if gitlabCtx.Err() != nil {
+		t.Error("GitLab job with the same ID should not be canceled")
+	}
+	if info, _ := scheduler.JobInfo(gitlabKey(1)); info.Status != "running" {
+		t.Errorf("Expected the GitLab job to keep running, got %s", info.Status)
+	}
+}
+
+func TestScheduler_ScheduleJobSuppressesDuplicates(t *testing.T) {
+	scheduler := NewScheduler(testSchedulerConfig(), &mockVMManager{}, newMockGitLabService(), testLogger())
+
+	suppressed := testutil.ToFloat64(metrics.DuplicateEventsSuppressed.WithLabelValues("build_id"))
+
+	event := &gitlab.JobEvent{BuildID: 1, ProjectID: 2, BuildStatus: "created"}
+	if err := scheduler.ScheduleJob(event); err != nil {
+		t.Fatalf("ScheduleJob() failed: %v", err)
+	}
+
+	event.BuildStatus = "pending"
+	if err := scheduler.ScheduleJob(event); err != nil {
+		t.Fatalf("Duplicate ScheduleJob() should not fail: %v", err)
+	}
+
+	if queued := scheduler.queue.len(); queued != 1 {
+		t.Errorf("Expected 1 queued job, got %d", queued)
+	}
+	if got := testutil.ToFloat64(metrics.DuplicateEventsSuppressed.WithLabelValues("build_id")); got != suppressed+1 {
+		t.Errorf("Expected suppressed counter %v, got %v", suppressed+1, got)
+	}
+}
+
+type mockJobClaimer struct {
+	mu       sync.Mutex
+	payloads []*gitlab.JobPayload
+	updates  []string
+	traces   []string
+}
+
+func (c *mockJobClaimer) URL() string { return "https://gitlab.example.com" }
+
+func (c *mockJobClaimer) RequestJob(ctx context.Context) (*gitlab.JobPayload, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.payloads) == 0 {
+		return nil, nil
+	}
+	payload := c.payloads[0]
+	c.payloads = c.payloads[1:]
+	return payload, nil
+}
+
+func (c *mockJobClaimer) UpdateJob(ctx context.Context, jobID int64, jobToken, state, failureReason string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.updates = append(c.updates, fmt.Sprintf("%d:%s:%s:%s", jobID, jobToken, state, failureReason))
+	return nil
+}
+
+func (c *mockJobClaimer) AppendTrace(ctx context.Context, jobID int64, jobToken string, offset int, data []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.traces = append(c.traces, string(data))
+	return offset + len(data), nil
+}
+
+func TestWorker_ProcessClaimedJob(t *testing.T) {
+	vmManager := &mockVMManager{}
+	scheduler := NewScheduler(testSchedulerConfig(), vmManager, newMockGitLabService(), testLogger())
+
+	payload := &gitlab.JobPayload{ID: 1, Token: "job-token", Raw: []byte(`{"id":1}`)}
+	payload.JobInfo.ProjectID = 2
+	scheduler.SetJobClaimer(&mockJobClaimer{payloads: []*gitlab.JobPayload{payload}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{ID: 1, Forge: forge.GitLab, ProjectID: 2, Status: "queued", CreatedAt: time.Now(), ctx: ctx, cancel: cancel}
+	scheduler.trackJob(job)
+
+	worker := &Worker{ID: 1, scheduler: scheduler, logger: testLogger().WithField("worker_id", 1)}
+	worker.processJob(job)
+
+	req := vmManager.lastRequest
+	if req == nil || req.Job == nil {
+		t.Fatal("Expected claimed job in VM request")
+	}
+	if req.Runner != nil {
+		t.Error("No runner should be registered for a claimed job")
+	}
+	if req.Job.Token != "job-token" || string(req.Job.Payload) != `{"id":1}` {
+		t.Errorf("Unexpected job configuration: %+v", req.Job)
+	}
+	if job.JobToken != "job-token" {
+		t.Errorf("Expected job token to be tracked, got %q", job.JobToken)
+	}
+	if job.Status != "finished" {
+		t.Errorf("Expected status finished, got %s", job.Status)
+	}
+}
+
+func TestWorker_ProcessClaimedJob_ReportsVMFailure(t *testing.T) {
+	vmManager := &mockVMManager{createError: errors.New("no capacity")}
+	scheduler := NewScheduler(testSchedulerConfig(), vmManager, newMockGitLabService(), testLogger())
+
+	payload := &gitlab.JobPayload{ID: 1, Token: "job-token", Raw: []byte(`{"id":1}`)}
+	claimer := &mockJobClaimer{payloads: []*gitlab.JobPayload{payload}}
+	scheduler.SetJobClaimer(claimer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{ID: 1, Forge: forge.GitLab, ProjectID: 2, Status: "queued", CreatedAt: time.Now(), ctx: ctx, cancel: cancel}
+	scheduler.trackJob(job)
+
+	worker := &Worker{ID: 1, scheduler: scheduler, logger: testLogger().WithField("worker_id", 1)}
+	worker.processJob(job)
+
+	if job.Status != "failed" {
+		t.Errorf("Expected status failed, got %s", job.Status)
+	}
+	if len(claimer.updates) != 1 || claimer.updates[0] != "1:job-token:failed:runner_system_failure" {
+		t.Errorf("Expected failure to be reported to GitLab, got %v", claimer.updates)
+	}
+	if len(claimer.traces) != 1 || !strings.Contains(claimer.traces[0], "no capacity") {
+		t.Errorf("Expected failure reason in job trace, got %v", claimer.traces)
+	}
+}
+
+func TestWorker_RunClaimedJob_ReportsAbortSource(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		abort   func(s *Scheduler) error
+		updates []string
+	}{
+		{
+			name:    "canceled through the admin API",
+			abort:   func(s *Scheduler) error { return s.CancelJob(gitlabKey(1)) },
+			updates: []string{"1:job-token:failed:runner_system_failure"},
+		},
+		{
+			name:  "canceled in GitLab",
+			abort: func(s *Scheduler) error { return s.AbortJob(gitlabKey(1), "canceled") },
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			gitlabSvc := newMockGitLabService()
+			scheduler := NewScheduler(testSchedulerConfig(), &mockVMManager{}, gitlabSvc, testLogger())
+			claimer := &mockJobClaimer{}
+			scheduler.SetJobClaimer(claimer)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			job := &Job{ID: 1, Forge: forge.GitLab, ProjectID: 2, Status: "running", JobToken: "job-token", CreatedAt: time.Now(), ctx: ctx, cancel: cancel}
+			scheduler.trackJob(job)
+
+			if err := tt.abort(scheduler); err != nil {
+				t.Fatalf("abort failed: %v", err)
+			}
+			worker := &Worker{ID: 1, scheduler: scheduler, logger: testLogger().WithField("worker_id", 1)}
+			worker.runJob(job, "vm-1", nil, &cloudinit.Job{ID: 1, Token: "job-token"})
+
+			if len(claimer.updates) != len(tt.updates) || (len(tt.updates) > 0 && claimer.updates[0] != tt.updates[0]) {
+				t.Errorf("Expected updates %v, got %v", tt.updates, claimer.updates)
+			}
+			if len(gitlabSvc.canceled) != 0 {
+				t.Errorf("A claimed job should not be canceled through the API, got %v", gitlabSvc.canceled)
+			}
+			if job.Status != "canceled" {
+				t.Errorf("Expected status canceled, got %s", job.Status)
+			}
+		})
+	}
+}
+
+func TestWorker_ProcessClaimedJob_DifferentJob(t *testing.T) {
+	vmManager := &mockVMManager{}
+	scheduler := NewScheduler(testSchedulerConfig(), vmManager, newMockGitLabService(), testLogger())
+
+	payload := &gitlab.JobPayload{ID: 7, Token: "other-token", Raw: []byte(`{"id":7}`)}
+	payload.JobInfo.ProjectID = 2
+	scheduler.SetJobClaimer(&mockJobClaimer{payloads: []*gitlab.JobPayload{payload}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	slot := &Job{ID: 1, Forge: forge.GitLab, ProjectID: 2, Status: "queued", CreatedAt: time.Now(), ctx: ctx, cancel: cancel}
+	scheduler.trackJob(slot)
+
+	worker := &Worker{ID: 1, scheduler: scheduler, logger: testLogger().WithField("worker_id", 1)}
+	worker.processJob(slot)
+
+	claimed, exists := scheduler.GetJob(gitlabKey(7))
+	if !exists {
+		t.Fatal("Claimed job should be tracked")
+	}
+	if claimed.Status != "finished" || claimed.JobToken != "other-token" {
+		t.Errorf("Unexpected claimed job: status=%s token=%q", claimed.Status, claimed.JobToken)
+	}
+
+	// The mock GitLab reports job 1 as finished, so the slot is dropped
+	// instead of requeued.
+	if _, exists := scheduler.GetJob(gitlabKey(1)); exists {
+		t.Error("Slot of a job that is no longer pending should be dropped")
+	}
+}
+
+func TestWorker_ProcessClaimedJob_HeldUntilItFits(t *testing.T) {
+	vmManager := &mockVMManager{}
+	scheduler := NewScheduler(testSchedulerConfig(), vmManager, newMockGitLabService(), testLogger())
+	scheduler.SetForgeLimit(forge.GitLab, 1)
+
+	payload := &gitlab.JobPayload{ID: 7, Token: "other-token", Raw: []byte(`{"id":7}`)}
+	payload.JobInfo.ProjectID = 2
+	scheduler.SetJobClaimer(&mockJobClaimer{payloads: []*gitlab.JobPayload{payload}})
+
+	running := &Job{ID: 3, Forge: forge.GitLab, ProjectID: 2, Status: "running", CreatedAt: time.Now(), admitted: true, ctx: context.Background(), cancel: func() {}}
+	scheduler.trackJob(running)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	slot := &Job{ID: 1, Forge: forge.GitLab, ProjectID: 2, Status: "queued", CreatedAt: time.Now(), admitted: true, ctx: ctx, cancel: cancel}
+	scheduler.trackJob(slot)
+
+	worker := &Worker{ID: 1, scheduler: scheduler, logger: testLogger().WithField("worker_id", 1)}
+	done := make(chan struct{})
+	go func() {
+		worker.processJob(slot)
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if vmManager.wasCreateCalled() {
+		t.Fatal("Claimed job should be held while the forge limit is reached")
+	}
+	scheduler.jobsMu.RLock()
+	reserved := slot.admitted
+	scheduler.jobsMu.RUnlock()
+	if !reserved {
+		t.Error("Slot should keep its reservation while the claimed job is held")
+	}
+
+	scheduler.updateJobStatus(gitlabKey(3), "finished")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !vmManager.wasCreateCalled() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !vmManager.wasCreateCalled() {
+		t.Error("Claimed job was not admitted after the running job finished")
+	}
+	<-done
+}
+
+type fakeForge struct {
+	mu           sync.Mutex
+	registered   []forge.Job
+	unregistered []int64
+	pending      bool
+}
+
+func (f *fakeForge) RegisterRunner(ctx context.Context, job forge.Job, vmID string) (*forge.Runner, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.registered = append(f.registered, job)
+	return &forge.Runner{ID: 77, Guest: &cloudinit.Runner{Forge: forge.GitHub, Name: vmID, JITConfig: "jit"}}, nil
+}
+
+func (f *fakeForge) UnregisterRunner(ctx context.Context, job forge.Job, runnerID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unregistered = append(f.unregistered, runnerID)
+	return nil
+}
+
+func (f *fakeForge) WaitForJob(ctx context.Context, job forge.Job) error {
+	return nil
+}
+
+func (f *fakeForge) JobPending(ctx context.Context, job forge.Job) (bool, error) {
+	return f.pending, nil
+}
+
+func (f *fakeForge) CancelJob(ctx context.Context, job forge.Job) error {
+	return forge.ErrCancelUnsupported
+}
+
+func TestScheduler_ScheduleForgeJob(t *testing.T) {
+	vmManager := &mockVMManager{}
+	scheduler := NewScheduler(testSchedulerConfig(), vmManager, newMockGitLabService(), testLogger())
+
+	job := forge.Job{ID: 1, ProjectID: 5, Repository: "acme/app", Tags: []string{"firerunner-4cpu-8gb"}}
+	if err := scheduler.ScheduleForgeJob(forge.GitHub, job); err == nil {
+		t.Fatal("ScheduleForgeJob() should fail for a forge that is not configured")
+	}
+
+	github := &fakeForge{}
+	scheduler.SetForge(forge.GitHub, github)
+	if err := scheduler.ScheduleForgeJob(forge.GitHub, job); err != nil {
+		t.Fatalf("ScheduleForgeJob() failed: %v", err)
+	}
+
+	queued, ok := scheduler.nextJob(nil)
+	if !ok {
+		t.Fatal("Expected a queued job")
+	}
+	if queued.Forge != forge.GitHub || queued.Repository != "acme/app" || queued.VCPU != 4 {
+		t.Fatalf("Unexpected queued job: %+v", queued)
+	}
+
+	worker := &Worker{ID: 1, scheduler: scheduler, logger: testLogger().WithField("worker_id", 1)}
+	worker.processJob(queued)
+
+	if len(github.registered) != 1 || github.registered[0].Repository != "acme/app" {
+		t.Errorf("Expected runner to be registered with the GitHub forge, got %v", github.registered)
+	}
+	if len(github.unregistered) != 1 || github.unregistered[0] != 77 {
+		t.Errorf("Expected runner 77 to be unregistered, got %v", github.unregistered)
+	}
+	if req := vmManager.lastRequest; req == nil || req.Runner == nil || req.Runner.JITConfig != "jit" {
+		t.Errorf("Expected JIT runner configuration in VM request, got %+v", req)
+	}
+	if queued.Status != "finished" {
+		t.Errorf("Expected status finished, got %s", queued.Status)
+	}
+}
+
+func testSpecParser() *vmspec.Parser {
+	return vmspec.NewParser(&config.VMConfig{
+		DefaultVCPU:     2,
+		DefaultMemoryMB: 4096,
+		SizeClasses:     map[string]config.SizeClass{"large": {VCPU: 8, MemoryMB: 16384}},
+		Images:          map[string]config.VMImage{"ubuntu": {Kernel: "ghcr.io/firerunner/kernel:6.1", RootFS: "ghcr.io/firerunner/ubuntu:24.04"}},
+		Limits:          config.VMLimits{MaxVCPU: 16},
+	})
+}
+
+func TestScheduler_ScheduleJobUsesVMSpec(t *testing.T) {
+	vmManager := &mockVMManager{}
+	scheduler := NewScheduler(testSchedulerConfig(), vmManager, newMockGitLabService(), testLogger())
+	scheduler.SetSpecParser(testSpecParser())
+
+	scheduleProjectJob(t, scheduler, 1, 2, "acme/app", "firerunner-large-disk30gb-arm64", "firerunner-image:ubuntu")
+
+	job := tryNextJob(scheduler)
+	if job == nil || job.ID != 1 {
+		t.Fatalf("Expected job 1 to be queued, got %v", job)
+	}
+	if job.VCPU != 8 || job.MemoryMB != 16384 || job.DiskGB != 30 || job.Arch != "arm64" {
+		t.Errorf("Unexpected VM size: %+v", job.info())
+	}
+
+	worker := &Worker{ID: 1, scheduler: scheduler, logger: testLogger().WithField("worker_id", 1)}
+	if _, err := worker.createVM(job, "vm-1", nil, nil); err != nil {
+		t.Fatalf("createVM() failed: %v", err)
+	}
+	req := vmManager.lastRequest
+	if req.KernelImage != "ghcr.io/firerunner/kernel:6.1" || req.RootFSImage != "ghcr.io/firerunner/ubuntu:24.04" || req.DiskGB != 30 || req.HostSelector["arch"] != "arm64" {
+		t.Errorf("Unexpected VM request: %+v", req)
+	}
+}
+
+func TestScheduler_ScheduleJobRejectsInvalidTags(t *testing.T) {
+	gitlabSvc := newMockGitLabService()
+	scheduler := NewScheduler(testSchedulerConfig(), &mockVMManager{}, gitlabSvc, testLogger())
+	scheduler.SetSpecParser(testSpecParser())
+
+	invalid := testutil.ToFloat64(metrics.JobsRejected.WithLabelValues("invalid_tag"))
+	exceeds := testutil.ToFloat64(metrics.JobsRejected.WithLabelValues("exceeds_limits"))
+
+	scheduleProjectJob(t, scheduler, 1, 2, "acme/app", "firecracker-4cpu-8gbb")
+	scheduleProjectJob(t, scheduler, 2, 2, "acme/app", "firecracker-64cpu-512gb")
+
+	for _, id := range []int64{1, 2} {
+		info, exists := scheduler.JobInfo(gitlabKey(id))
+		if !exists {
+			t.Fatalf("Rejected job %d should stay tracked", id)
+		}
+		if info.Status != "failed" || info.FailureReason == "" {
+			t.Errorf("Expected job %d to fail with a reason, got %+v", id, info)
+		}
+	}
+	if info, _ := scheduler.JobInfo(gitlabKey(2)); !strings.Contains(info.FailureReason, "at most 16") {
+		t.Errorf("Expected the limit in the failure reason, got %q", info.FailureReason)
+	}
+
+	if scheduler.queue.len() != 0 {
+		t.Errorf("Rejected jobs should not be queued, queue has %d", scheduler.queue.len())
+	}
+	if canceled := gitlabSvc.canceled; len(canceled) != 2 || canceled[0] != 1 || canceled[1] != 2 {
+		t.Errorf("Expected rejected jobs to be canceled in GitLab, got %v", gitlabSvc.canceled)
+	}
+	if got := testutil.ToFloat64(metrics.JobsRejected.WithLabelValues("invalid_tag")); got != invalid+1 {
+		t.Errorf("Expected one invalid tag rejection, got %v", got-invalid)
+	}
+	if got := testutil.ToFloat64(metrics.JobsRejected.WithLabelValues("exceeds_limits")); got != exceeds+1 {
+		t.Errorf("Expected one limit rejection, got %v", got-exceeds)
+	}
+}
+
+func TestWorker_ProcessClaimedJob_RejectedTags(t *testing.T) {
+	vmManager := &mockVMManager{}
+	scheduler := NewScheduler(testSchedulerConfig(), vmManager, newMockGitLabService(), testLogger())
+	scheduler.SetSpecParser(testSpecParser())
+
+	payload := &gitlab.JobPayload{ID: 1, Token: "job-token", Raw: []byte(`{"id":1}`)}
+	payload.JobInfo.ProjectID = 2
+	claimer := &mockJobClaimer{payloads: []*gitlab.JobPayload{payload}}
+	scheduler.SetJobClaimer(claimer)
+
+	scheduleProjectJob(t, scheduler, 1, 2, "acme/app", "firerunner-huge")
+	scheduleProjectJob(t, scheduler, 2, 2, "acme/app", "firerunner")
+
+	slot := tryNextJob(scheduler)
+	if slot == nil || slot.ID != 2 {
+		t.Fatalf("Expected job 2 as slot, got %v", slot)
+	}
+
+	worker := &Worker{ID: 1, scheduler: scheduler, logger: testLogger().WithField("worker_id", 1)}
+	worker.processJob(slot)
+
+	if vmManager.wasCreateCalled() {
+		t.Error("No VM should be created for a job with rejected tags")
+	}
+	if len(claimer.updates) != 1 || claimer.updates[0] != "1:job-token:failed:runner_unsupported" {
+		t.Errorf("Expected rejection to be reported to GitLab, got %v", claimer.updates)
+	}
+	if len(claimer.traces) != 1 || !strings.Contains(claimer.traces[0], "huge") {
+		t.Errorf("Expected failure reason in job trace, got %v", claimer.traces)
+	}
+	if info, _ := scheduler.JobInfo(gitlabKey(1)); info.Status != "failed" {
+		t.Errorf("Expected status failed, got %s", info.Status)
+	}
+}
+
+func TestWorker_CreateVM_AttachesCaches(t *testing.T) {
+	caches, err := cache.NewManager(&config.CacheConfig{Dir: t.TempDir(), MountPoint: "/cache"}, testLogger())
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	vmManager := &mockVMManager{}
+	scheduler := NewScheduler(testSchedulerConfig(), vmManager, newMockGitLabService(), testLogger())
+	scheduler.SetSpecParser(testSpecParser())
+	scheduler.SetCacheManager(caches)
+
+	scheduleProjectJob(t, scheduler, 1, 2, "acme/app", "firerunner-cache:go-mod")
+	scheduleProjectJob(t, scheduler, 2, 2, "acme/app", "firerunner-cache:go-mod")
+
+	worker := &Worker{ID: 1, scheduler: scheduler, logger: testLogger().WithField("worker_id", 1)}
+	var jobs []*Job
+	for range 2 {
+		job := tryNextJob(scheduler)
+		if job == nil {
+			t.Fatal("Expected a queued job")
+		}
+		vm, err := worker.createVM(job, "", nil, nil)
+		if err != nil {
+			t.Fatalf("createVM() failed: %v", err)
+		}
+		job.VMID = vm.ID
+		jobs = append(jobs, job)
+
+		volumes := vmManager.lastRequest.Volumes
+		if len(volumes) != 1 || volumes[0].ID != "cache-go-mod" || volumes[0].MountPoint != "/cache/go-mod" {
+			t.Fatalf("Unexpected volumes: %+v", volumes)
+		}
+		if wantReadOnly := len(jobs) == 2; volumes[0].ReadOnly != wantReadOnly {
+			t.Errorf("Job %d: expected read-only %v, got %v", job.ID, wantReadOnly, volumes[0].ReadOnly)
+		}
+	}
+
+	for _, job := range jobs {
+		worker.cleanupVM(job)
+		if job.cacheLease != nil {
+			t.Errorf("Expected the caches of job %d to be released", job.ID)
+		}
+	}
+
+	lease, err := caches.Acquire("gitlab-2", []string{"go-mod"})
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if lease.Mounts[0].ReadOnly {
+		t.Error("Expected the cache to be writable after both jobs finished")
+	}
+}
+
+func TestWorker_CleanupVM_ReclaimsCachesOfVMsThatFailedToBeDestroyed(t *testing.T) {
+	caches, err := cache.NewManager(&config.CacheConfig{Dir: t.TempDir(), MountPoint: "/cache"}, testLogger())
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	vmManager := &mockVMManager{destroyError: errors.New("flintlock unavailable")}
+	scheduler := NewScheduler(testSchedulerConfig(), vmManager, newMockGitLabService(), testLogger())
+	scheduler.SetSpecParser(testSpecParser())
+	scheduler.SetCacheManager(caches)
+	scheduler.destroyRetryDelay = 10 * time.Millisecond
+	defer scheduler.Shutdown(context.Background())
+
+	scheduleProjectJob(t, scheduler, 1, 2, "acme/app", "firerunner-cache:go-mod")
+
+	worker := &Worker{ID: 1, scheduler: scheduler, logger: testLogger().WithField("worker_id", 1)}
+	job := tryNextJob(scheduler)
+	if job == nil {
+		t.Fatal("Expected a queued job")
+	}
+	vm, err := worker.createVM(job, "", nil, nil)
+	if err != nil {
+		t.Fatalf("createVM() failed: %v", err)
+	}
+	job.VMID = vm.ID
+	worker.cleanupVM(job)
+
+	lease, err := caches.Acquire("gitlab-2", []string{"go-mod"})
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if !lease.Mounts[0].ReadOnly {
+		t.Error("Expected the cache to stay leased while the VM is not destroyed")
+	}
+	lease.Release()
+
+	vmManager.mu.Lock()
+	vmManager.destroyError = nil
+	vmManager.mu.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lease, err := caches.Acquire("gitlab-3", []string{"go-mod"})
+		if err != nil {
+			t.Fatalf("Acquire() error = %v", err)
+		}
+		writable := !lease.Mounts[0].ReadOnly
+		lease.Release()
+		if writable {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the cache to be released once a retry destroyed the VM")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestScheduler_ScheduleJobEgress(t *testing.T) {
+	vmManager := &mockVMManager{}
+	scheduler := NewScheduler(testSchedulerConfig(), vmManager, newMockGitLabService(), testLogger())
+	scheduler.SetSpecParser(vmspec.NewParser(&config.VMConfig{
+		Network: config.NetworkConfig{Egress: config.EgressConfig{
+			Forks:    config.EgressDeny,
+			Projects: map[string]string{"acme": config.EgressGitLabOnly},
+		}},
+	}))
+	scheduler.SetPipelineResolver(&mockPipelineResolver{infos: map[int64]*gitlab.PipelineInfo{
+		9: {Ref: "refs/merge-requests/3/head", Source: "merge_request_event", Fork: true},
+	}})
+
+	repository := gitlab.Repository{Homepage: "https://gitlab.example.com/acme/app"}
+	for id, pipelineID := range map[int64]int64{1: 8, 2: 9} {
+		event := &gitlab.JobEvent{BuildID: id, ProjectID: 2, PipelineID: pipelineID, Repository: repository}
+		if err := scheduler.ScheduleJob(event); err != nil {
+			t.Fatalf("ScheduleJob() failed: %v", err)
+		}
+	}
+
+	for id, want := range map[int64]string{1: config.EgressGitLabOnly, 2: config.EgressDeny} {
+		if info, _ := scheduler.JobInfo(gitlabKey(id)); info.Egress != want {
+			t.Errorf("Job %d: expected egress %s, got %s", id, want, info.Egress)
+		}
+	}
+
+	worker := &Worker{ID: 1, scheduler: scheduler, logger: testLogger().WithField("worker_id", 1)}
+	for range 2 {
+		job := tryNextJob(scheduler)
+		if job == nil {
+			t.Fatal("Expected a queued job")
+		}
+		if _, err := worker.createVM(job, "", nil, nil); err != nil {
+			t.Fatalf("createVM() failed: %v", err)
+		}
+		if vmManager.lastRequest.Egress != job.Egress {
+			t.Errorf("Job %d: expected VM egress %s, got %s", job.ID, job.Egress, vmManager.lastRequest.Egress)
+		}
+	}
+}
+
+func TestScheduler_ScheduleJobEgressUnknownPipeline(t *testing.T) {
+	scheduler := NewScheduler(testSchedulerConfig(), &mockVMManager{}, newMockGitLabService(), testLogger())
+	scheduler.SetSpecParser(vmspec.NewParser(&config.VMConfig{
+		Network: config.NetworkConfig{Egress: config.EgressConfig{
+			Default: config.EgressGitLabOnly,
+			Forks:   config.EgressDeny,
+		}},
+	}))
+	scheduler.SetPipelineResolver(&mockPipelineResolver{err: errors.New("gitlab unavailable")})
+
+	if err := scheduler.ScheduleJob(&gitlab.JobEvent{BuildID: 1, ProjectID: 2, PipelineID: 8}); err != nil {
+		t.Fatalf("ScheduleJob() failed: %v", err)
+	}
+	if info, _ := scheduler.JobInfo(gitlabKey(1)); info.Egress != config.EgressDeny {
+		t.Errorf("Expected fork egress %s for unknown pipeline, got %s", config.EgressDeny, info.Egress)
+	}
+}
+
+type pendingGitLabService struct {
+	mockGitLabService
+}
+
+func (m *pendingGitLabService) GetJob(ctx context.Context, projectID, jobID int64) (*gogitlab.Job, error) {
+	return &gogitlab.Job{ID: int(jobID), Status: "pending"}, nil
+}
+
+func TestScheduler_ReleaseSlot(t *testing.T) {
+	scheduler := NewScheduler(testSchedulerConfig(), &mockVMManager{}, &pendingGitLabService{}, testLogger())
+	scheduler.SetJobClaimer(&mockJobClaimer{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	slot := &Job{ID: 1, Forge: forge.GitLab, ProjectID: 2, Status: "queued", CreatedAt: time.Now(), ctx: ctx, cancel: cancel, admitted: true}
+	scheduler.trackJob(slot)
+
+	scheduler.releaseSlot(slot)
+	if scheduler.queue.len() != 0 {
+		t.Error("Released slot should only be requeued after a delay")
+	}
+	if slot.admitted {
+		t.Error("Released slot should not hold quota while it waits")
+	}
+	if job, _ := scheduler.GetJob(gitlabKey(1)); job.Status != "queued" {
+		t.Errorf("Expected released slot to stay queued, got %s", job.Status)
+	}
+
+	slot.releases = maxSlotReleases
+	scheduler.releaseSlot(slot)
+	job, _ := scheduler.GetJob(gitlabKey(1))
+	if job.Status != "failed" || job.FailureReason == "" {
+		t.Errorf("Expected slot to fail after %d tries, got status %s reason %q", maxSlotReleases, job.Status, job.FailureReason)
+	}
+	if ctx.Err() == nil {
+		t.Error("Failed slot should be canceled")
+	}
+}
+
+func TestScheduler_RequeueSlotIntoFullQueue(t *testing.T) {
+	cfg := testSchedulerConfig()
+	cfg.QueueSize = 1
+	scheduler := NewScheduler(cfg, &mockVMManager{}, &pendingGitLabService{}, testLogger())
+	scheduler.SetJobClaimer(&mockJobClaimer{})
+	defer scheduler.Shutdown(context.Background())
+
+	if err := scheduler.ScheduleJob(&gitlab.JobEvent{BuildID: 2, ProjectID: 2}); err != nil {
+		t.Fatalf("ScheduleJob() failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	slot := &Job{ID: 1, Forge: forge.GitLab, ProjectID: 2, Status: "queued", CreatedAt: time.Now(), ctx: ctx, cancel: cancel}
+	scheduler.trackJob(slot)
+
+	scheduler.requeueSlot(slot)
+	if job, exists := scheduler.GetJob(gitlabKey(1)); !exists || job.Status != "queued" {
+		t.Fatal("Slot that did not fit into the queue should stay tracked")
+	}
+	if slot.releases != 1 || ctx.Err() != nil {
+		t.Errorf("Expected the slot to be released for another try, got %d releases", slot.releases)
+	}
+}
@@ -0,0 +1,240 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+)
+
+type JobStore interface {
+	Save(record *JobRecord) error
+	Delete(key JobKey) error
+	Load() ([]*JobRecord, error)
+	Close() error
+}
+
+type JobRecord struct {
+	ID            int64     `json:"id"`
+	Forge         string    `json:"forge,omitempty"`
+	Repository    string    `json:"repository,omitempty"`
+	ProjectID     int64     `json:"project_id"`
+	PipelineID    int64     `json:"pipeline_id"`
+	Status        string    `json:"status"`
+	Priority      int       `json:"priority,omitempty"`
+	Tags          []string  `json:"tags"`
+	VCPU          int64     `json:"vcpu"`
+	MemoryMB      int64     `json:"memory_mb"`
+	DiskGB        int64     `json:"disk_gb,omitempty"`
+	Arch          string    `json:"arch,omitempty"`
+	Image         string    `json:"image,omitempty"`
+	KernelImage   string    `json:"kernel_image,omitempty"`
+	RootFSImage   string    `json:"rootfs_image,omitempty"`
+	Caches        []string  `json:"caches,omitempty"`
+	Egress        string    `json:"egress,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	VMID          string    `json:"vm_id,omitempty"`
+	RunnerID      int64     `json:"runner_id,omitempty"`
+	JobToken      string    `json:"job_token,omitempty"`
+}
+
+func (r *JobRecord) key() JobKey {
+	if r.Forge == "" {
+		// Stored before FireRunner supported more than one forge.
+		return JobKey{Forge: forge.GitLab, ID: r.ID}
+	}
+	return JobKey{Forge: r.Forge, ID: r.ID}
+}
+
+// FileJobStore keeps the records in memory and writes them to a JSON file
+// in the background, so saving a record never waits for the disk while the
+// scheduler holds its lock. Close writes any pending change.
+type FileJobStore struct {
+	path    string
+	records map[JobKey]*JobRecord
+	dirty   bool
+	err     error // of the last write
+	mu      sync.Mutex
+
+	writeMu   sync.Mutex
+	flushCh   chan struct{}
+	closeCh   chan struct{}
+	doneCh    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewFileJobStore(path string) (*FileJobStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	store := &FileJobStore{
+		path:    path,
+		records: make(map[JobKey]*JobRecord),
+		flushCh: make(chan struct{}, 1),
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read job store: %w", err)
+	}
+
+	if len(data) > 0 {
+		var records []*JobRecord
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("failed to parse job store %s: %w", path, err)
+		}
+		for _, record := range records {
+			store.records[record.key()] = record
+		}
+	}
+
+	go store.run()
+
+	return store, nil
+}
+
+// Save records a job and schedules a write. It returns the error of the
+// previous write, if that failed.
+func (s *FileJobStore) Save(record *JobRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *record
+	s.records[record.key()] = &copied
+	s.markDirty()
+	return s.err
+}
+
+func (s *FileJobStore) Delete(key JobKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.records[key]; !exists {
+		return nil
+	}
+	delete(s.records, key)
+	s.markDirty()
+	return s.err
+}
+
+func (s *FileJobStore) Load() ([]*JobRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]*JobRecord, 0, len(s.records))
+	for _, record := range s.records {
+		copied := *record
+		records = append(records, &copied)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+
+	return records, nil
+}
+
+func (s *FileJobStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closeCh)
+	})
+	<-s.doneCh
+	return s.flush()
+}
+
+// markDirty must be called with mu held.
+func (s *FileJobStore) markDirty() {
+	s.dirty = true
+	select {
+	case s.flushCh <- struct{}{}:
+	default:
+	}
+}
+
+func (s *FileJobStore) run() {
+	defer close(s.doneCh)
+	for {
+		select {
+		case <-s.flushCh:
+			s.flush()
+		case <-s.closeCh:
+			return
+		}
+	}
+}
+
+// flush writes the store if it changed since the last write. Changes made
+// while a write is in progress are picked up by the next one.
+func (s *FileJobStore) flush() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.Lock()
+	if !s.dirty {
+		err := s.err
+		s.mu.Unlock()
+		return err
+	}
+	// Saved records are replaced rather than modified, so they can be
+	// encoded after the lock is released.
+	records := make([]*JobRecord, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	s.dirty = false
+	s.mu.Unlock()
+
+	err := s.write(records)
+
+	s.mu.Lock()
+	s.err = err
+	if err != nil {
+		s.dirty = true
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// write writes the records to a temporary file and renames it over the
+// previous state so a crash mid-write never leaves a truncated file behind.
+func (s *FileJobStore) write(records []*JobRecord) error {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("failed to encode job store: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".jobs-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write job store: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync job store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close job store: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace job store: %w", err)
+	}
+
+	return nil
+}
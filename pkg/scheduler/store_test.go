@@ -0,0 +1,196 @@
+package scheduler
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+)
+
+func TestFileJobStore_SaveLoadDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "jobs.json")
+
+	store, err := NewFileJobStore(path)
+	if err != nil {
+		t.Fatalf("NewFileJobStore() failed: %v", err)
+	}
+
+	now := time.Now()
+	if err := store.Save(&JobRecord{ID: 2, Status: "running", VMID: "vm-2", CreatedAt: now}); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	if err := store.Save(&JobRecord{ID: 1, Status: "queued", CreatedAt: now.Add(-time.Minute)}); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	// Job IDs are only unique within a forge.
+	if err := store.Save(&JobRecord{ID: 1, Forge: forge.GitHub, Status: "queued", CreatedAt: now.Add(time.Minute)}); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+
+	// Reopen to make sure state survives a restart
+	reopened, err := NewFileJobStore(path)
+	if err != nil {
+		t.Fatalf("NewFileJobStore() reopen failed: %v", err)
+	}
+	defer reopened.Close()
+
+	records, err := reopened.Load()
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(records))
+	}
+
+	if records[0].ID != 1 || records[1].ID != 2 || records[2].Forge != forge.GitHub {
+		t.Error("Records should be ordered by creation time")
+	}
+
+	if records[1].VMID != "vm-2" {
+		t.Errorf("Expected VMID vm-2, got %s", records[1].VMID)
+	}
+
+	if err := reopened.Delete(gitlabKey(1)); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+
+	records, _ = reopened.Load()
+	if len(records) != 2 || records[1].Forge != forge.GitHub {
+		t.Errorf("Expected only the GitLab job to be deleted, got %+v", records)
+	}
+}
+
+func TestFileJobStore_WritesInBackground(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+
+	store, err := NewFileJobStore(path)
+	if err != nil {
+		t.Fatalf("NewFileJobStore() failed: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Save(&JobRecord{ID: 1, Status: "queued", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		reopened, err := NewFileJobStore(path)
+		if err != nil {
+			t.Fatalf("NewFileJobStore() reopen failed: %v", err)
+		}
+		records, _ := reopened.Load()
+		reopened.Close()
+		if len(records) == 1 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Saved record was never written without Close")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestScheduler_RecoverJobs(t *testing.T) {
+	cfg := testSchedulerConfig()
+	cfg.WorkerCount = 0
+
+	store, err := NewFileJobStore(filepath.Join(t.TempDir(), "jobs.json"))
+	if err != nil {
+		t.Fatalf("NewFileJobStore() failed: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	_ = store.Save(&JobRecord{ID: 1, ProjectID: 10, Status: "queued", CreatedAt: now})
+	_ = store.Save(&JobRecord{ID: 2, ProjectID: 10, Status: "running", VMID: "vm-2", CreatedAt: now})
+	_ = store.Save(&JobRecord{ID: 3, ProjectID: 10, Status: "finished", CreatedAt: now, FinishedAt: now})
+
+	vmManager := &mockVMManager{}
+	scheduler := NewScheduler(cfg, vmManager, newMockGitLabService(), testLogger())
+	scheduler.SetJobStore(store)
+
+	if err := scheduler.Start(); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+
+	if scheduler.queue.len() != 2 {
+		t.Errorf("Expected queued and runner-less in-flight job to be queued, got %d", scheduler.queue.len())
+	}
+
+	job, exists := scheduler.GetJob(gitlabKey(2))
+	if !exists {
+		t.Fatal("Recovered job should be tracked")
+	}
+
+	if job.Status != "queued" || job.VMID != "" {
+		t.Errorf("Expected job 2 to be requeued without a VM, got status %s vm %q", job.Status, job.VMID)
+	}
+
+	if !vmManager.destroyCalled {
+		t.Error("Adopted VM of an in-flight job without a runner should be destroyed")
+	}
+
+	if _, exists := scheduler.GetJob(gitlabKey(3)); !exists {
+		t.Error("Finished job should be kept for history")
+	}
+
+	records, _ := store.Load()
+	for _, record := range records {
+		if record.ID == 2 && record.Status != "queued" {
+			t.Errorf("Expected persisted status queued for job 2, got %s", record.Status)
+		}
+	}
+}
+
+func TestScheduler_RecoverJobWithLostRunner(t *testing.T) {
+	tests := []struct {
+		name       string
+		pending    bool
+		wantStatus string
+	}{
+		{name: "still pending", pending: true, wantStatus: "queued"},
+		{name: "picked up by the lost runner", pending: false, wantStatus: "failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testSchedulerConfig()
+			cfg.WorkerCount = 0
+
+			store, err := NewFileJobStore(filepath.Join(t.TempDir(), "jobs.json"))
+			if err != nil {
+				t.Fatalf("NewFileJobStore() failed: %v", err)
+			}
+			defer store.Close()
+			_ = store.Save(&JobRecord{ID: 1, Forge: forge.GitHub, Repository: "acme/app", Status: "running", VMID: "vm-1", RunnerID: 77, CreatedAt: time.Now()})
+
+			scheduler := NewScheduler(cfg, &mockVMManager{adoptError: fmt.Errorf("not found")}, newMockGitLabService(), testLogger())
+			github := &fakeForge{pending: tt.pending}
+			scheduler.SetForge(forge.GitHub, github)
+			scheduler.SetJobStore(store)
+
+			if err := scheduler.Start(); err != nil {
+				t.Fatalf("Start() failed: %v", err)
+			}
+
+			job, exists := scheduler.GetJob(JobKey{Forge: forge.GitHub, ID: 1})
+			if !exists {
+				t.Fatal("Recovered job should be tracked")
+			}
+			if job.Status != tt.wantStatus {
+				t.Errorf("Expected status %s, got %s", tt.wantStatus, job.Status)
+			}
+			if len(github.unregistered) != 1 || github.unregistered[0] != 77 {
+				t.Errorf("Expected the lost runner to be unregistered, got %v", github.unregistered)
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+// Package vmspec works out the VM a job runs in from its tags.
+//
+// A FireRunner tag starts with one of forge.TagPrefixes and continues with
+// dash-separated parts, in any order:
+//
+//	4cpu, 4vcpu         vCPUs
+//	8gb, 512mb          memory
+//	disk50gb            root disk size
+//	amd64, arm64        host architecture
+//	small, large, ...   a size class from vm.size_classes
+//	image:<name>        an image from vm.images; it takes the rest of the tag
+//	cache:<key>         a cache volume of the project; it takes the rest of
+//	                    the tag and may be given in several tags
+//
+// Jobs without an image part get the vm.project_images image of their
+// project or group, if any.
+// e.g. "firerunner-large-disk100gb" or "firecracker-4cpu-8gb-image:ubuntu-24.04".
+// Explicit parts override a size class. Parts of several tags are combined
+// and must not contradict each other.
+package vmspec
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+	"github.com/ismoilovdevml/firerunner/pkg/forge"
+)
+
+const (
+	defaultVCPU     = 2
+	defaultMemoryMB = 4096
+	minMemoryMB     = 512
+)
+
+var (
+	ErrInvalidTag    = errors.New("invalid VM tag")
+	ErrExceedsLimits = errors.New("VM exceeds limits")
+)
+
+var (
+	cpuPattern    = regexp.MustCompile(`^(\d+)v?cpu$`)
+	memoryPattern = regexp.MustCompile(`^(\d+)(gb|mb)$`)
+	diskPattern   = regexp.MustCompile(`^disk(\d+)gb$`)
+	cachePattern  = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+)
+
+var architectures = map[string]string{
+	"amd64":   "amd64",
+	"x86_64":  "amd64",
+	"arm64":   "arm64",
+	"aarch64": "arm64",
+}
+
+// Spec is the VM a job asks for.
+type Spec struct {
+	VCPU     int64
+	MemoryMB int64
+	// DiskGB is zero to keep the size of the root filesystem image.
+	DiskGB int64
+	// Arch is empty to run on any host.
+	Arch string
+	// Image names the vm.images entry to boot, or is empty for
+	// vm.kernel_image and vm.rootfs_image. Kernel and RootFS are its
+	// references; an empty one means the configured default.
+	Image  string
+	Kernel string
+	RootFS string
+	// Caches are the keys of the project caches to mount, sorted.
+	Caches []string
+}
+
+type Parser struct {
+	config *config.VMConfig
+}
+
+// NewParser returns a parser for the size classes, images and limits of
+// cfg. A nil cfg only understands explicit sizes and applies no limits.
+func NewParser(cfg *config.VMConfig) *Parser {
+	if cfg == nil {
+		cfg = &config.VMConfig{}
+	}
+	return &Parser{config: cfg}
+}
+
+// fields collects what tags ask for; zero values are unset.
+type fields struct {
+	class    string
+	vcpu     int64
+	memoryMB int64
+	diskGB   int64
+	arch     string
+	image    string
+	caches   map[string]bool
+}
+
+// Parse returns the VM the tags of a job of the project path ask for. Tags
+// without a FireRunner prefix are ignored; a FireRunner tag that cannot be
+// parsed is an error wrapping ErrInvalidTag.
+func (p *Parser) Parse(tags []string, project string) (Spec, error) {
+	var f fields
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		parts, ok := splitTag(tag)
+		if !ok {
+			continue
+		}
+		if err := p.parseParts(&f, parts); err != nil {
+			return Spec{}, fmt.Errorf("%w %q: %v", ErrInvalidTag, tag, err)
+		}
+	}
+
+	if f.image == "" {
+		f.image = p.projectImage(project)
+	}
+	return p.spec(f), nil
+}
+
+// splitTag returns the parts of a FireRunner tag after its prefix, or false
+// for tags of other runners. A tag like "firecrackerx" that merely starts
+// with a prefix yields a single invalid part.
+func splitTag(tag string) ([]string, bool) {
+	for _, prefix := range forge.TagPrefixes {
+		if !strings.HasPrefix(tag, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(tag, prefix)
+		if rest == "" {
+			return nil, true
+		}
+		if !strings.HasPrefix(rest, "-") {
+			return []string{tag}, true
+		}
+		return strings.Split(rest[1:], "-"), true
+	}
+	return nil, false
+}
+
+func (p *Parser) parseParts(f *fields, parts []string) error {
+	for i, part := range parts {
+		if key, found := strings.CutPrefix(part, "cache:"); found {
+			// Cache keys may contain dashes as well.
+			key = strings.Join(append([]string{key}, parts[i+1:]...), "-")
+			if !cachePattern.MatchString(key) {
+				return fmt.Errorf("invalid cache key %q", key)
+			}
+			if f.caches == nil {
+				f.caches = make(map[string]bool)
+			}
+			f.caches[key] = true
+			return nil
+		}
+
+		if name, found := strings.CutPrefix(part, "image:"); found {
+			// Image names may contain dashes, so the image takes the rest.
+			name = strings.Join(append([]string{name}, parts[i+1:]...), "-")
+			if _, exists := p.config.Images[name]; !exists {
+				return fmt.Errorf("unknown image %q", name)
+			}
+			return set(&f.image, name, "image")
+		}
+
+		if err := p.parsePart(f, part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Parser) parsePart(f *fields, part string) error {
+	if m := cpuPattern.FindStringSubmatch(part); m != nil {
+		vcpu, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil || vcpu < 1 {
+			return fmt.Errorf("invalid vCPU count %q", part)
+		}
+		return set(&f.vcpu, vcpu, "vCPU count")
+	}
+
+	if m := memoryPattern.FindStringSubmatch(part); m != nil {
+		memory, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid memory size %q", part)
+		}
+		if m[2] == "gb" {
+			memory *= 1024
+		}
+		if memory < minMemoryMB {
+			return fmt.Errorf("memory size %q is below %d MB", part, minMemoryMB)
+		}
+		return set(&f.memoryMB, memory, "memory size")
+	}
+
+	if m := diskPattern.FindStringSubmatch(part); m != nil {
+		disk, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil || disk < 1 {
+			return fmt.Errorf("invalid disk size %q", part)
+		}
+		return set(&f.diskGB, disk, "disk size")
+	}
+
+	if arch, ok := architectures[part]; ok {
+		return set(&f.arch, arch, "architecture")
+	}
+
+	if _, ok := p.config.SizeClasses[part]; ok {
+		return set(&f.class, part, "size class")
+	}
+
+	return fmt.Errorf("unknown part %q", part)
+}
+
+func set[T comparable](field *T, value T, name string) error {
+	var zero T
+	if *field != zero && *field != value {
+		return fmt.Errorf("conflicting %s %v and %v", name, *field, value)
+	}
+	*field = value
+	return nil
+}
+
+// spec applies size class and defaults to what the tags set explicitly.
+func (p *Parser) spec(f fields) Spec {
+	spec := Spec{
+		VCPU:     p.config.DefaultVCPU,
+		MemoryMB: p.config.DefaultMemoryMB,
+		Arch:     f.arch,
+	}
+	if image, ok := p.config.Images[f.image]; ok {
+		spec.Image = f.image
+		spec.Kernel = image.Kernel
+		spec.RootFS = image.RootFS
+	}
+	if spec.VCPU == 0 {
+		spec.VCPU = defaultVCPU
+	}
+	if spec.MemoryMB == 0 {
+		spec.MemoryMB = defaultMemoryMB
+	}
+
+	for key := range f.caches {
+		spec.Caches = append(spec.Caches, key)
+	}
+	sort.Strings(spec.Caches)
+
+	if class, ok := p.config.SizeClasses[f.class]; ok {
+		spec.VCPU = class.VCPU
+		spec.MemoryMB = class.MemoryMB
+		spec.DiskGB = class.DiskGB
+	}
+
+	if f.vcpu > 0 {
+		spec.VCPU = f.vcpu
+	}
+	if f.memoryMB > 0 {
+		spec.MemoryMB = f.memoryMB
+	}
+	if f.diskGB > 0 {
+		spec.DiskGB = f.diskGB
+	}
+	return spec
+}
+
+// Check returns an error wrapping ErrExceedsLimits if spec is larger than
+// the limits for the project path allow.
+func (p *Parser) Check(spec Spec, project string) error {
+	limits := p.limits(project)
+
+	if limits.MaxVCPU > 0 && spec.VCPU > limits.MaxVCPU {
+		return fmt.Errorf("%w: %d vCPUs requested, at most %d allowed", ErrExceedsLimits, spec.VCPU, limits.MaxVCPU)
+	}
+	if limits.MaxMemoryMB > 0 && spec.MemoryMB > limits.MaxMemoryMB {
+		return fmt.Errorf("%w: %d MB memory requested, at most %d MB allowed", ErrExceedsLimits, spec.MemoryMB, limits.MaxMemoryMB)
+	}
+	if limits.MaxDiskGB > 0 && spec.DiskGB > limits.MaxDiskGB {
+		return fmt.Errorf("%w: %d GB disk requested, at most %d GB allowed", ErrExceedsLimits, spec.DiskGB, limits.MaxDiskGB)
+	}
+	return nil
+}
+
+// Egress returns the egress policy of a job of the project path:
+// vm.network.egress.forks for jobs of merge requests from forks if set,
+// otherwise the policy of the longest matching vm.network.egress.projects
+// path or the default. It never returns an empty policy.
+func (p *Parser) Egress(project string, fork bool) string {
+	egress := p.config.Network.Egress
+	if fork && egress.Forks != "" {
+		return egress.Forks
+	}
+
+	policy, from := egress.Default, 0
+	for path, projectPolicy := range egress.Projects {
+		path = strings.Trim(path, "/")
+		if inPath(project, path) && len(path) > from && projectPolicy != "" {
+			policy, from = projectPolicy, len(path)
+		}
+	}
+	if policy == "" {
+		policy = config.EgressAllowAll
+	}
+	return policy
+}
+
+// ForkEgress reports whether jobs of merge requests from forks have an
+// egress policy of their own.
+func (p *Parser) ForkEgress() bool {
+	return p.config.Network.Egress.Forks != ""
+}
+
+// projectImage returns the image of the longest vm.project_images path
+// matching a project path.
+func (p *Parser) projectImage(project string) string {
+	var image string
+	var from int
+	for path, name := range p.config.ProjectImages {
+		path = strings.Trim(path, "/")
+		if inPath(project, path) && len(path) > from {
+			image, from = name, len(path)
+		}
+	}
+	return image
+}
+
+// limits returns the limits for a project path: for each limit the value of
+// the longest matching vm.project_limits path that sets it, or vm.limits.
+func (p *Parser) limits(project string) config.VMLimits {
+	limits := p.config.Limits
+
+	var vcpuFrom, memoryFrom, diskFrom int
+	for path, projectLimits := range p.config.ProjectLimits {
+		path = strings.Trim(path, "/")
+		if !inPath(project, path) {
+			continue
+		}
+		if projectLimits.MaxVCPU > 0 && len(path) > vcpuFrom {
+			limits.MaxVCPU, vcpuFrom = projectLimits.MaxVCPU, len(path)
+		}
+		if projectLimits.MaxMemoryMB > 0 && len(path) > memoryFrom {
+			limits.MaxMemoryMB, memoryFrom = projectLimits.MaxMemoryMB, len(path)
+		}
+		if projectLimits.MaxDiskGB > 0 && len(path) > diskFrom {
+			limits.MaxDiskGB, diskFrom = projectLimits.MaxDiskGB, len(path)
+		}
+	}
+	return limits
+}
+
+// inPath reports whether a project is the project or in the group path.
+func inPath(project, path string) bool {
+	return path != "" && (project == path || strings.HasPrefix(project, path+"/"))
+}
@@ -0,0 +1,241 @@
+package vmspec
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ismoilovdevml/firerunner/pkg/config"
+)
+
+func testVMConfig() *config.VMConfig {
+	return &config.VMConfig{
+		DefaultVCPU:     2,
+		DefaultMemoryMB: 4096,
+		SizeClasses: map[string]config.SizeClass{
+			"small":  {VCPU: 2, MemoryMB: 4096},
+			"large":  {VCPU: 8, MemoryMB: 16384, DiskGB: 40},
+			"xlarge": {VCPU: 16, MemoryMB: 32768},
+		},
+		Images: map[string]config.VMImage{
+			"ubuntu-24.04": {RootFS: "ghcr.io/firerunner/ubuntu:24.04"},
+			"android":      {Kernel: "ghcr.io/firerunner/kernel:6.1-kvm", RootFS: "ghcr.io/firerunner/android-sdk:34"},
+		},
+		ProjectImages: map[string]string{
+			"mobile": "android",
+		},
+		Limits: config.VMLimits{MaxVCPU: 16, MaxMemoryMB: 65536, MaxDiskGB: 200},
+		ProjectLimits: map[string]config.VMLimits{
+			"acme":          {MaxVCPU: 8},
+			"acme/monorepo": {MaxVCPU: 32, MaxDiskGB: 500},
+		},
+	}
+}
+
+func TestParser_Parse(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want Spec
+	}{
+		{
+			name: "standard 2cpu 4gb",
+			tags: []string{"firecracker-2cpu-4gb"},
+			want: Spec{VCPU: 2, MemoryMB: 4096},
+		},
+		{
+			name: "large 8cpu 16gb",
+			tags: []string{"actuated-8cpu-16gb"},
+			want: Spec{VCPU: 8, MemoryMB: 16384},
+		},
+		{
+			name: "no vm tags - defaults",
+			tags: []string{"docker"},
+			want: Spec{VCPU: 2, MemoryMB: 4096},
+		},
+		{
+			name: "bare prefix",
+			tags: []string{"firerunner"},
+			want: Spec{VCPU: 2, MemoryMB: 4096},
+		},
+		{
+			name: "memory in MB and vcpu",
+			tags: []string{"microvm-3vcpu-1536mb"},
+			want: Spec{VCPU: 3, MemoryMB: 1536},
+		},
+		{
+			name: "size class",
+			tags: []string{"firerunner-large"},
+			want: Spec{VCPU: 8, MemoryMB: 16384, DiskGB: 40},
+		},
+		{
+			name: "explicit parts override class",
+			tags: []string{"firerunner-large-disk100gb-12cpu"},
+			want: Spec{VCPU: 12, MemoryMB: 16384, DiskGB: 100},
+		},
+		{
+			name: "architecture and image across tags",
+			tags: []string{"firerunner-aarch64", "FireRunner-4cpu-image:ubuntu-24.04"},
+			want: Spec{VCPU: 4, MemoryMB: 4096, Arch: "arm64", Image: "ubuntu-24.04", RootFS: "ghcr.io/firerunner/ubuntu:24.04"},
+		},
+		{
+			name: "caches",
+			tags: []string{"firerunner-cache:go-mod", "firerunner-4cpu-cache:docker", "firerunner-cache:go-mod"},
+			want: Spec{VCPU: 4, MemoryMB: 4096, Caches: []string{"docker", "go-mod"}},
+		},
+	}
+
+	parser := NewParser(testVMConfig())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := parser.Parse(tt.tags, "acme/app")
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(spec, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", spec, tt.want)
+			}
+		})
+	}
+}
+
+func TestParser_ParseInvalid(t *testing.T) {
+	tests := map[string][]string{
+		"unknown part":       {"firerunner-4cpu-8gbb"},
+		"unknown class":      {"firerunner-huge"},
+		"unknown image":      {"firerunner-image:windows"},
+		"too little memory":  {"firecracker-2cpu-256mb"},
+		"zero vcpus":         {"firecracker-0cpu"},
+		"conflicting sizes":  {"firerunner-4cpu", "firerunner-8cpu"},
+		"conflicting arch":   {"firerunner-amd64-arm64"},
+		"prefix without -":   {"firecrackerx"},
+		"empty part":         {"firerunner--4cpu"},
+		"two classes":        {"firerunner-small-large"},
+		"malformed disk tag": {"firerunner-diskgb"},
+		"invalid cache key":  {"firerunner-cache:../etc"},
+		"empty cache key":    {"firerunner-cache:"},
+	}
+
+	parser := NewParser(testVMConfig())
+	for name, tags := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parser.Parse(tags, "acme/app"); !errors.Is(err, ErrInvalidTag) {
+				t.Errorf("Parse(%v) error = %v, want ErrInvalidTag", tags, err)
+			}
+		})
+	}
+}
+
+func TestParser_NilConfig(t *testing.T) {
+	parser := NewParser(nil)
+
+	spec, err := parser.Parse([]string{"firecracker-64cpu-512gb"}, "acme/app")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if spec.VCPU != 64 || spec.MemoryMB != 524288 {
+		t.Errorf("Expected 64 vCPU and 524288 MB, got %+v", spec)
+	}
+	if err := parser.Check(spec, "acme/app"); err != nil {
+		t.Errorf("Check() error = %v, want no limits", err)
+	}
+
+	if _, err := parser.Parse([]string{"firerunner-large"}, "acme/app"); !errors.Is(err, ErrInvalidTag) {
+		t.Errorf("Expected size classes to be unknown, got %v", err)
+	}
+}
+
+func TestParser_ProjectImage(t *testing.T) {
+	parser := NewParser(testVMConfig())
+
+	spec, err := parser.Parse([]string{"firerunner-4cpu"}, "mobile/app")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := Spec{VCPU: 4, MemoryMB: 4096, Image: "android", Kernel: "ghcr.io/firerunner/kernel:6.1-kvm", RootFS: "ghcr.io/firerunner/android-sdk:34"}
+	if !reflect.DeepEqual(spec, want) {
+		t.Errorf("Parse() = %+v, want %+v", spec, want)
+	}
+
+	spec, err = parser.Parse([]string{"firerunner-image:ubuntu-24.04"}, "mobile/app")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if spec.Image != "ubuntu-24.04" || spec.Kernel != "" {
+		t.Errorf("Expected the tag to override the project image, got %+v", spec)
+	}
+
+	spec, err = parser.Parse(nil, "mobile-web/app")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if spec.Image != "" {
+		t.Errorf("Expected the default image outside the group, got %+v", spec)
+	}
+}
+
+func TestParser_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    Spec
+		project string
+		wantErr bool
+	}{
+		{"within global limits", Spec{VCPU: 16, MemoryMB: 65536}, "other/app", false},
+		{"above global vcpu", Spec{VCPU: 17, MemoryMB: 4096}, "other/app", true},
+		{"above global memory", Spec{VCPU: 2, MemoryMB: 131072}, "other/app", true},
+		{"group limit", Spec{VCPU: 12, MemoryMB: 4096}, "acme/web", true},
+		{"longest path wins", Spec{VCPU: 32, MemoryMB: 4096}, "acme/monorepo", false},
+		{"project disk limit", Spec{VCPU: 2, MemoryMB: 4096, DiskGB: 400}, "acme/monorepo", false},
+		{"global disk limit", Spec{VCPU: 2, MemoryMB: 4096, DiskGB: 400}, "acme/web", true},
+		{"path prefix is not a group", Spec{VCPU: 12, MemoryMB: 4096}, "acme-labs/app", false},
+	}
+
+	parser := NewParser(testVMConfig())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := parser.Check(tt.spec, tt.project)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrExceedsLimits) {
+				t.Errorf("Check() error = %v, want ErrExceedsLimits", err)
+			}
+		})
+	}
+}
+
+func TestParser_Egress(t *testing.T) {
+	cfg := testVMConfig()
+	cfg.Network.Egress = config.EgressConfig{
+		Forks: config.EgressGitLabOnly,
+		Projects: map[string]string{
+			"acme":        config.EgressDeny,
+			"acme/public": config.EgressAllowAll,
+		},
+	}
+	parser := NewParser(cfg)
+
+	tests := []struct {
+		project string
+		fork    bool
+		want    string
+	}{
+		{"other/app", false, config.EgressAllowAll},
+		{"acme/app", false, config.EgressDeny},
+		{"acme/public/site", false, config.EgressAllowAll},
+		{"acme/public/site", true, config.EgressGitLabOnly},
+	}
+	for _, tt := range tests {
+		if got := parser.Egress(tt.project, tt.fork); got != tt.want {
+			t.Errorf("Egress(%s, %v) = %s, want %s", tt.project, tt.fork, got, tt.want)
+		}
+	}
+	if !parser.ForkEgress() {
+		t.Error("Expected forks to have their own policy")
+	}
+
+	if got := NewParser(nil).Egress("acme/app", true); got != config.EgressAllowAll {
+		t.Errorf("Expected allow-all without configuration, got %s", got)
+	}
+}